@@ -1,13 +1,38 @@
 package bootstrap
 
 import (
+	"log"
+
 	"backend/controllers"
+	"backend/services"
 )
 
 func InitializeApp() {
+	migrate()
+	ensureIndexes()
 	initControllers()
 }
 
+func migrate() {
+	if err := services.NewMigrationService().Run(); err != nil {
+		log.Fatal("Ошибка миграции данных: ", err)
+	}
+}
+
+func ensureIndexes() {
+	for _, ensure := range []func() error{
+		services.NewLoginLimiter().EnsureIndexes,
+		services.NewUserService().EnsureIndexes,
+		services.NewAlertTypeService().EnsureIndexes,
+		services.NewFeedbackService().EnsureIndexes,
+		services.NewIncidentService().EnsureIndexes,
+	} {
+		if err := ensure(); err != nil {
+			log.Fatal("Ошибка создания индексов: ", err)
+		}
+	}
+}
+
 func initControllers() {
 	controllers.InitCameraController()
 }
@@ -11,6 +11,14 @@ import (
 
 func main() {
 	config.ConnectDatabase()
+	config.LoadAuthConfig()
+	config.LoadOIDCConfig()
+	config.LoadMailerConfig()
+	config.LoadNotificationConfig()
+	config.LoadIncidentConfig()
+	config.LoadRealtimeConfig()
+	config.LoadExportConfig()
+	config.LoadBlobConfig()
 	bootstrap.InitializeApp()
 
 	r := routes.SetupRouter()
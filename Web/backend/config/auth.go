@@ -0,0 +1,122 @@
+package config
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"log"
+	"os"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
+
+// Registration modes for self-service sign-up.
+const (
+	RegistrationOpen     = "open"
+	RegistrationInvite   = "invite"
+	RegistrationDisabled = "disabled"
+)
+
+// AuthConfig holds the token signing and sign-up settings.
+type AuthConfig struct {
+	SigningMethod    jwt.SigningMethod
+	Secret           []byte
+	PrivateKey       crypto.Signer
+	KeyID            string
+	AccessTokenTTL   time.Duration
+	RefreshTokenTTL  time.Duration
+	RegistrationMode string
+}
+
+var Auth *AuthConfig
+
+// LoadAuthConfig reads the signing settings from the environment:
+// JWT_ALGORITHM (HS256, RS256 or EdDSA), JWT_SECRET for HS256,
+// JWT_PRIVATE_KEY_FILE and JWT_KEY_ID for RS256 and EdDSA, and the
+// ACCESS_TOKEN_TTL and REFRESH_TOKEN_TTL durations. REGISTRATION_MODE is one
+// of open, invite or disabled.
+func LoadAuthConfig() {
+	cfg := &AuthConfig{
+		KeyID:            os.Getenv("JWT_KEY_ID"),
+		AccessTokenTTL:   durationEnv("ACCESS_TOKEN_TTL", 15*time.Minute),
+		RefreshTokenTTL:  durationEnv("REFRESH_TOKEN_TTL", 30*24*time.Hour),
+		RegistrationMode: os.Getenv("REGISTRATION_MODE"),
+	}
+
+	switch cfg.RegistrationMode {
+	case "":
+		cfg.RegistrationMode = RegistrationOpen
+	case RegistrationOpen, RegistrationInvite, RegistrationDisabled:
+	default:
+		log.Fatal("Неподдерживаемый REGISTRATION_MODE: ", cfg.RegistrationMode)
+	}
+
+	algorithm := os.Getenv("JWT_ALGORITHM")
+	if algorithm == "" {
+		algorithm = "HS256"
+	}
+
+	switch algorithm {
+	case "HS256":
+		cfg.SigningMethod = jwt.SigningMethodHS256
+		cfg.Secret = []byte(os.Getenv("JWT_SECRET"))
+		if len(cfg.Secret) == 0 {
+			log.Fatal("JWT_SECRET не установлен в .env")
+		}
+	case "RS256", "EdDSA":
+		pemData, err := os.ReadFile(os.Getenv("JWT_PRIVATE_KEY_FILE"))
+		if err != nil {
+			log.Fatal("Ошибка чтения JWT_PRIVATE_KEY_FILE: ", err)
+		}
+		if algorithm == "RS256" {
+			cfg.SigningMethod = jwt.SigningMethodRS256
+			cfg.PrivateKey, err = jwt.ParseRSAPrivateKeyFromPEM(pemData)
+		} else {
+			cfg.SigningMethod = jwt.SigningMethodEdDSA
+			var key crypto.PrivateKey
+			key, err = jwt.ParseEdPrivateKeyFromPEM(pemData)
+			if err == nil {
+				cfg.PrivateKey = key.(ed25519.PrivateKey)
+			}
+		}
+		if err != nil {
+			log.Fatal("Некорректный ключ в JWT_PRIVATE_KEY_FILE: ", err)
+		}
+	default:
+		log.Fatal("Неподдерживаемый JWT_ALGORITHM: ", algorithm)
+	}
+
+	Auth = cfg
+}
+
+// SigningKey returns the key passed to jwt.Token.SignedString.
+func (c *AuthConfig) SigningKey() interface{} {
+	if c.PrivateKey != nil {
+		return c.PrivateKey
+	}
+	return c.Secret
+}
+
+// VerificationKey returns the key used to validate token signatures.
+func (c *AuthConfig) VerificationKey() interface{} {
+	switch key := c.PrivateKey.(type) {
+	case *rsa.PrivateKey:
+		return &key.PublicKey
+	case ed25519.PrivateKey:
+		return key.Public()
+	}
+	return c.Secret
+}
+
+func durationEnv(name string, fallback time.Duration) time.Duration {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		log.Fatalf("Некорректное значение %s: %v", name, err)
+	}
+	return d
+}
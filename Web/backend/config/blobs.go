@@ -0,0 +1,31 @@
+package config
+
+import (
+	"log"
+	"os"
+	"strconv"
+)
+
+// BlobConfig controls the store of files attached to alerts.
+type BlobConfig struct {
+	// MaxSize is the largest accepted file in bytes.
+	MaxSize int64
+}
+
+var Blobs *BlobConfig
+
+// LoadBlobConfig reads BLOB_MAX_SIZE.
+func LoadBlobConfig() {
+	cfg := &BlobConfig{
+		MaxSize: 20 << 20,
+	}
+	if value := os.Getenv("BLOB_MAX_SIZE"); value != "" {
+		size, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || size <= 0 {
+			log.Fatalf("Некорректное значение BLOB_MAX_SIZE: %s", value)
+		}
+		cfg.MaxSize = size
+	}
+
+	Blobs = cfg
+}
@@ -45,10 +45,14 @@ func ConnectDatabase() {
 	DB = client
 }
 
-func GetCollection(collectionName string) *mongo.Collection {
+func GetDatabase() *mongo.Database {
 	dbName := os.Getenv("DATABASE_NAME")
 	if dbName == "" {
 		log.Fatal("DATABASE_NAME не установлен в .env")
 	}
-	return DB.Database(dbName).Collection(collectionName)
+	return DB.Database(dbName)
+}
+
+func GetCollection(collectionName string) *mongo.Collection {
+	return GetDatabase().Collection(collectionName)
 }
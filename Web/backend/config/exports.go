@@ -0,0 +1,42 @@
+package config
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"time"
+)
+
+// ExportConfig controls alert exports and reports.
+type ExportConfig struct {
+	// SyncLimit is the largest number of alerts exported in the request
+	// itself; larger exports run as background jobs.
+	SyncLimit int64
+	// TTL is how long the file of a finished job can be downloaded.
+	TTL time.Duration
+	// PDFFont is the path of a TrueType font with Cyrillic embedded into PDF
+	// reports. The standard PDF fonts have no Cyrillic, so without it
+	// reports are refused.
+	PDFFont string
+}
+
+var Exports *ExportConfig
+
+// LoadExportConfig reads EXPORT_SYNC_LIMIT, the EXPORT_TTL duration and
+// EXPORT_PDF_FONT.
+func LoadExportConfig() {
+	cfg := &ExportConfig{
+		SyncLimit: 1000,
+		TTL:       durationEnv("EXPORT_TTL", 24*time.Hour),
+		PDFFont:   os.Getenv("EXPORT_PDF_FONT"),
+	}
+	if value := os.Getenv("EXPORT_SYNC_LIMIT"); value != "" {
+		limit, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || limit < 0 {
+			log.Fatalf("Некорректное значение EXPORT_SYNC_LIMIT: %s", value)
+		}
+		cfg.SyncLimit = limit
+	}
+
+	Exports = cfg
+}
@@ -0,0 +1,19 @@
+package config
+
+import "time"
+
+// IncidentConfig tunes alert correlation.
+type IncidentConfig struct {
+	// Window is how long after its last alert an incident still absorbs
+	// new alerts.
+	Window time.Duration
+}
+
+var Incidents *IncidentConfig
+
+// LoadIncidentConfig reads the INCIDENT_WINDOW duration.
+func LoadIncidentConfig() {
+	Incidents = &IncidentConfig{
+		Window: durationEnv("INCIDENT_WINDOW", 2*time.Minute),
+	}
+}
@@ -0,0 +1,75 @@
+package config
+
+import (
+	"log"
+	"os"
+	"time"
+)
+
+// Mailer drivers.
+const (
+	MailerSMTP = "smtp"
+	MailerFile = "file"
+	MailerLog  = "log"
+)
+
+// MailerConfig configures outgoing email and the links put into it.
+type MailerConfig struct {
+	Driver       string
+	SMTPHost     string
+	SMTPPort     string
+	SMTPUsername string
+	SMTPPassword string
+	From         string
+	// FilePath is where the file driver appends messages.
+	FilePath string
+	// AppURL is the frontend base URL used in invitation and reset links.
+	AppURL string
+
+	InviteTTL        time.Duration
+	PasswordResetTTL time.Duration
+	EmailChangeTTL   time.Duration
+}
+
+var Mailer *MailerConfig
+
+// LoadMailerConfig reads MAILER_DRIVER (smtp, file or log), the SMTP_* server
+// settings, MAIL_FROM, MAILER_FILE, APP_URL and the INVITE_TTL,
+// PASSWORD_RESET_TTL and EMAIL_CHANGE_TTL durations. Without a driver mail is only logged.
+func LoadMailerConfig() {
+	cfg := &MailerConfig{
+		Driver:           os.Getenv("MAILER_DRIVER"),
+		SMTPHost:         os.Getenv("SMTP_HOST"),
+		SMTPPort:         os.Getenv("SMTP_PORT"),
+		SMTPUsername:     os.Getenv("SMTP_USERNAME"),
+		SMTPPassword:     os.Getenv("SMTP_PASSWORD"),
+		From:             os.Getenv("MAIL_FROM"),
+		FilePath:         os.Getenv("MAILER_FILE"),
+		AppURL:           os.Getenv("APP_URL"),
+		InviteTTL:        durationEnv("INVITE_TTL", 7*24*time.Hour),
+		PasswordResetTTL: durationEnv("PASSWORD_RESET_TTL", time.Hour),
+		EmailChangeTTL:   durationEnv("EMAIL_CHANGE_TTL", 24*time.Hour),
+	}
+	switch cfg.Driver {
+	case "":
+		cfg.Driver = MailerLog
+	case MailerSMTP:
+		if cfg.SMTPHost == "" {
+			log.Fatal("SMTP_HOST не установлен в .env")
+		}
+	case MailerFile, MailerLog:
+	default:
+		log.Fatal("Неподдерживаемый MAILER_DRIVER: ", cfg.Driver)
+	}
+	if cfg.SMTPPort == "" {
+		cfg.SMTPPort = "587"
+	}
+	if cfg.FilePath == "" {
+		cfg.FilePath = "mail.log"
+	}
+	if cfg.From == "" {
+		cfg.From = "no-reply@localhost"
+	}
+
+	Mailer = cfg
+}
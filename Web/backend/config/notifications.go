@@ -0,0 +1,34 @@
+package config
+
+import "os"
+
+// NotificationConfig holds the credentials of the push channels. A channel
+// is disabled when its settings are empty; email uses the mailer settings.
+type NotificationConfig struct {
+	TelegramAPIURL   string
+	TelegramBotToken string
+
+	SMSGatewayURL   string
+	SMSGatewayToken string
+	SMSSender       string
+}
+
+var Notifications *NotificationConfig
+
+// LoadNotificationConfig reads TELEGRAM_BOT_TOKEN, TELEGRAM_API_URL (for a
+// self-hosted Bot API server), SMS_GATEWAY_URL, SMS_GATEWAY_TOKEN and
+// SMS_SENDER.
+func LoadNotificationConfig() {
+	cfg := &NotificationConfig{
+		TelegramAPIURL:   os.Getenv("TELEGRAM_API_URL"),
+		TelegramBotToken: os.Getenv("TELEGRAM_BOT_TOKEN"),
+		SMSGatewayURL:    os.Getenv("SMS_GATEWAY_URL"),
+		SMSGatewayToken:  os.Getenv("SMS_GATEWAY_TOKEN"),
+		SMSSender:        os.Getenv("SMS_SENDER"),
+	}
+	if cfg.TelegramAPIURL == "" {
+		cfg.TelegramAPIURL = "https://api.telegram.org"
+	}
+
+	Notifications = cfg
+}
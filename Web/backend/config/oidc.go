@@ -0,0 +1,62 @@
+package config
+
+import (
+	"os"
+	"strings"
+)
+
+// OIDCConfig configures single sign-on through an OpenID Connect provider.
+type OIDCConfig struct {
+	IssuerURL    string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+	GroupsClaim  string
+	// GroupRoles maps IdP group names to roles.
+	GroupRoles  map[string]string
+	DefaultRole string
+	// OrganizationID is the organization auto-provisioned users join.
+	OrganizationID string
+}
+
+var OIDC *OIDCConfig
+
+// LoadOIDCConfig enables SSO when OIDC_ISSUER_URL is set. Group mapping is read
+// from OIDC_GROUP_ROLES as a comma-separated list of group=role pairs.
+func LoadOIDCConfig() {
+	issuer := os.Getenv("OIDC_ISSUER_URL")
+	if issuer == "" {
+		OIDC = nil
+		return
+	}
+
+	cfg := &OIDCConfig{
+		IssuerURL:      issuer,
+		ClientID:       os.Getenv("OIDC_CLIENT_ID"),
+		ClientSecret:   os.Getenv("OIDC_CLIENT_SECRET"),
+		RedirectURL:    os.Getenv("OIDC_REDIRECT_URL"),
+		Scopes:         []string{"openid", "profile", "email"},
+		GroupsClaim:    os.Getenv("OIDC_GROUPS_CLAIM"),
+		GroupRoles:     map[string]string{},
+		DefaultRole:    os.Getenv("OIDC_DEFAULT_ROLE"),
+		OrganizationID: os.Getenv("OIDC_ORGANIZATION_ID"),
+	}
+	if scopes := os.Getenv("OIDC_SCOPES"); scopes != "" {
+		cfg.Scopes = strings.Fields(strings.ReplaceAll(scopes, ",", " "))
+	}
+	if cfg.GroupsClaim == "" {
+		cfg.GroupsClaim = "groups"
+	}
+	if cfg.DefaultRole == "" {
+		cfg.DefaultRole = "viewer"
+	}
+	for _, pair := range strings.Split(os.Getenv("OIDC_GROUP_ROLES"), ",") {
+		group, role, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if ok {
+			cfg.GroupRoles[group] = role
+		}
+	}
+
+	OIDC = cfg
+}
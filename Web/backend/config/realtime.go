@@ -0,0 +1,36 @@
+package config
+
+import (
+	"log"
+	"os"
+)
+
+// Alert pub/sub drivers.
+const (
+	PubSubMemory       = "memory"
+	PubSubChangeStream = "changestream"
+)
+
+// RealtimeConfig selects how new alerts reach the live feeds of every
+// backend instance.
+type RealtimeConfig struct {
+	PubSub string
+}
+
+var Realtime *RealtimeConfig
+
+// LoadRealtimeConfig reads ALERT_PUBSUB: memory (default) for a single
+// instance, or changestream to follow the alerts collection, which requires
+// MongoDB to run as a replica set.
+func LoadRealtimeConfig() {
+	cfg := &RealtimeConfig{PubSub: os.Getenv("ALERT_PUBSUB")}
+	switch cfg.PubSub {
+	case "":
+		cfg.PubSub = PubSubMemory
+	case PubSubMemory, PubSubChangeStream:
+	default:
+		log.Fatal("Неподдерживаемый ALERT_PUBSUB: ", cfg.PubSub)
+	}
+
+	Realtime = cfg
+}
@@ -0,0 +1,76 @@
+package controllers
+
+import (
+	"net/http"
+
+	"backend/models"
+	"backend/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+var userService *services.UserService
+
+func InitAdminController() {
+	userService = services.NewUserService()
+}
+
+type UpdateRoleRequest struct {
+	Role models.Role `json:"role" binding:"required"`
+}
+
+func UpdateUserRole(c *gin.Context) {
+	scope, ok := accessScope(c)
+	if !ok {
+		return
+	}
+
+	var req UpdateRoleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, err := userService.UpdateRole(scope, c.Param("id"), req.Role)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, user)
+}
+
+type UpdateAccessRequest struct {
+	BuildingIDs []string `json:"building_ids"`
+	FloorIDs    []string `json:"floor_ids"`
+}
+
+// UpdateUserAccess replaces the building and floor grants of a user. Empty
+// lists give the user access to the whole organization again.
+func UpdateUserAccess(c *gin.Context) {
+	scope, ok := accessScope(c)
+	if !ok {
+		return
+	}
+
+	var req UpdateAccessRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, err := userService.UpdateAccess(scope, c.Param("id"), req.BuildingIDs, req.FloorIDs)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, user)
+}
+
+// GetRoles returns the permission matrix so clients can render role editors.
+func GetRoles(c *gin.Context) {
+	roles := gin.H{string(models.RoleAdmin): []string{"*"}}
+	for role, permissions := range models.RolePermissions {
+		roles[string(role)] = permissions
+	}
+	c.JSON(http.StatusOK, roles)
+}
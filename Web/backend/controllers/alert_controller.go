@@ -1,23 +1,38 @@
 package controllers
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
 	"backend/models"
 	"backend/services"
 
 	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"golang.org/x/net/websocket"
 )
 
 var alertService *services.AlertService
 
+// InitAlertController starts feeding new alerts of every instance to the
+// live feeds of this one.
 func InitAlertController() {
 	alertService = services.NewAlertService()
+	go alertService.RunPubSub(context.Background())
 }
 
+var errInvalidDate = errors.New("неверный формат даты")
+
+// ParseFilters reads the alert filters and pagination shared by the alert
+// list and its exports. Tags are normalized as when they are set.
 func ParseFilters(c *gin.Context) (services.AlertFilter, services.AlertPagination, error) {
 	var startDate, endDate time.Time
 	var err error
@@ -25,14 +40,14 @@ func ParseFilters(c *gin.Context) (services.AlertFilter, services.AlertPaginatio
 	if startDateStr := c.Query("start_date"); startDateStr != "" {
 		startDate, err = time.Parse(time.RFC3339, startDateStr)
 		if err != nil {
-			return services.AlertFilter{}, services.AlertPagination{}, err
+			return services.AlertFilter{}, services.AlertPagination{}, errInvalidDate
 		}
 	}
 
 	if endDateStr := c.Query("end_date"); endDateStr != "" {
 		endDate, err = time.Parse(time.RFC3339, endDateStr)
 		if err != nil {
-			return services.AlertFilter{}, services.AlertPagination{}, err
+			return services.AlertFilter{}, services.AlertPagination{}, errInvalidDate
 		}
 	}
 
@@ -41,10 +56,17 @@ func ParseFilters(c *gin.Context) (services.AlertFilter, services.AlertPaginatio
 	sortDesc, _ := strconv.ParseBool(c.Query("sort_desc"))
 
 	filter := services.AlertFilter{
-		CameraID:  c.Query("camera_id"),
-		Status:    c.Query("status"),
-		StartDate: startDate,
-		EndDate:   endDate,
+		CameraID:    c.Query("camera_id"),
+		Status:      c.Query("status"),
+		MinSeverity: c.Query("min_severity"),
+		StartDate:   startDate,
+		EndDate:     endDate,
+	}
+	if severity := c.Query("severity"); severity != "" {
+		filter.Severities = strings.Split(severity, ",")
+	}
+	if filter.Tags, err = services.NormalizeTags(queryList(c, "tag")); err != nil {
+		return services.AlertFilter{}, services.AlertPagination{}, err
 	}
 
 	pagination := services.AlertPagination{
@@ -58,13 +80,18 @@ func ParseFilters(c *gin.Context) (services.AlertFilter, services.AlertPaginatio
 }
 
 func GetAlerts(c *gin.Context) {
+	scope, ok := accessScope(c)
+	if !ok {
+		return
+	}
+
 	filter, pagination, err := ParseFilters(c)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "неверный формат даты"})
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	alerts, total, err := alertService.GetAlerts(filter, pagination)
+	alerts, total, err := alertService.GetAlerts(scope, filter, pagination)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -74,13 +101,30 @@ func GetAlerts(c *gin.Context) {
 }
 
 func CreateAlert(c *gin.Context) {
+	scope, ok := accessScope(c)
+	if !ok {
+		return
+	}
+
 	var alert models.Alert
 	if err := c.ShouldBindJSON(&alert); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	createdAlert, err := alertService.CreateAlert(&alert)
+	createdAlert, err := alertService.CreateAlert(scope, &alert)
+	if errors.Is(err, services.ErrAlertSuppressed) {
+		c.JSON(http.StatusOK, gin.H{"suppressed": true, "message": err.Error()})
+		return
+	}
+	if errors.Is(err, services.ErrUnknownAlertType) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if errors.Is(err, services.ErrAlertOutOfScope) {
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -89,6 +133,224 @@ func CreateAlert(c *gin.Context) {
 	c.JSON(http.StatusCreated, createdAlert)
 }
 
+func AcknowledgeAlert(c *gin.Context) {
+	changeAlertStatus(c, models.AuditAlertAck, alertService.AcknowledgeAlert)
+}
+
+type ResolveAlertRequest struct {
+	Resolution string `json:"resolution"`
+}
+
+// ResolveAlert accepts an optional body with the resolution: confirmed or
+// false_positive.
+func ResolveAlert(c *gin.Context) {
+	var req ResolveAlertRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	changeAlertStatus(c, models.AuditAlertResolve, func(scope services.AccessScope, id, username string) (*models.Alert, *models.Alert, error) {
+		return alertService.ResolveAlert(scope, id, username, req.Resolution)
+	})
+}
+
+// SetAlertFeedback gives or corrects the verdict on a resolved alert, which
+// replaces its training label. The body is the same as for ResolveAlert,
+// with a required resolution.
+func SetAlertFeedback(c *gin.Context) {
+	scope, ok := accessScope(c)
+	if !ok {
+		return
+	}
+
+	var req ResolveAlertRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	id := c.Param("id")
+	before, after, err := alertService.SetResolution(scope, id, c.GetString("username"), req.Resolution)
+	if err != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	auditChange(c, models.AuditAlertFeedback, "alert", id, before, after)
+
+	c.JSON(http.StatusOK, after)
+}
+
+func changeAlertStatus(c *gin.Context, action string, change func(services.AccessScope, string, string) (*models.Alert, *models.Alert, error)) {
+	scope, ok := accessScope(c)
+	if !ok {
+		return
+	}
+
+	id := c.Param("id")
+	before, after, err := change(scope, id, c.GetString("username"))
+	if err != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	auditChange(c, action, "alert", id, before, after)
+	if escalationService != nil {
+		if err := escalationService.StopEscalations(after.ID); err != nil {
+			log.Printf("Ошибка остановки эскалаций тревоги %s: %v", id, err)
+		}
+	}
+
+	c.JSON(http.StatusOK, after)
+}
+
 func HandleWebSocket(c *gin.Context) {
-	websocket.Handler(alertService.HandleWebSocket).ServeHTTP(c.Writer, c.Request)
+	scope, ok := accessScope(c)
+	if !ok {
+		return
+	}
+
+	filter, err := parseStreamFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	websocket.Handler(func(conn *websocket.Conn) {
+		alertService.HandleWebSocket(scope, filter, conn)
+	}).ServeHTTP(c.Writer, c.Request)
+}
+
+// parseStreamFilter reads the live feed filters: comma-separated building_id,
+// floor_id, camera_id, alert_type and severity lists and min_severity.
+func parseStreamFilter(c *gin.Context) (models.AlertStreamFilter, error) {
+	filter := models.AlertStreamFilter{MinSeverity: c.Query("min_severity")}
+	if filter.MinSeverity != "" && models.SeverityRank(filter.MinSeverity) == 0 {
+		return filter, errors.New("неизвестная важность: " + filter.MinSeverity)
+	}
+
+	ids := func(param string) ([]primitive.ObjectID, error) {
+		var ids []primitive.ObjectID
+		for _, value := range queryList(c, param) {
+			id, err := primitive.ObjectIDFromHex(value)
+			if err != nil {
+				return nil, errors.New("некорректный " + param)
+			}
+			ids = append(ids, id)
+		}
+		return ids, nil
+	}
+
+	var err error
+	if filter.BuildingIDs, err = ids("building_id"); err != nil {
+		return filter, err
+	}
+	if filter.FloorIDs, err = ids("floor_id"); err != nil {
+		return filter, err
+	}
+	if filter.CameraIDs, err = ids("camera_id"); err != nil {
+		return filter, err
+	}
+	for _, alertType := range queryList(c, "alert_type") {
+		filter.AlertTypes = append(filter.AlertTypes, models.AlertType(alertType))
+	}
+	filter.Severities = queryList(c, "severity")
+
+	return filter, nil
+}
+
+func queryList(c *gin.Context, param string) []string {
+	var values []string
+	for _, value := range strings.Split(c.Query(param), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// StreamAlerts serves new alerts as Server-Sent Events, carrying the same
+// alert events as the WebSocket. Each event id is the alert id; a client
+// reconnecting with it in Last-Event-ID first receives what it missed.
+func StreamAlerts(c *gin.Context) {
+	scope, ok := accessScope(c)
+	if !ok {
+		return
+	}
+
+	filter, err := parseStreamFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Subscribe before replaying so that no alert falls in between.
+	stream := alertService.StreamAlerts(scope, filter)
+	defer stream.Close()
+
+	header := c.Writer.Header()
+	header.Set("Content-Type", "text/event-stream")
+	header.Set("Cache-Control", "no-cache")
+	header.Set("Connection", "keep-alive")
+	header.Set("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+	fmt.Fprintf(c.Writer, "retry: %d\n\n", (5 * time.Second).Milliseconds())
+
+	replayed := make(map[primitive.ObjectID]bool)
+	if lastEventID := c.GetHeader("Last-Event-ID"); lastEventID != "" {
+		missed, err := alertService.MissedAlerts(scope, lastEventID)
+		if err != nil {
+			writeEvent(c.Writer, "", "resume_failed", gin.H{"error": err.Error()})
+		}
+		for i := range missed {
+			if !filter.Matches(&missed[i]) {
+				continue
+			}
+			if writeEvent(c.Writer, missed[i].ID.Hex(), "alert", &missed[i]) != nil {
+				return
+			}
+			replayed[missed[i].ID] = true
+		}
+	}
+	c.Writer.Flush()
+
+	heartbeat := time.NewTicker(services.HeartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case <-heartbeat.C:
+			if _, err := io.WriteString(c.Writer, ": heartbeat\n\n"); err != nil {
+				return
+			}
+		case alert, ok := <-stream.Alerts:
+			if !ok {
+				return
+			}
+			if replayed[alert.ID] {
+				continue
+			}
+			if writeEvent(c.Writer, alert.ID.Hex(), "alert", alert) != nil {
+				return
+			}
+		}
+		c.Writer.Flush()
+	}
+}
+
+func writeEvent(w io.Writer, id, event string, data interface{}) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	if id != "" {
+		if _, err := fmt.Fprintf(w, "id: %s\n", id); err != nil {
+			return err
+		}
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, payload)
+	return err
 }
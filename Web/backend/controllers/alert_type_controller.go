@@ -0,0 +1,64 @@
+package controllers
+
+import (
+	"net/http"
+
+	"backend/models"
+	"backend/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+var alertTypeService *services.AlertTypeService
+
+func InitAlertTypeController() {
+	alertTypeService = services.NewAlertTypeService()
+}
+
+func GetAlertTypes(c *gin.Context) {
+	scope, ok := accessScope(c)
+	if !ok {
+		return
+	}
+
+	types, err := alertTypeService.GetTypes(scope.OrganizationID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, types)
+}
+
+// SaveAlertType creates a custom type or overrides a built-in one.
+func SaveAlertType(c *gin.Context) {
+	scope, ok := accessScope(c)
+	if !ok {
+		return
+	}
+
+	var definition models.AlertTypeDefinition
+	if err := c.ShouldBindJSON(&definition); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	saved, err := alertTypeService.SaveType(scope, c.Param("key"), &definition)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, saved)
+}
+
+func DeleteAlertType(c *gin.Context) {
+	scope, ok := accessScope(c)
+	if !ok {
+		return
+	}
+
+	if err := alertTypeService.DeleteType(scope, c.Param("key")); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "тип тревоги удален"})
+}
@@ -0,0 +1,77 @@
+package controllers
+
+import (
+	"net/http"
+	"time"
+
+	"backend/models"
+	"backend/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+var apiKeyService *services.APIKeyService
+
+func InitAPIKeyController() {
+	apiKeyService = services.NewAPIKeyService()
+}
+
+type CreateAPIKeyRequest struct {
+	Name        string              `json:"name" binding:"required"`
+	Permissions []models.Permission `json:"permissions" binding:"required"`
+	ExpiresAt   *time.Time          `json:"expires_at"`
+}
+
+func GetAPIKeys(c *gin.Context) {
+	scope, ok := accessScope(c)
+	if !ok {
+		return
+	}
+
+	keys, err := apiKeyService.GetAPIKeys(scope)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, keys)
+}
+
+// CreateAPIKey returns the raw key in the response. It cannot be retrieved later.
+func CreateAPIKey(c *gin.Context) {
+	scope, ok := accessScope(c)
+	if !ok {
+		return
+	}
+
+	var req CreateAPIKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	key := &models.APIKey{
+		Name:        req.Name,
+		Permissions: req.Permissions,
+		ExpiresAt:   req.ExpiresAt,
+	}
+	raw, createdKey, err := apiKeyService.CreateAPIKey(scope, key, c.GetString("user_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"key": raw, "api_key": createdKey})
+}
+
+func RevokeAPIKey(c *gin.Context) {
+	scope, ok := accessScope(c)
+	if !ok {
+		return
+	}
+
+	if err := apiKeyService.RevokeAPIKey(scope, c.Param("id")); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "ключ отозван"})
+}
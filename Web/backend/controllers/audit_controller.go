@@ -0,0 +1,139 @@
+package controllers
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"backend/middleware"
+	"backend/models"
+	"backend/services"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var auditService *services.AuditService
+
+func InitAuditController() {
+	auditService = services.NewAuditService()
+}
+
+// recordAudit appends an entry for the current request. The caller's
+// identity, organization and IP are taken from the context unless the entry
+// already sets them. A failure to write the entry is logged, not returned, so
+// auditing never breaks the audited action.
+func recordAudit(c *gin.Context, entry models.AuditEntry) {
+	if auditService == nil {
+		return
+	}
+	if entry.UserID == "" {
+		entry.UserID = c.GetString("user_id")
+		if keyID := c.GetString("api_key_id"); entry.UserID == "" && keyID != "" {
+			entry.UserID = "apikey:" + keyID
+		}
+	}
+	if entry.Username == "" {
+		entry.Username = c.GetString("username")
+	}
+	if entry.OrganizationID.IsZero() {
+		entry.OrganizationID, _ = primitive.ObjectIDFromHex(c.GetString("organization_id"))
+	}
+	entry.IP = c.ClientIP()
+
+	if err := auditService.Record(&entry); err != nil {
+		log.Printf("Ошибка записи аудита %s: %v", entry.Action, err)
+	}
+}
+
+// auditChange records a successful change of a resource with its state
+// before and after.
+func auditChange(c *gin.Context, action, resourceType, resourceID string, before, after interface{}) {
+	entry := models.AuditEntry{
+		Action:       action,
+		ResourceType: resourceType,
+		ResourceID:   resourceID,
+		Success:      true,
+	}
+	if before != nil {
+		entry.Before = services.AuditSnapshot(before)
+	}
+	if after != nil {
+		entry.After = services.AuditSnapshot(after)
+	}
+	recordAudit(c, entry)
+}
+
+// auditScope is the caller's organization, or every organization when a
+// holder of audit:read_all asks for all=true.
+func auditScope(c *gin.Context) (services.AccessScope, bool) {
+	if c.Query("all") == "true" && middleware.HasPermission(c, models.PermAuditReadAll) {
+		return services.AccessScope{}, true
+	}
+	return accessScope(c)
+}
+
+// GetAuditLog lists audit entries of the current organization. Platform
+// operators can ask for every organization, including failed logins of
+// unknown users, which belong to none.
+func GetAuditLog(c *gin.Context) {
+	scope, ok := auditScope(c)
+	if !ok {
+		return
+	}
+
+	filter := services.AuditFilter{
+		Action:       c.Query("action"),
+		UserID:       c.Query("user_id"),
+		Username:     c.Query("username"),
+		ResourceType: c.Query("resource_type"),
+		ResourceID:   c.Query("resource_id"),
+	}
+	if success := c.Query("success"); success != "" {
+		value, err := strconv.ParseBool(success)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "некорректное значение success"})
+			return
+		}
+		filter.Success = &value
+	}
+	var err error
+	if from := c.Query("from"); from != "" {
+		if filter.From, err = time.Parse(time.RFC3339, from); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "неверный формат даты"})
+			return
+		}
+	}
+	if to := c.Query("to"); to != "" {
+		if filter.To, err = time.Parse(time.RFC3339, to); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "неверный формат даты"})
+			return
+		}
+	}
+
+	page, _ := strconv.ParseInt(c.Query("page"), 10, 64)
+	pageSize, _ := strconv.ParseInt(c.Query("page_size"), 10, 64)
+
+	entries, total, err := auditService.GetEntries(scope, filter, page, pageSize)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"entries": entries, "total": total})
+}
+
+// VerifyAuditLog checks the hash chain of the whole log.
+func VerifyAuditLog(c *gin.Context) {
+	scope, ok := auditScope(c)
+	if !ok {
+		return
+	}
+
+	result, err := auditService.Verify(scope)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, result)
+}
@@ -1,23 +1,36 @@
 package controllers
 
 import (
+	"errors"
+	"log"
 	"net/http"
 	"time"
 
+	"backend/config"
 	"backend/models"
 	"backend/services"
 
 	"github.com/gin-gonic/gin"
-	"github.com/golang-jwt/jwt"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 type AuthController struct {
-	authService *services.AuthService
+	authService  *services.AuthService
+	tokenService *services.TokenService
+	oidcService  *services.OIDCService
+	mfaService   *services.MFAService
+	loginLimiter *services.LoginLimiter
+	userService  *services.UserService
 }
 
 func NewAuthController() *AuthController {
 	return &AuthController{
-		authService: services.NewAuthService(),
+		authService:  services.NewAuthService(),
+		tokenService: services.NewTokenService(),
+		oidcService:  services.NewOIDCService(config.OIDC),
+		mfaService:   services.NewMFAService(),
+		loginLimiter: services.NewLoginLimiter(),
+		userService:  services.NewUserService(),
 	}
 }
 
@@ -27,12 +40,26 @@ type RegisterRequest struct {
 	Email    string `json:"email" binding:"required,email"`
 }
 
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token" binding:"required"`
+}
+
 type LoginRequest struct {
-	Username string `json:"username" binding:"required"`
-	Password string `json:"password" binding:"required"`
+	Username       string `json:"username" binding:"required"`
+	Password       string `json:"password" binding:"required"`
+	OrganizationID string `json:"organization_id"`
 }
 
 func (c *AuthController) Register(ctx *gin.Context) {
+	switch config.Auth.RegistrationMode {
+	case config.RegistrationDisabled:
+		ctx.JSON(http.StatusForbidden, gin.H{"error": "регистрация отключена"})
+		return
+	case config.RegistrationInvite:
+		ctx.JSON(http.StatusForbidden, gin.H{"error": "регистрация только по приглашению"})
+		return
+	}
+
 	var req RegisterRequest
 	if err := ctx.ShouldBindJSON(&req); err != nil {
 		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
@@ -43,7 +70,6 @@ func (c *AuthController) Register(ctx *gin.Context) {
 		Username: req.Username,
 		Password: req.Password,
 		Email:    req.Email,
-		Role:     "user",
 	}
 
 	registeredUser, err := c.authService.Register(user)
@@ -62,28 +88,304 @@ func (c *AuthController) Login(ctx *gin.Context) {
 		return
 	}
 
+	if !c.throttleLogin(ctx, req.Username) {
+		return
+	}
+
 	user, err := c.authService.Login(req.Username, req.Password)
 	if err != nil {
+		c.recordLoginFailure(req.Username, ctx.ClientIP())
+		c.auditLogin(ctx, nil, req.Username, primitive.NilObjectID, "password")
 		ctx.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
 		return
 	}
 
-	// Создаем JWT токен
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"user_id":  user.ID.Hex(),
-		"username": user.Username,
-		"role":     user.Role,
-		"exp":      time.Now().Add(time.Hour * 24).Unix(),
-	})
+	orgID, err := services.ResolveOrganization(user, req.OrganizationID)
+	if err != nil {
+		ctx.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	}
+	c.auditLogin(ctx, user, user.Username, orgID, "password")
+
+	// The failures are cleared only when no second factor is pending, or
+	// the password alone would reset the budget of TOTP guesses.
+	if c.completeLogin(ctx, user, orgID) {
+		c.recordLoginSuccess(user.Username)
+	}
+}
+
+// auditLogin records a login attempt. user is nil when the attempt failed;
+// method names the factor that was checked. A failure without an
+// organization is listed in every organization of the named user.
+func (c *AuthController) auditLogin(ctx *gin.Context, user *models.User, username string, orgID primitive.ObjectID, method string) {
+	entry := models.AuditEntry{
+		Action:         models.AuditLoginFailure,
+		Username:       username,
+		OrganizationID: orgID,
+		ResourceType:   "login",
+		ResourceID:     method,
+	}
+	if user != nil {
+		entry.Action = models.AuditLoginSuccess
+		entry.UserID = user.ID.Hex()
+		entry.Success = true
+	} else if orgID.IsZero() && username != "" {
+		entry.OrganizationIDs = c.authService.MemberOrganizations(username)
+	}
+	recordAudit(ctx, entry)
+}
+
+// throttleLogin rejects locked usernames and IPs and otherwise waits out the
+// progressive delay earned by previous failures.
+func (c *AuthController) throttleLogin(ctx *gin.Context, username string) bool {
+	delay, err := c.loginLimiter.Check(username, ctx.ClientIP())
+	if errors.Is(err, services.ErrLoginLocked) {
+		ctx.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+		return false
+	}
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return false
+	}
+
+	time.Sleep(delay)
+	return true
+}
+
+// recordLoginFailure counts a failed attempt. The attempt is rejected anyway,
+// so a storage error is only logged.
+func (c *AuthController) recordLoginFailure(username, ip string) {
+	if err := c.loginLimiter.RecordFailure(username, ip); err != nil {
+		log.Printf("Ошибка учета неудачного входа %s: %v", username, err)
+	}
+}
+
+func (c *AuthController) recordLoginSuccess(username string) {
+	if err := c.loginLimiter.RecordSuccess(username); err != nil {
+		log.Printf("Ошибка сброса счетчика неудачных входов %s: %v", username, err)
+	}
+}
+
+// UnlockUser clears the failed-login lockout of a member of the organization.
+func (c *AuthController) UnlockUser(ctx *gin.Context) {
+	scope, ok := accessScope(ctx)
+	if !ok {
+		return
+	}
+
+	user, err := c.authService.GetUserByID(ctx.Param("id"))
+	if err == nil {
+		_, err = services.ResolveOrganization(user, scope.OrganizationID.Hex())
+	}
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "пользователь не найден"})
+		return
+	}
+
+	if err := c.loginLimiter.Unlock(user.Username); err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"message": "учетная запись разблокирована"})
+}
+
+// GetLockouts lists the locked members of the organization.
+func (c *AuthController) GetLockouts(ctx *gin.Context) {
+	scope, ok := accessScope(ctx)
+	if !ok {
+		return
+	}
+
+	users, err := c.userService.GetUsers(scope)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	usernames := make([]string, len(users))
+	for i, user := range users {
+		usernames[i] = user.Username
+	}
 
-	tokenString, err := token.SignedString([]byte("your-secret-key")) // Замените на секретный ключ из конфигурации
+	lockouts, err := c.loginLimiter.GetLockouts(usernames)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, lockouts)
+}
+
+// completeLogin issues tokens, or an MFA token when the user has to pass or
+// enrol a second factor first. It reports whether tokens were issued.
+func (c *AuthController) completeLogin(ctx *gin.Context, user *models.User, orgID primitive.ObjectID) bool {
+	purpose := ""
+	if user.TOTPEnabled {
+		purpose = services.MFAPurposeVerify
+	} else {
+		required, err := c.mfaService.RequiresMFA(orgID, user.RoleIn(orgID))
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return false
+		}
+		if required {
+			purpose = services.MFAPurposeEnroll
+		}
+	}
+
+	if purpose == "" {
+		return c.respondWithToken(ctx, user, orgID)
+	}
+
+	mfaToken, err := services.SignMFAToken(user.ID.Hex(), orgID, purpose)
 	if err != nil {
 		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "ошибка создания токена"})
+		return false
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"mfa_required":            purpose == services.MFAPurposeVerify,
+		"mfa_enrollment_required": purpose == services.MFAPurposeEnroll,
+		"mfa_token":               mfaToken,
+	})
+	return false
+}
+
+// OIDCLogin redirects the browser to the identity provider.
+func (c *AuthController) OIDCLogin(ctx *gin.Context) {
+	url, err := c.oidcService.AuthURL()
+	if err != nil {
+		ctx.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.Redirect(http.StatusFound, url)
+}
+
+// OIDCCallback finishes the SSO login and continues like Login does, with the
+// second factor the organization requires.
+func (c *AuthController) OIDCCallback(ctx *gin.Context) {
+	if errParam := ctx.Query("error"); errParam != "" {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": errParam})
+		return
+	}
+
+	user, err := c.oidcService.Callback(ctx.Query("state"), ctx.Query("code"))
+	if err != nil {
+		c.auditLogin(ctx, nil, "", primitive.NilObjectID, "oidc")
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+
+	orgID, err := services.ResolveOrganization(user, "")
+	if err != nil {
+		ctx.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	}
+	c.auditLogin(ctx, user, user.Username, orgID, "oidc")
+
+	c.completeLogin(ctx, user, orgID)
+}
+
+// SwitchOrganization issues a new token bound to another organization of the
+// user, after the second factor if that organization requires one.
+func (c *AuthController) SwitchOrganization(ctx *gin.Context) {
+	user, err := c.authService.GetUserByID(ctx.GetString("user_id"))
+	if err != nil {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+
+	orgID, err := services.ResolveOrganization(user, ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
 		return
 	}
 
+	c.completeLogin(ctx, user, orgID)
+}
+
+func (c *AuthController) respondWithToken(ctx *gin.Context, user *models.User, orgID primitive.ObjectID) bool {
+	tokens, err := c.tokenService.IssueTokens(user, orgID)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "ошибка создания токена"})
+		return false
+	}
+
 	ctx.JSON(http.StatusOK, gin.H{
-		"token": tokenString,
-		"user":  user,
+		"token":         tokens.AccessToken,
+		"refresh_token": tokens.RefreshToken,
+		"expires_in":    tokens.ExpiresIn,
+		"user":          user,
 	})
+	return true
+}
+
+// Refresh exchanges a refresh token for a new token pair.
+func (c *AuthController) Refresh(ctx *gin.Context) {
+	var req RefreshRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	tokens, err := c.tokenService.Refresh(req.RefreshToken)
+	if err != nil {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, tokens)
+}
+
+func (c *AuthController) Logout(ctx *gin.Context) {
+	var req RefreshRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := c.tokenService.Logout(req.RefreshToken); err != nil {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"message": "сеанс завершен"})
+}
+
+// RevokeSessions ends every session of the current user.
+func (c *AuthController) RevokeSessions(ctx *gin.Context) {
+	c.revokeSessions(ctx, ctx.GetString("user_id"))
+}
+
+// RevokeUserSessions ends every session of another member of the organization.
+func (c *AuthController) RevokeUserSessions(ctx *gin.Context) {
+	scope, ok := accessScope(ctx)
+	if !ok {
+		return
+	}
+
+	user, err := c.authService.GetUserByID(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if _, err := services.ResolveOrganization(user, scope.OrganizationID.Hex()); err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "пользователь не найден"})
+		return
+	}
+
+	c.revokeSessions(ctx, user.ID.Hex())
+}
+
+func (c *AuthController) revokeSessions(ctx *gin.Context, userID string) {
+	if err := c.tokenService.RevokeAllSessions(userID); err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"message": "все сеансы пользователя завершены"})
+}
+
+// JWKS publishes the public key used to sign access tokens.
+func (c *AuthController) JWKS(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, services.JWKS())
 }
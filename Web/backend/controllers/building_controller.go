@@ -16,7 +16,12 @@ func InitBuildingController() {
 }
 
 func GetAllBuildings(c *gin.Context) {
-	buildings, err := buildingService.GetAllBuildings()
+	scope, ok := accessScope(c)
+	if !ok {
+		return
+	}
+
+	buildings, err := buildingService.GetAllBuildings(scope)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -25,24 +30,35 @@ func GetAllBuildings(c *gin.Context) {
 }
 
 func CreateBuilding(c *gin.Context) {
+	scope, ok := accessScope(c)
+	if !ok {
+		return
+	}
+
 	var building models.Building
 	if err := c.ShouldBindJSON(&building); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	createdBuilding, err := buildingService.CreateBuilding(&building)
+	createdBuilding, err := buildingService.CreateBuilding(scope, &building)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	auditChange(c, models.AuditBuildingCreate, "building", createdBuilding.ID.Hex(), nil, createdBuilding)
 
 	c.JSON(http.StatusCreated, createdBuilding)
 }
 
 func GetBuildingByID(c *gin.Context) {
+	scope, ok := accessScope(c)
+	if !ok {
+		return
+	}
+
 	id := c.Param("id")
-	building, err := buildingService.GetBuildingByID(id)
+	building, err := buildingService.GetBuildingByID(scope, id)
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
 		return
@@ -51,6 +67,11 @@ func GetBuildingByID(c *gin.Context) {
 }
 
 func UpdateBuilding(c *gin.Context) {
+	scope, ok := accessScope(c)
+	if !ok {
+		return
+	}
+
 	id := c.Param("id")
 	var building models.Building
 	if err := c.ShouldBindJSON(&building); err != nil {
@@ -58,28 +79,42 @@ func UpdateBuilding(c *gin.Context) {
 		return
 	}
 
-	updatedBuilding, err := buildingService.UpdateBuilding(id, &building)
+	before, _ := buildingService.GetBuildingByID(scope, id)
+	updatedBuilding, err := buildingService.UpdateBuilding(scope, id, &building)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	auditChange(c, models.AuditBuildingUpdate, "building", id, before, updatedBuilding)
 
 	c.JSON(http.StatusOK, updatedBuilding)
 }
 
 func DeleteBuilding(c *gin.Context) {
+	scope, ok := accessScope(c)
+	if !ok {
+		return
+	}
+
 	id := c.Param("id")
-	err := buildingService.DeleteBuilding(id)
+	before, _ := buildingService.GetBuildingByID(scope, id)
+	err := buildingService.DeleteBuilding(scope, id)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	auditChange(c, models.AuditBuildingDelete, "building", id, before, nil)
 	c.JSON(http.StatusOK, gin.H{"message": "здание успешно удалено"})
 }
 
 func GetBuildingByCameraID(c *gin.Context) {
+	scope, ok := accessScope(c)
+	if !ok {
+		return
+	}
+
 	cameraID := c.Param("camera_id")
-	building, err := buildingService.GetBuildingByCameraID(cameraID)
+	building, err := buildingService.GetBuildingByCameraID(scope, cameraID)
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
 		return
@@ -7,6 +7,7 @@ import (
 	"backend/services"
 
 	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 var cameraService *services.CameraService
@@ -16,7 +17,12 @@ func InitCameraController() {
 }
 
 func GetCameras(c *gin.Context) {
-	cameras, err := cameraService.GetAllCameras()
+	scope, ok := accessScope(c)
+	if !ok {
+		return
+	}
+
+	cameras, err := cameraService.GetAllCameras(scope)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка при получении списка камер"})
 		return
@@ -25,28 +31,71 @@ func GetCameras(c *gin.Context) {
 }
 
 func CreateCamera(c *gin.Context) {
+	scope, ok := accessScope(c)
+	if !ok {
+		return
+	}
+
 	var camera models.Camera
 	if err := c.ShouldBindJSON(&camera); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Некорректный JSON"})
 		return
 	}
 
-	createdCamera, err := cameraService.CreateCamera(&camera)
+	createdCamera, err := cameraService.CreateCamera(scope, &camera)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка при создании камеры"})
 		return
 	}
+	auditChange(c, models.AuditCameraCreate, "camera", createdCamera.ID.Hex(), nil, createdCamera)
 
 	c.JSON(http.StatusCreated, createdCamera)
 }
 
+type CameraNeighborsRequest struct {
+	NeighborIDs []primitive.ObjectID `json:"neighbor_ids"`
+}
+
+// UpdateCameraNeighbors sets the cameras whose alerts are correlated with
+// this camera's alerts into incidents.
+func UpdateCameraNeighbors(c *gin.Context) {
+	scope, ok := accessScope(c)
+	if !ok {
+		return
+	}
+
+	var req CameraNeighborsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Некорректный JSON"})
+		return
+	}
+
+	id := c.Param("id")
+	before, _ := cameraService.GetCameraByID(scope, id)
+	camera, err := cameraService.SetNeighbors(scope, id, req.NeighborIDs)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	auditChange(c, models.AuditCameraUpdate, "camera", id, before, camera)
+
+	c.JSON(http.StatusOK, camera)
+}
+
 func DeleteCamera(c *gin.Context) {
+	scope, ok := accessScope(c)
+	if !ok {
+		return
+	}
+
 	id := c.Param("id")
-	err := cameraService.DeleteCamera(id)
+	before, _ := cameraService.GetCameraByID(scope, id)
+	err := cameraService.DeleteCamera(scope, id)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	auditChange(c, models.AuditCameraDelete, "camera", id, before, nil)
 
 	c.JSON(http.StatusOK, gin.H{"message": "Камера удалена"})
 }
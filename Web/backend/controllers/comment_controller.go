@@ -0,0 +1,230 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+
+	"backend/middleware"
+	"backend/models"
+	"backend/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+var commentService *services.CommentService
+
+// InitCommentController shares the alert pub/sub with comments and notifies
+// mentions. It must run after InitAlertController and
+// InitNotificationController.
+func InitCommentController() {
+	commentService = services.NewCommentService(alertService.PubSub, notificationService)
+}
+
+type AlertTagsRequest struct {
+	Tags []string `json:"tags"`
+}
+
+// SetAlertTags replaces the tags of an alert.
+func SetAlertTags(c *gin.Context) {
+	scope, ok := accessScope(c)
+	if !ok {
+		return
+	}
+
+	var req AlertTagsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	id := c.Param("id")
+	before, after, err := alertService.SetTags(scope, id, req.Tags)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	auditChange(c, models.AuditAlertTag, "alert", id, before, after)
+
+	c.JSON(http.StatusOK, after)
+}
+
+// GetAlertTags lists the tags in use, for suggestions and the tag filter of
+// GetAlerts.
+func GetAlertTags(c *gin.Context) {
+	scope, ok := accessScope(c)
+	if !ok {
+		return
+	}
+
+	tags, err := alertService.GetTags(scope)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, tags)
+}
+
+func GetAlertComments(c *gin.Context) {
+	scope, ok := accessScope(c)
+	if !ok {
+		return
+	}
+
+	comments, err := commentService.GetComments(scope, c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, comments)
+}
+
+type AlertCommentRequest struct {
+	Body string `json:"body"`
+	// ParentID answers a comment; the reply joins that comment's thread.
+	ParentID string `json:"parentId"`
+}
+
+// CreateAlertComment adds a comment to an alert. Members mentioned as
+// @username are notified.
+func CreateAlertComment(c *gin.Context) {
+	scope, ok := accessScope(c)
+	if !ok {
+		return
+	}
+
+	var req AlertCommentRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	comment, err := commentService.AddComment(scope, c.Param("id"), req.ParentID, callerID(c), c.GetString("username"), req.Body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, comment)
+}
+
+// UpdateAlertComment edits the caller's own comment.
+func UpdateAlertComment(c *gin.Context) {
+	scope, ok := accessScope(c)
+	if !ok {
+		return
+	}
+
+	var req AlertCommentRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	_, comment, err := commentService.EditComment(scope, c.Param("id"), c.Param("comment_id"), callerID(c), req.Body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, comment)
+}
+
+// DeleteAlertComment removes the text of the caller's own comment.
+func DeleteAlertComment(c *gin.Context) {
+	scope, ok := accessScope(c)
+	if !ok {
+		return
+	}
+
+	commentID := c.Param("comment_id")
+	before, after, err := commentService.DeleteComment(scope, c.Param("id"), commentID, callerID(c))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	auditChange(c, models.AuditAlertCommentDelete, "alert_comment", commentID, before, after)
+
+	c.Status(http.StatusNoContent)
+}
+
+func GetAlertAttachments(c *gin.Context) {
+	scope, ok := accessScope(c)
+	if !ok {
+		return
+	}
+
+	attachments, err := commentService.GetAttachments(scope, c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, attachments)
+}
+
+// UploadAlertAttachment stores the multipart field file on an alert, and on
+// the comment commentId when that field is set. Photos, videos and PDF
+// documents are accepted.
+func UploadAlertAttachment(c *gin.Context) {
+	scope, ok := accessScope(c)
+	if !ok {
+		return
+	}
+
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, commentService.MaxFileSize+1<<20)
+	header, err := c.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "файл не передан или слишком велик"})
+		return
+	}
+	file, err := header.Open()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	defer file.Close()
+
+	attachment, err := commentService.AddAttachment(scope, c.Param("id"), c.PostForm("commentId"), callerID(c), header.Filename, file)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, attachment)
+}
+
+func DownloadAlertAttachment(c *gin.Context) {
+	scope, ok := accessScope(c)
+	if !ok {
+		return
+	}
+
+	attachment, content, err := commentService.OpenAttachment(scope, c.Param("id"), c.Param("attachment_id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	defer content.Close()
+	recordAudit(c, models.AuditEntry{Action: models.AuditRecordingExport, ResourceType: "alert_attachment", ResourceID: attachment.ID.Hex(), Success: true})
+
+	c.DataFromReader(http.StatusOK, attachment.Size, attachment.ContentType, content, map[string]string{
+		"Content-Disposition":    fmt.Sprintf("inline; filename=%q", attachment.FileName),
+		"X-Content-Type-Options": "nosniff",
+	})
+}
+
+// DeleteAlertAttachment removes an attachment uploaded by the caller, or any
+// attachment for user managers.
+func DeleteAlertAttachment(c *gin.Context) {
+	scope, ok := accessScope(c)
+	if !ok {
+		return
+	}
+
+	attachmentID := c.Param("attachment_id")
+	manage := middleware.HasPermission(c, models.PermUsersManage)
+	attachment, err := commentService.DeleteAttachment(scope, c.Param("id"), attachmentID, callerID(c), manage)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	auditChange(c, models.AuditAlertAttachmentDelete, "alert_attachment", attachmentID, attachment, nil)
+
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,273 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"backend/models"
+	"backend/services"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var exportService *services.ExportService
+var reportService *services.ReportService
+var feedbackService *services.FeedbackService
+
+func InitExportController() {
+	exportService = services.NewExportService()
+	reportService = services.NewReportService()
+	feedbackService = services.NewFeedbackService()
+	go exportService.Run(context.Background())
+	go feedbackService.Run(context.Background())
+}
+
+// callerID identifies the caller, who owns export jobs and comments: the
+// user, or the API key for machine clients.
+func callerID(c *gin.Context) string {
+	if keyID := c.GetString("api_key_id"); c.GetString("user_id") == "" && keyID != "" {
+		return "apikey:" + keyID
+	}
+	return c.GetString("user_id")
+}
+
+// exportLocation reads the timezone in which exported times are written,
+// UTC by default.
+func exportLocation(c *gin.Context) (string, *time.Location, bool) {
+	timezone := c.DefaultQuery("timezone", "UTC")
+	loc, err := time.LoadLocation(timezone)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "неизвестный часовой пояс: " + timezone})
+		return "", nil, false
+	}
+	return timezone, loc, true
+}
+
+func exportDownloadURL(job *models.ExportJob) string {
+	return "/api/exports/" + job.ID.Hex() + "/download"
+}
+
+func attachment(c *gin.Context, format, fileName string) {
+	c.Header("Content-Type", services.ExportContentType(format))
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", fileName))
+}
+
+// ExportAlerts exports the alerts selected by the GetAlerts filters and sort
+// as format csv (default) or xlsx, with times in timezone. Exports of up to
+// the sync limit are returned directly; larger ones are queued and answered
+// with 202 and the job to poll.
+func ExportAlerts(c *gin.Context) {
+	scope, ok := accessScope(c)
+	if !ok {
+		return
+	}
+
+	filter, pagination, err := ParseFilters(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	format := c.DefaultQuery("format", models.ExportCSV)
+	if format != models.ExportCSV && format != models.ExportXLSX {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "неподдерживаемый формат экспорта: " + format})
+		return
+	}
+	timezone, loc, ok := exportLocation(c)
+	if !ok {
+		return
+	}
+
+	query := services.AlertQuery(scope, filter)
+	sort := services.AlertSort(pagination)
+	total, err := exportService.CountAlerts(query)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if total > exportService.SyncLimit {
+		job, err := exportService.CreateJob(scope, callerID(c), format, timezone, query, sort)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		recordAudit(c, models.AuditEntry{Action: models.AuditAlertExport, ResourceType: "alert", ResourceID: job.ID.Hex(), Success: true})
+		c.JSON(http.StatusAccepted, gin.H{"job": job, "download_url": exportDownloadURL(job)})
+		return
+	}
+
+	// The export is written in full before the response starts, so a
+	// failure is answered with an error instead of a truncated file.
+	var file bytes.Buffer
+	if _, err := exportService.WriteAlerts(c.Request.Context(), scope.OrganizationID, query, sort, format, loc, &file); err != nil {
+		log.Printf("Ошибка экспорта тревог: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "ошибка экспорта тревог"})
+		return
+	}
+
+	recordAudit(c, models.AuditEntry{Action: models.AuditAlertExport, ResourceType: "alert", Success: true})
+	attachment(c, format, services.ExportFileName(format, time.Now()))
+	c.Data(http.StatusOK, services.ExportContentType(format), file.Bytes())
+}
+
+func GetExportJobs(c *gin.Context) {
+	scope, ok := accessScope(c)
+	if !ok {
+		return
+	}
+
+	jobs, err := exportService.GetJobs(scope, callerID(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, jobs)
+}
+
+func GetExportJob(c *gin.Context) {
+	scope, ok := accessScope(c)
+	if !ok {
+		return
+	}
+
+	job, err := exportService.GetJob(scope, callerID(c), c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	response := gin.H{"job": job}
+	if job.Status == models.ExportDone {
+		response["download_url"] = exportDownloadURL(job)
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+func DownloadExport(c *gin.Context) {
+	scope, ok := accessScope(c)
+	if !ok {
+		return
+	}
+
+	job, err := exportService.GetJob(scope, callerID(c), c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	file, err := exportService.OpenJob(job)
+	if errors.Is(err, services.ErrExportNotReady) {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	if errors.Is(err, services.ErrBlobNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "файл экспорта удален"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer file.Close()
+
+	attachment(c, job.Format, job.FileName)
+	c.DataFromReader(http.StatusOK, job.Size, services.ExportContentType(job.Format), file, nil)
+}
+
+// GetAlertReport returns the PDF report of an alert, with times in timezone.
+func GetAlertReport(c *gin.Context) {
+	sendReport(c, "alert", "alert-", reportService.AlertReport)
+}
+
+// GetIncidentReport returns the PDF report of an incident and its alerts,
+// with times in timezone.
+func GetIncidentReport(c *gin.Context) {
+	sendReport(c, "incident", "incident-", reportService.IncidentReport)
+}
+
+func sendReport(c *gin.Context, resourceType, filePrefix string, render func(services.AccessScope, string, *time.Location) ([]byte, error)) {
+	scope, ok := accessScope(c)
+	if !ok {
+		return
+	}
+	_, loc, ok := exportLocation(c)
+	if !ok {
+		return
+	}
+
+	id := c.Param("id")
+	report, err := render(scope, id, loc)
+	if errors.Is(err, services.ErrReportFont) {
+		c.JSON(http.StatusNotImplemented, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	recordAudit(c, models.AuditEntry{Action: models.AuditAlertExport, ResourceType: resourceType, ResourceID: id, Success: true})
+	attachment(c, models.ExportPDF, filePrefix+id+".pdf")
+	c.Data(http.StatusOK, services.ExportContentType(models.ExportPDF), report)
+}
+
+// ExportTrainingManifest returns the dataset manifest of the clips labelled
+// by operators, for retraining the anomaly model: format jsonl (default) or
+// csv, filtered by camera_id, model_version and the start_date and end_date
+// of the clips.
+func ExportTrainingManifest(c *gin.Context) {
+	scope, ok := accessScope(c)
+	if !ok {
+		return
+	}
+
+	var filter services.TrainingFilter
+	if cameraID := c.Query("camera_id"); cameraID != "" {
+		id, err := primitive.ObjectIDFromHex(cameraID)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "некорректный camera_id"})
+			return
+		}
+		filter.CameraID = id
+	}
+	filter.ModelVersion = c.Query("model_version")
+	for param, date := range map[string]*time.Time{"start_date": &filter.StartDate, "end_date": &filter.EndDate} {
+		if value := c.Query(param); value != "" {
+			parsed, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "неверный формат даты"})
+				return
+			}
+			*date = parsed
+		}
+	}
+
+	format := c.DefaultQuery("format", services.ManifestJSONL)
+	contentType := "application/x-ndjson"
+	switch format {
+	case services.ManifestJSONL:
+	case services.ManifestCSV:
+		contentType = "text/csv; charset=utf-8"
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "неподдерживаемый формат манифеста: " + format})
+		return
+	}
+
+	// As with alerts, the manifest is written in full before the response, so
+	// that only a complete export is audited as successful.
+	var file bytes.Buffer
+	if _, err := feedbackService.WriteManifest(c.Request.Context(), scope, filter, format, &file); err != nil {
+		log.Printf("Ошибка экспорта манифеста обучения: %v", err)
+		recordAudit(c, models.AuditEntry{Action: models.AuditAlertExport, ResourceType: "training_manifest", Success: false})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "ошибка экспорта манифеста обучения"})
+		return
+	}
+
+	recordAudit(c, models.AuditEntry{Action: models.AuditAlertExport, ResourceType: "training_manifest", Success: true})
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", "training-"+time.Now().Format("20060102-150405")+"."+format))
+	c.Data(http.StatusOK, contentType, file.Bytes())
+}
@@ -16,7 +16,12 @@ func InitFloorController() {
 }
 
 func GetAllFloors(c *gin.Context) {
-	floors, err := floorService.GetAllFloors()
+	scope, ok := accessScope(c)
+	if !ok {
+		return
+	}
+
+	floors, err := floorService.GetAllFloors(scope)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -25,24 +30,35 @@ func GetAllFloors(c *gin.Context) {
 }
 
 func CreateFloor(c *gin.Context) {
+	scope, ok := accessScope(c)
+	if !ok {
+		return
+	}
+
 	var floor models.Floor
 	if err := c.ShouldBindJSON(&floor); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	createdFloor, err := floorService.CreateFloor(&floor)
+	createdFloor, err := floorService.CreateFloor(scope, &floor)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	auditChange(c, models.AuditFloorCreate, "floor", createdFloor.ID.Hex(), nil, createdFloor)
 
 	c.JSON(http.StatusCreated, createdFloor)
 }
 
 func GetFloorByID(c *gin.Context) {
+	scope, ok := accessScope(c)
+	if !ok {
+		return
+	}
+
 	id := c.Param("id")
-	floor, err := floorService.GetFloorByID(id)
+	floor, err := floorService.GetFloorByID(scope, id)
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
 		return
@@ -51,6 +67,11 @@ func GetFloorByID(c *gin.Context) {
 }
 
 func UpdateFloor(c *gin.Context) {
+	scope, ok := accessScope(c)
+	if !ok {
+		return
+	}
+
 	id := c.Param("id")
 	var floor models.Floor
 	if err := c.ShouldBindJSON(&floor); err != nil {
@@ -58,21 +79,30 @@ func UpdateFloor(c *gin.Context) {
 		return
 	}
 
-	updatedFloor, err := floorService.UpdateFloor(id, &floor)
+	before, _ := floorService.GetFloorByID(scope, id)
+	updatedFloor, err := floorService.UpdateFloor(scope, id, &floor)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	auditChange(c, models.AuditFloorUpdate, "floor", id, before, updatedFloor)
 
 	c.JSON(http.StatusOK, updatedFloor)
 }
 
 func DeleteFloor(c *gin.Context) {
+	scope, ok := accessScope(c)
+	if !ok {
+		return
+	}
+
 	id := c.Param("id")
-	err := floorService.DeleteFloor(id)
+	before, _ := floorService.GetFloorByID(scope, id)
+	err := floorService.DeleteFloor(scope, id)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	auditChange(c, models.AuditFloorDelete, "floor", id, before, nil)
 	c.JSON(http.StatusOK, gin.H{"message": "этаж успешно удален"})
 }
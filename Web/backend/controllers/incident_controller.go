@@ -0,0 +1,87 @@
+package controllers
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+
+	"backend/models"
+	"backend/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+var incidentService *services.IncidentService
+
+func InitIncidentController() {
+	incidentService = services.NewIncidentService()
+}
+
+func GetIncidents(c *gin.Context) {
+	scope, ok := accessScope(c)
+	if !ok {
+		return
+	}
+
+	page, _ := strconv.ParseInt(c.Query("page"), 10, 64)
+	pageSize, _ := strconv.ParseInt(c.Query("page_size"), 10, 64)
+	filter := services.IncidentFilter{
+		Status:    c.Query("status"),
+		AlertType: c.Query("alert_type"),
+	}
+
+	incidents, total, err := incidentService.GetIncidents(scope, filter, services.AlertPagination{Page: page, PageSize: pageSize})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"incidents": incidents, "total": total})
+}
+
+func GetIncident(c *gin.Context) {
+	scope, ok := accessScope(c)
+	if !ok {
+		return
+	}
+
+	incident, alerts, err := incidentService.GetIncident(scope, c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"incident": incident, "alerts": alerts})
+}
+
+func AcknowledgeIncident(c *gin.Context) {
+	changeIncidentStatus(c, models.AuditIncidentAck, incidentService.AcknowledgeIncident)
+}
+
+func ResolveIncident(c *gin.Context) {
+	changeIncidentStatus(c, models.AuditIncidentResolve, incidentService.ResolveIncident)
+}
+
+func changeIncidentStatus(c *gin.Context, action string, change func(services.AccessScope, string, string) (*models.Incident, *models.Incident, error)) {
+	scope, ok := accessScope(c)
+	if !ok {
+		return
+	}
+
+	id := c.Param("id")
+	before, after, err := change(scope, id, c.GetString("username"))
+	if err != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	auditChange(c, action, "incident", id, before, after)
+	if escalationService != nil {
+		for _, alertID := range after.AlertIDs {
+			if err := escalationService.StopEscalations(alertID); err != nil {
+				log.Printf("Ошибка остановки эскалаций тревоги %s: %v", alertID.Hex(), err)
+			}
+		}
+	}
+
+	c.JSON(http.StatusOK, after)
+}
@@ -0,0 +1,220 @@
+package controllers
+
+import (
+	"net/http"
+
+	"backend/models"
+	"backend/services"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type MFALoginRequest struct {
+	MFAToken     string `json:"mfa_token" binding:"required"`
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+type MFACodeRequest struct {
+	MFAToken string `json:"mfa_token"`
+	Code     string `json:"code" binding:"required"`
+}
+
+type MFAEnrollRequest struct {
+	MFAToken string `json:"mfa_token"`
+}
+
+// LoginMFA is the second step of a login for users with 2FA enabled.
+func (c *AuthController) LoginMFA(ctx *gin.Context) {
+	var req MFALoginRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	userID, orgID, err := services.ParseMFAToken(req.MFAToken, services.MFAPurposeVerify)
+	if err != nil {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, err := c.authService.GetUserByID(userID)
+	if err != nil {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+	if !c.throttleLogin(ctx, user.Username) {
+		return
+	}
+
+	if err := c.mfaService.Verify(userID, req.Code, req.RecoveryCode); err != nil {
+		c.recordLoginFailure(user.Username, ctx.ClientIP())
+		recordAudit(ctx, models.AuditEntry{
+			Action:         models.AuditLoginFailure,
+			UserID:         userID,
+			Username:       user.Username,
+			OrganizationID: orgID,
+			ResourceType:   "login",
+			ResourceID:     "mfa",
+		})
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+	c.recordLoginSuccess(user.Username)
+	c.auditLogin(ctx, user, user.Username, orgID, "mfa")
+
+	c.respondWithToken(ctx, user, orgID)
+}
+
+// EnrollMFA starts 2FA enrolment, either for a logged-in user or for a user
+// whose role requires 2FA and who holds an enrolment MFA token.
+func (c *AuthController) EnrollMFA(ctx *gin.Context) {
+	var req MFAEnrollRequest
+	ctx.ShouldBindJSON(&req)
+
+	userID, _, ok := c.mfaUser(ctx, req.MFAToken)
+	if !ok {
+		return
+	}
+
+	secret, uri, err := c.mfaService.BeginEnrollment(userID)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"secret": secret, "provisioning_uri": uri})
+}
+
+// ConfirmMFA enables 2FA and returns the recovery codes. Users enrolling during
+// login also receive their tokens.
+func (c *AuthController) ConfirmMFA(ctx *gin.Context) {
+	var req MFACodeRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	userID, orgID, ok := c.mfaUser(ctx, req.MFAToken)
+	if !ok {
+		return
+	}
+
+	codes, err := c.mfaService.ConfirmEnrollment(userID, req.Code)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if req.MFAToken == "" {
+		ctx.JSON(http.StatusOK, gin.H{"recovery_codes": codes})
+		return
+	}
+
+	user, err := c.authService.GetUserByID(userID)
+	if err != nil {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+	tokens, err := c.tokenService.IssueTokens(user, orgID)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "ошибка создания токена"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"recovery_codes": codes,
+		"token":          tokens.AccessToken,
+		"refresh_token":  tokens.RefreshToken,
+		"expires_in":     tokens.ExpiresIn,
+		"user":           user,
+	})
+}
+
+func (c *AuthController) DisableMFA(ctx *gin.Context) {
+	var req MFACodeRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	orgID, _ := primitive.ObjectIDFromHex(ctx.GetString("organization_id"))
+	if err := c.mfaService.Disable(ctx.GetString("user_id"), orgID, req.Code); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"message": "двухфакторная аутентификация отключена"})
+}
+
+func (c *AuthController) RegenerateRecoveryCodes(ctx *gin.Context) {
+	var req MFACodeRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	codes, err := c.mfaService.RegenerateRecoveryCodes(ctx.GetString("user_id"), req.Code)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"recovery_codes": codes})
+}
+
+func (c *AuthController) GetSecuritySettings(ctx *gin.Context) {
+	scope, ok := accessScope(ctx)
+	if !ok {
+		return
+	}
+
+	settings, err := c.mfaService.GetSettings(scope.OrganizationID)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, settings)
+}
+
+func (c *AuthController) UpdateSecuritySettings(ctx *gin.Context) {
+	scope, ok := accessScope(ctx)
+	if !ok {
+		return
+	}
+
+	var settings models.SecuritySettings
+	if err := ctx.ShouldBindJSON(&settings); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	updated, err := c.mfaService.UpdateSettings(scope.OrganizationID, &settings)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, updated)
+}
+
+// mfaUser identifies the user either by an enrolment MFA token or, on
+// authenticated routes, by the access token.
+func (c *AuthController) mfaUser(ctx *gin.Context, mfaToken string) (string, primitive.ObjectID, bool) {
+	if mfaToken != "" {
+		userID, orgID, err := services.ParseMFAToken(mfaToken, services.MFAPurposeEnroll)
+		if err != nil {
+			ctx.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return "", primitive.NilObjectID, false
+		}
+		return userID, orgID, true
+	}
+
+	userID := ctx.GetString("user_id")
+	if userID == "" {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "отсутствует токен авторизации"})
+		return "", primitive.NilObjectID, false
+	}
+	orgID, _ := primitive.ObjectIDFromHex(ctx.GetString("organization_id"))
+	return userID, orgID, true
+}
@@ -0,0 +1,82 @@
+package controllers
+
+import (
+	"net/http"
+
+	"backend/middleware"
+	"backend/models"
+	"backend/services"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var notificationService *services.NotificationService
+
+// InitNotificationController registers alert pushes as an alert hook.
+func InitNotificationController() {
+	notificationService = services.NewNotificationService()
+	services.RegisterAlertHook(notificationService.NotifyAlert)
+}
+
+// currentMember returns the user and organization of the session. It writes a
+// 403 response for API keys and sessions without an organization.
+func currentMember(c *gin.Context) (primitive.ObjectID, primitive.ObjectID, bool) {
+	userID, errUser := primitive.ObjectIDFromHex(c.GetString("user_id"))
+	orgID, errOrg := primitive.ObjectIDFromHex(c.GetString("organization_id"))
+	if errUser != nil || errOrg != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "доступно только пользователям организации"})
+		return primitive.NilObjectID, primitive.NilObjectID, false
+	}
+	return userID, orgID, true
+}
+
+func GetNotificationPreferences(c *gin.Context) {
+	userID, orgID, ok := currentMember(c)
+	if !ok {
+		return
+	}
+
+	prefs, err := notificationService.GetPreferences(userID, orgID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, prefs)
+}
+
+func UpdateNotificationPreferences(c *gin.Context) {
+	userID, orgID, ok := currentMember(c)
+	if !ok {
+		return
+	}
+
+	var prefs models.NotificationPreferences
+	if err := c.ShouldBindJSON(&prefs); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	updated, err := notificationService.UpdatePreferences(userID, orgID, &prefs)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, updated)
+}
+
+// GetAlertNotifications returns who was notified about an alert and how.
+// Contact addresses are only shown to user managers.
+func GetAlertNotifications(c *gin.Context) {
+	scope, ok := accessScope(c)
+	if !ok {
+		return
+	}
+
+	notifications, err := notificationService.GetAlertNotifications(scope, c.Param("id"), middleware.HasPermission(c, models.PermUsersManage))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, notifications)
+}
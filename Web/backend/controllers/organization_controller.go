@@ -0,0 +1,80 @@
+package controllers
+
+import (
+	"net/http"
+
+	"backend/models"
+	"backend/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	organizationService *services.OrganizationService
+	organizationUsers   *services.AuthService
+)
+
+func InitOrganizationController() {
+	organizationService = services.NewOrganizationService()
+	organizationUsers = services.NewAuthService()
+}
+
+// GetOrganizations returns the organizations the current user belongs to.
+func GetOrganizations(c *gin.Context) {
+	user, err := organizationUsers.GetUserByID(c.GetString("user_id"))
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+
+	organizations, err := organizationService.GetOrganizations(user.OrganizationIDs())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, organizations)
+}
+
+func CreateOrganization(c *gin.Context) {
+	var org models.Organization
+	if err := c.ShouldBindJSON(&org); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	createdOrg, err := organizationService.CreateOrganization(&org, c.GetString("user_id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, createdOrg)
+}
+
+// RemoveOrganizationMember ends the user's membership. Users join an
+// organization only by accepting an invitation.
+func RemoveOrganizationMember(c *gin.Context) {
+	if !requireCurrentOrganization(c) {
+		return
+	}
+
+	if err := organizationService.RemoveMember(c.Param("id"), c.Param("user_id")); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "пользователь удален из организации"})
+}
+
+// requireCurrentOrganization only lets members manage the organization their
+// session is bound to.
+func requireCurrentOrganization(c *gin.Context) bool {
+	scope, ok := accessScope(c)
+	if !ok {
+		return false
+	}
+	if scope.OrganizationID.Hex() != c.Param("id") {
+		c.JSON(http.StatusForbidden, gin.H{"error": "нет доступа к организации"})
+		return false
+	}
+	return true
+}
@@ -0,0 +1,39 @@
+package controllers
+
+import (
+	"net/http"
+
+	"backend/models"
+	"backend/services"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var scopeService *services.ScopeService
+
+func InitScope() {
+	scopeService = services.NewScopeService()
+}
+
+// accessScope builds the access scope of the current request from the values
+// AuthMiddleware copied out of the JWT and the user's building and floor
+// grants. API keys see their whole organization. It writes a 403 response and
+// returns false when the session is not bound to an organization.
+func accessScope(c *gin.Context) (services.AccessScope, bool) {
+	orgID, err := primitive.ObjectIDFromHex(c.GetString("organization_id"))
+	if err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "пользователь не состоит ни в одной организации"})
+		return services.AccessScope{}, false
+	}
+	if c.GetString("api_key_id") != "" {
+		return services.AccessScope{OrganizationID: orgID}, true
+	}
+
+	scope, err := scopeService.Resolve(orgID, c.GetString("user_id"), models.Role(c.GetString("role")))
+	if err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return services.AccessScope{}, false
+	}
+	return scope, true
+}
@@ -0,0 +1,82 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"backend/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+var alertStatsService *services.AlertStatsService
+
+func InitStatsController() {
+	alertStatsService = services.NewAlertStatsService()
+}
+
+// parseStatsQuery reads start_date and end_date (RFC 3339), granularity
+// (hour, day, week or month), timezone and group_by.
+func parseStatsQuery(c *gin.Context) (services.StatsQuery, bool) {
+	query := services.StatsQuery{
+		Granularity: c.Query("granularity"),
+		Timezone:    c.Query("timezone"),
+		GroupBy:     c.Query("group_by"),
+	}
+
+	var err error
+	if value := c.Query("start_date"); value != "" {
+		if query.StartDate, err = time.Parse(time.RFC3339, value); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "неверный формат даты"})
+			return query, false
+		}
+	}
+	if value := c.Query("end_date"); value != "" {
+		if query.EndDate, err = time.Parse(time.RFC3339, value); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "неверный формат даты"})
+			return query, false
+		}
+	}
+	return query, true
+}
+
+func respondWithStats(c *gin.Context, compute func(services.AccessScope, services.StatsQuery) (interface{}, error)) {
+	scope, ok := accessScope(c)
+	if !ok {
+		return
+	}
+	query, ok := parseStatsQuery(c)
+	if !ok {
+		return
+	}
+
+	stats, err := compute(scope, query)
+	if errors.Is(err, services.ErrStatsUnsupported) {
+		c.JSON(http.StatusNotImplemented, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, stats)
+}
+
+func GetAlertCounts(c *gin.Context) {
+	respondWithStats(c, func(scope services.AccessScope, query services.StatsQuery) (interface{}, error) {
+		return alertStatsService.AlertCounts(scope, query)
+	})
+}
+
+func GetAlertResponseTimes(c *gin.Context) {
+	respondWithStats(c, func(scope services.AccessScope, query services.StatsQuery) (interface{}, error) {
+		return alertStatsService.ResponseTimes(scope, query)
+	})
+}
+
+func GetFalsePositiveRates(c *gin.Context) {
+	respondWithStats(c, func(scope services.AccessScope, query services.StatsQuery) (interface{}, error) {
+		return alertStatsService.FalsePositiveRates(scope, query)
+	})
+}
@@ -35,6 +35,11 @@ func InitStreamController() {
 
 // HandleStreamWebSocket handles WebSocket connections for streaming RTSP camera feeds
 func HandleStreamWebSocket(c *gin.Context) {
+	scope, ok := accessScope(c)
+	if !ok {
+		return
+	}
+
 	cameraID := c.Query("cameraId")
 	if cameraID == "" {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Не указан идентификатор камеры"})
@@ -42,7 +47,7 @@ func HandleStreamWebSocket(c *gin.Context) {
 	}
 
 	// Get camera details
-	camera, err := streamService.GetCameraByID(cameraID)
+	camera, err := streamService.GetCameraByID(scope, cameraID)
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Камера не найдена"})
 		return
@@ -54,6 +59,13 @@ func HandleStreamWebSocket(c *gin.Context) {
 		return
 	}
 
+	recordAudit(c, models.AuditEntry{
+		Action:       models.AuditStreamView,
+		ResourceType: "camera",
+		ResourceID:   camera.ID.Hex(),
+		Success:      true,
+	})
+
 	// Upgrade to WebSocket
 	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
 	if err != nil {
@@ -0,0 +1,326 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+
+	"backend/models"
+	"backend/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// UserController serves user administration, self-service password and email
+// changes and the emailed invitation and password reset flows.
+type UserController struct {
+	userService    *services.UserService
+	accountService *services.AccountService
+	tokenService   *services.TokenService
+	loginLimiter   *services.LoginLimiter
+}
+
+func NewUserController() *UserController {
+	return &UserController{
+		userService:    services.NewUserService(),
+		accountService: services.NewAccountService(),
+		tokenService:   services.NewTokenService(),
+		loginLimiter:   services.NewLoginLimiter(),
+	}
+}
+
+type ChangeEmailRequest struct {
+	Email string `json:"email" binding:"required"`
+}
+
+type ConfirmEmailRequest struct {
+	Token string `json:"token" binding:"required"`
+}
+
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password" binding:"required"`
+	NewPassword     string `json:"new_password" binding:"required"`
+}
+
+type InviteRequest struct {
+	Email string      `json:"email" binding:"required"`
+	Role  models.Role `json:"role" binding:"required"`
+}
+
+type AcceptInvitationRequest struct {
+	Token    string `json:"token" binding:"required"`
+	Username string `json:"username" binding:"required"`
+	Password string `json:"password" binding:"required"`
+}
+
+type ForgotPasswordRequest struct {
+	Email string `json:"email" binding:"required"`
+}
+
+type ResetPasswordRequest struct {
+	Token    string `json:"token" binding:"required"`
+	Password string `json:"password" binding:"required"`
+}
+
+func (c *UserController) GetUsers(ctx *gin.Context) {
+	scope, ok := accessScope(ctx)
+	if !ok {
+		return
+	}
+
+	users, err := c.userService.GetUsers(scope)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, users)
+}
+
+func (c *UserController) GetUser(ctx *gin.Context) {
+	scope, ok := accessScope(ctx)
+	if !ok {
+		return
+	}
+
+	user, err := c.userService.GetUser(scope, ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(userErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, user)
+}
+
+// DisableUser blocks the user's membership in the current organization. Their
+// tokens for the organization stop working at once; the account and its
+// sessions in other organizations are not affected.
+func (c *UserController) DisableUser(ctx *gin.Context) {
+	c.setDisabled(ctx, true)
+}
+
+func (c *UserController) EnableUser(ctx *gin.Context) {
+	c.setDisabled(ctx, false)
+}
+
+func (c *UserController) setDisabled(ctx *gin.Context, disabled bool) {
+	scope, ok := accessScope(ctx)
+	if !ok {
+		return
+	}
+	if disabled && ctx.Param("id") == ctx.GetString("user_id") {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "нельзя отключить собственную учетную запись"})
+		return
+	}
+
+	user, err := c.userService.SetDisabled(scope, ctx.Param("id"), disabled)
+	if err != nil {
+		ctx.JSON(userErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, user)
+}
+
+// DeleteUser removes the user from the current organization. The account
+// itself is kept.
+func (c *UserController) DeleteUser(ctx *gin.Context) {
+	scope, ok := accessScope(ctx)
+	if !ok {
+		return
+	}
+	if ctx.Param("id") == ctx.GetString("user_id") {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "нельзя исключить себя из организации"})
+		return
+	}
+
+	if err := c.userService.DeleteUser(scope, ctx.Param("id")); err != nil {
+		ctx.JSON(userErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"message": "пользователь исключен из организации"})
+}
+
+// ChangePassword changes the caller's password and ends their other sessions.
+func (c *UserController) ChangePassword(ctx *gin.Context) {
+	var req ChangePasswordRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	userID := ctx.GetString("user_id")
+	if err := c.userService.ChangePassword(userID, req.CurrentPassword, req.NewPassword); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := c.tokenService.RevokeOtherSessions(userID, ctx.GetString("session_id")); err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"message": "пароль изменен"})
+}
+
+// ChangeEmail sends a confirmation link to the caller's new email. The email
+// changes when the link is opened.
+func (c *UserController) ChangeEmail(ctx *gin.Context) {
+	var req ChangeEmailRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := c.accountService.RequestEmailChange(ctx.GetString("user_id"), req.Email); err != nil {
+		ctx.JSON(userErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusAccepted, gin.H{"message": "на новый адрес отправлена ссылка для подтверждения"})
+}
+
+// ConfirmEmail applies an email change from a confirmation link.
+func (c *UserController) ConfirmEmail(ctx *gin.Context) {
+	var req ConfirmEmailRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, err := c.accountService.ConfirmEmailChange(req.Token)
+	if err != nil {
+		ctx.JSON(userErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, user)
+}
+
+func (c *UserController) GetInvitations(ctx *gin.Context) {
+	scope, ok := accessScope(ctx)
+	if !ok {
+		return
+	}
+
+	invitations, err := c.accountService.GetInvitations(scope)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, invitations)
+}
+
+func (c *UserController) CreateInvitation(ctx *gin.Context) {
+	scope, ok := accessScope(ctx)
+	if !ok {
+		return
+	}
+
+	var req InviteRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	invitation, err := c.accountService.Invite(scope, req.Email, req.Role, ctx.GetString("username"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusCreated, invitation)
+}
+
+func (c *UserController) RevokeInvitation(ctx *gin.Context) {
+	scope, ok := accessScope(ctx)
+	if !ok {
+		return
+	}
+
+	if err := c.accountService.RevokeInvitation(scope, ctx.Param("id")); err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"message": "приглашение отозвано"})
+}
+
+// AcceptInvitation creates the account of an invited user. It works in every
+// registration mode.
+func (c *UserController) AcceptInvitation(ctx *gin.Context) {
+	var req AcceptInvitationRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, err := c.accountService.AcceptInvitation(req.Token, req.Username, req.Password)
+	if err != nil {
+		ctx.JSON(userErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusCreated, user)
+}
+
+type JoinOrganizationRequest struct {
+	Token string `json:"token" binding:"required"`
+}
+
+// JoinOrganization accepts an invitation into another organization for the
+// logged-in user. The invitation must have been sent to the user's email.
+func (c *UserController) JoinOrganization(ctx *gin.Context) {
+	var req JoinOrganizationRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, err := c.accountService.JoinOrganization(req.Token, ctx.GetString("user_id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, user)
+}
+
+// ForgotPassword always answers the same way, whether or not the email is known.
+func (c *UserController) ForgotPassword(ctx *gin.Context) {
+	var req ForgotPasswordRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := c.accountService.RequestPasswordReset(req.Email); err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"message": "если адрес зарегистрирован, на него отправлена ссылка для сброса пароля"})
+}
+
+// ResetPassword sets a new password from a reset link, ends all sessions of
+// the user and lifts a login lockout.
+func (c *UserController) ResetPassword(ctx *gin.Context) {
+	var req ResetPasswordRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, err := c.accountService.ResetPassword(req.Token, req.Password)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := c.tokenService.RevokeAllSessions(user.ID.Hex()); err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if err := c.loginLimiter.Unlock(user.Username); err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"message": "пароль изменен"})
+}
+
+func userErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, services.ErrUserNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, services.ErrUserExists), errors.Is(err, services.ErrEmailInUse):
+		return http.StatusConflict
+	}
+	return http.StatusBadRequest
+}
@@ -0,0 +1,169 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+
+	"backend/models"
+	"backend/services"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var webhookService *services.WebhookService
+
+// InitWebhookController registers webhooks as an alert hook and starts the
+// delivery worker.
+func InitWebhookController() {
+	webhookService = services.NewWebhookService()
+	services.RegisterAlertHook(webhookService.EnqueueAlert)
+	go webhookService.Run(context.Background())
+}
+
+type WebhookRequest struct {
+	Name        string             `json:"name" binding:"required"`
+	URL         string             `json:"url" binding:"required"`
+	Enabled     *bool              `json:"enabled"`
+	AlertTypes  []models.AlertType `json:"alert_types"`
+	BuildingIDs []string           `json:"building_ids"`
+	Severities  []string           `json:"severities"`
+}
+
+func (r *WebhookRequest) webhook() (*models.Webhook, bool) {
+	webhook := &models.Webhook{
+		Name:       r.Name,
+		URL:        r.URL,
+		Enabled:    r.Enabled == nil || *r.Enabled,
+		AlertTypes: r.AlertTypes,
+		Severities: r.Severities,
+	}
+	for _, id := range r.BuildingIDs {
+		objID, err := primitive.ObjectIDFromHex(id)
+		if err != nil {
+			return nil, false
+		}
+		webhook.BuildingIDs = append(webhook.BuildingIDs, objID)
+	}
+	return webhook, true
+}
+
+func GetWebhooks(c *gin.Context) {
+	scope, ok := accessScope(c)
+	if !ok {
+		return
+	}
+
+	webhooks, err := webhookService.GetWebhooks(scope)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, webhooks)
+}
+
+// CreateWebhook returns the signing secret in the response. It cannot be
+// retrieved later.
+func CreateWebhook(c *gin.Context) {
+	scope, ok := accessScope(c)
+	if !ok {
+		return
+	}
+
+	var req WebhookRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	webhook, ok := req.webhook()
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "некорректный ID здания"})
+		return
+	}
+
+	secret, created, err := webhookService.CreateWebhook(scope, webhook)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"secret": secret, "webhook": created})
+}
+
+func UpdateWebhook(c *gin.Context) {
+	scope, ok := accessScope(c)
+	if !ok {
+		return
+	}
+
+	var req WebhookRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	webhook, ok := req.webhook()
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "некорректный ID здания"})
+		return
+	}
+
+	updated, err := webhookService.UpdateWebhook(scope, c.Param("id"), webhook)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, updated)
+}
+
+func DeleteWebhook(c *gin.Context) {
+	scope, ok := accessScope(c)
+	if !ok {
+		return
+	}
+
+	if err := webhookService.DeleteWebhook(scope, c.Param("id")); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "вебхук удален"})
+}
+
+// GetWebhookDeliveries is the delivery log, filterable by webhook_id and status.
+func GetWebhookDeliveries(c *gin.Context) {
+	getWebhookDeliveries(c, c.Query("status"))
+}
+
+// GetDeadLetters lists deliveries that exhausted their retries.
+func GetDeadLetters(c *gin.Context) {
+	getWebhookDeliveries(c, models.DeliveryDead)
+}
+
+func getWebhookDeliveries(c *gin.Context, status string) {
+	scope, ok := accessScope(c)
+	if !ok {
+		return
+	}
+
+	limit, _ := strconv.ParseInt(c.Query("limit"), 10, 64)
+	filter := services.WebhookDeliveryFilter{WebhookID: c.Query("webhook_id"), Status: status}
+
+	deliveries, err := webhookService.GetDeliveries(scope, filter, limit)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, deliveries)
+}
+
+func RetryWebhookDelivery(c *gin.Context) {
+	scope, ok := accessScope(c)
+	if !ok {
+		return
+	}
+
+	if err := webhookService.RetryDelivery(scope, c.Param("id")); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "доставка поставлена в очередь"})
+}
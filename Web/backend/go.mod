@@ -3,14 +3,17 @@ module backend
 go 1.24.0
 
 require (
+	github.com/coreos/go-oidc/v3 v3.11.0
 	github.com/deepch/vdk v0.0.27
+	github.com/google/uuid v1.6.0
+	github.com/gorilla/websocket v1.5.3
 	go.mongodb.org/mongo-driver v1.17.3
+	golang.org/x/oauth2 v0.21.0
 )
 
 require (
 	github.com/davecgh/go-spew v1.1.1 // indirect
-	github.com/google/uuid v1.6.0 // indirect
-	github.com/gorilla/websocket v1.5.3 // indirect
+	github.com/go-jose/go-jose/v4 v4.0.2 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
 )
 
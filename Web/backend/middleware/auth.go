@@ -4,12 +4,25 @@ import (
 	"net/http"
 	"strings"
 
+	"backend/services"
+
 	"github.com/gin-gonic/gin"
-	"github.com/golang-jwt/jwt"
 )
 
+// AuthMiddleware accepts either a Bearer JWT of a user or an API key of a
+// service account, passed as "Authorization: ApiKey <key>" or "X-API-Key".
+// The role of a user comes from their membership in the token's
+// organization as stored now, not from the token.
 func AuthMiddleware() gin.HandlerFunc {
+	apiKeyService := services.NewAPIKeyService()
+	tokenService := services.NewTokenService()
+
 	return func(c *gin.Context) {
+		if apiKey := c.GetHeader("X-API-Key"); apiKey != "" {
+			authenticateAPIKey(c, apiKeyService, apiKey)
+			return
+		}
+
 		authHeader := c.GetHeader("Authorization")
 		if authHeader == "" {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "отсутствует токен авторизации"})
@@ -18,34 +31,48 @@ func AuthMiddleware() gin.HandlerFunc {
 		}
 
 		parts := strings.Split(authHeader, " ")
+		if len(parts) == 2 && parts[0] == "ApiKey" {
+			authenticateAPIKey(c, apiKeyService, parts[1])
+			return
+		}
 		if len(parts) != 2 || parts[0] != "Bearer" {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "неверный формат токена"})
 			c.Abort()
 			return
 		}
 
-		tokenString := parts[1]
-		token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-			return []byte("your-secret-key"), nil
-		})
-
-		if err != nil || !token.Valid {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "недействительный токен"})
+		principal, err := tokenService.Authenticate(parts[1])
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
 			c.Abort()
 			return
 		}
 
-		claims, ok := token.Claims.(jwt.MapClaims)
-		if !ok {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "неверный формат токена"})
-			c.Abort()
-			return
+		c.Set("user_id", principal.User.ID.Hex())
+		c.Set("username", principal.User.Username)
+		c.Set("role", string(principal.Role))
+		c.Set("platform_operator", principal.User.PlatformOperator)
+		c.Set("session_id", principal.SessionID)
+		if !principal.OrganizationID.IsZero() {
+			c.Set("organization_id", principal.OrganizationID.Hex())
 		}
 
-		c.Set("user_id", claims["user_id"])
-		c.Set("username", claims["username"])
-		c.Set("role", claims["role"])
-
 		c.Next()
 	}
 }
+
+func authenticateAPIKey(c *gin.Context, apiKeyService *services.APIKeyService, raw string) {
+	key, err := apiKeyService.Authenticate(raw)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": services.ErrInvalidAPIKey.Error()})
+		c.Abort()
+		return
+	}
+
+	c.Set("api_key", key)
+	c.Set("api_key_id", key.ID.Hex())
+	c.Set("username", "apikey:"+key.Name)
+	c.Set("organization_id", key.OrganizationID.Hex())
+
+	c.Next()
+}
@@ -0,0 +1,42 @@
+package middleware
+
+import (
+	"net/http"
+
+	"backend/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RequirePermission rejects the request unless the user's role in the current
+// organization, or the permissions of the API key, grant the permission. It
+// must run after AuthMiddleware.
+func RequirePermission(permission models.Permission) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !HasPermission(c, permission) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "недостаточно прав"})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
+
+func HasPermission(c *gin.Context, permission models.Permission) bool {
+	if value, ok := c.Get("api_key"); ok {
+		key, ok := value.(*models.APIKey)
+		return ok && key.Can(permission)
+	}
+	if models.Role(c.GetString("role")).Can(permission) {
+		return true
+	}
+	if c.GetBool("platform_operator") {
+		for _, p := range models.PlatformPermissions {
+			if p == permission {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,38 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Purposes of single-use tokens sent to users by email.
+const (
+	AccountTokenInvite        = "invite"
+	AccountTokenPasswordReset = "password_reset"
+	AccountTokenEmailChange   = "email_change"
+)
+
+// AccountToken is an emailed invitation, password reset or email confirmation
+// link. Only the hash of the token is stored.
+type AccountToken struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Purpose   string             `bson:"purpose" json:"purpose"`
+	TokenHash string             `bson:"tokenHash" json:"-"`
+
+	// Email is the address the link was sent to. For email changes it is the
+	// new address, which becomes the user's once the link is opened.
+	Email string `bson:"email" json:"email"`
+
+	// UserID is set for password resets and email changes.
+	UserID primitive.ObjectID `bson:"userId,omitempty" json:"userId,omitempty"`
+
+	// Organization and role the invited user joins with.
+	OrganizationID primitive.ObjectID `bson:"organizationId,omitempty" json:"organizationId,omitempty"`
+	Role           Role               `bson:"role,omitempty" json:"role,omitempty"`
+	InvitedBy      string             `bson:"invitedBy,omitempty" json:"invitedBy,omitempty"`
+
+	CreatedAt time.Time  `bson:"createdAt" json:"createdAt"`
+	ExpiresAt time.Time  `bson:"expiresAt" json:"expiresAt"`
+	UsedAt    *time.Time `bson:"usedAt,omitempty" json:"usedAt,omitempty"`
+}
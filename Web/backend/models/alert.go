@@ -6,8 +6,10 @@ import (
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
+// AlertType is the key of a definition in the alert type catalog.
 type AlertType string
 
+// Keys of the built-in alert types, see BuiltinAlertTypes.
 const (
 	AlertTypeIntrusion  AlertType = "Intrusion"
 	AlertTypeFire       AlertType = "Fire"
@@ -15,10 +17,91 @@ const (
 	AlertTypeFight      AlertType = "Fight"
 )
 
+// Alert severities, from least to most urgent.
+const (
+	SeverityLow      = "low"
+	SeverityMedium   = "medium"
+	SeverityHigh     = "high"
+	SeverityCritical = "critical"
+)
+
+var severityRank = map[string]int{
+	SeverityLow:      1,
+	SeverityMedium:   2,
+	SeverityHigh:     3,
+	SeverityCritical: 4,
+}
+
+// SeverityRank orders severities for comparisons. Unknown or missing
+// severities rank lowest.
+func SeverityRank(severity string) int {
+	return severityRank[severity]
+}
+
+// Alert lifecycle. Alerts stored before statuses existed have none and are
+// treated as new.
+const (
+	AlertStatusNew          = "new"
+	AlertStatusAcknowledged = "acknowledged"
+	AlertStatusResolved     = "resolved"
+)
+
+// Outcomes an operator may record when resolving an alert.
+const (
+	AlertResolutionConfirmed     = "confirmed"
+	AlertResolutionFalsePositive = "false_positive"
+)
+
+// AlertClip is the video segment the model scored, as kept by the detector.
+// Path is where the detector stored it, relative to its clip storage.
+type AlertClip struct {
+	Path  string    `bson:"path" json:"path"`
+	Start time.Time `bson:"start" json:"start"`
+	End   time.Time `bson:"end" json:"end"`
+}
+
 type Alert struct {
 	ID            primitive.ObjectID `bson:"_id,omitempty" json:"id"`
 	AlertType     AlertType          `bson:"alert_type" json:"alert_type"`
+	Severity      string             `bson:"severity,omitempty" json:"severity,omitempty"`
+	Priority      int                `bson:"priority" json:"priority"` // SeverityRank(Severity), for sorting
+	Confidence    float64            `bson:"confidence,omitempty" json:"confidence,omitempty"`
+	ModelClass    string             `bson:"modelClass,omitempty" json:"modelClass,omitempty"`
+	ModelVersion  string             `bson:"modelVersion,omitempty" json:"modelVersion,omitempty"`
 	Source        string             `bson:"source" json:"source"`
+	CameraID      primitive.ObjectID `bson:"cameraId,omitempty" json:"cameraId,omitempty"`
+	BuildingID    primitive.ObjectID `bson:"buildingId,omitempty" json:"buildingId,omitempty"`
+	FloorID       primitive.ObjectID `bson:"floorId,omitempty" json:"floorId,omitempty"`
+	Location      *Point             `bson:"location,omitempty" json:"location,omitempty"`
+	ZoneID        primitive.ObjectID `bson:"zoneId,omitempty" json:"zoneId,omitempty"`
+	ZoneName      string             `bson:"zoneName,omitempty" json:"zoneName,omitempty"`
+	SnapshotURL   string             `bson:"snapshotUrl,omitempty" json:"snapshotUrl,omitempty"`
+	Clip          *AlertClip         `bson:"clip,omitempty" json:"clip,omitempty"`
 	StartDateTime time.Time          `bson:"start_datetime" json:"start_datetime"`
 	EndDateTime   time.Time          `bson:"end_datetime" json:"end_datetime"`
+
+	Status         string     `bson:"status" json:"status"`
+	AcknowledgedBy string     `bson:"acknowledgedBy,omitempty" json:"acknowledgedBy,omitempty"`
+	AcknowledgedAt *time.Time `bson:"acknowledgedAt,omitempty" json:"acknowledgedAt,omitempty"`
+	ResolvedBy     string     `bson:"resolvedBy,omitempty" json:"resolvedBy,omitempty"`
+	ResolvedAt     *time.Time `bson:"resolvedAt,omitempty" json:"resolvedAt,omitempty"`
+	Resolution     string     `bson:"resolution,omitempty" json:"resolution,omitempty"`
+	// LabeledBy and LabeledAt record the latest verdict, given on resolve or
+	// corrected later. LabelPending is set with the verdict and cleared once
+	// its training label is stored.
+	LabeledBy    string     `bson:"labeledBy,omitempty" json:"labeledBy,omitempty"`
+	LabeledAt    *time.Time `bson:"labeledAt,omitempty" json:"labeledAt,omitempty"`
+	LabelPending bool       `bson:"labelPending,omitempty" json:"-"`
+
+	// Tags are free-form lowercase labels set by operators, such as
+	// "police called" or "drill".
+	Tags []string `bson:"tags,omitempty" json:"tags,omitempty"`
+
+	IncidentID primitive.ObjectID `bson:"incidentId,omitempty" json:"incidentId,omitempty"`
+	// Correlated is set when the alert joined an open incident that was
+	// already announced: one still new whose severity the alert does not
+	// raise. Such alerts are not notified or escalated again.
+	Correlated bool `bson:"correlated,omitempty" json:"correlated,omitempty"`
+
+	OrganizationID primitive.ObjectID `bson:"organizationId" json:"organizationId"`
 }
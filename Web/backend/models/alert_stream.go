@@ -0,0 +1,34 @@
+package models
+
+import "go.mongodb.org/mongo-driver/bson/primitive"
+
+// AlertStreamFilter selects the alerts pushed to a live subscriber. Empty
+// conditions match every alert.
+type AlertStreamFilter struct {
+	BuildingIDs []primitive.ObjectID `json:"building_ids,omitempty"`
+	FloorIDs    []primitive.ObjectID `json:"floor_ids,omitempty"`
+	CameraIDs   []primitive.ObjectID `json:"camera_ids,omitempty"`
+	AlertTypes  []AlertType          `json:"alert_types,omitempty"`
+	Severities  []string             `json:"severities,omitempty"`
+	MinSeverity string               `json:"min_severity,omitempty"`
+}
+
+// Matches reports whether the alert meets every condition of the filter.
+func (f *AlertStreamFilter) Matches(alert *Alert) bool {
+	if len(f.BuildingIDs) > 0 && !containsObjectID(f.BuildingIDs, alert.BuildingID) {
+		return false
+	}
+	if len(f.FloorIDs) > 0 && !containsObjectID(f.FloorIDs, alert.FloorID) {
+		return false
+	}
+	if len(f.CameraIDs) > 0 && !containsObjectID(f.CameraIDs, alert.CameraID) {
+		return false
+	}
+	if len(f.AlertTypes) > 0 && !containsAlertType(f.AlertTypes, alert.AlertType) {
+		return false
+	}
+	if len(f.Severities) > 0 && !containsString(f.Severities, alert.Severity) {
+		return false
+	}
+	return SeverityRank(alert.Severity) >= SeverityRank(f.MinSeverity)
+}
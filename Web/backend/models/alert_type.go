@@ -0,0 +1,140 @@
+package models
+
+import (
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Notification policies of an alert type.
+const (
+	// NotifySubscribers pushes the alert to every user whose preferences match.
+	NotifySubscribers = "subscribers"
+	// NotifyOptIn pushes the alert only to users who listed the type in
+	// their preferences.
+	NotifyOptIn = "opt_in"
+	// NotifyNone never pushes the alert; it is only shown in the UI.
+	NotifyNone = "none"
+)
+
+// AlertTypeDefinition describes an alert type of the catalog. Detectors
+// refer to it by Key or by one of its ModelClasses.
+type AlertTypeDefinition struct {
+	ID                 primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
+	OrganizationID     primitive.ObjectID `bson:"organizationId,omitempty" json:"organizationId,omitempty"`
+	Key                AlertType          `bson:"key" json:"key"`
+	DisplayName        string             `bson:"displayName" json:"display_name"`
+	Icon               string             `bson:"icon,omitempty" json:"icon,omitempty"`
+	DefaultSeverity    string             `bson:"defaultSeverity" json:"default_severity"`
+	NotificationPolicy string             `bson:"notificationPolicy,omitempty" json:"notification_policy,omitempty"`
+	ModelClasses       []string           `bson:"modelClasses,omitempty" json:"model_classes,omitempty"`
+	Disabled           bool               `bson:"disabled" json:"disabled"`
+
+	// BuiltIn marks the types shipped with the backend. An organization may
+	// override them; deleting the override restores the built-in definition.
+	BuiltIn bool `bson:"-" json:"built_in"`
+}
+
+// BuiltinAlertTypes is the catalog every organization starts with.
+var BuiltinAlertTypes = []AlertTypeDefinition{
+	{
+		Key:             AlertTypeFire,
+		DisplayName:     "Пожар",
+		Icon:            "fire",
+		DefaultSeverity: SeverityCritical,
+		ModelClasses:    []string{"fire", "smoke"},
+		BuiltIn:         true,
+	},
+	{
+		Key:             AlertTypeFight,
+		DisplayName:     "Драка",
+		Icon:            "fight",
+		DefaultSeverity: SeverityHigh,
+		ModelClasses:    []string{"fight", "violence"},
+		BuiltIn:         true,
+	},
+	{
+		Key:             AlertTypeIntrusion,
+		DisplayName:     "Проникновение",
+		Icon:            "intrusion",
+		DefaultSeverity: SeverityHigh,
+		ModelClasses:    []string{"intrusion", "trespassing"},
+		BuiltIn:         true,
+	},
+	{
+		Key:             AlertTypeSuspicious,
+		DisplayName:     "Подозрительная активность",
+		Icon:            "suspicious",
+		DefaultSeverity: SeverityMedium,
+		ModelClasses:    []string{"suspicious", "loitering"},
+		BuiltIn:         true,
+	},
+}
+
+// MergeAlertTypes overlays an organization's definitions on the built-in
+// catalog. Overrides keep the built-in position; custom types follow.
+func MergeAlertTypes(custom []AlertTypeDefinition) []AlertTypeDefinition {
+	catalog := make([]AlertTypeDefinition, 0, len(BuiltinAlertTypes)+len(custom))
+	overridden := make(map[AlertType]bool, len(custom))
+	for _, builtin := range BuiltinAlertTypes {
+		definition := builtin
+		for _, c := range custom {
+			if c.Key == builtin.Key {
+				definition = c
+				definition.BuiltIn = true
+				overridden[c.Key] = true
+				break
+			}
+		}
+		catalog = append(catalog, definition)
+	}
+	for _, c := range custom {
+		if !overridden[c.Key] {
+			catalog = append(catalog, c)
+		}
+	}
+	return catalog
+}
+
+// FindAlertType returns the enabled definition with the key or, if key is
+// empty, the one mapping the model class. It returns nil if there is none.
+func FindAlertType(catalog []AlertTypeDefinition, key AlertType, modelClass string) *AlertTypeDefinition {
+	for i := range catalog {
+		definition := &catalog[i]
+		if definition.Disabled {
+			continue
+		}
+		if key != "" {
+			if definition.Key == key {
+				return definition
+			}
+		} else if modelClass != "" && definition.HasModelClass(modelClass) {
+			return definition
+		}
+	}
+	return nil
+}
+
+// HasModelClass reports whether the detector class maps to this type.
+// Classes are compared case-insensitively.
+func (d *AlertTypeDefinition) HasModelClass(class string) bool {
+	for _, c := range d.ModelClasses {
+		if strings.EqualFold(c, class) {
+			return true
+		}
+	}
+	return false
+}
+
+// Notifies reports whether alerts of this type are pushed to a user with the
+// given preferences.
+func (d *AlertTypeDefinition) Notifies(prefs *NotificationPreferences) bool {
+	switch d.NotificationPolicy {
+	case NotifyNone:
+		return false
+	case NotifyOptIn:
+		return containsAlertType(prefs.AlertTypes, d.Key)
+	default:
+		return true
+	}
+}
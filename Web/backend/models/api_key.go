@@ -0,0 +1,32 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// APIKey is a credential for a service account such as an inference node or an
+// access-control integration. Only a hash of the secret is stored.
+type APIKey struct {
+	ID             primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	OrganizationID primitive.ObjectID `bson:"organizationId" json:"organizationId"`
+	Name           string             `bson:"name" json:"name"`
+	Prefix         string             `bson:"prefix" json:"prefix"`
+	KeyHash        string             `bson:"keyHash" json:"-"`
+	Permissions    []Permission       `bson:"permissions" json:"permissions"`
+	CreatedBy      primitive.ObjectID `bson:"createdBy,omitempty" json:"createdBy,omitempty"`
+	CreatedAt      time.Time          `bson:"createdAt" json:"createdAt"`
+	ExpiresAt      *time.Time         `bson:"expiresAt,omitempty" json:"expiresAt,omitempty"`
+	LastUsedAt     *time.Time         `bson:"lastUsedAt,omitempty" json:"lastUsedAt,omitempty"`
+	RevokedAt      *time.Time         `bson:"revokedAt,omitempty" json:"revokedAt,omitempty"`
+}
+
+func (k *APIKey) Can(permission Permission) bool {
+	for _, p := range k.Permissions {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,63 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Audited actions.
+const (
+	AuditLoginSuccess          = "auth.login"
+	AuditLoginFailure          = "auth.login_failed"
+	AuditCameraCreate          = "camera.create"
+	AuditCameraUpdate          = "camera.update"
+	AuditCameraDelete          = "camera.delete"
+	AuditBuildingCreate        = "building.create"
+	AuditBuildingUpdate        = "building.update"
+	AuditBuildingDelete        = "building.delete"
+	AuditFloorCreate           = "floor.create"
+	AuditFloorUpdate           = "floor.update"
+	AuditFloorDelete           = "floor.delete"
+	AuditAlertAck              = "alert.acknowledge"
+	AuditAlertResolve          = "alert.resolve"
+	AuditAlertFeedback         = "alert.feedback"
+	AuditAlertExport           = "alert.export"
+	AuditAlertTag              = "alert.tag"
+	AuditAlertCommentDelete    = "alert.comment_delete"
+	AuditAlertAttachmentDelete = "alert.attachment_delete"
+	AuditIncidentAck           = "incident.acknowledge"
+	AuditIncidentResolve       = "incident.resolve"
+	AuditStreamView            = "stream.view"
+	AuditRecordingExport       = "recording.export"
+)
+
+// AuditEntry is one record of the append-only audit log. Entries form a hash
+// chain: Hash covers the entry's fields and the previous entry's hash, so a
+// modified or deleted entry breaks the chain from that point on.
+type AuditEntry struct {
+	Seq       int64     `bson:"_id" json:"seq"`
+	Timestamp time.Time `bson:"timestamp" json:"timestamp"`
+
+	OrganizationID primitive.ObjectID `bson:"organizationId,omitempty" json:"organizationId,omitempty"`
+	UserID         string             `bson:"userId,omitempty" json:"userId,omitempty"`
+	Username       string             `bson:"username" json:"username"`
+	IP             string             `bson:"ip" json:"ip"`
+
+	// OrganizationIDs are the organizations of the user a failed login was
+	// attempted for. Such entries are listed in each of them.
+	OrganizationIDs []primitive.ObjectID `bson:"organizationIds,omitempty" json:"organizationIds,omitempty"`
+
+	Action       string `bson:"action" json:"action"`
+	ResourceType string `bson:"resourceType,omitempty" json:"resourceType,omitempty"`
+	ResourceID   string `bson:"resourceId,omitempty" json:"resourceId,omitempty"`
+	Success      bool   `bson:"success" json:"success"`
+
+	// Before and After hold the JSON of the resource around the change.
+	Before json.RawMessage `bson:"before,omitempty" json:"before,omitempty"`
+	After  json.RawMessage `bson:"after,omitempty" json:"after,omitempty"`
+
+	PrevHash string `bson:"prevHash" json:"prevHash"`
+	Hash     string `bson:"hash" json:"hash"`
+}
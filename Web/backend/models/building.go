@@ -8,4 +8,6 @@ type Building struct {
 	Address string             `bson:"address" json:"address"`
 	Floors  []Floor            `bson:"floors" json:"floors"`
 	Matches bool               `bson:"matches,omitempty" json:"matches,omitempty"`
-}
\ No newline at end of file
+
+	OrganizationID primitive.ObjectID `bson:"organizationId" json:"organizationId"`
+}
@@ -29,4 +29,10 @@ type Camera struct {
 	RTSPUrl      string             `bson:"rtspUrl" json:"rtspUrl"`
 	RTSPUsername string             `bson:"rtspUsername" json:"rtspUsername"`
 	RTSPPassword string             `bson:"rtspPassword" json:"rtspPassword"`
+
+	// NeighborIDs are cameras whose alerts may belong to the same incident
+	// even on another floor. The relation is symmetric.
+	NeighborIDs []primitive.ObjectID `bson:"neighborIds,omitempty" json:"neighborIds,omitempty"`
+
+	OrganizationID primitive.ObjectID `bson:"organizationId" json:"organizationId"`
 }
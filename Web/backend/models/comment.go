@@ -0,0 +1,55 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// AlertComment is an operator's note on an alert. Replies point at the
+// first comment of their thread; threads are one level deep.
+type AlertComment struct {
+	ID             primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	AlertID        primitive.ObjectID `bson:"alertId" json:"alertId"`
+	OrganizationID primitive.ObjectID `bson:"organizationId" json:"organizationId"`
+	ParentID       primitive.ObjectID `bson:"parentId,omitempty" json:"parentId,omitempty"`
+	AuthorID       string             `bson:"authorId" json:"authorId"`
+	AuthorName     string             `bson:"authorName" json:"authorName"`
+	Body           string             `bson:"body" json:"body"`
+	// Mentions are the members named as @username in the body.
+	Mentions  []primitive.ObjectID `bson:"mentions,omitempty" json:"mentions,omitempty"`
+	CreatedAt time.Time            `bson:"createdAt" json:"createdAt"`
+	EditedAt  *time.Time           `bson:"editedAt,omitempty" json:"editedAt,omitempty"`
+	// Deleted comments keep their place in the thread without the body.
+	Deleted bool `bson:"deleted,omitempty" json:"deleted,omitempty"`
+}
+
+// AlertAttachment is a file, such as a photo from the scene, attached to an
+// alert and optionally to one of its comments. The file itself lives in the
+// blob store under Key.
+type AlertAttachment struct {
+	ID             primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	AlertID        primitive.ObjectID `bson:"alertId" json:"alertId"`
+	OrganizationID primitive.ObjectID `bson:"organizationId" json:"organizationId"`
+	CommentID      primitive.ObjectID `bson:"commentId,omitempty" json:"commentId,omitempty"`
+	FileName       string             `bson:"fileName" json:"fileName"`
+	ContentType    string             `bson:"contentType" json:"contentType"`
+	Size           int64              `bson:"size" json:"size"`
+	Key            string             `bson:"key" json:"-"`
+	UploadedBy     string             `bson:"uploadedBy" json:"uploadedBy"`
+	CreatedAt      time.Time          `bson:"createdAt" json:"createdAt"`
+}
+
+// Kinds of activity on existing alerts shown in the live feed.
+const (
+	ActivityComment = "comment"
+	ActivityTags    = "tags"
+)
+
+// AlertActivity is a change to an existing alert pushed to the WebSocket
+// feed: a new or edited comment, or the alert's new set of tags.
+type AlertActivity struct {
+	Type    string
+	Alert   *Alert
+	Comment *AlertComment
+}
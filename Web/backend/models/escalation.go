@@ -0,0 +1,70 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// EscalationStep notifies its users when the alert is still unacknowledged
+// DelaySeconds after the previous step, or after the alert for the first step.
+type EscalationStep struct {
+	DelaySeconds int                  `bson:"delaySeconds" json:"delay_seconds"`
+	UserIDs      []primitive.ObjectID `bson:"userIds" json:"user_ids"`
+}
+
+func (s EscalationStep) Delay() time.Duration {
+	return time.Duration(s.DelaySeconds) * time.Second
+}
+
+// EscalationPolicy applies to the alerts of its buildings and types. Empty
+// filter lists match every alert of the organization.
+type EscalationPolicy struct {
+	ID             primitive.ObjectID   `bson:"_id,omitempty" json:"id"`
+	OrganizationID primitive.ObjectID   `bson:"organizationId" json:"organizationId"`
+	Name           string               `bson:"name" json:"name"`
+	Enabled        bool                 `bson:"enabled" json:"enabled"`
+	BuildingIDs    []primitive.ObjectID `bson:"buildingIds,omitempty" json:"building_ids,omitempty"`
+	AlertTypes     []AlertType          `bson:"alertTypes,omitempty" json:"alert_types,omitempty"`
+	MinSeverity    string               `bson:"minSeverity,omitempty" json:"min_severity,omitempty"`
+	Steps          []EscalationStep     `bson:"steps" json:"steps"`
+	CreatedAt      time.Time            `bson:"createdAt" json:"createdAt"`
+}
+
+func (p *EscalationPolicy) Matches(alert *Alert) bool {
+	if len(p.AlertTypes) > 0 && !containsAlertType(p.AlertTypes, alert.AlertType) {
+		return false
+	}
+	if len(p.BuildingIDs) > 0 && !containsObjectID(p.BuildingIDs, alert.BuildingID) {
+		return false
+	}
+	return SeverityRank(alert.Severity) >= SeverityRank(p.MinSeverity)
+}
+
+// Escalation states.
+const (
+	EscalationActive    = "active"
+	EscalationCompleted = "completed"
+	EscalationStopped   = "stopped"
+)
+
+// Escalation is the scheduled progress of one policy for one alert. It is
+// stored so that pending steps survive restarts.
+type Escalation struct {
+	ID             primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	AlertID        primitive.ObjectID `bson:"alertId" json:"alertId"`
+	OrganizationID primitive.ObjectID `bson:"organizationId" json:"organizationId"`
+	PolicyID       primitive.ObjectID `bson:"policyId" json:"policyId"`
+	Status         string             `bson:"status" json:"status"`
+	NextStep       int                `bson:"nextStep" json:"nextStep"`
+	DueAt          time.Time          `bson:"dueAt" json:"dueAt"`
+	History        []EscalationEvent  `bson:"history" json:"history"`
+	CreatedAt      time.Time          `bson:"createdAt" json:"createdAt"`
+}
+
+// EscalationEvent records a step that fired.
+type EscalationEvent struct {
+	Step       int                  `bson:"step" json:"step"`
+	UserIDs    []primitive.ObjectID `bson:"userIds" json:"userIds"`
+	NotifiedAt time.Time            `bson:"notifiedAt" json:"notifiedAt"`
+}
@@ -0,0 +1,50 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Export file formats.
+const (
+	ExportCSV  = "csv"
+	ExportXLSX = "xlsx"
+	ExportPDF  = "pdf"
+)
+
+// Export job states.
+const (
+	ExportPending = "pending"
+	ExportRunning = "running"
+	ExportDone    = "done"
+	ExportFailed  = "failed"
+)
+
+// ExportJob is an alert export too large to produce within the request. It
+// is queued in MongoDB and written by whichever instance claims it to shared
+// storage, from which any instance serves the download.
+type ExportJob struct {
+	ID             primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	OrganizationID primitive.ObjectID `bson:"organizationId" json:"organizationId"`
+	UserID         string             `bson:"userId" json:"userId"`
+	Format         string             `bson:"format" json:"format"`
+	Timezone       string             `bson:"timezone" json:"timezone"`
+
+	// Query and Sort select the alerts, with the requester's access scope
+	// already applied.
+	Query bson.M `bson:"query" json:"-"`
+	Sort  bson.D `bson:"sort" json:"-"`
+
+	Status   string `bson:"status" json:"status"`
+	Rows     int64  `bson:"rows" json:"rows"`
+	FileName string `bson:"fileName" json:"fileName"`
+	Size     int64  `bson:"size,omitempty" json:"size,omitempty"`
+	Error    string `bson:"error,omitempty" json:"error,omitempty"`
+
+	CreatedAt  time.Time  `bson:"createdAt" json:"createdAt"`
+	LeaseUntil time.Time  `bson:"leaseUntil" json:"-"`
+	FinishedAt *time.Time `bson:"finishedAt,omitempty" json:"finishedAt,omitempty"`
+	ExpiresAt  *time.Time `bson:"expiresAt,omitempty" json:"expiresAt,omitempty"`
+}
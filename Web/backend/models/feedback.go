@@ -0,0 +1,41 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Training labels of the anomaly model, as the STEAD head is trained on.
+const (
+	LabelNormal  = 0
+	LabelAnomaly = 1
+)
+
+// AlertFeedback is the operator's verdict on an alert kept as a training
+// label: a confirmed alert is an anomaly, a false positive is normal
+// footage. There is one label per alert; correcting the verdict of the
+// resolved alert replaces it.
+type AlertFeedback struct {
+	ID             primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	AlertID        primitive.ObjectID `bson:"alertId" json:"alertId"`
+	OrganizationID primitive.ObjectID `bson:"organizationId" json:"organizationId"`
+	CameraID       primitive.ObjectID `bson:"cameraId,omitempty" json:"cameraId,omitempty"`
+	BuildingID     primitive.ObjectID `bson:"buildingId,omitempty" json:"buildingId,omitempty"`
+	FloorID        primitive.ObjectID `bson:"floorId,omitempty" json:"floorId,omitempty"`
+
+	AlertType    AlertType `bson:"alertType" json:"alertType"`
+	ModelClass   string    `bson:"modelClass,omitempty" json:"modelClass,omitempty"`
+	ModelVersion string    `bson:"modelVersion,omitempty" json:"modelVersion,omitempty"`
+	Score        float64   `bson:"score" json:"score"`
+	// Start and End bound the scored clip, or the alert when the detector
+	// kept no clip.
+	Start    time.Time `bson:"start" json:"start"`
+	End      time.Time `bson:"end" json:"end"`
+	ClipPath string    `bson:"clipPath,omitempty" json:"clipPath,omitempty"`
+
+	Resolution string    `bson:"resolution" json:"resolution"`
+	Label      int       `bson:"label" json:"label"`
+	LabeledBy  string    `bson:"labeledBy" json:"labeledBy"`
+	LabeledAt  time.Time `bson:"labeledAt" json:"labeledAt"`
+}
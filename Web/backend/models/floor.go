@@ -7,4 +7,6 @@ type Floor struct {
 	Name    string             `bson:"name" json:"name"`
 	Cameras []Camera           `bson:"cameras" json:"cameras"`
 	Matches bool               `bson:"matches,omitempty" json:"matches,omitempty"`
+
+	OrganizationID primitive.ObjectID `bson:"organizationId" json:"organizationId"`
 }
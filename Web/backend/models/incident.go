@@ -0,0 +1,35 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Incident groups the alerts of one event: alerts of the same type raised
+// close together in time by cameras on the same floor or neighbouring ones.
+// It shares the alert statuses.
+type Incident struct {
+	ID             primitive.ObjectID   `bson:"_id,omitempty" json:"id"`
+	OrganizationID primitive.ObjectID   `bson:"organizationId" json:"organizationId"`
+	AlertType      AlertType            `bson:"alert_type" json:"alert_type"`
+	Severity       string               `bson:"severity" json:"severity"`
+	Priority       int                  `bson:"priority" json:"priority"`
+	BuildingID     primitive.ObjectID   `bson:"buildingId,omitempty" json:"buildingId,omitempty"`
+	FloorID        primitive.ObjectID   `bson:"floorId,omitempty" json:"floorId,omitempty"`
+	CameraIDs      []primitive.ObjectID `bson:"cameraIds" json:"cameraIds"`
+	AlertIDs       []primitive.ObjectID `bson:"alertIds" json:"alertIds"`
+	AlertCount     int                  `bson:"alertCount" json:"alertCount"`
+	FirstAlertAt   time.Time            `bson:"firstAlertAt" json:"firstAlertAt"`
+	LastAlertAt    time.Time            `bson:"lastAlertAt" json:"lastAlertAt"`
+	// OpenKey names the floor, or the camera of alerts without a floor, while
+	// the incident takes new alerts. It is unique, so only one incident can
+	// be opened there at a time.
+	OpenKey string `bson:"openKey,omitempty" json:"-"`
+
+	Status         string     `bson:"status" json:"status"`
+	AcknowledgedBy string     `bson:"acknowledgedBy,omitempty" json:"acknowledgedBy,omitempty"`
+	AcknowledgedAt *time.Time `bson:"acknowledgedAt,omitempty" json:"acknowledgedAt,omitempty"`
+	ResolvedBy     string     `bson:"resolvedBy,omitempty" json:"resolvedBy,omitempty"`
+	ResolvedAt     *time.Time `bson:"resolvedAt,omitempty" json:"resolvedAt,omitempty"`
+}
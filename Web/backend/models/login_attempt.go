@@ -0,0 +1,15 @@
+package models
+
+import "time"
+
+// LoginAttempts counts consecutive failed logins for a username or client IP.
+type LoginAttempts struct {
+	Key           string     `bson:"_id" json:"key"`
+	Failures      int        `bson:"failures" json:"failures"`
+	LastFailureAt time.Time  `bson:"lastFailureAt" json:"lastFailureAt"`
+	LockedUntil   *time.Time `bson:"lockedUntil,omitempty" json:"lockedUntil,omitempty"`
+
+	// ExpiresAt is when the counter is dropped by the TTL index: the end of
+	// the failure window or of the lockout, whichever is later.
+	ExpiresAt time.Time `bson:"expiresAt" json:"-"`
+}
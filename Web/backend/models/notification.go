@@ -0,0 +1,112 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Notification channels.
+const (
+	ChannelEmail    = "email"
+	ChannelTelegram = "telegram"
+	ChannelSMS      = "sms"
+)
+
+// ChannelSubscription is a destination of a user on one channel: an email
+// address, a Telegram chat ID or a phone number.
+type ChannelSubscription struct {
+	Channel string `bson:"channel" json:"channel"`
+	Address string `bson:"address" json:"address"`
+	Enabled bool   `bson:"enabled" json:"enabled"`
+}
+
+// QuietHours is a daily window, possibly spanning midnight, in which only
+// critical alerts are pushed. Start and End are "HH:MM" in Timezone.
+type QuietHours struct {
+	Start    string `bson:"start" json:"start"`
+	End      string `bson:"end" json:"end"`
+	Timezone string `bson:"timezone,omitempty" json:"timezone,omitempty"`
+	// AllowCritical lets critical alerts through during quiet hours.
+	AllowCritical bool `bson:"allowCritical" json:"allowCritical"`
+}
+
+func (q *QuietHours) Contains(t time.Time) bool {
+	window := TimeWindow{Start: q.Start, End: q.End, Timezone: q.Timezone}
+	return window.Contains(t)
+}
+
+// TimeWindow is a daily window of "HH:MM" times in Timezone. A window whose
+// end is before its start spans midnight.
+type TimeWindow struct {
+	Start    string `bson:"start" json:"start"`
+	End      string `bson:"end" json:"end"`
+	Timezone string `bson:"timezone,omitempty" json:"timezone,omitempty"`
+}
+
+// Contains reports whether t falls inside the window. Malformed windows
+// contain nothing.
+func (w *TimeWindow) Contains(t time.Time) bool {
+	start, errStart := time.Parse("15:04", w.Start)
+	end, errEnd := time.Parse("15:04", w.End)
+	if errStart != nil || errEnd != nil {
+		return false
+	}
+	if w.Timezone != "" {
+		if loc, err := time.LoadLocation(w.Timezone); err == nil {
+			t = t.In(loc)
+		}
+	}
+
+	minute := t.Hour()*60 + t.Minute()
+	from := start.Hour()*60 + start.Minute()
+	to := end.Hour()*60 + end.Minute()
+	if from <= to {
+		return minute >= from && minute < to
+	}
+	return minute >= from || minute < to
+}
+
+// NotificationPreferences are the alert subscriptions of a user in one
+// organization. Empty filter lists match every alert.
+type NotificationPreferences struct {
+	ID             primitive.ObjectID    `bson:"_id,omitempty" json:"id"`
+	UserID         primitive.ObjectID    `bson:"userId" json:"userId"`
+	OrganizationID primitive.ObjectID    `bson:"organizationId" json:"organizationId"`
+	Channels       []ChannelSubscription `bson:"channels" json:"channels"`
+	AlertTypes     []AlertType           `bson:"alertTypes,omitempty" json:"alertTypes,omitempty"`
+	BuildingIDs    []primitive.ObjectID  `bson:"buildingIds,omitempty" json:"buildingIds,omitempty"`
+	MinSeverity    string                `bson:"minSeverity,omitempty" json:"minSeverity,omitempty"`
+	QuietHours     *QuietHours           `bson:"quietHours,omitempty" json:"quietHours,omitempty"`
+}
+
+// Matches reports whether the alert passes the subscription filters.
+func (p *NotificationPreferences) Matches(alert *Alert) bool {
+	if len(p.AlertTypes) > 0 && !containsAlertType(p.AlertTypes, alert.AlertType) {
+		return false
+	}
+	if len(p.BuildingIDs) > 0 && !containsObjectID(p.BuildingIDs, alert.BuildingID) {
+		return false
+	}
+	return SeverityRank(alert.Severity) >= SeverityRank(p.MinSeverity)
+}
+
+// Notification delivery states.
+const (
+	NotificationSent    = "sent"
+	NotificationFailed  = "failed"
+	NotificationSkipped = "skipped"
+)
+
+// Notification records one push of an alert to a user over a channel.
+type Notification struct {
+	ID             primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	AlertID        primitive.ObjectID `bson:"alertId" json:"alertId"`
+	OrganizationID primitive.ObjectID `bson:"organizationId" json:"organizationId"`
+	UserID         primitive.ObjectID `bson:"userId" json:"userId"`
+	Channel        string             `bson:"channel" json:"channel"`
+	Address        string             `bson:"address" json:"address,omitempty"`
+	Status         string             `bson:"status" json:"status"`
+	Error          string             `bson:"error,omitempty" json:"error,omitempty"`
+	CreatedAt      time.Time          `bson:"createdAt" json:"createdAt"`
+}
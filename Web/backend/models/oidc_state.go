@@ -0,0 +1,12 @@
+package models
+
+import "time"
+
+// OIDCState tracks an authorization request between the redirect to the
+// identity provider and the callback.
+type OIDCState struct {
+	State        string    `bson:"_id" json:"-"`
+	Nonce        string    `bson:"nonce" json:"-"`
+	CodeVerifier string    `bson:"codeVerifier" json:"-"`
+	ExpiresAt    time.Time `bson:"expiresAt" json:"-"`
+}
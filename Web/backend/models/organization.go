@@ -0,0 +1,13 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type Organization struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Name      string             `bson:"name" json:"name" binding:"required"`
+	CreatedAt time.Time          `bson:"createdAt" json:"createdAt"`
+}
@@ -0,0 +1,24 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// RefreshToken is a server-side record of an issued refresh token. Tokens of
+// one login share a SessionID; each refresh rotates the token inside the session.
+type RefreshToken struct {
+	ID             primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	SessionID      string             `bson:"sessionId" json:"sessionId"`
+	UserID         primitive.ObjectID `bson:"userId" json:"userId"`
+	OrganizationID primitive.ObjectID `bson:"organizationId,omitempty" json:"organizationId,omitempty"`
+	TokenHash      string             `bson:"tokenHash" json:"-"`
+	CreatedAt      time.Time          `bson:"createdAt" json:"createdAt"`
+	ExpiresAt      time.Time          `bson:"expiresAt" json:"expiresAt"`
+	RevokedAt      *time.Time         `bson:"revokedAt,omitempty" json:"revokedAt,omitempty"`
+
+	// SessionRevoked tells a logout or revocation apart from a rotation.
+	// Access tokens of a revoked session are rejected.
+	SessionRevoked bool `bson:"sessionRevoked,omitempty" json:"sessionRevoked,omitempty"`
+}
@@ -0,0 +1,100 @@
+package models
+
+type Role string
+
+const (
+	RoleAdmin      Role = "admin"
+	RoleSupervisor Role = "supervisor"
+	RoleOperator   Role = "operator"
+	RoleViewer     Role = "viewer"
+)
+
+type Permission string
+
+const (
+	PermBuildingsRead       Permission = "buildings:read"
+	PermBuildingsWrite      Permission = "buildings:write"
+	PermBuildingsDelete     Permission = "buildings:delete"
+	PermCamerasRead         Permission = "cameras:read"
+	PermCamerasWrite        Permission = "cameras:write"
+	PermCamerasDelete       Permission = "cameras:delete"
+	PermDetectionConfigure  Permission = "detection:configure"
+	PermAlertsRead          Permission = "alerts:read"
+	PermAlertsWrite         Permission = "alerts:write"
+	PermStreamsView         Permission = "streams:view"
+	PermRecordingsView      Permission = "recordings:view"
+	PermUsersManage         Permission = "users:manage"
+	PermOrganizationsManage Permission = "organizations:manage"
+	PermAuditRead           Permission = "audit:read"
+
+	// PermAuditReadAll reads the audit log of every organization. It is a
+	// platform permission: no role grants it.
+	PermAuditReadAll Permission = "audit:read_all"
+)
+
+var AllPermissions = []Permission{
+	PermBuildingsRead, PermBuildingsWrite, PermBuildingsDelete,
+	PermCamerasRead, PermCamerasWrite, PermCamerasDelete,
+	PermDetectionConfigure,
+	PermAlertsRead, PermAlertsWrite,
+	PermStreamsView, PermRecordingsView,
+	PermUsersManage, PermOrganizationsManage,
+	PermAuditRead,
+}
+
+func (p Permission) Valid() bool {
+	for _, known := range AllPermissions {
+		if p == known {
+			return true
+		}
+	}
+	return false
+}
+
+// RolePermissions is the permission matrix. Admins are granted every
+// permission in AllPermissions.
+var RolePermissions = map[Role][]Permission{
+	RoleSupervisor: {
+		PermBuildingsRead, PermBuildingsWrite,
+		PermCamerasRead, PermCamerasWrite,
+		PermDetectionConfigure,
+		PermAlertsRead, PermAlertsWrite,
+		PermStreamsView, PermRecordingsView,
+		PermAuditRead,
+	},
+	RoleOperator: {
+		PermBuildingsRead,
+		PermCamerasRead,
+		PermAlertsRead, PermAlertsWrite,
+		PermStreamsView, PermRecordingsView,
+	},
+	RoleViewer: {
+		PermBuildingsRead,
+		PermCamerasRead,
+		PermAlertsRead,
+	},
+}
+
+// PlatformPermissions are held by platform operators on top of the role in
+// their current organization, also when the session has no organization.
+var PlatformPermissions = []Permission{PermOrganizationsManage, PermAuditReadAll}
+
+func (r Role) Valid() bool {
+	if r == RoleAdmin {
+		return true
+	}
+	_, ok := RolePermissions[r]
+	return ok
+}
+
+func (r Role) Can(permission Permission) bool {
+	if r == RoleAdmin {
+		return permission.Valid()
+	}
+	for _, p := range RolePermissions[r] {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
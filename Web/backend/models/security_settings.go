@@ -0,0 +1,9 @@
+package models
+
+import "go.mongodb.org/mongo-driver/bson/primitive"
+
+// SecuritySettings are per-organization authentication policies.
+type SecuritySettings struct {
+	OrganizationID  primitive.ObjectID `bson:"_id" json:"organizationId"`
+	RequireMFARoles []Role             `bson:"requireMfaRoles" json:"require_mfa_roles"`
+}
@@ -0,0 +1,49 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// SeverityRule assigns a severity to the alerts it matches. Rules are tried
+// in ascending Order and the first match wins; empty conditions match
+// everything.
+type SeverityRule struct {
+	ID             primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	OrganizationID primitive.ObjectID `bson:"organizationId" json:"organizationId"`
+	Name           string             `bson:"name" json:"name"`
+	Order          int                `bson:"order" json:"order"`
+
+	AlertTypes    []AlertType          `bson:"alertTypes,omitempty" json:"alert_types,omitempty"`
+	BuildingIDs   []primitive.ObjectID `bson:"buildingIds,omitempty" json:"building_ids,omitempty"`
+	ZoneIDs       []primitive.ObjectID `bson:"zoneIds,omitempty" json:"zone_ids,omitempty"`
+	MinConfidence *float64             `bson:"minConfidence,omitempty" json:"min_confidence,omitempty"`
+	MaxConfidence *float64             `bson:"maxConfidence,omitempty" json:"max_confidence,omitempty"`
+	TimeWindow    *TimeWindow          `bson:"timeWindow,omitempty" json:"time_window,omitempty"`
+
+	Severity string `bson:"severity" json:"severity"`
+}
+
+// Matches reports whether the alert, raised at t, meets every condition.
+func (r *SeverityRule) Matches(alert *Alert, t time.Time) bool {
+	if len(r.AlertTypes) > 0 && !containsAlertType(r.AlertTypes, alert.AlertType) {
+		return false
+	}
+	if len(r.BuildingIDs) > 0 && !containsObjectID(r.BuildingIDs, alert.BuildingID) {
+		return false
+	}
+	if len(r.ZoneIDs) > 0 && !containsObjectID(r.ZoneIDs, alert.ZoneID) {
+		return false
+	}
+	if r.MinConfidence != nil && alert.Confidence < *r.MinConfidence {
+		return false
+	}
+	if r.MaxConfidence != nil && alert.Confidence > *r.MaxConfidence {
+		return false
+	}
+	if r.TimeWindow != nil && !r.TimeWindow.Contains(t) {
+		return false
+	}
+	return true
+}
@@ -9,5 +9,63 @@ type User struct {
 	Username string             `bson:"username" json:"username"`
 	Password string             `bson:"password" json:"-"`
 	Email    string             `bson:"email" json:"email"`
-	Role     string             `bson:"role" json:"role"`
+
+	// Role, grants and blocking are kept per organization, so an admin of one
+	// organization cannot change the user's access to another.
+	Memberships []Membership `bson:"memberships" json:"memberships"`
+
+	// PlatformOperator is set on the first registered user. It is not tied to
+	// an organization and is never granted through a role.
+	PlatformOperator bool `bson:"platformOperator,omitempty" json:"platformOperator,omitempty"`
+
+	// Two-factor authentication. Recovery codes are stored hashed.
+	TOTPEnabled       bool     `bson:"totpEnabled" json:"totpEnabled"`
+	TOTPSecret        string   `bson:"totpSecret,omitempty" json:"-"`
+	TOTPPendingSecret string   `bson:"totpPendingSecret,omitempty" json:"-"`
+	TOTPLastStep      int64    `bson:"totpLastStep,omitempty" json:"-"`
+	RecoveryCodes     []string `bson:"recoveryCodes,omitempty" json:"-"`
+
+	// Identity of users provisioned through single sign-on.
+	OIDCIssuer  string `bson:"oidcIssuer,omitempty" json:"-"`
+	OIDCSubject string `bson:"oidcSubject,omitempty" json:"-"`
+}
+
+// Membership is the user's access to one organization.
+type Membership struct {
+	OrganizationID primitive.ObjectID `bson:"organizationId" json:"organizationId"`
+	Role           Role               `bson:"role" json:"role"`
+	Disabled       bool               `bson:"disabled" json:"disabled"`
+
+	// Building and floor grants. Members without grants see the whole organization.
+	BuildingIDs []primitive.ObjectID `bson:"buildingIds,omitempty" json:"buildingIds,omitempty"`
+	FloorIDs    []primitive.ObjectID `bson:"floorIds,omitempty" json:"floorIds,omitempty"`
+}
+
+// Membership returns the user's membership in the organization, or nil.
+func (u *User) Membership(orgID primitive.ObjectID) *Membership {
+	for i := range u.Memberships {
+		if u.Memberships[i].OrganizationID == orgID {
+			return &u.Memberships[i]
+		}
+	}
+	return nil
+}
+
+// RoleIn returns the user's role in the organization, or "" when the user is
+// not an active member.
+func (u *User) RoleIn(orgID primitive.ObjectID) Role {
+	membership := u.Membership(orgID)
+	if membership == nil || membership.Disabled {
+		return ""
+	}
+	return membership.Role
+}
+
+// OrganizationIDs lists the organizations the user belongs to.
+func (u *User) OrganizationIDs() []primitive.ObjectID {
+	ids := make([]primitive.ObjectID, len(u.Memberships))
+	for i, membership := range u.Memberships {
+		ids[i] = membership.OrganizationID
+	}
+	return ids
 }
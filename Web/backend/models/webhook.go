@@ -0,0 +1,93 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Webhook is an outbound subscription to alerts of an organization. Empty
+// filter lists match everything.
+type Webhook struct {
+	ID             primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	OrganizationID primitive.ObjectID `bson:"organizationId" json:"organizationId"`
+	Name           string             `bson:"name" json:"name"`
+	URL            string             `bson:"url" json:"url"`
+	// Secret signs payloads with HMAC-SHA256. It is returned only on creation.
+	Secret  string `bson:"secret" json:"-"`
+	Enabled bool   `bson:"enabled" json:"enabled"`
+
+	AlertTypes  []AlertType          `bson:"alertTypes,omitempty" json:"alertTypes,omitempty"`
+	BuildingIDs []primitive.ObjectID `bson:"buildingIds,omitempty" json:"buildingIds,omitempty"`
+	Severities  []string             `bson:"severities,omitempty" json:"severities,omitempty"`
+
+	CreatedAt time.Time `bson:"createdAt" json:"createdAt"`
+}
+
+// Matches reports whether the alert passes the subscription filters.
+func (w *Webhook) Matches(alert *Alert) bool {
+	if len(w.AlertTypes) > 0 && !containsAlertType(w.AlertTypes, alert.AlertType) {
+		return false
+	}
+	if len(w.BuildingIDs) > 0 && !containsObjectID(w.BuildingIDs, alert.BuildingID) {
+		return false
+	}
+	if len(w.Severities) > 0 && !containsString(w.Severities, alert.Severity) {
+		return false
+	}
+	return true
+}
+
+// Webhook delivery states. Dead deliveries exhausted their retries and wait
+// in the dead-letter list for a manual retry.
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryDead      = "dead"
+)
+
+// WebhookDelivery is one queued or attempted POST of an event to a webhook.
+// The payload is stored as sent so that retries carry identical bodies.
+type WebhookDelivery struct {
+	ID             primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	WebhookID      primitive.ObjectID `bson:"webhookId" json:"webhookId"`
+	OrganizationID primitive.ObjectID `bson:"organizationId" json:"organizationId"`
+	AlertID        primitive.ObjectID `bson:"alertId,omitempty" json:"alertId,omitempty"`
+	Event          string             `bson:"event" json:"event"`
+	Payload        string             `bson:"payload" json:"payload"`
+
+	Status         string     `bson:"status" json:"status"`
+	Attempts       int        `bson:"attempts" json:"attempts"`
+	NextAttemptAt  time.Time  `bson:"nextAttemptAt" json:"nextAttemptAt"`
+	LastStatusCode int        `bson:"lastStatusCode,omitempty" json:"lastStatusCode,omitempty"`
+	LastError      string     `bson:"lastError,omitempty" json:"lastError,omitempty"`
+	CreatedAt      time.Time  `bson:"createdAt" json:"createdAt"`
+	DeliveredAt    *time.Time `bson:"deliveredAt,omitempty" json:"deliveredAt,omitempty"`
+}
+
+func containsAlertType(types []AlertType, t AlertType) bool {
+	for _, item := range types {
+		if item == t {
+			return true
+		}
+	}
+	return false
+}
+
+func containsObjectID(ids []primitive.ObjectID, id primitive.ObjectID) bool {
+	for _, item := range ids {
+		if item == id {
+			return true
+		}
+	}
+	return false
+}
+
+func containsString(values []string, value string) bool {
+	for _, item := range values {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,26 @@
+package models
+
+import "go.mongodb.org/mongo-driver/bson/primitive"
+
+type ZoneType string
+
+const (
+	ZoneTypeInclude ZoneType = "include"
+	ZoneTypeExclude ZoneType = "exclude"
+)
+
+// Point is a position within a camera frame in normalized coordinates (0..1).
+type Point struct {
+	X float64 `bson:"x" json:"x"`
+	Y float64 `bson:"y" json:"y"`
+}
+
+type Zone struct {
+	ID       primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	CameraID primitive.ObjectID `bson:"cameraId" json:"cameraId"`
+	Name     string             `bson:"name" json:"name"`
+	Type     ZoneType           `bson:"type" json:"type"`
+	Polygon  []Point            `bson:"polygon" json:"polygon"`
+
+	OrganizationID primitive.ObjectID `bson:"organizationId" json:"organizationId"`
+}
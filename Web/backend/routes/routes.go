@@ -3,6 +3,7 @@ package routes
 import (
 	"backend/controllers"
 	"backend/middleware"
+	"backend/models"
 
 	"github.com/gin-gonic/gin"
 )
@@ -10,57 +11,213 @@ import (
 func SetupRouter() *gin.Engine {
 	r := gin.Default()
 
+	controllers.InitScope()
 	controllers.InitAlertController()
 	controllers.InitBuildingController()
 	controllers.InitFloorController()
 	controllers.InitCameraController()
 	controllers.InitStreamController()
+	controllers.InitZoneController()
+	controllers.InitSeverityController()
+	controllers.InitAlertTypeController()
+	controllers.InitIncidentController()
+	controllers.InitStatsController()
+	controllers.InitExportController()
+	controllers.InitOrganizationController()
+	controllers.InitAdminController()
+	controllers.InitAPIKeyController()
+	controllers.InitAuditController()
+	controllers.InitWebhookController()
+	controllers.InitNotificationController()
+	controllers.InitEscalationController()
+	controllers.InitCommentController()
 
 	authController := controllers.NewAuthController()
+	userController := controllers.NewUserController()
 	r.POST("/auth/register", authController.Register)
 	r.POST("/auth/login", authController.Login)
+	r.POST("/auth/login/2fa", authController.LoginMFA)
+	r.POST("/auth/2fa/enroll", authController.EnrollMFA)
+	r.POST("/auth/2fa/confirm", authController.ConfirmMFA)
+	r.POST("/auth/refresh", authController.Refresh)
+	r.POST("/auth/logout", authController.Logout)
+	r.GET("/auth/oidc/login", authController.OIDCLogin)
+	r.GET("/auth/oidc/callback", authController.OIDCCallback)
+	r.POST("/auth/invitations/accept", userController.AcceptInvitation)
+	r.POST("/auth/password/forgot", userController.ForgotPassword)
+	r.POST("/auth/password/reset", userController.ResetPassword)
+	r.POST("/auth/email/confirm", userController.ConfirmEmail)
+	r.GET("/.well-known/jwks.json", authController.JWKS)
+
+	can := middleware.RequirePermission
 
 	api := r.Group("/api")
 	api.Use(middleware.AuthMiddleware())
 	{
+		api.POST("/auth/sessions/revoke", authController.RevokeSessions)
+		api.POST("/invitations/accept", userController.JoinOrganization)
+		api.POST("/auth/2fa/enroll", authController.EnrollMFA)
+		api.POST("/auth/2fa/confirm", authController.ConfirmMFA)
+		api.POST("/auth/2fa/disable", authController.DisableMFA)
+		api.POST("/auth/2fa/recovery-codes", authController.RegenerateRecoveryCodes)
+
+		userRoutes := api.Group("/users")
+		{
+			userRoutes.PUT("/me/password", userController.ChangePassword)
+			userRoutes.PUT("/me/email", userController.ChangeEmail)
+			userRoutes.GET("/", can(models.PermUsersManage), userController.GetUsers)
+			userRoutes.GET("/invitations", can(models.PermUsersManage), userController.GetInvitations)
+			userRoutes.POST("/invitations", can(models.PermUsersManage), userController.CreateInvitation)
+			userRoutes.DELETE("/invitations/:id", can(models.PermUsersManage), userController.RevokeInvitation)
+			userRoutes.GET("/:id", can(models.PermUsersManage), userController.GetUser)
+			userRoutes.POST("/:id/disable", can(models.PermUsersManage), userController.DisableUser)
+			userRoutes.POST("/:id/enable", can(models.PermUsersManage), userController.EnableUser)
+			userRoutes.DELETE("/:id", can(models.PermUsersManage), userController.DeleteUser)
+		}
+
+		notificationRoutes := api.Group("/notifications")
+		{
+			notificationRoutes.GET("/preferences", controllers.GetNotificationPreferences)
+			notificationRoutes.PUT("/preferences", controllers.UpdateNotificationPreferences)
+		}
+
+		organizationRoutes := api.Group("/organizations")
+		{
+			organizationRoutes.GET("/", controllers.GetOrganizations)
+			organizationRoutes.POST("/", can(models.PermOrganizationsManage), controllers.CreateOrganization)
+			organizationRoutes.POST("/:id/switch", authController.SwitchOrganization)
+			organizationRoutes.DELETE("/:id/members/:user_id", can(models.PermUsersManage), controllers.RemoveOrganizationMember)
+		}
 
 		cameraRoutes := api.Group("/cameras")
 		{
-			cameraRoutes.GET("/", controllers.GetCameras)
-			cameraRoutes.POST("/", controllers.CreateCamera)
-			cameraRoutes.DELETE("/:id", controllers.DeleteCamera)
+			cameraRoutes.GET("/", can(models.PermCamerasRead), controllers.GetCameras)
+			cameraRoutes.POST("/", can(models.PermCamerasWrite), controllers.CreateCamera)
+			cameraRoutes.DELETE("/:id", can(models.PermCamerasDelete), controllers.DeleteCamera)
+			cameraRoutes.PUT("/:id/neighbors", can(models.PermDetectionConfigure), controllers.UpdateCameraNeighbors)
+			cameraRoutes.GET("/:id/zones", can(models.PermCamerasRead), controllers.GetCameraZones)
+			cameraRoutes.POST("/:id/zones", can(models.PermDetectionConfigure), controllers.CreateCameraZone)
+			cameraRoutes.PUT("/:id/zones/:zone_id", can(models.PermDetectionConfigure), controllers.UpdateCameraZone)
+			cameraRoutes.DELETE("/:id/zones/:zone_id", can(models.PermDetectionConfigure), controllers.DeleteCameraZone)
 		}
 
 		alertRoutes := api.Group("/alerts")
 		{
-			alertRoutes.GET("/", controllers.GetAlerts)
-			alertRoutes.POST("/", controllers.CreateAlert)
-			alertRoutes.GET("/ws", controllers.HandleWebSocket)
+			alertRoutes.GET("/", can(models.PermAlertsRead), controllers.GetAlerts)
+			alertRoutes.POST("/", can(models.PermAlertsWrite), controllers.CreateAlert)
+			alertRoutes.GET("/ws", can(models.PermAlertsRead), controllers.HandleWebSocket)
+			alertRoutes.GET("/stream", can(models.PermAlertsRead), controllers.StreamAlerts)
+			alertRoutes.GET("/export", can(models.PermAlertsRead), controllers.ExportAlerts)
+			alertRoutes.GET("/tags", can(models.PermAlertsRead), controllers.GetAlertTags)
+			alertRoutes.POST("/:id/acknowledge", can(models.PermAlertsWrite), controllers.AcknowledgeAlert)
+			alertRoutes.POST("/:id/resolve", can(models.PermAlertsWrite), controllers.ResolveAlert)
+			alertRoutes.PUT("/:id/feedback", can(models.PermAlertsWrite), controllers.SetAlertFeedback)
+			alertRoutes.GET("/:id/notifications", can(models.PermAlertsRead), controllers.GetAlertNotifications)
+			alertRoutes.GET("/:id/escalations", can(models.PermAlertsRead), controllers.GetAlertEscalations)
+			alertRoutes.GET("/:id/report", can(models.PermAlertsRead), controllers.GetAlertReport)
+			alertRoutes.PUT("/:id/tags", can(models.PermAlertsWrite), controllers.SetAlertTags)
+			alertRoutes.GET("/:id/comments", can(models.PermAlertsRead), controllers.GetAlertComments)
+			alertRoutes.POST("/:id/comments", can(models.PermAlertsWrite), controllers.CreateAlertComment)
+			alertRoutes.PUT("/:id/comments/:comment_id", can(models.PermAlertsWrite), controllers.UpdateAlertComment)
+			alertRoutes.DELETE("/:id/comments/:comment_id", can(models.PermAlertsWrite), controllers.DeleteAlertComment)
+			alertRoutes.GET("/:id/attachments", can(models.PermAlertsRead), controllers.GetAlertAttachments)
+			alertRoutes.POST("/:id/attachments", can(models.PermAlertsWrite), controllers.UploadAlertAttachment)
+			alertRoutes.GET("/:id/attachments/:attachment_id", can(models.PermRecordingsView), controllers.DownloadAlertAttachment)
+			alertRoutes.DELETE("/:id/attachments/:attachment_id", can(models.PermAlertsWrite), controllers.DeleteAlertAttachment)
+		}
+
+		exportRoutes := api.Group("/exports", can(models.PermAlertsRead))
+		{
+			exportRoutes.GET("/", controllers.GetExportJobs)
+			exportRoutes.GET("/:id", controllers.GetExportJob)
+			exportRoutes.GET("/:id/download", controllers.DownloadExport)
+		}
+
+		api.GET("/training/manifest", can(models.PermDetectionConfigure), controllers.ExportTrainingManifest)
+
+		statsRoutes := api.Group("/stats/alerts", can(models.PermAlertsRead))
+		{
+			statsRoutes.GET("/counts", controllers.GetAlertCounts)
+			statsRoutes.GET("/response-times", controllers.GetAlertResponseTimes)
+			statsRoutes.GET("/false-positives", controllers.GetFalsePositiveRates)
+		}
+
+		incidentRoutes := api.Group("/incidents")
+		{
+			incidentRoutes.GET("/", can(models.PermAlertsRead), controllers.GetIncidents)
+			incidentRoutes.GET("/:id", can(models.PermAlertsRead), controllers.GetIncident)
+			incidentRoutes.GET("/:id/report", can(models.PermAlertsRead), controllers.GetIncidentReport)
+			incidentRoutes.POST("/:id/acknowledge", can(models.PermAlertsWrite), controllers.AcknowledgeIncident)
+			incidentRoutes.POST("/:id/resolve", can(models.PermAlertsWrite), controllers.ResolveIncident)
+		}
+
+		api.GET("/alert-types", can(models.PermAlertsRead), controllers.GetAlertTypes)
+
+		severityRoutes := api.Group("/severity-rules")
+		{
+			severityRoutes.GET("/", can(models.PermAlertsRead), controllers.GetSeverityRules)
+			severityRoutes.POST("/", can(models.PermDetectionConfigure), controllers.CreateSeverityRule)
+			severityRoutes.PUT("/:id", can(models.PermDetectionConfigure), controllers.UpdateSeverityRule)
+			severityRoutes.DELETE("/:id", can(models.PermDetectionConfigure), controllers.DeleteSeverityRule)
 		}
 
 		buildingRoutes := api.Group("/buildings")
 		{
-			buildingRoutes.GET("/", controllers.GetAllBuildings)
-			buildingRoutes.POST("/", controllers.CreateBuilding)
-			buildingRoutes.GET("/:id", controllers.GetBuildingByID)
-			buildingRoutes.PUT("/:id", controllers.UpdateBuilding)
-			buildingRoutes.DELETE("/:id", controllers.DeleteBuilding)
-			buildingRoutes.GET("/camera/:camera_id", controllers.GetBuildingByCameraID)
+			buildingRoutes.GET("/", can(models.PermBuildingsRead), controllers.GetAllBuildings)
+			buildingRoutes.POST("/", can(models.PermBuildingsWrite), controllers.CreateBuilding)
+			buildingRoutes.GET("/:id", can(models.PermBuildingsRead), controllers.GetBuildingByID)
+			buildingRoutes.PUT("/:id", can(models.PermBuildingsWrite), controllers.UpdateBuilding)
+			buildingRoutes.DELETE("/:id", can(models.PermBuildingsDelete), controllers.DeleteBuilding)
+			buildingRoutes.GET("/camera/:camera_id", can(models.PermBuildingsRead), controllers.GetBuildingByCameraID)
 		}
 
 		floorRoutes := api.Group("/floors")
 		{
-			floorRoutes.GET("/", controllers.GetAllFloors)
-			floorRoutes.POST("/", controllers.CreateFloor)
-			floorRoutes.GET("/:id", controllers.GetFloorByID)
-			floorRoutes.PUT("/:id", controllers.UpdateFloor)
-			floorRoutes.DELETE("/:id", controllers.DeleteFloor)
+			floorRoutes.GET("/", can(models.PermBuildingsRead), controllers.GetAllFloors)
+			floorRoutes.POST("/", can(models.PermBuildingsWrite), controllers.CreateFloor)
+			floorRoutes.GET("/:id", can(models.PermBuildingsRead), controllers.GetFloorByID)
+			floorRoutes.PUT("/:id", can(models.PermBuildingsWrite), controllers.UpdateFloor)
+			floorRoutes.DELETE("/:id", can(models.PermBuildingsDelete), controllers.DeleteFloor)
 		}
 
 		// Stream routes
 		streamRoutes := api.Group("/stream")
 		{
-			streamRoutes.GET("/ws", controllers.HandleStreamWebSocket)
+			streamRoutes.GET("/ws", can(models.PermStreamsView), controllers.HandleStreamWebSocket)
+		}
+
+		auditRoutes := api.Group("/audit", can(models.PermAuditRead))
+		{
+			auditRoutes.GET("/", controllers.GetAuditLog)
+			auditRoutes.GET("/verify", controllers.VerifyAuditLog)
+		}
+
+		adminRoutes := api.Group("/admin", can(models.PermUsersManage))
+		{
+			adminRoutes.GET("/roles", controllers.GetRoles)
+			adminRoutes.PUT("/users/:id/role", controllers.UpdateUserRole)
+			adminRoutes.PUT("/users/:id/access", controllers.UpdateUserAccess)
+			adminRoutes.POST("/users/:id/sessions/revoke", authController.RevokeUserSessions)
+			adminRoutes.POST("/users/:id/unlock", authController.UnlockUser)
+			adminRoutes.GET("/lockouts", authController.GetLockouts)
+			adminRoutes.GET("/security", authController.GetSecuritySettings)
+			adminRoutes.PUT("/security", authController.UpdateSecuritySettings)
+			adminRoutes.GET("/api-keys", controllers.GetAPIKeys)
+			adminRoutes.POST("/api-keys", controllers.CreateAPIKey)
+			adminRoutes.DELETE("/api-keys/:id", controllers.RevokeAPIKey)
+			adminRoutes.PUT("/alert-types/:key", controllers.SaveAlertType)
+			adminRoutes.DELETE("/alert-types/:key", controllers.DeleteAlertType)
+			adminRoutes.GET("/escalation-policies", controllers.GetEscalationPolicies)
+			adminRoutes.POST("/escalation-policies", controllers.CreateEscalationPolicy)
+			adminRoutes.PUT("/escalation-policies/:id", controllers.UpdateEscalationPolicy)
+			adminRoutes.DELETE("/escalation-policies/:id", controllers.DeleteEscalationPolicy)
+			adminRoutes.GET("/webhooks", controllers.GetWebhooks)
+			adminRoutes.POST("/webhooks", controllers.CreateWebhook)
+			adminRoutes.PUT("/webhooks/:id", controllers.UpdateWebhook)
+			adminRoutes.DELETE("/webhooks/:id", controllers.DeleteWebhook)
+			adminRoutes.GET("/webhook-deliveries", controllers.GetWebhookDeliveries)
+			adminRoutes.GET("/webhook-deliveries/dead", controllers.GetDeadLetters)
+			adminRoutes.POST("/webhook-deliveries/:id/retry", controllers.RetryWebhookDelivery)
 		}
 	}
 
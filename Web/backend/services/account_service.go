@@ -0,0 +1,410 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/mail"
+	"net/url"
+	"strings"
+	"time"
+
+	"backend/config"
+	"backend/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var ErrInvalidAccountToken = errors.New("недействительная или просроченная ссылка")
+
+// AccountService handles the emailed account flows: invitations, password
+// resets and email changes.
+type AccountService struct {
+	Tokens *mongo.Collection
+	Users  *UserService
+	Mailer Mailer
+
+	AppURL           string
+	InviteTTL        time.Duration
+	PasswordResetTTL time.Duration
+	EmailChangeTTL   time.Duration
+}
+
+func NewAccountService() *AccountService {
+	s := &AccountService{
+		Tokens:           config.GetCollection("account_tokens"),
+		Users:            NewUserService(),
+		Mailer:           NewMailer(),
+		InviteTTL:        7 * 24 * time.Hour,
+		PasswordResetTTL: time.Hour,
+		EmailChangeTTL:   24 * time.Hour,
+	}
+	if cfg := config.Mailer; cfg != nil {
+		s.AppURL = cfg.AppURL
+		s.InviteTTL = cfg.InviteTTL
+		s.PasswordResetTTL = cfg.PasswordResetTTL
+		s.EmailChangeTTL = cfg.EmailChangeTTL
+	}
+	return s
+}
+
+// Invite emails a link that lets the recipient create an account in the
+// scope's organization with the given role.
+func (s *AccountService) Invite(scope AccessScope, email string, role models.Role, invitedBy string) (*models.AccountToken, error) {
+	email, err := normalizeEmail(email)
+	if err != nil {
+		return nil, err
+	}
+	if !role.Valid() {
+		return nil, errors.New("неизвестная роль")
+	}
+
+	token := &models.AccountToken{
+		Purpose:        models.AccountTokenInvite,
+		Email:          email,
+		OrganizationID: scope.OrganizationID,
+		Role:           role,
+		InvitedBy:      invitedBy,
+	}
+	raw, err := s.createToken(token, s.InviteTTL)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.sendMail(MailMessage{
+		To:      []string{email},
+		Subject: "Приглашение в систему видеонаблюдения",
+		Body: fmt.Sprintf("%s приглашает вас в систему видеонаблюдения.\n\nЧтобы создать учетную запись, перейдите по ссылке:\n%s\n\nСсылка действительна до %s.",
+			invitedBy, s.link("/invite", raw), token.ExpiresAt.Format("02.01.2006 15:04")),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("не удалось отправить приглашение: %w", err)
+	}
+
+	return token, nil
+}
+
+// GetInvitations lists the pending invitations of the scope's organization.
+func (s *AccountService) GetInvitations(scope AccessScope) ([]models.AccountToken, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	cursor, err := s.Tokens.Find(ctx, bson.M{
+		"purpose":        models.AccountTokenInvite,
+		"organizationId": scope.OrganizationID,
+		"usedAt":         bson.M{"$exists": false},
+		"expiresAt":      bson.M{"$gt": time.Now()},
+	}, options.Find().SetSort(bson.D{{Key: "createdAt", Value: -1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	invitations := []models.AccountToken{}
+	if err := cursor.All(ctx, &invitations); err != nil {
+		return nil, err
+	}
+
+	return invitations, nil
+}
+
+func (s *AccountService) RevokeInvitation(scope AccessScope, id string) error {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return errors.New("некорректный ID приглашения")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	result, err := s.Tokens.DeleteOne(ctx, bson.M{
+		"_id":            objID,
+		"purpose":        models.AccountTokenInvite,
+		"organizationId": scope.OrganizationID,
+	})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return errors.New("приглашение не найдено")
+	}
+	return nil
+}
+
+// AcceptInvitation creates the invited user. The invitation can be used once;
+// it is given back when the user cannot be created, for example because the
+// username is taken.
+func (s *AccountService) AcceptInvitation(rawToken, username, password string) (*models.User, error) {
+	username = strings.TrimSpace(username)
+	if username == "" {
+		return nil, errors.New("имя пользователя обязательно")
+	}
+	hash, err := HashPassword(password)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	token, err := s.consumeToken(ctx, rawToken, models.AccountTokenInvite, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	user := &models.User{
+		ID:          primitive.NewObjectID(),
+		Username:    username,
+		Password:    hash,
+		Email:       token.Email,
+		Memberships: []models.Membership{{OrganizationID: token.OrganizationID, Role: token.Role}},
+	}
+	if _, err := s.Users.Collection.InsertOne(ctx, user); err != nil {
+		return nil, errors.Join(duplicateUserError(err), s.releaseToken(ctx, token.ID))
+	}
+
+	return user, nil
+}
+
+// JoinOrganization accepts an invitation on behalf of an existing user, who
+// becomes a member of the inviting organization with the invited role. Only
+// invitations sent to the user's own email address are accepted.
+func (s *AccountService) JoinOrganization(rawToken, userID string) (*models.User, error) {
+	objID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return nil, errors.New("некорректный ID пользователя")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var user models.User
+	if err := s.Users.Collection.FindOne(ctx, bson.M{"_id": objID}).Decode(&user); err != nil {
+		return nil, ErrUserNotFound
+	}
+	if user.Email == "" {
+		return nil, ErrInvalidAccountToken
+	}
+
+	token, err := s.consumeToken(ctx, rawToken, models.AccountTokenInvite, bson.M{
+		"email":          user.Email,
+		"organizationId": bson.M{"$nin": user.OrganizationIDs()},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	membership := models.Membership{OrganizationID: token.OrganizationID, Role: token.Role}
+	result, err := s.Users.Collection.UpdateOne(ctx,
+		bson.M{"_id": objID, "memberships.organizationId": bson.M{"$ne": token.OrganizationID}},
+		bson.M{"$push": bson.M{"memberships": membership}},
+	)
+	if err != nil {
+		return nil, err
+	}
+	if result.MatchedCount == 0 {
+		return nil, errors.New("пользователь уже состоит в организации")
+	}
+
+	user.Memberships = append(user.Memberships, membership)
+	return &user, nil
+}
+
+// RequestPasswordReset emails a reset link to the user with the given email.
+// Unknown addresses are ignored so the endpoint cannot be used to probe
+// which emails are registered.
+func (s *AccountService) RequestPasswordReset(email string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var user models.User
+	err := s.Users.Collection.FindOne(ctx, bson.M{
+		"email": strings.TrimSpace(email),
+		"$or": bson.A{
+			bson.M{"memberships": bson.M{"$size": 0}},
+			bson.M{"memberships": bson.M{"$elemMatch": bson.M{"disabled": false}}},
+		},
+	}).Decode(&user)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil
+		}
+		return err
+	}
+
+	token := &models.AccountToken{
+		Purpose: models.AccountTokenPasswordReset,
+		Email:   user.Email,
+		UserID:  user.ID,
+	}
+	raw, err := s.createToken(token, s.PasswordResetTTL)
+	if err != nil {
+		return err
+	}
+
+	return s.sendMail(MailMessage{
+		To:      []string{user.Email},
+		Subject: "Сброс пароля",
+		Body: fmt.Sprintf("Для пользователя %s запрошен сброс пароля.\n\nЧтобы задать новый пароль, перейдите по ссылке:\n%s\n\nСсылка действительна до %s. Если вы не запрашивали сброс, проигнорируйте это письмо.",
+			user.Username, s.link("/reset-password", raw), token.ExpiresAt.Format("02.01.2006 15:04")),
+	})
+}
+
+// ResetPassword sets a new password using a reset link and returns the user,
+// whose sessions the caller should revoke.
+func (s *AccountService) ResetPassword(rawToken, password string) (*models.User, error) {
+	hash, err := HashPassword(password)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	token, err := s.consumeToken(ctx, rawToken, models.AccountTokenPasswordReset, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var user models.User
+	err = s.Users.Collection.FindOneAndUpdate(ctx,
+		bson.M{"_id": token.UserID},
+		bson.M{"$set": bson.M{"password": hash}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&user)
+	if err != nil {
+		return nil, ErrUserNotFound
+	}
+
+	return &user, nil
+}
+
+// RequestEmailChange emails a confirmation link to the new address. The
+// user's email changes only once the link is opened, so an address the user
+// does not control can never receive their password resets.
+func (s *AccountService) RequestEmailChange(userID, email string) error {
+	objID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return errors.New("некорректный ID пользователя")
+	}
+	email, err = normalizeEmail(email)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var user models.User
+	if err := s.Users.Collection.FindOne(ctx, bson.M{"_id": objID}).Decode(&user); err != nil {
+		return ErrUserNotFound
+	}
+	if user.Email == email {
+		return errors.New("этот email уже указан в учетной записи")
+	}
+
+	token := &models.AccountToken{
+		Purpose: models.AccountTokenEmailChange,
+		Email:   email,
+		UserID:  user.ID,
+	}
+	raw, err := s.createToken(token, s.EmailChangeTTL)
+	if err != nil {
+		return err
+	}
+
+	return s.sendMail(MailMessage{
+		To:      []string{email},
+		Subject: "Подтверждение email",
+		Body: fmt.Sprintf("Пользователь %s указал этот адрес в учетной записи.\n\nЧтобы подтвердить адрес, перейдите по ссылке:\n%s\n\nСсылка действительна до %s. Если вы не меняли адрес, проигнорируйте это письмо.",
+			user.Username, s.link("/confirm-email", raw), token.ExpiresAt.Format("02.01.2006 15:04")),
+	})
+}
+
+// ConfirmEmailChange sets the address confirmed by the link as the user's
+// email.
+func (s *AccountService) ConfirmEmailChange(rawToken string) (*models.User, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	token, err := s.consumeToken(ctx, rawToken, models.AccountTokenEmailChange, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.Users.SetEmail(token.UserID, token.Email)
+}
+
+// normalizeEmail trims the address and checks that it is a bare address
+// without a display name.
+func normalizeEmail(email string) (string, error) {
+	email = strings.TrimSpace(email)
+	address, err := mail.ParseAddress(email)
+	if err != nil || address.Address != email {
+		return "", errors.New("некорректный email")
+	}
+	return email, nil
+}
+
+func (s *AccountService) createToken(token *models.AccountToken, ttl time.Duration) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	raw := randomString()
+	token.ID = primitive.NewObjectID()
+	token.TokenHash = hashToken(raw)
+	token.CreatedAt = time.Now()
+	token.ExpiresAt = token.CreatedAt.Add(ttl)
+
+	if _, err := s.Tokens.InsertOne(ctx, token); err != nil {
+		return "", err
+	}
+	return raw, nil
+}
+
+// consumeToken marks an unused, unexpired token that matches the extra
+// conditions as used and returns it.
+func (s *AccountService) consumeToken(ctx context.Context, rawToken, purpose string, conditions bson.M) (*models.AccountToken, error) {
+	filter := bson.M{
+		"tokenHash": hashToken(rawToken),
+		"purpose":   purpose,
+		"usedAt":    bson.M{"$exists": false},
+		"expiresAt": bson.M{"$gt": time.Now()},
+	}
+	for key, value := range conditions {
+		filter[key] = value
+	}
+
+	var token models.AccountToken
+	err := s.Tokens.FindOneAndUpdate(ctx, filter, bson.M{"$set": bson.M{"usedAt": time.Now()}}).Decode(&token)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrInvalidAccountToken
+		}
+		return nil, err
+	}
+
+	return &token, nil
+}
+
+// sendMail sends with a timeout of its own, so that a slow relay does not
+// use up the time of the surrounding database calls.
+func (s *AccountService) sendMail(msg MailMessage) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	return s.Mailer.Send(ctx, msg)
+}
+
+// releaseToken makes a consumed token usable again.
+func (s *AccountService) releaseToken(ctx context.Context, id primitive.ObjectID) error {
+	_, err := s.Tokens.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$unset": bson.M{"usedAt": ""}})
+	return err
+}
+
+func (s *AccountService) link(path, token string) string {
+	return strings.TrimRight(s.AppURL, "/") + path + "?token=" + url.QueryEscape(token)
+}
@@ -0,0 +1,83 @@
+package services
+
+import (
+	"sync"
+
+	"backend/models"
+)
+
+// busQueueSize is the number of enqueued events a bus holds before Enqueue
+// waits for its handlers.
+const busQueueSize = 256
+
+// Bus fans events out to the live feeds of this instance. Handlers run
+// synchronously, one after another, so every feed sees the same events in
+// the same order.
+type Bus[T any] struct {
+	mu       sync.RWMutex
+	nextID   int
+	handlers map[int]func(T)
+
+	start sync.Once
+	queue chan T
+}
+
+func NewBus[T any]() *Bus[T] {
+	return &Bus[T]{handlers: make(map[int]func(T))}
+}
+
+// Subscribe registers a handler for every published event until the
+// returned function is called.
+func (b *Bus[T]) Subscribe(handler func(T)) (unsubscribe func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	id := b.nextID
+	b.nextID++
+	b.handlers[id] = handler
+
+	return func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		delete(b.handlers, id)
+	}
+}
+
+func (b *Bus[T]) Publish(event T) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	for _, handler := range b.handlers {
+		handler(event)
+	}
+}
+
+// Enqueue publishes the event from the bus's own goroutine, so the caller
+// does not wait for the handlers while events keep the order they were
+// enqueued in.
+func (b *Bus[T]) Enqueue(event T) {
+	b.start.Do(func() {
+		b.queue = make(chan T, busQueueSize)
+		go func() {
+			for event := range b.queue {
+				b.Publish(event)
+			}
+		}()
+	})
+	b.queue <- event
+}
+
+// AlertBus carries new alerts to the WebSocket broadcaster and every
+// Server-Sent Events stream.
+type AlertBus = Bus[*models.Alert]
+
+func NewAlertBus() *AlertBus {
+	return NewBus[*models.Alert]()
+}
+
+// alertBus carries the alerts created through AlertService.
+var alertBus = NewAlertBus()
+
+// activityBus carries comments and tag changes on existing alerts to the
+// WebSocket broadcaster.
+var activityBus = NewBus[*models.AlertActivity]()
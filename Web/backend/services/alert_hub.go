@@ -0,0 +1,147 @@
+package services
+
+import (
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+
+	"backend/models"
+
+	"golang.org/x/net/websocket"
+)
+
+const (
+	defaultQueueSize    = 256
+	defaultWriteTimeout = 10 * time.Second
+)
+
+// AlertHub delivers new alerts to the WebSocket clients of this instance.
+// Every client has its own outbound queue drained by its own writer, so a
+// slow client only delays itself; a client whose queue overflows or whose
+// write misses the deadline is disconnected and may resume later.
+type AlertHub struct {
+	QueueSize    int
+	WriteTimeout time.Duration
+
+	mu      sync.RWMutex
+	clients map[*wsClient]struct{}
+
+	subscribe sync.Once
+}
+
+func NewAlertHub() *AlertHub {
+	return &AlertHub{
+		QueueSize:    defaultQueueSize,
+		WriteTimeout: defaultWriteTimeout,
+	}
+}
+
+// defaultAlertHub serves the alert WebSocket of this instance.
+var defaultAlertHub = NewAlertHub()
+
+// Clients returns the number of connected clients.
+func (h *AlertHub) Clients() int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return len(h.clients)
+}
+
+// Broadcast queues the alert for every client with a matching subscription.
+// The alert is marshalled once for all of them.
+func (h *AlertHub) Broadcast(alert *models.Alert) {
+	payload, err := json.Marshal(alert)
+	if err != nil {
+		log.Printf("Ошибка сериализации тревоги %s: %v", alert.ID.Hex(), err)
+		return
+	}
+
+	for _, client := range h.snapshot() {
+		client.pushAlert(alert, payload)
+	}
+}
+
+// BroadcastActivity queues a comment or tag change for every client with a
+// subscription matching its alert.
+func (h *AlertHub) BroadcastActivity(activity *models.AlertActivity) {
+	msg := wsServerMessage{Type: activity.Type, AlertID: activity.Alert.ID.Hex()}
+	var err error
+	switch activity.Type {
+	case models.ActivityComment:
+		msg.Comment, err = json.Marshal(activity.Comment)
+	case models.ActivityTags:
+		tags := activity.Alert.Tags
+		if tags == nil {
+			tags = []string{}
+		}
+		msg.Tags, err = json.Marshal(tags)
+	}
+	if err != nil {
+		log.Printf("Ошибка сериализации изменений тревоги %s: %v", msg.AlertID, err)
+		return
+	}
+
+	for _, client := range h.snapshot() {
+		if msg.Subscriptions = client.matching(activity.Alert); len(msg.Subscriptions) == 0 {
+			continue
+		}
+		if data, err := json.Marshal(msg); err == nil {
+			client.offer(data)
+		}
+	}
+}
+
+func (h *AlertHub) snapshot() []*wsClient {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	clients := make([]*wsClient, 0, len(h.clients))
+	for client := range h.clients {
+		clients = append(clients, client)
+	}
+	return clients
+}
+
+// connect registers a connection and starts its writer. The first client
+// subscribes the hub to new alerts and to activity on them.
+func (h *AlertHub) connect(conn *websocket.Conn, scope AccessScope, filter models.AlertStreamFilter) *wsClient {
+	h.subscribe.Do(func() {
+		alertBus.Subscribe(h.Broadcast)
+		activityBus.Subscribe(h.BroadcastActivity)
+	})
+
+	queueSize, writeTimeout := h.QueueSize, h.WriteTimeout
+	if queueSize <= 0 {
+		queueSize = defaultQueueSize
+	}
+	if writeTimeout <= 0 {
+		writeTimeout = defaultWriteTimeout
+	}
+
+	client := &wsClient{
+		conn:         conn,
+		scope:        scope,
+		subs:         map[string]models.AlertStreamFilter{defaultSubscription: filter},
+		implicit:     true,
+		queue:        make(chan []byte, queueSize),
+		done:         make(chan struct{}),
+		writeTimeout: writeTimeout,
+	}
+
+	h.mu.Lock()
+	if h.clients == nil {
+		h.clients = make(map[*wsClient]struct{})
+	}
+	h.clients[client] = struct{}{}
+	h.mu.Unlock()
+
+	go client.writeLoop()
+	return client
+}
+
+func (h *AlertHub) disconnect(client *wsClient) {
+	h.mu.Lock()
+	delete(h.clients, client)
+	h.mu.Unlock()
+	client.close()
+}
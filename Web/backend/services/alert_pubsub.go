@@ -0,0 +1,229 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"backend/config"
+	"backend/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// AlertPubSub carries new alerts, and the comments and tag changes on them,
+// to the live feeds of every backend instance. Each instance runs one
+// subscriber, which hands every event, wherever it happened, exactly once to
+// its local bus.
+type AlertPubSub interface {
+	// Publish announces an alert stored by this instance.
+	Publish(alert *models.Alert) error
+	// Run passes every announced alert to deliver until ctx is done.
+	Run(ctx context.Context, deliver func(*models.Alert)) error
+	// PublishActivity announces a comment or tag change stored by this
+	// instance.
+	PublishActivity(activity *models.AlertActivity) error
+	// RunActivity passes every announced activity to deliver until ctx is
+	// done.
+	RunActivity(ctx context.Context, deliver func(*models.AlertActivity)) error
+}
+
+// NewAlertPubSub returns the pub/sub selected by ALERT_PUBSUB.
+func NewAlertPubSub() AlertPubSub {
+	if config.Realtime != nil && config.Realtime.PubSub == config.PubSubChangeStream {
+		return &ChangeStreamPubSub{
+			Collection: config.GetCollection("alerts"),
+			Comments:   config.GetCollection("alert_comments"),
+			RetryDelay: 5 * time.Second,
+		}
+	}
+	return NewMemoryPubSub()
+}
+
+// ErrPubSubFull is returned when the in-memory queue is not being drained.
+var ErrPubSubFull = errors.New("очередь тревог переполнена")
+
+const memoryPubSubBuffer = 1024
+
+// MemoryPubSub delivers alerts within a single instance.
+type MemoryPubSub struct {
+	alerts   chan *models.Alert
+	activity chan *models.AlertActivity
+}
+
+func NewMemoryPubSub() *MemoryPubSub {
+	return &MemoryPubSub{
+		alerts:   make(chan *models.Alert, memoryPubSubBuffer),
+		activity: make(chan *models.AlertActivity, memoryPubSubBuffer),
+	}
+}
+
+func (p *MemoryPubSub) Publish(alert *models.Alert) error {
+	return offerEvent(p.alerts, alert)
+}
+
+func (p *MemoryPubSub) Run(ctx context.Context, deliver func(*models.Alert)) error {
+	return drainEvents(ctx, p.alerts, deliver)
+}
+
+func (p *MemoryPubSub) PublishActivity(activity *models.AlertActivity) error {
+	return offerEvent(p.activity, activity)
+}
+
+func (p *MemoryPubSub) RunActivity(ctx context.Context, deliver func(*models.AlertActivity)) error {
+	return drainEvents(ctx, p.activity, deliver)
+}
+
+func offerEvent[T any](events chan T, event T) error {
+	select {
+	case events <- event:
+		return nil
+	default:
+		return ErrPubSubFull
+	}
+}
+
+func drainEvents[T any](ctx context.Context, events chan T, deliver func(T)) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case event := <-events:
+			deliver(event)
+		}
+	}
+}
+
+// ChangeStreamPubSub follows the alerts and comments collections, so every
+// instance sees the alerts, comments and tags stored by all of them.
+// Publishing is implicit in the write.
+type ChangeStreamPubSub struct {
+	Collection *mongo.Collection
+	// Comments is the collection of alert comments. Without it comments
+	// written on other instances are not delivered.
+	Comments   *mongo.Collection
+	RetryDelay time.Duration
+}
+
+func (p *ChangeStreamPubSub) Publish(alert *models.Alert) error {
+	return nil
+}
+
+func (p *ChangeStreamPubSub) PublishActivity(activity *models.AlertActivity) error {
+	return nil
+}
+
+// Run watches for inserted alerts.
+func (p *ChangeStreamPubSub) Run(ctx context.Context, deliver func(*models.Alert)) error {
+	pipeline := mongo.Pipeline{{{Key: "$match", Value: bson.M{"operationType": "insert"}}}}
+	return p.watch(ctx, p.Collection, pipeline, func(stream *mongo.ChangeStream) error {
+		var event struct {
+			FullDocument models.Alert `bson:"fullDocument"`
+		}
+		if err := stream.Decode(&event); err != nil {
+			return err
+		}
+		deliver(&event.FullDocument)
+		return nil
+	})
+}
+
+// RunActivity watches for alerts whose tags were set and for written
+// comments, which are delivered with their alert.
+func (p *ChangeStreamPubSub) RunActivity(ctx context.Context, deliver func(*models.AlertActivity)) error {
+	errs := make(chan error, 2)
+	watches := 1
+
+	tagsPipeline := mongo.Pipeline{{{Key: "$match", Value: bson.M{
+		"operationType":                        "update",
+		"updateDescription.updatedFields.tags": bson.M{"$exists": true},
+	}}}}
+	go func() {
+		errs <- p.watch(ctx, p.Collection, tagsPipeline, func(stream *mongo.ChangeStream) error {
+			var event struct {
+				FullDocument *models.Alert `bson:"fullDocument"`
+			}
+			if err := stream.Decode(&event); err != nil {
+				return err
+			}
+			if event.FullDocument != nil {
+				deliver(&models.AlertActivity{Type: models.ActivityTags, Alert: event.FullDocument})
+			}
+			return nil
+		})
+	}()
+
+	if p.Comments != nil {
+		watches++
+		commentsPipeline := mongo.Pipeline{{{Key: "$match", Value: bson.M{
+			"operationType": bson.M{"$in": bson.A{"insert", "update", "replace"}},
+		}}}}
+		go func() {
+			errs <- p.watch(ctx, p.Comments, commentsPipeline, func(stream *mongo.ChangeStream) error {
+				var event struct {
+					FullDocument *models.AlertComment `bson:"fullDocument"`
+				}
+				if err := stream.Decode(&event); err != nil {
+					return err
+				}
+				if event.FullDocument == nil {
+					return nil
+				}
+				var alert models.Alert
+				if err := p.Collection.FindOne(ctx, bson.M{"_id": event.FullDocument.AlertID}).Decode(&alert); err != nil {
+					return err
+				}
+				deliver(&models.AlertActivity{Type: models.ActivityComment, Alert: &alert, Comment: event.FullDocument})
+				return nil
+			})
+		}()
+	}
+
+	err := <-errs
+	for i := 1; i < watches; i++ {
+		<-errs
+	}
+	return err
+}
+
+// watch follows the change stream of coll, with full documents looked up
+// for updates, and hands every event to handle. It resumes after the last
+// handled event when the stream breaks.
+func (p *ChangeStreamPubSub) watch(ctx context.Context, coll *mongo.Collection, pipeline mongo.Pipeline, handle func(*mongo.ChangeStream) error) error {
+	var resumeToken bson.Raw
+
+	for {
+		opts := options.ChangeStream().SetFullDocument(options.UpdateLookup)
+		if resumeToken != nil {
+			opts.SetResumeAfter(resumeToken)
+		}
+
+		stream, err := coll.Watch(ctx, pipeline, opts)
+		if err == nil {
+			for stream.Next(ctx) {
+				if err := handle(stream); err != nil {
+					log.Printf("Ошибка чтения потока изменений %s: %v", coll.Name(), err)
+				}
+				resumeToken = stream.ResumeToken()
+			}
+			err = stream.Err()
+			stream.Close(context.Background())
+		}
+
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil {
+			log.Printf("Поток изменений %s прерван: %v", coll.Name(), err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(p.RetryDelay):
+		}
+	}
+}
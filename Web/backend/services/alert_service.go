@@ -2,9 +2,14 @@ package services
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
 	"sync"
 	"time"
+	"unicode/utf8"
 
 	"backend/config"
 	"backend/models"
@@ -13,18 +18,37 @@ import (
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
-	"golang.org/x/net/websocket"
 )
 
 type AlertService struct {
 	Collection *mongo.Collection
+	Zones      *ZoneService
+	Cameras    *CameraService
+	Severity   *SeverityService
+	Types      *AlertTypeService
+	Incidents  *IncidentService
+	Hub        *AlertHub
+	PubSub     AlertPubSub
+	Feedback   *FeedbackService
 }
 
+// ErrAlertSuppressed is returned by CreateAlert when the detection falls
+// outside the camera's include zones or inside one of its exclude zones.
+var ErrAlertSuppressed = errors.New("тревога подавлена зонами камеры")
+
+// ErrAlertOutOfScope is returned by CreateAlert when an alert without a
+// camera names a building or floor outside the caller's access.
+var ErrAlertOutOfScope = errors.New("здание или этаж тревоги вне доступных вам")
+
 type AlertFilter struct {
-	CameraID  string
-	Status    string
-	StartDate time.Time
-	EndDate   time.Time
+	CameraID    string
+	Status      string
+	Severities  []string
+	MinSeverity string
+	StartDate   time.Time
+	EndDate     time.Time
+	// Tags selects alerts carrying any of the tags.
+	Tags []string
 }
 
 type AlertPagination struct {
@@ -37,50 +61,82 @@ type AlertPagination struct {
 func NewAlertService() *AlertService {
 	return &AlertService{
 		Collection: config.GetCollection("alerts"),
+		Zones:      NewZoneService(),
+		Cameras:    NewCameraService(),
+		Severity:   NewSeverityService(),
+		Types:      NewAlertTypeService(),
+		Incidents:  NewIncidentService(),
+		Hub:        defaultAlertHub,
+		PubSub:     NewAlertPubSub(),
+		Feedback:   NewFeedbackService(),
 	}
 }
 
-func (s *AlertService) GetAlerts(filter AlertFilter, pagination AlertPagination) ([]models.Alert, int64, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-
-	filterQuery := bson.M{}
+// AlertQuery builds the Mongo filter selecting the alerts in scope that pass
+// the filter.
+func AlertQuery(scope AccessScope, filter AlertFilter) bson.M {
+	query := scope.LocationFilter(bson.M{})
 	if filter.CameraID != "" {
 		cameraID, err := primitive.ObjectIDFromHex(filter.CameraID)
 		if err == nil {
-			filterQuery["cameraId"] = cameraID
+			query["cameraId"] = cameraID
 		}
 	}
 	if filter.Status != "" {
-		filterQuery["status"] = filter.Status
+		query["status"] = filter.Status
 	}
-	if !filter.StartDate.IsZero() {
-		filterQuery["createdAt"] = bson.M{"$gte": filter.StartDate}
+	if len(filter.Severities) > 0 {
+		query["severity"] = bson.M{"$in": filter.Severities}
 	}
-	if !filter.EndDate.IsZero() {
-		if filterQuery["createdAt"] == nil {
-			filterQuery["createdAt"] = bson.M{}
+	if filter.MinSeverity != "" {
+		query["priority"] = bson.M{"$gte": models.SeverityRank(filter.MinSeverity)}
+	}
+	if !filter.StartDate.IsZero() || !filter.EndDate.IsZero() {
+		period := bson.M{}
+		if !filter.StartDate.IsZero() {
+			period["$gte"] = filter.StartDate
+		}
+		if !filter.EndDate.IsZero() {
+			period["$lte"] = filter.EndDate
 		}
-		filterQuery["createdAt"].(bson.M)["$lte"] = filter.EndDate
+		query["start_datetime"] = period
+	}
+	if len(filter.Tags) > 0 {
+		query["tags"] = bson.M{"$in": filter.Tags}
+	}
+	return query
+}
+
+// AlertSort returns the order of the alert list. Without an explicit order
+// the most urgent and then the newest alerts come first.
+func AlertSort(pagination AlertPagination) bson.D {
+	if pagination.SortBy == "" {
+		return bson.D{{Key: "priority", Value: -1}, {Key: "start_datetime", Value: -1}}
+	}
+	sortValue := 1
+	if pagination.SortDesc {
+		sortValue = -1
+	}
+	sortBy := pagination.SortBy
+	if sortBy == "severity" {
+		sortBy = "priority"
 	}
+	return bson.D{{Key: sortBy, Value: sortValue}}
+}
+
+func (s *AlertService) GetAlerts(scope AccessScope, filter AlertFilter, pagination AlertPagination) ([]models.Alert, int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
 
+	filterQuery := AlertQuery(scope, filter)
 	total, err := s.Collection.CountDocuments(ctx, filterQuery)
 	if err != nil {
 		return nil, 0, err
 	}
 
-	sortOptions := bson.D{}
-	if pagination.SortBy != "" {
-		sortValue := 1
-		if pagination.SortDesc {
-			sortValue = -1
-		}
-		sortOptions = append(sortOptions, bson.E{Key: pagination.SortBy, Value: sortValue})
-	}
-
 	skip := (pagination.Page - 1) * pagination.PageSize
 	findOptions := options.Find().
-		SetSort(sortOptions).
+		SetSort(AlertSort(pagination)).
 		SetSkip(skip).
 		SetLimit(pagination.PageSize)
 
@@ -98,64 +154,425 @@ func (s *AlertService) GetAlerts(filter AlertFilter, pagination AlertPagination)
 	return alerts, total, nil
 }
 
-func (s *AlertService) CreateAlert(alert *models.Alert) (*models.Alert, error) {
+func (s *AlertService) CreateAlert(scope AccessScope, alert *models.Alert) (*models.Alert, error) {
+	alertType, err := s.resolveType(scope, alert)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.locateAlert(scope, alert); err != nil {
+		return nil, err
+	}
+	if err := s.applyZones(scope, alert); err != nil {
+		return nil, err
+	}
+
+	if alert.Tags, err = NormalizeTags(alert.Tags); err != nil {
+		return nil, err
+	}
+	if alert.Clip != nil && alert.Clip.Path == "" {
+		alert.Clip = nil
+	}
+	if alert.Clip != nil && !ValidClipPath(alert.Clip.Path) {
+		return nil, errors.New("путь клипа должен быть относительным и без \"..\": " + alert.Clip.Path)
+	}
+
 	alert.ID = primitive.NewObjectID()
+	alert.OrganizationID = scope.OrganizationID
+	if err := s.assignSeverity(alert, alertType.DefaultSeverity); err != nil {
+		return nil, err
+	}
+	alert.Status = models.AlertStatusNew
+	alert.AcknowledgedBy, alert.AcknowledgedAt = "", nil
+	alert.ResolvedBy, alert.ResolvedAt = "", nil
+	alert.Resolution, alert.LabeledBy, alert.LabeledAt, alert.LabelPending = "", "", nil, false
 	alert.StartDateTime = time.Now()
 	alert.EndDateTime = time.Now()
+	alert.IncidentID, alert.Correlated = primitive.NilObjectID, false
+	if s.Incidents != nil {
+		if _, err := s.Incidents.Correlate(alert); err != nil {
+			return nil, err
+		}
+	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	_, err := s.Collection.InsertOne(ctx, alert)
+	_, err = s.Collection.InsertOne(ctx, alert)
 	if err != nil {
+		if !alert.IncidentID.IsZero() {
+			err = errors.Join(err, s.Incidents.Detach(alert))
+		}
 		return nil, err
 	}
 
-	go s.broadcastNewAlert(alert)
+	s.publish(alert)
+	go runAlertHooks(alert)
 
 	return alert, nil
 }
 
-var (
-	wsClients    = make(map[*websocket.Conn]bool)
-	wsClientsMux sync.RWMutex
-)
+// publish hands a stored alert to the live feeds. Without a pub/sub it only
+// reaches the clients of this instance.
+func (s *AlertService) publish(alert *models.Alert) {
+	if s.PubSub == nil {
+		alertBus.Enqueue(alert)
+		return
+	}
+	if err := s.PubSub.Publish(alert); err != nil {
+		log.Printf("Ошибка публикации тревоги %s: %v", alert.ID.Hex(), err)
+	}
+}
 
-func (s *AlertService) HandleWebSocket(conn *websocket.Conn) {
-	wsClientsMux.Lock()
-	wsClients[conn] = true
-	wsClientsMux.Unlock()
+// publishActivity hands a stored comment or tag change to the WebSocket
+// feed, like AlertService.publish does for alerts.
+func publishActivity(pubsub AlertPubSub, activity *models.AlertActivity) {
+	if pubsub == nil {
+		activityBus.Enqueue(activity)
+		return
+	}
+	if err := pubsub.PublishActivity(activity); err != nil {
+		log.Printf("Ошибка публикации изменений тревоги %s: %v", activity.Alert.ID.Hex(), err)
+	}
+}
 
-	defer func() {
-		wsClientsMux.Lock()
-		delete(wsClients, conn)
-		wsClientsMux.Unlock()
-		conn.Close()
+// RunPubSub feeds the alerts, comments and tag changes of all instances to
+// the live feeds of this one until the context is cancelled.
+func (s *AlertService) RunPubSub(ctx context.Context) {
+	if s.PubSub == nil {
+		return
+	}
+	go func() {
+		if err := s.PubSub.RunActivity(ctx, activityBus.Publish); err != nil && ctx.Err() == nil {
+			log.Printf("Доставка комментариев и меток остановлена: %v", err)
+		}
 	}()
+	if err := s.PubSub.Run(ctx, alertBus.Publish); err != nil && ctx.Err() == nil {
+		log.Printf("Доставка тревог остановлена: %v", err)
+	}
+}
 
-	for {
-		_, err := conn.Read(make([]byte, 1024))
-		if err != nil {
-			break
+// AcknowledgeAlert marks a new alert as taken by the user. It returns the
+// alert before and after the change.
+func (s *AlertService) AcknowledgeAlert(scope AccessScope, id, username string) (before, after *models.Alert, err error) {
+	now := time.Now()
+	return s.transition(scope, id,
+		bson.A{models.AlertStatusNew, nil},
+		bson.M{"status": models.AlertStatusAcknowledged, "acknowledgedBy": username, "acknowledgedAt": now},
+		func(a *models.Alert) {
+			a.Status = models.AlertStatusAcknowledged
+			a.AcknowledgedBy = username
+			a.AcknowledgedAt = &now
+		},
+	)
+}
+
+// ResolveAlert closes a new or acknowledged alert, optionally recording
+// whether it was a real event or a false positive. That verdict is kept as
+// a training label of the anomaly model.
+func (s *AlertService) ResolveAlert(scope AccessScope, id, username, resolution string) (before, after *models.Alert, err error) {
+	switch resolution {
+	case "", models.AlertResolutionConfirmed, models.AlertResolutionFalsePositive:
+	default:
+		return nil, nil, errors.New("неизвестный результат: " + resolution)
+	}
+
+	// MongoDB keeps milliseconds; the pending mark is cleared by matching
+	// labeledAt, so the time must round-trip unchanged.
+	now := time.Now().Truncate(time.Millisecond)
+	// A resolve without a verdict also clears any stored one, so that the
+	// label backfill never picks it up.
+	set := bson.M{"status": models.AlertStatusResolved, "resolvedBy": username, "resolvedAt": now, "resolution": resolution}
+	if resolution != "" {
+		set["labeledBy"] = username
+		set["labeledAt"] = now
+		set["labelPending"] = true
+	}
+	before, after, err = s.transition(scope, id,
+		bson.A{models.AlertStatusNew, models.AlertStatusAcknowledged, nil},
+		set,
+		func(a *models.Alert) {
+			a.Status = models.AlertStatusResolved
+			a.ResolvedBy = username
+			a.ResolvedAt = &now
+			a.Resolution = resolution
+			if resolution != "" {
+				a.LabeledBy, a.LabeledAt, a.LabelPending = username, &now, true
+			}
+		},
+	)
+	if err != nil {
+		return nil, nil, err
+	}
+	if resolution != "" {
+		s.recordLabel(after)
+	}
+	return before, after, nil
+}
+
+// SetResolution gives or corrects the verdict on a resolved alert and
+// replaces its training label. It returns the alert before and after the
+// change.
+func (s *AlertService) SetResolution(scope AccessScope, id, username, resolution string) (before, after *models.Alert, err error) {
+	if resolution != models.AlertResolutionConfirmed && resolution != models.AlertResolutionFalsePositive {
+		return nil, nil, errors.New("неизвестный результат: " + resolution)
+	}
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, nil, errors.New("некорректный ID тревоги")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	now := time.Now().Truncate(time.Millisecond)
+	before = &models.Alert{}
+	err = s.Collection.FindOneAndUpdate(ctx,
+		scope.LocationFilter(bson.M{"_id": objID, "status": models.AlertStatusResolved}),
+		bson.M{"$set": bson.M{"resolution": resolution, "labeledBy": username, "labeledAt": now, "labelPending": true}},
+	).Decode(before)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil, errors.New("тревога не найдена или еще не закрыта")
 		}
+		return nil, nil, err
 	}
+
+	updated := *before
+	updated.Resolution = resolution
+	updated.LabeledBy, updated.LabeledAt, updated.LabelPending = username, &now, true
+	s.recordLabel(&updated)
+	return before, &updated, nil
 }
 
-func (s *AlertService) broadcastNewAlert(alert *models.Alert) {
-	wsClientsMux.RLock()
-	defer wsClientsMux.RUnlock()
+// recordLabel stores the training label of a new verdict. A failure is only
+// logged: the alert keeps its labelPending mark and FeedbackService.Run
+// stores the label later.
+func (s *AlertService) recordLabel(alert *models.Alert) {
+	if s.Feedback == nil {
+		return
+	}
+	if err := s.Feedback.RecordLabel(alert); err != nil {
+		log.Printf("Ошибка сохранения разметки тревоги %s, повтор позже: %v", alert.ID.Hex(), err)
+	}
+}
 
-	for client := range wsClients {
-		data, err := json.Marshal(alert)
-		if err != nil {
-			client.Close()
-			delete(wsClients, client)
+const (
+	maxAlertTags = 20
+	maxTagLength = 50
+)
+
+// NormalizeTags trims and lowercases tags and drops empty and repeated ones,
+// keeping the original order.
+func NormalizeTags(tags []string) ([]string, error) {
+	var normalized []string
+	seen := make(map[string]bool)
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.Join(strings.Fields(tag), " "))
+		if tag == "" || seen[tag] {
 			continue
 		}
-		_, err = client.Write(data)
+		if utf8.RuneCountInString(tag) > maxTagLength {
+			return nil, fmt.Errorf("метка длиннее %d символов: %s", maxTagLength, tag)
+		}
+		seen[tag] = true
+		normalized = append(normalized, tag)
+	}
+	if len(normalized) > maxAlertTags {
+		return nil, fmt.Errorf("у тревоги может быть не больше %d меток", maxAlertTags)
+	}
+	return normalized, nil
+}
+
+// SetTags replaces the tags of an alert and announces the new set on the
+// live feed. It returns the alert before and after the change.
+func (s *AlertService) SetTags(scope AccessScope, id string, tags []string) (before, after *models.Alert, err error) {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, nil, errors.New("некорректный ID тревоги")
+	}
+	tags, err = NormalizeTags(tags)
+	if err != nil {
+		return nil, nil, err
+	}
+	if tags == nil {
+		tags = []string{}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	before = &models.Alert{}
+	err = s.Collection.FindOneAndUpdate(ctx,
+		scope.LocationFilter(bson.M{"_id": objID}),
+		bson.M{"$set": bson.M{"tags": tags}},
+	).Decode(before)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil, errors.New("тревога не найдена")
+		}
+		return nil, nil, err
+	}
+
+	updated := *before
+	updated.Tags = tags
+	publishActivity(s.PubSub, &models.AlertActivity{Type: models.ActivityTags, Alert: &updated})
+	return before, &updated, nil
+}
+
+// GetTags returns the tags used on the alerts in scope, sorted, for
+// suggestions and filters.
+func (s *AlertService) GetTags(scope AccessScope) ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	values, err := s.Collection.Distinct(ctx, "tags", scope.LocationFilter(bson.M{}))
+	if err != nil {
+		return nil, err
+	}
+	tags := make([]string, 0, len(values))
+	for _, value := range values {
+		if tag, ok := value.(string); ok {
+			tags = append(tags, tag)
+		}
+	}
+	sort.Strings(tags)
+	return tags, nil
+}
+
+// transition moves an alert whose status is one of from, applying set in the
+// database and apply to the returned copy.
+func (s *AlertService) transition(scope AccessScope, id string, from bson.A, set bson.M, apply func(*models.Alert)) (*models.Alert, *models.Alert, error) {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, nil, errors.New("некорректный ID тревоги")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var before models.Alert
+	err = s.Collection.FindOneAndUpdate(ctx,
+		scope.LocationFilter(bson.M{"_id": objID, "status": bson.M{"$in": from}}),
+		bson.M{"$set": set},
+	).Decode(&before)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil, errors.New("тревога не найдена или уже обработана")
+		}
+		return nil, nil, err
+	}
+
+	after := before
+	apply(&after)
+	return &before, &after, nil
+}
+
+// resolveType looks up the catalog type of a new alert, naming the alert
+// after it when the source only sent a model class. Without a type service
+// only the built-in types are known.
+func (s *AlertService) resolveType(scope AccessScope, alert *models.Alert) (*models.AlertTypeDefinition, error) {
+	var alertType *models.AlertTypeDefinition
+	if s.Types != nil {
+		var err error
+		alertType, err = s.Types.Resolve(scope.OrganizationID, alert.AlertType, alert.ModelClass)
+		if err != nil {
+			return nil, err
+		}
+	} else if alertType = models.FindAlertType(models.BuiltinAlertTypes, alert.AlertType, alert.ModelClass); alertType == nil {
+		return nil, ErrUnknownAlertType
+	}
+
+	alert.AlertType = alertType.Key
+	return alertType, nil
+}
+
+// assignSeverity computes the severity of a new alert. Without a severity
+// service a valid severity sent by the source is kept.
+func (s *AlertService) assignSeverity(alert *models.Alert, defaultSeverity string) error {
+	if s.Severity != nil {
+		severity, err := s.Severity.Compute(alert, defaultSeverity)
 		if err != nil {
-			client.Close()
-			delete(wsClients, client)
+			return err
 		}
+		alert.Severity = severity
+	} else if models.SeverityRank(alert.Severity) == 0 {
+		alert.Severity = ComputeSeverity(nil, alert, defaultSeverity, time.Now())
+	}
+
+	alert.Priority = models.SeverityRank(alert.Severity)
+	return nil
+}
+
+// locateAlert copies the building and floor of the source camera onto the
+// alert so that access scopes can be applied to it.
+func (s *AlertService) locateAlert(scope AccessScope, alert *models.Alert) error {
+	if s.Cameras == nil || alert.CameraID.IsZero() {
+		// Without a camera the source names the location, which must be one
+		// the caller may see, or the alert would reach other buildings.
+		if !scope.AllowsLocation(scope.OrganizationID, alert.BuildingID, alert.FloorID) {
+			return ErrAlertOutOfScope
+		}
+		return nil
+	}
+
+	camera, err := s.Cameras.GetCameraByID(scope, alert.CameraID.Hex())
+	if err != nil {
+		return err
+	}
+	alert.BuildingID = camera.BuildingID
+	alert.FloorID = camera.FloorID
+
+	return nil
+}
+
+// applyZones checks the detection point against the camera zones and records
+// the zone that fired on the alert. A zone sent by the source is dropped.
+func (s *AlertService) applyZones(scope AccessScope, alert *models.Alert) error {
+	alert.ZoneID, alert.ZoneName = primitive.NilObjectID, ""
+	if s.Zones == nil || alert.CameraID.IsZero() || alert.Location == nil {
+		return nil
+	}
+
+	zones, err := s.Zones.GetZonesByCamera(scope, alert.CameraID.Hex())
+	if err != nil {
+		return err
+	}
+
+	zone, allowed := MatchZones(zones, *alert.Location)
+	if !allowed {
+		return ErrAlertSuppressed
+	}
+	if zone != nil {
+		alert.ZoneID = zone.ID
+		alert.ZoneName = zone.Name
+	}
+
+	return nil
+}
+
+// AlertHook is called in the background for every stored alert. Hooks must
+// not modify the alert.
+type AlertHook func(alert *models.Alert)
+
+var (
+	alertHooks    []AlertHook
+	alertHooksMux sync.RWMutex
+)
+
+// RegisterAlertHook adds an integration, such as webhooks, that reacts to new
+// alerts.
+func RegisterAlertHook(hook AlertHook) {
+	alertHooksMux.Lock()
+	defer alertHooksMux.Unlock()
+	alertHooks = append(alertHooks, hook)
+}
+
+func runAlertHooks(alert *models.Alert) {
+	alertHooksMux.RLock()
+	hooks := alertHooks
+	alertHooksMux.RUnlock()
+
+	for _, hook := range hooks {
+		hook(alert)
 	}
 }
@@ -0,0 +1,358 @@
+package services
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"backend/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang.org/x/net/websocket"
+)
+
+// The alert WebSocket speaks JSON messages with a "type" field.
+//
+// Client messages:
+//
+//	subscribe   {id, filter}  receive alerts matching filter under id
+//	unsubscribe {id}
+//	resume      {token}       replay alerts raised after the token
+//	ping                      keep the connection alive
+//
+// Server messages:
+//
+//	hello        {heartbeat_interval}  sent once on connect
+//	subscribed   {id}
+//	unsubscribed {id}
+//	alert        {subscriptions, alert, resume_token}
+//	comment      {subscriptions, alert_id, comment}
+//	tags         {subscriptions, alert_id, tags}
+//	resumed      {count}
+//	heartbeat                          sent every heartbeat interval
+//	pong
+//	error        {id, error}
+//
+// The server closes connections that stay silent for two heartbeat
+// intervals. A client that reconnects subscribes again and then sends the
+// resume_token of the last alert it received; alerts of up to ResumeWindow
+// ago are replayed. Replay may repeat alerts raised in the same second as
+// the token, so clients deduplicate by alert id.
+//
+// Every connection starts with the subscription "default", whose filter is
+// read from the URL query like that of the Server-Sent Events feed (for
+// example ?min_severity=high). Clients written before subscriptions existed
+// never send subscribe and keep receiving alerts through it. The first
+// subscribe message drops it, so clients that manage their own subscriptions
+// only see alerts tagged "default" until then.
+//
+// comment carries a new or edited comment on an alert the client is
+// subscribed to, tags the alert's whole new set of tags. They are only
+// pushed live, never replayed; after a reconnect clients reload them over
+// the REST API.
+const (
+	HeartbeatInterval = 30 * time.Second
+	ResumeWindow      = 5 * time.Minute
+
+	maxSubscriptions  = 20
+	maxSubscriptionID = 64
+	maxResumedAlerts  = 500
+
+	defaultSubscription = "default"
+)
+
+// ErrResumeExpired is returned for resume tokens older than ResumeWindow.
+var ErrResumeExpired = errors.New("токен возобновления устарел, загрузите тревоги заново")
+
+type wsClientMessage struct {
+	Type   string                   `json:"type"`
+	ID     string                   `json:"id,omitempty"`
+	Filter models.AlertStreamFilter `json:"filter"`
+	Token  string                   `json:"token,omitempty"`
+}
+
+type wsServerMessage struct {
+	Type              string          `json:"type"`
+	ID                string          `json:"id,omitempty"`
+	Error             string          `json:"error,omitempty"`
+	HeartbeatInterval int             `json:"heartbeat_interval,omitempty"`
+	Subscriptions     []string        `json:"subscriptions,omitempty"`
+	Alert             json.RawMessage `json:"alert,omitempty"`
+	AlertID           string          `json:"alert_id,omitempty"`
+	Comment           json.RawMessage `json:"comment,omitempty"`
+	Tags              json.RawMessage `json:"tags,omitempty"`
+	ResumeToken       string          `json:"resume_token,omitempty"`
+	Count             *int            `json:"count,omitempty"`
+}
+
+// errClientClosed is returned when sending to a disconnected client.
+var errClientClosed = errors.New("соединение закрыто")
+
+// wsClient is one alert WebSocket connection with its subscriptions. All
+// writes go through queue to the single writeLoop.
+type wsClient struct {
+	conn         *websocket.Conn
+	scope        AccessScope
+	queue        chan []byte
+	done         chan struct{}
+	closeOnce    sync.Once
+	writeTimeout time.Duration
+
+	subsMu sync.RWMutex
+	subs   map[string]models.AlertStreamFilter
+	// implicit is set while subs holds only the default subscription made
+	// on connect.
+	implicit bool
+}
+
+func (c *wsClient) writeLoop() {
+	for {
+		select {
+		case <-c.done:
+			return
+		case data := <-c.queue:
+			c.conn.SetWriteDeadline(time.Now().Add(c.writeTimeout))
+			if err := websocket.Message.Send(c.conn, string(data)); err != nil {
+				c.close()
+				return
+			}
+		}
+	}
+}
+
+// close disconnects the client. Its read loop then fails and unregisters it.
+func (c *wsClient) close() {
+	c.closeOnce.Do(func() {
+		close(c.done)
+		c.conn.Close()
+	})
+}
+
+// send queues a reply, waiting for room in the queue. Only the client's own
+// goroutines call it, so the wait slows down nobody else.
+func (c *wsClient) send(msg wsServerMessage) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	select {
+	case c.queue <- data:
+		return nil
+	case <-c.done:
+		return errClientClosed
+	}
+}
+
+// offer queues a message without waiting. A client that cannot keep up is
+// disconnected.
+func (c *wsClient) offer(data []byte) {
+	select {
+	case c.queue <- data:
+	case <-c.done:
+	default:
+		log.Printf("Клиент WebSocket тревог отключен: очередь переполнена")
+		c.close()
+	}
+}
+
+// matching returns the sorted ids of the subscriptions that select the alert.
+func (c *wsClient) matching(alert *models.Alert) []string {
+	if !c.scope.AllowsLocation(alert.OrganizationID, alert.BuildingID, alert.FloorID) {
+		return nil
+	}
+
+	c.subsMu.RLock()
+	defer c.subsMu.RUnlock()
+
+	var ids []string
+	for id, filter := range c.subs {
+		if filter.Matches(alert) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func (c *wsClient) alertMessage(alert *models.Alert, payload []byte) ([]string, wsServerMessage) {
+	ids := c.matching(alert)
+	return ids, wsServerMessage{
+		Type:          "alert",
+		Subscriptions: ids,
+		Alert:         payload,
+		ResumeToken:   alert.ID.Hex(),
+	}
+}
+
+// pushAlert offers a broadcast alert, already marshalled, if any
+// subscription selects it.
+func (c *wsClient) pushAlert(alert *models.Alert, payload []byte) {
+	ids, msg := c.alertMessage(alert, payload)
+	if len(ids) == 0 {
+		return
+	}
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return
+	}
+	c.offer(data)
+}
+
+// hub returns the hub serving the service's WebSocket clients.
+func (s *AlertService) hub() *AlertHub {
+	if s.Hub != nil {
+		return s.Hub
+	}
+	return defaultAlertHub
+}
+
+// HandleWebSocket serves the alert protocol on the connection within the
+// given scope, starting with the default subscription for filter, and blocks
+// until the client disconnects.
+func (s *AlertService) HandleWebSocket(scope AccessScope, filter models.AlertStreamFilter, conn *websocket.Conn) {
+	hub := s.hub()
+	client := hub.connect(conn, scope, filter)
+	defer hub.disconnect(client)
+
+	if err := client.send(wsServerMessage{Type: "hello", HeartbeatInterval: int(HeartbeatInterval / time.Second)}); err != nil {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(HeartbeatInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-client.done:
+				return
+			case <-ticker.C:
+				heartbeat, _ := json.Marshal(wsServerMessage{Type: "heartbeat"})
+				client.offer(heartbeat)
+			}
+		}
+	}()
+
+	for {
+		conn.SetReadDeadline(time.Now().Add(2 * HeartbeatInterval))
+
+		var msg wsClientMessage
+		if err := websocket.JSON.Receive(conn, &msg); err != nil {
+			var syntaxErr *json.SyntaxError
+			var typeErr *json.UnmarshalTypeError
+			if errors.As(err, &syntaxErr) || errors.As(err, &typeErr) {
+				if client.send(wsServerMessage{Type: "error", Error: "некорректное сообщение"}) != nil {
+					return
+				}
+				continue
+			}
+			return
+		}
+
+		if err := s.handleSocketMessage(client, &msg); err != nil {
+			if client.send(wsServerMessage{Type: "error", ID: msg.ID, Error: err.Error()}) != nil {
+				return
+			}
+		}
+	}
+}
+
+func (s *AlertService) handleSocketMessage(client *wsClient, msg *wsClientMessage) error {
+	switch msg.Type {
+	case "subscribe":
+		if msg.ID == "" || len(msg.ID) > maxSubscriptionID {
+			return errors.New("некорректный ID подписки")
+		}
+		if msg.Filter.MinSeverity != "" && models.SeverityRank(msg.Filter.MinSeverity) == 0 {
+			return errors.New("неизвестная важность: " + msg.Filter.MinSeverity)
+		}
+
+		client.subsMu.Lock()
+		if client.implicit {
+			delete(client.subs, defaultSubscription)
+			client.implicit = false
+		}
+		_, exists := client.subs[msg.ID]
+		if !exists && len(client.subs) >= maxSubscriptions {
+			client.subsMu.Unlock()
+			return errors.New("слишком много подписок")
+		}
+		client.subs[msg.ID] = msg.Filter
+		client.subsMu.Unlock()
+
+		return client.send(wsServerMessage{Type: "subscribed", ID: msg.ID})
+
+	case "unsubscribe":
+		client.subsMu.Lock()
+		delete(client.subs, msg.ID)
+		client.subsMu.Unlock()
+
+		return client.send(wsServerMessage{Type: "unsubscribed", ID: msg.ID})
+
+	case "resume":
+		alerts, err := s.MissedAlerts(client.scope, msg.Token)
+		if err != nil {
+			return err
+		}
+		count := 0
+		for i := range alerts {
+			payload, err := json.Marshal(&alerts[i])
+			if err != nil {
+				return err
+			}
+			ids, reply := client.alertMessage(&alerts[i], payload)
+			if len(ids) == 0 {
+				continue
+			}
+			if err := client.send(reply); err != nil {
+				return err
+			}
+			count++
+		}
+		return client.send(wsServerMessage{Type: "resumed", Count: &count})
+
+	case "ping":
+		return client.send(wsServerMessage{Type: "pong"})
+	}
+
+	return errors.New("неизвестный тип сообщения: " + msg.Type)
+}
+
+// MissedAlerts returns the alerts in scope raised after the one whose id is
+// the resume token, oldest first. Alerts raised in the token's second are
+// included because ids are only ordered across seconds.
+func (s *AlertService) MissedAlerts(scope AccessScope, token string) ([]models.Alert, error) {
+	lastID, err := primitive.ObjectIDFromHex(token)
+	if err != nil {
+		return nil, errors.New("некорректный токен возобновления")
+	}
+	if time.Since(lastID.Timestamp()) > ResumeWindow {
+		return nil, ErrResumeExpired
+	}
+
+	// The smallest id of the token's second.
+	var since primitive.ObjectID
+	binary.BigEndian.PutUint32(since[:4], uint32(lastID.Timestamp().Unix()))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	cursor, err := s.Collection.Find(ctx,
+		scope.LocationFilter(bson.M{"_id": bson.M{"$gte": since, "$ne": lastID}}),
+		options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}).SetLimit(maxResumedAlerts),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	alerts := []models.Alert{}
+	if err := cursor.All(ctx, &alerts); err != nil {
+		return nil, err
+	}
+	return alerts, nil
+}
@@ -0,0 +1,285 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"time"
+
+	"backend/config"
+	"backend/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Dimensions alert counts can be grouped by.
+const (
+	StatsByType     = "type"
+	StatsByCamera   = "camera"
+	StatsByBuilding = "building"
+	StatsByHour     = "hour"
+	StatsByWeekday  = "weekday"
+)
+
+// defaultStatsPeriod is the period covered when no start date is given.
+const defaultStatsPeriod = 30 * 24 * time.Hour
+
+var statsGranularities = map[string]bool{"hour": true, "day": true, "week": true, "month": true}
+
+// ErrStatsUnsupported is returned when the database is older than MongoDB
+// 5.0, which introduced the $dateTrunc operator the time series rely on.
+var ErrStatsUnsupported = errors.New("статистика требует MongoDB 5.0 или новее")
+
+// invalidPipelineOperator is the server error code for an unknown
+// aggregation operator.
+const invalidPipelineOperator = 168
+
+// StatsQuery selects the alerts to aggregate and how to bucket them in time.
+type StatsQuery struct {
+	StartDate   time.Time
+	EndDate     time.Time
+	Granularity string
+	Timezone    string
+	GroupBy     string
+}
+
+type StatsBucket struct {
+	Bucket time.Time `bson:"bucket" json:"bucket"`
+	Count  int64     `bson:"count" json:"count"`
+}
+
+// AlertCountSeries is the number of alerts of one group per time bucket.
+// Key is the alert type, camera or building id, hour of day (0-23) or ISO
+// day of week (1 is Monday), depending on the grouping.
+type AlertCountSeries struct {
+	Key    interface{}   `bson:"_id" json:"key"`
+	Total  int64         `bson:"total" json:"total"`
+	Series []StatsBucket `bson:"series" json:"series"`
+}
+
+// ResponseTimeBucket holds the mean times to acknowledge and to resolve the
+// alerts raised in a time bucket. Means are nil when no alert got there.
+type ResponseTimeBucket struct {
+	Bucket       time.Time `bson:"_id" json:"bucket"`
+	Alerts       int64     `bson:"alerts" json:"alerts"`
+	Acknowledged int64     `bson:"acknowledged" json:"acknowledged"`
+	Resolved     int64     `bson:"resolved" json:"resolved"`
+	MTTASeconds  *float64  `bson:"mtta" json:"mtta_seconds"`
+	MTTRSeconds  *float64  `bson:"mttr" json:"mttr_seconds"`
+}
+
+type FalsePositiveBucket struct {
+	Bucket         time.Time `bson:"bucket" json:"bucket"`
+	Resolved       int64     `bson:"resolved" json:"resolved"`
+	FalsePositives int64     `bson:"falsePositives" json:"false_positives"`
+}
+
+// FalsePositiveStats is the share of a camera's resolved alerts that
+// operators marked as false positives.
+type FalsePositiveStats struct {
+	CameraID       primitive.ObjectID    `bson:"_id" json:"camera_id"`
+	Resolved       int64                 `bson:"resolved" json:"resolved"`
+	FalsePositives int64                 `bson:"falsePositives" json:"false_positives"`
+	Rate           float64               `bson:"-" json:"rate"`
+	Series         []FalsePositiveBucket `bson:"series" json:"series"`
+}
+
+type AlertStatsService struct {
+	Collection *mongo.Collection
+	Now        func() time.Time
+}
+
+func NewAlertStatsService() *AlertStatsService {
+	return &AlertStatsService{
+		Collection: config.GetCollection("alerts"),
+		Now:        time.Now,
+	}
+}
+
+// normalize fills in the defaults of a query and validates it.
+func (s *AlertStatsService) normalize(query *StatsQuery) error {
+	if query.EndDate.IsZero() {
+		query.EndDate = s.Now()
+	}
+	if query.StartDate.IsZero() {
+		query.StartDate = query.EndDate.Add(-defaultStatsPeriod)
+	}
+	if !query.StartDate.Before(query.EndDate) {
+		return errors.New("начало периода должно быть раньше конца")
+	}
+	if query.Granularity == "" {
+		query.Granularity = "day"
+	}
+	if !statsGranularities[query.Granularity] {
+		return errors.New("неизвестная гранулярность: " + query.Granularity)
+	}
+	if query.Timezone == "" {
+		query.Timezone = "UTC"
+	}
+	if _, err := time.LoadLocation(query.Timezone); err != nil {
+		return errors.New("неизвестный часовой пояс: " + query.Timezone)
+	}
+	return nil
+}
+
+func (q *StatsQuery) match(scope AccessScope, extra bson.M) bson.M {
+	extra["start_datetime"] = bson.M{"$gte": q.StartDate, "$lt": q.EndDate}
+	return scope.LocationFilter(extra)
+}
+
+// bucket truncates the alert time to the granularity in the query's time
+// zone. $dateTrunc needs MongoDB 5.0 or newer.
+func (q *StatsQuery) bucket() bson.M {
+	return bson.M{"$dateTrunc": bson.M{"date": "$start_datetime", "unit": q.Granularity, "timezone": q.Timezone}}
+}
+
+// AlertCounts counts alerts per group and time bucket, largest groups first.
+func (s *AlertStatsService) AlertCounts(scope AccessScope, query StatsQuery) ([]AlertCountSeries, error) {
+	if err := s.normalize(&query); err != nil {
+		return nil, err
+	}
+
+	var key interface{}
+	switch query.GroupBy {
+	case StatsByType, "":
+		key = "$alert_type"
+	case StatsByCamera:
+		key = "$cameraId"
+	case StatsByBuilding:
+		key = "$buildingId"
+	case StatsByHour:
+		key = bson.M{"$hour": bson.M{"date": "$start_datetime", "timezone": query.Timezone}}
+	case StatsByWeekday:
+		key = bson.M{"$isoDayOfWeek": bson.M{"date": "$start_datetime", "timezone": query.Timezone}}
+	default:
+		return nil, errors.New("неизвестная группировка: " + query.GroupBy)
+	}
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: query.match(scope, bson.M{})}},
+		{{Key: "$group", Value: bson.M{
+			"_id":   bson.M{"key": key, "bucket": query.bucket()},
+			"count": bson.M{"$sum": 1},
+		}}},
+		{{Key: "$sort", Value: bson.D{{Key: "_id.bucket", Value: 1}}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":    "$_id.key",
+			"total":  bson.M{"$sum": "$count"},
+			"series": bson.M{"$push": bson.M{"bucket": "$_id.bucket", "count": "$count"}},
+		}}},
+		{{Key: "$sort", Value: bson.D{{Key: "total", Value: -1}, {Key: "_id", Value: 1}}}},
+	}
+
+	series := []AlertCountSeries{}
+	if err := s.aggregate(pipeline, &series); err != nil {
+		return nil, err
+	}
+	return series, nil
+}
+
+// ResponseTimes returns the mean time to acknowledge and to resolve alerts
+// per time bucket of their creation.
+func (s *AlertStatsService) ResponseTimes(scope AccessScope, query StatsQuery) ([]ResponseTimeBucket, error) {
+	if err := s.normalize(&query); err != nil {
+		return nil, err
+	}
+
+	since := func(field string) bson.M {
+		return bson.M{"$cond": bson.A{
+			bson.M{"$gt": bson.A{field, nil}},
+			bson.M{"$subtract": bson.A{field, "$start_datetime"}},
+			nil,
+		}}
+	}
+	count := func(field string) bson.M {
+		return bson.M{"$sum": bson.M{"$cond": bson.A{bson.M{"$gt": bson.A{field, nil}}, 1, 0}}}
+	}
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: query.match(scope, bson.M{})}},
+		{{Key: "$group", Value: bson.M{
+			"_id":          query.bucket(),
+			"alerts":       bson.M{"$sum": 1},
+			"acknowledged": count("$acknowledgedAt"),
+			"resolved":     count("$resolvedAt"),
+			"mtta":         bson.M{"$avg": since("$acknowledgedAt")},
+			"mttr":         bson.M{"$avg": since("$resolvedAt")},
+		}}},
+		{{Key: "$sort", Value: bson.D{{Key: "_id", Value: 1}}}},
+	}
+
+	buckets := []ResponseTimeBucket{}
+	if err := s.aggregate(pipeline, &buckets); err != nil {
+		return nil, err
+	}
+	// Means are aggregated in milliseconds.
+	for i := range buckets {
+		for _, mean := range []*float64{buckets[i].MTTASeconds, buckets[i].MTTRSeconds} {
+			if mean != nil {
+				*mean /= 1000
+			}
+		}
+	}
+	return buckets, nil
+}
+
+// FalsePositiveRates returns per camera the share of resolved alerts marked
+// as false positives, highest rate first.
+func (s *AlertStatsService) FalsePositiveRates(scope AccessScope, query StatsQuery) ([]FalsePositiveStats, error) {
+	if err := s.normalize(&query); err != nil {
+		return nil, err
+	}
+
+	falsePositive := bson.M{"$cond": bson.A{bson.M{"$eq": bson.A{"$resolution", models.AlertResolutionFalsePositive}}, 1, 0}}
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: query.match(scope, bson.M{
+			"status":   models.AlertStatusResolved,
+			"cameraId": bson.M{"$exists": true},
+		})}},
+		{{Key: "$group", Value: bson.M{
+			"_id":            bson.M{"camera": "$cameraId", "bucket": query.bucket()},
+			"resolved":       bson.M{"$sum": 1},
+			"falsePositives": bson.M{"$sum": falsePositive},
+		}}},
+		{{Key: "$sort", Value: bson.D{{Key: "_id.bucket", Value: 1}}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":            "$_id.camera",
+			"resolved":       bson.M{"$sum": "$resolved"},
+			"falsePositives": bson.M{"$sum": "$falsePositives"},
+			"series": bson.M{"$push": bson.M{
+				"bucket":         "$_id.bucket",
+				"resolved":       "$resolved",
+				"falsePositives": "$falsePositives",
+			}},
+		}}},
+	}
+
+	stats := []FalsePositiveStats{}
+	if err := s.aggregate(pipeline, &stats); err != nil {
+		return nil, err
+	}
+	for i := range stats {
+		if stats[i].Resolved > 0 {
+			stats[i].Rate = float64(stats[i].FalsePositives) / float64(stats[i].Resolved)
+		}
+	}
+	sort.SliceStable(stats, func(i, j int) bool { return stats[i].Rate > stats[j].Rate })
+	return stats, nil
+}
+
+func (s *AlertStatsService) aggregate(pipeline mongo.Pipeline, results interface{}) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	cursor, err := s.Collection.Aggregate(ctx, pipeline)
+	var serverErr mongo.ServerError
+	if errors.As(err, &serverErr) && serverErr.HasErrorCode(invalidPipelineOperator) {
+		return ErrStatsUnsupported
+	}
+	if err != nil {
+		return err
+	}
+	return cursor.All(ctx, results)
+}
@@ -0,0 +1,63 @@
+package services
+
+import (
+	"sync"
+
+	"backend/models"
+)
+
+// streamBuffer is how many alerts a live stream may lag behind before it is
+// cut off. The client then reconnects and resumes from its last event.
+const streamBuffer = 64
+
+// AlertStream is a live feed of the new alerts matching a filter within a
+// scope. Alerts is closed when the stream is closed or falls behind.
+type AlertStream struct {
+	Alerts <-chan *models.Alert
+
+	scope       AccessScope
+	filter      models.AlertStreamFilter
+	alerts      chan *models.Alert
+	unsubscribe func()
+
+	mu     sync.Mutex
+	closed bool
+}
+
+// StreamAlerts subscribes to new alerts in scope that match the filter. The
+// caller must Close the stream.
+func (s *AlertService) StreamAlerts(scope AccessScope, filter models.AlertStreamFilter) *AlertStream {
+	alerts := make(chan *models.Alert, streamBuffer)
+	stream := &AlertStream{Alerts: alerts, scope: scope, filter: filter, alerts: alerts}
+	stream.unsubscribe = alertBus.Subscribe(stream.push)
+	return stream
+}
+
+func (st *AlertStream) push(alert *models.Alert) {
+	if !st.scope.AllowsLocation(alert.OrganizationID, alert.BuildingID, alert.FloorID) || !st.filter.Matches(alert) {
+		return
+	}
+
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.closed {
+		return
+	}
+	select {
+	case st.alerts <- alert:
+	default:
+		st.closed = true
+		close(st.alerts)
+	}
+}
+
+func (st *AlertStream) Close() {
+	st.unsubscribe()
+
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if !st.closed {
+		st.closed = true
+		close(st.alerts)
+	}
+}
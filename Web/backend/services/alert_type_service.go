@@ -0,0 +1,185 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"backend/config"
+	"backend/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ErrUnknownAlertType is returned when an alert refers to a type that is not
+// in its organization's catalog or is disabled there.
+var ErrUnknownAlertType = errors.New("неизвестный тип тревоги")
+
+// AlertTypeService manages the alert type catalog of organizations: the
+// built-in types with the organization's overrides and custom types.
+type AlertTypeService struct {
+	Collection *mongo.Collection
+}
+
+func NewAlertTypeService() *AlertTypeService {
+	return &AlertTypeService{
+		Collection: config.GetCollection("alert_types"),
+	}
+}
+
+// EnsureIndexes creates the unique index that keeps one definition per key
+// and organization.
+func (s *AlertTypeService) EnsureIndexes() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	_, err := s.Collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "organizationId", Value: 1}, {Key: "key", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	return err
+}
+
+// GetTypes returns the catalog of the organization, disabled types included.
+func (s *AlertTypeService) GetTypes(organizationID primitive.ObjectID) ([]models.AlertTypeDefinition, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	cursor, err := s.Collection.Find(ctx,
+		bson.M{"organizationId": organizationID},
+		options.Find().SetSort(bson.D{{Key: "displayName", Value: 1}}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var custom []models.AlertTypeDefinition
+	if err := cursor.All(ctx, &custom); err != nil {
+		return nil, err
+	}
+
+	return models.MergeAlertTypes(custom), nil
+}
+
+// Resolve finds the enabled type an alert refers to, by key or, when the key
+// is empty, by the detector's model class.
+func (s *AlertTypeService) Resolve(organizationID primitive.ObjectID, key models.AlertType, modelClass string) (*models.AlertTypeDefinition, error) {
+	catalog, err := s.GetTypes(organizationID)
+	if err != nil {
+		return nil, err
+	}
+
+	definition := models.FindAlertType(catalog, key, modelClass)
+	if definition == nil {
+		return nil, ErrUnknownAlertType
+	}
+	return definition, nil
+}
+
+// SaveType creates or replaces the organization's definition of the type
+// with the given key. Saving a built-in key overrides the built-in type.
+func (s *AlertTypeService) SaveType(scope AccessScope, key string, definition *models.AlertTypeDefinition) (*models.AlertTypeDefinition, error) {
+	definition.Key = models.AlertType(strings.TrimSpace(key))
+	if definition.NotificationPolicy == "" {
+		definition.NotificationPolicy = models.NotifySubscribers
+	}
+	if err := validateAlertType(definition); err != nil {
+		return nil, err
+	}
+
+	catalog, err := s.GetTypes(scope.OrganizationID)
+	if err != nil {
+		return nil, err
+	}
+	for _, other := range catalog {
+		if other.Key == definition.Key || other.Disabled {
+			continue
+		}
+		for _, class := range definition.ModelClasses {
+			if other.HasModelClass(class) {
+				return nil, errors.New("класс модели " + class + " уже относится к типу " + string(other.Key))
+			}
+		}
+	}
+
+	definition.ID = primitive.NilObjectID
+	definition.OrganizationID = scope.OrganizationID
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	upsert := func(saved *models.AlertTypeDefinition) error {
+		return s.Collection.FindOneAndUpdate(ctx,
+			bson.M{"organizationId": scope.OrganizationID, "key": definition.Key},
+			bson.M{
+				"$set": bson.M{
+					"displayName":        definition.DisplayName,
+					"icon":               definition.Icon,
+					"defaultSeverity":    definition.DefaultSeverity,
+					"notificationPolicy": definition.NotificationPolicy,
+					"modelClasses":       definition.ModelClasses,
+					"disabled":           definition.Disabled,
+				},
+				"$setOnInsert": bson.M{"_id": primitive.NewObjectID()},
+			},
+			options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+		).Decode(saved)
+	}
+
+	// Of two concurrent upserts of a new key one fails on the unique index;
+	// retried, it updates the definition the other inserted.
+	var saved models.AlertTypeDefinition
+	err = upsert(&saved)
+	if mongo.IsDuplicateKeyError(err) {
+		err = upsert(&saved)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for _, builtin := range models.BuiltinAlertTypes {
+		if builtin.Key == saved.Key {
+			saved.BuiltIn = true
+		}
+	}
+	return &saved, nil
+}
+
+// DeleteType removes the organization's definition of the type. For a
+// built-in type this restores its default definition.
+func (s *AlertTypeService) DeleteType(scope AccessScope, key string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	result, err := s.Collection.DeleteOne(ctx, bson.M{"organizationId": scope.OrganizationID, "key": key})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return errors.New("тип тревоги не найден")
+	}
+	return nil
+}
+
+func validateAlertType(definition *models.AlertTypeDefinition) error {
+	if definition.Key == "" {
+		return errors.New("не указан ключ типа тревоги")
+	}
+	if strings.TrimSpace(definition.DisplayName) == "" {
+		return errors.New("не указано название типа тревоги")
+	}
+	if models.SeverityRank(definition.DefaultSeverity) == 0 {
+		return errors.New("неизвестная важность: " + definition.DefaultSeverity)
+	}
+	switch definition.NotificationPolicy {
+	case models.NotifySubscribers, models.NotifyOptIn, models.NotifyNone:
+	default:
+		return errors.New("неизвестная политика уведомлений: " + definition.NotificationPolicy)
+	}
+	return nil
+}
@@ -0,0 +1,175 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	"backend/config"
+	"backend/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const apiKeyPrefix = "abd"
+
+var ErrInvalidAPIKey = errors.New("недействительный API ключ")
+
+type APIKeyService struct {
+	Collection *mongo.Collection
+}
+
+func NewAPIKeyService() *APIKeyService {
+	return &APIKeyService{
+		Collection: config.GetCollection("api_keys"),
+	}
+}
+
+// GenerateAPIKey returns a new raw key of the form abd_<prefix>_<secret>
+// together with its lookup prefix and the hash to store.
+func GenerateAPIKey() (raw, prefix, hash string, err error) {
+	prefixBytes := make([]byte, 6)
+	secretBytes := make([]byte, 32)
+	if _, err = rand.Read(prefixBytes); err != nil {
+		return "", "", "", err
+	}
+	if _, err = rand.Read(secretBytes); err != nil {
+		return "", "", "", err
+	}
+
+	prefix = hex.EncodeToString(prefixBytes)
+	raw = apiKeyPrefix + "_" + prefix + "_" + base64.RawURLEncoding.EncodeToString(secretBytes)
+	return raw, prefix, hashToken(raw), nil
+}
+
+// ParseAPIKey extracts the lookup prefix from a raw key.
+func ParseAPIKey(raw string) (string, error) {
+	parts := strings.SplitN(raw, "_", 3)
+	if len(parts) != 3 || parts[0] != apiKeyPrefix || parts[1] == "" || parts[2] == "" {
+		return "", ErrInvalidAPIKey
+	}
+	return parts[1], nil
+}
+
+// CreateAPIKey stores a new key and returns it along with the raw secret,
+// which is shown to the caller only once.
+func (s *APIKeyService) CreateAPIKey(scope AccessScope, key *models.APIKey, createdBy string) (string, *models.APIKey, error) {
+	if key.Name == "" {
+		return "", nil, errors.New("не указано название ключа")
+	}
+	if len(key.Permissions) == 0 {
+		return "", nil, errors.New("не указаны права ключа")
+	}
+	for _, p := range key.Permissions {
+		if !p.Valid() {
+			return "", nil, errors.New("неизвестное право: " + string(p))
+		}
+	}
+
+	raw, prefix, hash, err := GenerateAPIKey()
+	if err != nil {
+		return "", nil, err
+	}
+
+	key.ID = primitive.NewObjectID()
+	key.OrganizationID = scope.OrganizationID
+	key.Prefix = prefix
+	key.KeyHash = hash
+	key.CreatedAt = time.Now()
+	key.LastUsedAt = nil
+	key.RevokedAt = nil
+	if creatorID, err := primitive.ObjectIDFromHex(createdBy); err == nil {
+		key.CreatedBy = creatorID
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if _, err := s.Collection.InsertOne(ctx, key); err != nil {
+		return "", nil, err
+	}
+
+	return raw, key, nil
+}
+
+func (s *APIKeyService) GetAPIKeys(scope AccessScope) ([]models.APIKey, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	findOptions := options.Find().SetSort(bson.D{{Key: "createdAt", Value: -1}})
+	cursor, err := s.Collection.Find(ctx, scope.Filter(bson.M{}), findOptions)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	keys := []models.APIKey{}
+	if err := cursor.All(ctx, &keys); err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+func (s *APIKeyService) RevokeAPIKey(scope AccessScope, id string) error {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return errors.New("некорректный ID ключа")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	result, err := s.Collection.UpdateOne(ctx,
+		scope.Filter(bson.M{"_id": objID, "revokedAt": bson.M{"$exists": false}}),
+		bson.M{"$set": bson.M{"revokedAt": time.Now()}},
+	)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return errors.New("ключ не найден")
+	}
+
+	return nil
+}
+
+// Authenticate checks a raw key and records its use.
+func (s *APIKeyService) Authenticate(raw string) (*models.APIKey, error) {
+	prefix, err := ParseAPIKey(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var key models.APIKey
+	err = s.Collection.FindOne(ctx, bson.M{"prefix": prefix}).Decode(&key)
+	if err != nil {
+		return nil, ErrInvalidAPIKey
+	}
+	if subtle.ConstantTimeCompare([]byte(key.KeyHash), []byte(hashToken(raw))) != 1 {
+		return nil, ErrInvalidAPIKey
+	}
+	if key.RevokedAt != nil || (key.ExpiresAt != nil && time.Now().After(*key.ExpiresAt)) {
+		return nil, ErrInvalidAPIKey
+	}
+
+	now := time.Now()
+	key.LastUsedAt = &now
+	_, err = s.Collection.UpdateOne(ctx, bson.M{"_id": key.ID}, bson.M{"$set": bson.M{"lastUsedAt": now}})
+	if err != nil {
+		return nil, err
+	}
+
+	return &key, nil
+}
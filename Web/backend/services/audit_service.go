@@ -0,0 +1,274 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"backend/config"
+	"backend/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const auditAppendAttempts = 5
+
+// AuditService appends to and reads the audit log. It deliberately has no
+// update or delete operations.
+type AuditService struct {
+	Collection *mongo.Collection
+
+	mu sync.Mutex
+}
+
+type AuditFilter struct {
+	Action       string
+	UserID       string
+	Username     string
+	ResourceType string
+	ResourceID   string
+	Success      *bool
+	From         time.Time
+	To           time.Time
+}
+
+// AuditVerification is the result of checking the hash chain. BrokenAt is the
+// sequence number of the first entry that does not match.
+type AuditVerification struct {
+	Valid    bool   `json:"valid"`
+	Checked  int64  `json:"checked"`
+	BrokenAt int64  `json:"broken_at,omitempty"`
+	Reason   string `json:"reason,omitempty"`
+}
+
+func NewAuditService() *AuditService {
+	return &AuditService{
+		Collection: config.GetCollection("audit_log"),
+	}
+}
+
+// Record appends an entry to the chain. The sequence number is the document
+// _id, so two instances appending at once cannot both extend the same head:
+// the loser gets a duplicate key error and retries on the new head.
+func (s *AuditService) Record(entry *models.AuditEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	for attempt := 0; attempt < auditAppendAttempts; attempt++ {
+		var head models.AuditEntry
+		err := s.Collection.FindOne(ctx, bson.M{}, options.FindOne().SetSort(bson.D{{Key: "_id", Value: -1}})).Decode(&head)
+		if err != nil && err != mongo.ErrNoDocuments {
+			return err
+		}
+
+		entry.Seq = head.Seq + 1
+		entry.PrevHash = head.Hash
+		entry.Timestamp = time.Now().UTC().Truncate(time.Millisecond)
+		entry.Hash = HashAuditEntry(entry)
+
+		_, err = s.Collection.InsertOne(ctx, entry)
+		if mongo.IsDuplicateKeyError(err) {
+			continue
+		}
+		return err
+	}
+
+	return errors.New("не удалось записать событие аудита")
+}
+
+// GetEntries returns matching entries, newest first. Entries of every
+// organization are returned when the scope has no organization.
+func (s *AuditService) GetEntries(scope AccessScope, filter AuditFilter, page, pageSize int64) ([]models.AuditEntry, int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	query := bson.M{}
+	if !scope.OrganizationID.IsZero() {
+		query["$or"] = bson.A{
+			bson.M{"organizationId": scope.OrganizationID},
+			bson.M{"organizationIds": scope.OrganizationID},
+		}
+	}
+	if filter.Action != "" {
+		query["action"] = filter.Action
+	}
+	if filter.UserID != "" {
+		query["userId"] = filter.UserID
+	}
+	if filter.Username != "" {
+		query["username"] = filter.Username
+	}
+	if filter.ResourceType != "" {
+		query["resourceType"] = filter.ResourceType
+	}
+	if filter.ResourceID != "" {
+		query["resourceId"] = filter.ResourceID
+	}
+	if filter.Success != nil {
+		query["success"] = *filter.Success
+	}
+	if !filter.From.IsZero() || !filter.To.IsZero() {
+		timestamp := bson.M{}
+		if !filter.From.IsZero() {
+			timestamp["$gte"] = filter.From
+		}
+		if !filter.To.IsZero() {
+			timestamp["$lte"] = filter.To
+		}
+		query["timestamp"] = timestamp
+	}
+
+	total, err := s.Collection.CountDocuments(ctx, query)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if page < 1 {
+		page = 1
+	}
+	if pageSize < 1 || pageSize > 500 {
+		pageSize = 100
+	}
+	findOptions := options.Find().
+		SetSort(bson.D{{Key: "_id", Value: -1}}).
+		SetSkip((page - 1) * pageSize).
+		SetLimit(pageSize)
+
+	cursor, err := s.Collection.Find(ctx, query, findOptions)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer cursor.Close(ctx)
+
+	entries := []models.AuditEntry{}
+	if err := cursor.All(ctx, &entries); err != nil {
+		return nil, 0, err
+	}
+
+	return entries, total, nil
+}
+
+// Verify walks the whole chain and reports the first entry whose hash, link
+// or sequence number does not match. The chain spans all organizations, so it
+// is always checked in full; for a scope with an organization only that
+// organization's entries are counted, and a break in another organization's
+// entry is reported without its number.
+func (s *AuditService) Verify(scope AccessScope) (*AuditVerification, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	cursor, err := s.Collection.Find(ctx, bson.M{}, options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	result := &AuditVerification{Valid: true}
+	var prev models.AuditEntry
+	for cursor.Next(ctx) {
+		var entry models.AuditEntry
+		if err := cursor.Decode(&entry); err != nil {
+			return nil, err
+		}
+
+		visible := auditEntryIn(&entry, scope)
+		if reason := checkAuditLink(&prev, &entry); reason != "" {
+			result.Valid = false
+			if visible {
+				result.BrokenAt = entry.Seq
+				result.Reason = reason
+			} else {
+				result.Reason = "журнал изменен в записях другой организации"
+			}
+			return result, nil
+		}
+
+		if visible {
+			result.Checked++
+		}
+		prev = entry
+	}
+
+	return result, cursor.Err()
+}
+
+// auditEntryIn reports whether GetEntries lists the entry for the scope.
+func auditEntryIn(entry *models.AuditEntry, scope AccessScope) bool {
+	if scope.OrganizationID.IsZero() || entry.OrganizationID == scope.OrganizationID {
+		return true
+	}
+	for _, id := range entry.OrganizationIDs {
+		if id == scope.OrganizationID {
+			return true
+		}
+	}
+	return false
+}
+
+func checkAuditLink(prev, entry *models.AuditEntry) string {
+	switch {
+	case entry.Seq != prev.Seq+1:
+		return fmt.Sprintf("пропущены записи %d–%d", prev.Seq+1, entry.Seq-1)
+	case entry.PrevHash != prev.Hash:
+		return "ссылка на предыдущую запись не совпадает"
+	case entry.Hash != HashAuditEntry(entry):
+		return "хеш записи не совпадает"
+	}
+	return ""
+}
+
+// HashAuditEntry returns the hex SHA-256 of the entry's content and the
+// previous hash. The Hash field itself is not covered.
+func HashAuditEntry(entry *models.AuditEntry) string {
+	content := struct {
+		Seq            int64
+		Timestamp      string
+		OrganizationID primitive.ObjectID
+		// Omitted when empty, so that entries written before the field
+		// existed keep their hash.
+		OrganizationIDs []primitive.ObjectID `json:",omitempty"`
+		UserID          string
+		Username        string
+		IP              string
+		Action          string
+		ResourceType    string
+		ResourceID      string
+		Success         bool
+		Before          json.RawMessage
+		After           json.RawMessage
+		PrevHash        string
+	}{
+		entry.Seq, entry.Timestamp.UTC().Format(time.RFC3339Nano), entry.OrganizationID, entry.OrganizationIDs,
+		entry.UserID, entry.Username, entry.IP,
+		entry.Action, entry.ResourceType, entry.ResourceID, entry.Success,
+		entry.Before, entry.After, entry.PrevHash,
+	}
+
+	data, _ := json.Marshal(content)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// AuditSnapshot encodes a resource for the Before and After fields. Nil
+// pointers, e.g. from a failed lookup, give an empty snapshot.
+func AuditSnapshot(value interface{}) json.RawMessage {
+	if value == nil {
+		return nil
+	}
+	data, err := json.Marshal(value)
+	if err != nil || string(data) == "null" {
+		return nil
+	}
+	return data
+}
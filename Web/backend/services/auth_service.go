@@ -14,13 +14,25 @@ import (
 	"golang.org/x/crypto/bcrypt"
 )
 
+var ErrUserDisabled = errors.New("учетная запись отключена")
+
+// platformOperatorMark is the _id of the document in the platform collection
+// claimed by the first registered user.
+const platformOperatorMark = "operator"
+
 type AuthService struct {
 	Collection *mongo.Collection
+	Platform   *mongo.Collection
 }
 
+// dummyPasswordHash is compared against when the username does not exist, so
+// that a login takes the same time whether or not the user exists.
+var dummyPasswordHash, _ = bcrypt.GenerateFromPassword([]byte("dummy-password"), bcrypt.DefaultCost)
+
 func NewAuthService() *AuthService {
 	return &AuthService{
 		Collection: config.GetCollection("users"),
+		Platform:   config.GetCollection("platform"),
 	}
 }
 
@@ -31,7 +43,7 @@ func (s *AuthService) Register(user *models.User) (*models.User, error) {
 	var existingUser models.User
 	err := s.Collection.FindOne(ctx, bson.M{"username": user.Username}).Decode(&existingUser)
 	if err == nil {
-		return nil, errors.New("пользователь уже существует")
+		return nil, ErrUserExists
 	}
 
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(user.Password), bcrypt.DefaultCost)
@@ -41,11 +53,29 @@ func (s *AuthService) Register(user *models.User) (*models.User, error) {
 
 	user.ID = primitive.NewObjectID()
 	user.Password = string(hashedPassword)
+	if user.Memberships == nil {
+		user.Memberships = []models.Membership{}
+	}
 
 	_, err = s.Collection.InsertOne(ctx, user)
 	if err != nil {
+		return nil, duplicateUserError(err)
+	}
+
+	// Первый зарегистрированный пользователь становится оператором платформы.
+	// Отметка вставляется с фиксированным _id, поэтому из одновременных
+	// регистраций ее получает только одна.
+	_, err = s.Platform.InsertOne(ctx, bson.M{"_id": platformOperatorMark, "userId": user.ID, "createdAt": time.Now()})
+	if mongo.IsDuplicateKeyError(err) {
+		return user, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if _, err := s.Collection.UpdateOne(ctx, bson.M{"_id": user.ID}, bson.M{"$set": bson.M{"platformOperator": true}}); err != nil {
 		return nil, err
 	}
+	user.PlatformOperator = true
 
 	return user, nil
 }
@@ -57,13 +87,78 @@ func (s *AuthService) Login(username, password string) (*models.User, error) {
 	var user models.User
 	err := s.Collection.FindOne(ctx, bson.M{"username": username}).Decode(&user)
 	if err != nil {
+		bcrypt.CompareHashAndPassword(dummyPasswordHash, []byte(password))
 		return nil, errors.New("неверные учетные данные")
 	}
 
-	err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password))
-	if err != nil {
+	passwordHash := []byte(user.Password)
+	if len(passwordHash) == 0 {
+		passwordHash = dummyPasswordHash
+	}
+	err = bcrypt.CompareHashAndPassword(passwordHash, []byte(password))
+	if err != nil || len(user.Password) == 0 {
 		return nil, errors.New("неверные учетные данные")
 	}
+	return &user, nil
+}
+
+// MemberOrganizations returns the organizations of the user with the given
+// username, or nil when there is no such user.
+func (s *AuthService) MemberOrganizations(username string) []primitive.ObjectID {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var user models.User
+	if err := s.Collection.FindOne(ctx, bson.M{"username": username}).Decode(&user); err != nil {
+		return nil
+	}
+	return user.OrganizationIDs()
+}
+
+func (s *AuthService) GetUserByID(id string) (*models.User, error) {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, errors.New("некорректный ID пользователя")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var user models.User
+	err = s.Collection.FindOne(ctx, bson.M{"_id": objID}).Decode(&user)
+	if err != nil {
+		return nil, errors.New("пользователь не найден")
+	}
 
 	return &user, nil
 }
+
+// ResolveOrganization returns the organization the session should be bound to:
+// the requested one if the user is an active member, otherwise the first
+// active membership. Users whose memberships are all disabled cannot log in.
+func ResolveOrganization(user *models.User, requested string) (primitive.ObjectID, error) {
+	if requested == "" {
+		if len(user.Memberships) == 0 {
+			return primitive.NilObjectID, nil
+		}
+		for _, membership := range user.Memberships {
+			if !membership.Disabled {
+				return membership.OrganizationID, nil
+			}
+		}
+		return primitive.NilObjectID, ErrUserDisabled
+	}
+
+	orgID, err := primitive.ObjectIDFromHex(requested)
+	if err != nil {
+		return primitive.NilObjectID, errors.New("некорректный ID организации")
+	}
+	membership := user.Membership(orgID)
+	if membership == nil {
+		return primitive.NilObjectID, errors.New("пользователь не состоит в организации")
+	}
+	if membership.Disabled {
+		return primitive.NilObjectID, ErrUserDisabled
+	}
+	return orgID, nil
+}
@@ -0,0 +1,108 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+
+	"backend/config"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/gridfs"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ErrBlobNotFound is returned when no file is stored under a key.
+var ErrBlobNotFound = errors.New("файл не найден")
+
+// BlobStore keeps files, such as alert attachments and exports, under
+// slash-separated keys.
+type BlobStore interface {
+	// Put stores the contents of r under key and returns the stored size.
+	Put(ctx context.Context, key string, r io.Reader) (int64, error)
+	Open(ctx context.Context, key string) (io.ReadCloser, error)
+	Delete(ctx context.Context, key string) error
+}
+
+// NewBlobStore returns the store of files attached to alerts, a GridFS
+// bucket shared by every instance.
+func NewBlobStore() BlobStore {
+	return NewGridFSBlobStore("attachments")
+}
+
+// GridFSBlobStore keeps files in a GridFS bucket of the database, so every
+// instance sees the same files. GridFS writes the file document after the
+// last chunk, so a reader never sees a partial file; storing a key again
+// adds a newer revision and removes the older ones.
+type GridFSBlobStore struct {
+	Bucket *gridfs.Bucket
+}
+
+// NewGridFSBlobStore opens the GridFS bucket with the given name.
+func NewGridFSBlobStore(name string) *GridFSBlobStore {
+	bucket, err := gridfs.NewBucket(config.GetDatabase(), options.GridFSBucket().SetName(name))
+	if err != nil {
+		log.Fatalf("Ошибка открытия хранилища файлов %s: %v", name, err)
+	}
+	return &GridFSBlobStore{Bucket: bucket}
+}
+
+func (s *GridFSBlobStore) Put(ctx context.Context, key string, r io.Reader) (int64, error) {
+	stream, err := s.Bucket.OpenUploadStream(key)
+	if err != nil {
+		return 0, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		stream.SetWriteDeadline(deadline)
+	}
+
+	size, err := io.Copy(stream, r)
+	if err != nil {
+		stream.Abort()
+		return size, err
+	}
+	if err := stream.Close(); err != nil {
+		return size, err
+	}
+	return size, s.remove(ctx, bson.M{"filename": key, "_id": bson.M{"$ne": stream.FileID}})
+}
+
+func (s *GridFSBlobStore) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	stream, err := s.Bucket.OpenDownloadStreamByName(key)
+	if err != nil {
+		if errors.Is(err, gridfs.ErrFileNotFound) {
+			return nil, ErrBlobNotFound
+		}
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		stream.SetReadDeadline(deadline)
+	}
+	return stream, nil
+}
+
+func (s *GridFSBlobStore) Delete(ctx context.Context, key string) error {
+	return s.remove(ctx, bson.M{"filename": key})
+}
+
+// remove deletes the revisions matched by filter. A revision removed
+// meanwhile by another instance is not an error.
+func (s *GridFSBlobStore) remove(ctx context.Context, filter bson.M) error {
+	cursor, err := s.Bucket.FindContext(ctx, filter)
+	if err != nil {
+		return err
+	}
+	var files []struct {
+		ID interface{} `bson:"_id"`
+	}
+	if err := cursor.All(ctx, &files); err != nil {
+		return err
+	}
+	for _, file := range files {
+		if err := s.Bucket.DeleteContext(ctx, file.ID); err != nil && !errors.Is(err, gridfs.ErrFileNotFound) {
+			return err
+		}
+	}
+	return nil
+}
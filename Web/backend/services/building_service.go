@@ -23,12 +23,12 @@ func NewBuildingService() *BuildingService {
 	}
 }
 
-func (s *BuildingService) GetAllBuildings() ([]models.Building, error) {
+func (s *BuildingService) GetAllBuildings(scope AccessScope) ([]models.Building, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
 	var buildings []models.Building
-	cursor, err := s.Collection.Find(ctx, bson.M{})
+	cursor, err := s.Collection.Find(ctx, scope.BuildingFilter(bson.M{}))
 	if err != nil {
 		return nil, err
 	}
@@ -48,8 +48,9 @@ func (s *BuildingService) GetAllBuildings() ([]models.Building, error) {
 	return buildings, nil
 }
 
-func (s *BuildingService) CreateBuilding(building *models.Building) (*models.Building, error) {
+func (s *BuildingService) CreateBuilding(scope AccessScope, building *models.Building) (*models.Building, error) {
 	building.ID = primitive.NewObjectID()
+	building.OrganizationID = scope.OrganizationID
 
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
@@ -62,7 +63,7 @@ func (s *BuildingService) CreateBuilding(building *models.Building) (*models.Bui
 	return building, nil
 }
 
-func (s *BuildingService) GetBuildingByID(id string) (*models.Building, error) {
+func (s *BuildingService) GetBuildingByID(scope AccessScope, id string) (*models.Building, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
@@ -72,7 +73,7 @@ func (s *BuildingService) GetBuildingByID(id string) (*models.Building, error) {
 	}
 
 	var building models.Building
-	err = s.Collection.FindOne(ctx, bson.M{"_id": objID}).Decode(&building)
+	err = s.Collection.FindOne(ctx, scope.BuildingFilter(bson.M{"_id": objID})).Decode(&building)
 	if err != nil {
 		return nil, err
 	}
@@ -80,7 +81,7 @@ func (s *BuildingService) GetBuildingByID(id string) (*models.Building, error) {
 	return &building, nil
 }
 
-func (s *BuildingService) UpdateBuilding(id string, building *models.Building) (*models.Building, error) {
+func (s *BuildingService) UpdateBuilding(scope AccessScope, id string, building *models.Building) (*models.Building, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
@@ -89,16 +90,20 @@ func (s *BuildingService) UpdateBuilding(id string, building *models.Building) (
 		return nil, errors.New("некорректный ID здания")
 	}
 
-	_, err = s.Collection.ReplaceOne(ctx, bson.M{"_id": objID}, building)
+	building.OrganizationID = scope.OrganizationID
+	result, err := s.Collection.ReplaceOne(ctx, scope.BuildingFilter(bson.M{"_id": objID}), building)
 	if err != nil {
 		return nil, err
 	}
+	if result.MatchedCount == 0 {
+		return nil, errors.New("здание не найдено")
+	}
 
 	return building, nil
 }
 
 
-func (s *BuildingService) DeleteBuilding(id string) error {
+func (s *BuildingService) DeleteBuilding(scope AccessScope, id string) error {
 	objID, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
 		return errors.New("некорректный ID здания")
@@ -107,7 +112,7 @@ func (s *BuildingService) DeleteBuilding(id string) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	_, err = s.Collection.DeleteOne(ctx, bson.M{"_id": objID})
+	_, err = s.Collection.DeleteOne(ctx, scope.BuildingFilter(bson.M{"_id": objID}))
 	if err != nil {
 		return err
 	}
@@ -115,12 +120,12 @@ func (s *BuildingService) DeleteBuilding(id string) error {
 	return nil
 }	
 
-func (s *BuildingService) GetBuildingByCameraID(cameraID string) (*models.Building, error) {
+func (s *BuildingService) GetBuildingByCameraID(scope AccessScope, cameraID string) (*models.Building, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
 	var building models.Building
-	err := s.Collection.FindOne(ctx, bson.M{"cameras": cameraID}).Decode(&building)
+	err := s.Collection.FindOne(ctx, scope.BuildingFilter(bson.M{"cameras": cameraID})).Decode(&building)
 	if err != nil {
 		return nil, err
 	}
@@ -11,6 +11,7 @@ import (
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 type CameraService struct {
@@ -23,12 +24,12 @@ func NewCameraService() *CameraService {
 	}
 }
 
-func (s *CameraService) GetAllCameras() ([]models.Camera, error) {
+func (s *CameraService) GetAllCameras(scope AccessScope) ([]models.Camera, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
 	var cameras []models.Camera
-	cursor, err := s.Collection.Find(ctx, bson.M{})
+	cursor, err := s.Collection.Find(ctx, scope.LocationFilter(bson.M{}))
 	if err != nil {
 		return nil, err
 	}
@@ -48,7 +49,7 @@ func (s *CameraService) GetAllCameras() ([]models.Camera, error) {
 	return cameras, nil
 }
 
-func (s *CameraService) GetCameraByID(id string) (*models.Camera, error) {
+func (s *CameraService) GetCameraByID(scope AccessScope, id string) (*models.Camera, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
@@ -60,7 +61,7 @@ func (s *CameraService) GetCameraByID(id string) (*models.Camera, error) {
 
 	// Find camera by ID
 	var camera models.Camera
-	err = s.Collection.FindOne(ctx, bson.M{"_id": objID}).Decode(&camera)
+	err = s.Collection.FindOne(ctx, scope.LocationFilter(bson.M{"_id": objID})).Decode(&camera)
 	if err != nil {
 		if err == mongo.ErrNoDocuments {
 			return nil, errors.New("камера не найдена")
@@ -71,8 +72,9 @@ func (s *CameraService) GetCameraByID(id string) (*models.Camera, error) {
 	return &camera, nil
 }
 
-func (s *CameraService) CreateCamera(camera *models.Camera) (*models.Camera, error) {
+func (s *CameraService) CreateCamera(scope AccessScope, camera *models.Camera) (*models.Camera, error) {
 	camera.ID = primitive.NewObjectID()
+	camera.OrganizationID = scope.OrganizationID
 
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
@@ -85,7 +87,49 @@ func (s *CameraService) CreateCamera(camera *models.Camera) (*models.Camera, err
 	return camera, nil
 }
 
-func (s *CameraService) DeleteCamera(id string) error {
+// SetNeighbors replaces the cameras adjacent to the camera for incident
+// correlation. Neighbours must be cameras the scope may see as well.
+func (s *CameraService) SetNeighbors(scope AccessScope, id string, neighborIDs []primitive.ObjectID) (*models.Camera, error) {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, errors.New("некорректный ID камеры")
+	}
+	for _, neighborID := range neighborIDs {
+		if neighborID == objID {
+			return nil, errors.New("камера не может быть соседней самой себе")
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if len(neighborIDs) > 0 {
+		count, err := s.Collection.CountDocuments(ctx, scope.LocationFilter(bson.M{"_id": bson.M{"$in": neighborIDs}}))
+		if err != nil {
+			return nil, err
+		}
+		if count != int64(len(neighborIDs)) {
+			return nil, errors.New("соседняя камера не найдена")
+		}
+	}
+
+	var camera models.Camera
+	err = s.Collection.FindOneAndUpdate(ctx,
+		scope.LocationFilter(bson.M{"_id": objID}),
+		bson.M{"$set": bson.M{"neighborIds": neighborIDs}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&camera)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, errors.New("камера не найдена")
+		}
+		return nil, err
+	}
+
+	return &camera, nil
+}
+
+func (s *CameraService) DeleteCamera(scope AccessScope, id string) error {
 	objID, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
 		return errors.New("некорректный ID камеры")
@@ -94,7 +138,7 @@ func (s *CameraService) DeleteCamera(id string) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	_, err = s.Collection.DeleteOne(ctx, bson.M{"_id": objID})
+	_, err = s.Collection.DeleteOne(ctx, scope.LocationFilter(bson.M{"_id": objID}))
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,526 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"backend/config"
+	"backend/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// MentionNotifier tells a user that a comment mentions them.
+type MentionNotifier interface {
+	NotifyMention(alert *models.Alert, comment *models.AlertComment, userID primitive.ObjectID) error
+}
+
+// CommentService keeps the comments and attachments of alerts. New and
+// edited comments are announced on the alert WebSocket feed and notify the
+// members they mention.
+type CommentService struct {
+	Collection  *mongo.Collection
+	Attachments *mongo.Collection
+	Alerts      *mongo.Collection
+	Users       *mongo.Collection
+	Blobs       BlobStore
+	// MaxFileSize is the largest attachment in bytes.
+	MaxFileSize int64
+	Notifier    MentionNotifier
+	PubSub      AlertPubSub
+}
+
+func NewCommentService(pubsub AlertPubSub, notifier MentionNotifier) *CommentService {
+	maxFileSize := int64(20 << 20)
+	if config.Blobs != nil {
+		maxFileSize = config.Blobs.MaxSize
+	}
+	return &CommentService{
+		Collection:  config.GetCollection("alert_comments"),
+		Attachments: config.GetCollection("alert_attachments"),
+		Alerts:      config.GetCollection("alerts"),
+		Users:       config.GetCollection("users"),
+		Blobs:       NewBlobStore(),
+		MaxFileSize: maxFileSize,
+		Notifier:    notifier,
+		PubSub:      pubsub,
+	}
+}
+
+const maxCommentLength = 5000
+
+// mentionPattern finds @username mentions. An @ preceded by a letter or
+// digit belongs to an email address and is skipped.
+var mentionPattern = regexp.MustCompile(`(?:^|[^\p{L}\p{N}_.@])@([\p{L}\p{N}_.\-]+)`)
+
+// mentionedUsernames returns the distinct usernames mentioned in a comment
+// body, without trailing punctuation.
+func mentionedUsernames(body string) []string {
+	var names []string
+	seen := make(map[string]bool)
+	for _, match := range mentionPattern.FindAllStringSubmatch(body, -1) {
+		name := strings.TrimRight(match[1], ".-")
+		if name != "" && !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// alert loads an alert within the scope.
+func (s *CommentService) alert(ctx context.Context, scope AccessScope, id string) (*models.Alert, error) {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, errors.New("некорректный ID тревоги")
+	}
+
+	var alert models.Alert
+	if err := s.Alerts.FindOne(ctx, scope.LocationFilter(bson.M{"_id": objID})).Decode(&alert); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, errors.New("тревога не найдена")
+		}
+		return nil, err
+	}
+	return &alert, nil
+}
+
+// resolveMentions returns the organization members mentioned in body.
+// Unknown usernames are ignored.
+func (s *CommentService) resolveMentions(ctx context.Context, organizationID primitive.ObjectID, body string) ([]primitive.ObjectID, error) {
+	names := mentionedUsernames(body)
+	if len(names) == 0 {
+		return nil, nil
+	}
+
+	cursor, err := s.Users.Find(ctx,
+		bson.M{"username": bson.M{"$in": names}, "memberships": bson.M{"$elemMatch": bson.M{"organizationId": organizationID, "disabled": false}}},
+		options.Find().SetProjection(bson.M{"_id": 1}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	var users []models.User
+	if err := cursor.All(ctx, &users); err != nil {
+		return nil, err
+	}
+
+	ids := make([]primitive.ObjectID, len(users))
+	for i := range users {
+		ids[i] = users[i].ID
+	}
+	return ids, nil
+}
+
+func validateCommentBody(body string) (string, error) {
+	body = strings.TrimSpace(body)
+	if body == "" {
+		return "", errors.New("комментарий пуст")
+	}
+	if utf8.RuneCountInString(body) > maxCommentLength {
+		return "", fmt.Errorf("комментарий длиннее %d символов", maxCommentLength)
+	}
+	return body, nil
+}
+
+// GetComments returns the comments of an alert, oldest first. Replies carry
+// the id of the thread's first comment.
+func (s *CommentService) GetComments(scope AccessScope, alertID string) ([]models.AlertComment, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	alert, err := s.alert(ctx, scope, alertID)
+	if err != nil {
+		return nil, err
+	}
+
+	cursor, err := s.Collection.Find(ctx,
+		bson.M{"alertId": alert.ID, "organizationId": alert.OrganizationID},
+		options.Find().SetSort(bson.D{{Key: "createdAt", Value: 1}}),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	comments := []models.AlertComment{}
+	if err := cursor.All(ctx, &comments); err != nil {
+		return nil, err
+	}
+	return comments, nil
+}
+
+// AddComment stores a comment by the author on an alert, as a reply in the
+// thread of parentID when it is set.
+func (s *CommentService) AddComment(scope AccessScope, alertID, parentID, authorID, authorName, body string) (*models.AlertComment, error) {
+	body, err := validateCommentBody(body)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	alert, err := s.alert(ctx, scope, alertID)
+	if err != nil {
+		return nil, err
+	}
+
+	comment := &models.AlertComment{
+		ID:             primitive.NewObjectID(),
+		AlertID:        alert.ID,
+		OrganizationID: alert.OrganizationID,
+		AuthorID:       authorID,
+		AuthorName:     authorName,
+		Body:           body,
+		CreatedAt:      time.Now(),
+	}
+	if parentID != "" {
+		if comment.ParentID, err = s.threadOf(ctx, alert, parentID); err != nil {
+			return nil, err
+		}
+	}
+	if comment.Mentions, err = s.resolveMentions(ctx, alert.OrganizationID, body); err != nil {
+		return nil, err
+	}
+
+	if _, err := s.Collection.InsertOne(ctx, comment); err != nil {
+		return nil, err
+	}
+
+	s.announce(alert, comment, nil)
+	return comment, nil
+}
+
+// threadOf returns the first comment of the thread the parent belongs to.
+func (s *CommentService) threadOf(ctx context.Context, alert *models.Alert, parentID string) (primitive.ObjectID, error) {
+	objID, err := primitive.ObjectIDFromHex(parentID)
+	if err != nil {
+		return primitive.NilObjectID, errors.New("некорректный ID комментария")
+	}
+
+	var parent models.AlertComment
+	err = s.Collection.FindOne(ctx, bson.M{"_id": objID, "alertId": alert.ID}).Decode(&parent)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return primitive.NilObjectID, errors.New("комментарий не найден")
+		}
+		return primitive.NilObjectID, err
+	}
+	if !parent.ParentID.IsZero() {
+		return parent.ParentID, nil
+	}
+	return parent.ID, nil
+}
+
+// EditComment replaces the body of the author's own comment. Members
+// mentioned for the first time are notified.
+func (s *CommentService) EditComment(scope AccessScope, alertID, commentID, authorID, body string) (before, after *models.AlertComment, err error) {
+	body, err = validateCommentBody(body)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	alert, err := s.alert(ctx, scope, alertID)
+	if err != nil {
+		return nil, nil, err
+	}
+	mentions, err := s.resolveMentions(ctx, alert.OrganizationID, body)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	now := time.Now()
+	before, err = s.updateOwn(ctx, alert, commentID, authorID, bson.M{"$set": bson.M{"body": body, "mentions": mentions, "editedAt": now}})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	edited := *before
+	edited.Body, edited.Mentions, edited.EditedAt = body, mentions, &now
+	s.announce(alert, &edited, before.Mentions)
+	return before, &edited, nil
+}
+
+// DeleteComment removes the body of the author's own comment. The comment
+// stays as a placeholder so that its replies keep their thread.
+func (s *CommentService) DeleteComment(scope AccessScope, alertID, commentID, authorID string) (before, after *models.AlertComment, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	alert, err := s.alert(ctx, scope, alertID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	before, err = s.updateOwn(ctx, alert, commentID, authorID, bson.M{
+		"$set":   bson.M{"deleted": true, "body": ""},
+		"$unset": bson.M{"mentions": ""},
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	deleted := *before
+	deleted.Body, deleted.Mentions, deleted.Deleted = "", nil, true
+	publishActivity(s.PubSub, &models.AlertActivity{Type: models.ActivityComment, Alert: alert, Comment: &deleted})
+	return before, &deleted, nil
+}
+
+// updateOwn applies update to a comment of the author that is not deleted
+// and returns it as it was before.
+func (s *CommentService) updateOwn(ctx context.Context, alert *models.Alert, commentID, authorID string, update bson.M) (*models.AlertComment, error) {
+	objID, err := primitive.ObjectIDFromHex(commentID)
+	if err != nil {
+		return nil, errors.New("некорректный ID комментария")
+	}
+
+	var comment models.AlertComment
+	err = s.Collection.FindOneAndUpdate(ctx,
+		bson.M{"_id": objID, "alertId": alert.ID, "authorId": authorID, "deleted": bson.M{"$ne": true}},
+		update,
+	).Decode(&comment)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, errors.New("комментарий не найден или написан другим пользователем")
+		}
+		return nil, err
+	}
+	return &comment, nil
+}
+
+// announce publishes a stored comment and notifies the members it mentions,
+// except the author and those notified before.
+func (s *CommentService) announce(alert *models.Alert, comment *models.AlertComment, notified []primitive.ObjectID) {
+	publishActivity(s.PubSub, &models.AlertActivity{Type: models.ActivityComment, Alert: alert, Comment: comment})
+	if s.Notifier == nil {
+		return
+	}
+
+	skip := make(map[primitive.ObjectID]bool, len(notified))
+	for _, userID := range notified {
+		skip[userID] = true
+	}
+	for _, userID := range comment.Mentions {
+		if skip[userID] || userID.Hex() == comment.AuthorID {
+			continue
+		}
+		go func(userID primitive.ObjectID) {
+			if err := s.Notifier.NotifyMention(alert, comment, userID); err != nil {
+				log.Printf("Ошибка уведомления об упоминании %s: %v", userID.Hex(), err)
+			}
+		}(userID)
+	}
+}
+
+// attachmentTypes are the accepted kinds of attachments, by detected
+// content type prefix.
+var attachmentTypes = []string{"image/", "video/", "application/pdf"}
+
+// AddAttachment stores a file uploaded to an alert, and to one of its
+// comments when commentID is set. The content type is detected from the
+// file itself.
+func (s *CommentService) AddAttachment(scope AccessScope, alertID, commentID, uploadedBy, fileName string, r io.Reader) (*models.AlertAttachment, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	alert, err := s.alert(ctx, scope, alertID)
+	if err != nil {
+		return nil, err
+	}
+
+	attachment := &models.AlertAttachment{
+		ID:             primitive.NewObjectID(),
+		AlertID:        alert.ID,
+		OrganizationID: alert.OrganizationID,
+		FileName:       attachmentName(fileName),
+		UploadedBy:     uploadedBy,
+		CreatedAt:      time.Now(),
+	}
+	if commentID != "" {
+		objID, err := primitive.ObjectIDFromHex(commentID)
+		if err != nil {
+			return nil, errors.New("некорректный ID комментария")
+		}
+		count, err := s.Collection.CountDocuments(ctx, bson.M{"_id": objID, "alertId": alert.ID})
+		if err != nil {
+			return nil, err
+		}
+		if count == 0 {
+			return nil, errors.New("комментарий не найден")
+		}
+		attachment.CommentID = objID
+	}
+
+	head := make([]byte, 512)
+	n, err := io.ReadFull(r, head)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		if err == io.EOF {
+			return nil, errors.New("файл пуст")
+		}
+		return nil, err
+	}
+	attachment.ContentType = http.DetectContentType(head[:n])
+	if !allowedAttachment(attachment.ContentType) {
+		return nil, errors.New("неподдерживаемый тип файла: " + attachment.ContentType)
+	}
+
+	attachment.Key = fmt.Sprintf("alerts/%s/%s/%s", alert.OrganizationID.Hex(), alert.ID.Hex(), attachment.ID.Hex())
+	content := io.LimitReader(io.MultiReader(bytes.NewReader(head[:n]), r), s.MaxFileSize+1)
+	if attachment.Size, err = s.Blobs.Put(ctx, attachment.Key, content); err != nil {
+		return nil, err
+	}
+	if attachment.Size > s.MaxFileSize {
+		s.removeBlob(attachment.Key)
+		return nil, fmt.Errorf("файл больше %d байт", s.MaxFileSize)
+	}
+
+	if _, err := s.Attachments.InsertOne(ctx, attachment); err != nil {
+		s.removeBlob(attachment.Key)
+		return nil, err
+	}
+	return attachment, nil
+}
+
+func allowedAttachment(contentType string) bool {
+	for _, prefix := range attachmentTypes {
+		if strings.HasPrefix(contentType, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// attachmentName keeps the base name of an uploaded file, which browsers
+// may send with a Windows path.
+func attachmentName(fileName string) string {
+	name := path.Base(strings.ReplaceAll(fileName, `\`, "/"))
+	if name == "." || name == "/" {
+		return "file"
+	}
+	for utf8.RuneCountInString(name) > 255 {
+		_, size := utf8.DecodeLastRuneInString(name)
+		name = name[:len(name)-size]
+	}
+	return name
+}
+
+func (s *CommentService) removeBlob(key string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	if err := s.Blobs.Delete(ctx, key); err != nil {
+		log.Printf("Ошибка удаления файла %s: %v", key, err)
+	}
+}
+
+// GetAttachments returns the attachments of an alert, oldest first.
+func (s *CommentService) GetAttachments(scope AccessScope, alertID string) ([]models.AlertAttachment, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	alert, err := s.alert(ctx, scope, alertID)
+	if err != nil {
+		return nil, err
+	}
+
+	cursor, err := s.Attachments.Find(ctx,
+		bson.M{"alertId": alert.ID, "organizationId": alert.OrganizationID},
+		options.Find().SetSort(bson.D{{Key: "createdAt", Value: 1}}),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	attachments := []models.AlertAttachment{}
+	if err := cursor.All(ctx, &attachments); err != nil {
+		return nil, err
+	}
+	return attachments, nil
+}
+
+// OpenAttachment returns an attachment of an alert with its contents, which
+// the caller must close.
+func (s *CommentService) OpenAttachment(scope AccessScope, alertID, attachmentID string) (*models.AlertAttachment, io.ReadCloser, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	attachment, err := s.findAttachment(ctx, scope, alertID, attachmentID, "", false)
+	if err != nil {
+		return nil, nil, err
+	}
+	content, err := s.Blobs.Open(context.Background(), attachment.Key)
+	if err != nil {
+		return nil, nil, err
+	}
+	return attachment, content, nil
+}
+
+// DeleteAttachment removes an attachment of an alert and its file. Only the
+// uploader may remove it, unless manage is set for user managers.
+func (s *CommentService) DeleteAttachment(scope AccessScope, alertID, attachmentID, userID string, manage bool) (*models.AlertAttachment, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	uploadedBy := userID
+	if manage {
+		uploadedBy = ""
+	}
+	attachment, err := s.findAttachment(ctx, scope, alertID, attachmentID, uploadedBy, true)
+	if err != nil {
+		return nil, err
+	}
+	s.removeBlob(attachment.Key)
+	return attachment, nil
+}
+
+// findAttachment reads, or with remove deletes, an attachment of an alert.
+// A non-empty uploadedBy restricts it to that uploader's attachments.
+func (s *CommentService) findAttachment(ctx context.Context, scope AccessScope, alertID, attachmentID, uploadedBy string, remove bool) (*models.AlertAttachment, error) {
+	alert, err := s.alert(ctx, scope, alertID)
+	if err != nil {
+		return nil, err
+	}
+	objID, err := primitive.ObjectIDFromHex(attachmentID)
+	if err != nil {
+		return nil, errors.New("некорректный ID вложения")
+	}
+
+	filter := bson.M{"_id": objID, "alertId": alert.ID, "organizationId": alert.OrganizationID}
+	if uploadedBy != "" {
+		filter["uploadedBy"] = uploadedBy
+	}
+	var result *mongo.SingleResult
+	if remove {
+		result = s.Attachments.FindOneAndDelete(ctx, filter)
+	} else {
+		result = s.Attachments.FindOne(ctx, filter)
+	}
+
+	var attachment models.AlertAttachment
+	if err := result.Decode(&attachment); err != nil {
+		if err == mongo.ErrNoDocuments {
+			if uploadedBy != "" {
+				return nil, errors.New("вложение не найдено или загружено другим пользователем")
+			}
+			return nil, errors.New("вложение не найдено")
+		}
+		return nil, err
+	}
+	return &attachment, nil
+}
@@ -0,0 +1,392 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"backend/config"
+	"backend/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// AlertNotifier pushes an alert to a single user.
+type AlertNotifier interface {
+	NotifyUser(alert *models.Alert, userID primitive.ObjectID) error
+}
+
+// EscalationService manages escalation policies and runs their steps. The
+// schedule lives in MongoDB and is polled, so steps survive restarts and any
+// instance can run them.
+type EscalationService struct {
+	Policies    *mongo.Collection
+	Escalations *mongo.Collection
+	Alerts      *mongo.Collection
+	Users       *mongo.Collection
+	Notifier    AlertNotifier
+
+	PollInterval time.Duration
+	// Lease is how long a claimed escalation is hidden from other workers.
+	// It must outlast the notifications of a step, or another worker fires
+	// the step again.
+	Lease time.Duration
+	Now   func() time.Time
+}
+
+func NewEscalationService(notifier AlertNotifier) *EscalationService {
+	return &EscalationService{
+		Policies:     config.GetCollection("escalation_policies"),
+		Escalations:  config.GetCollection("escalations"),
+		Alerts:       config.GetCollection("alerts"),
+		Users:        config.GetCollection("users"),
+		Notifier:     notifier,
+		PollInterval: 5 * time.Second,
+		Lease:        5 * time.Minute,
+		Now:          time.Now,
+	}
+}
+
+func (s *EscalationService) GetPolicies(scope AccessScope) ([]models.EscalationPolicy, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	cursor, err := s.Policies.Find(ctx, scope.Filter(bson.M{}), options.Find().SetSort(bson.D{{Key: "name", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	policies := []models.EscalationPolicy{}
+	if err := cursor.All(ctx, &policies); err != nil {
+		return nil, err
+	}
+
+	return policies, nil
+}
+
+func (s *EscalationService) CreatePolicy(scope AccessScope, policy *models.EscalationPolicy) (*models.EscalationPolicy, error) {
+	if err := validatePolicy(policy); err != nil {
+		return nil, err
+	}
+
+	policy.ID = primitive.NewObjectID()
+	policy.OrganizationID = scope.OrganizationID
+	policy.CreatedAt = s.Now()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := s.checkRecipients(ctx, scope, policy); err != nil {
+		return nil, err
+	}
+	if _, err := s.Policies.InsertOne(ctx, policy); err != nil {
+		return nil, err
+	}
+
+	return policy, nil
+}
+
+func (s *EscalationService) UpdatePolicy(scope AccessScope, id string, policy *models.EscalationPolicy) (*models.EscalationPolicy, error) {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, errors.New("некорректный ID политики")
+	}
+	if err := validatePolicy(policy); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := s.checkRecipients(ctx, scope, policy); err != nil {
+		return nil, err
+	}
+
+	var updated models.EscalationPolicy
+	err = s.Policies.FindOneAndUpdate(ctx,
+		scope.Filter(bson.M{"_id": objID}),
+		bson.M{"$set": bson.M{
+			"name":        policy.Name,
+			"enabled":     policy.Enabled,
+			"buildingIds": policy.BuildingIDs,
+			"alertTypes":  policy.AlertTypes,
+			"minSeverity": policy.MinSeverity,
+			"steps":       policy.Steps,
+		}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&updated)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, errors.New("политика не найдена")
+		}
+		return nil, err
+	}
+
+	return &updated, nil
+}
+
+// DeletePolicy removes the policy and stops its running escalations.
+func (s *EscalationService) DeletePolicy(scope AccessScope, id string) error {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return errors.New("некорректный ID политики")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	result, err := s.Policies.DeleteOne(ctx, scope.Filter(bson.M{"_id": objID}))
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return errors.New("политика не найдена")
+	}
+
+	_, err = s.Escalations.UpdateMany(ctx,
+		bson.M{"policyId": objID, "status": models.EscalationActive},
+		bson.M{"$set": bson.M{"status": models.EscalationStopped}},
+	)
+	return err
+}
+
+func validatePolicy(policy *models.EscalationPolicy) error {
+	if strings.TrimSpace(policy.Name) == "" {
+		return errors.New("не указано название политики")
+	}
+	if policy.MinSeverity != "" && models.SeverityRank(policy.MinSeverity) == 0 {
+		return errors.New("неизвестная важность: " + policy.MinSeverity)
+	}
+	if len(policy.Steps) == 0 {
+		return errors.New("политика должна содержать хотя бы один шаг")
+	}
+	for _, step := range policy.Steps {
+		if step.DelaySeconds < 0 {
+			return errors.New("задержка шага не может быть отрицательной")
+		}
+		if len(step.UserIDs) == 0 {
+			return errors.New("у шага должны быть получатели")
+		}
+	}
+	return nil
+}
+
+// checkRecipients makes sure that every step notifies members of the scope's
+// organization only.
+func (s *EscalationService) checkRecipients(ctx context.Context, scope AccessScope, policy *models.EscalationPolicy) error {
+	unique := map[primitive.ObjectID]bool{}
+	for _, step := range policy.Steps {
+		for _, userID := range step.UserIDs {
+			unique[userID] = true
+		}
+	}
+	ids := make([]primitive.ObjectID, 0, len(unique))
+	for id := range unique {
+		ids = append(ids, id)
+	}
+
+	count, err := s.Users.CountDocuments(ctx, bson.M{"_id": bson.M{"$in": ids}, "memberships.organizationId": scope.OrganizationID})
+	if err != nil {
+		return err
+	}
+	if count != int64(len(ids)) {
+		return errors.New("получатели шагов должны состоять в организации")
+	}
+	return nil
+}
+
+// StartEscalations schedules every enabled policy of the alert's organization
+// that matches the alert, unless the alert joined an open incident. It is
+// registered as an alert hook.
+func (s *EscalationService) StartEscalations(alert *models.Alert) {
+	if alert.Correlated {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	cursor, err := s.Policies.Find(ctx, bson.M{"organizationId": alert.OrganizationID, "enabled": true})
+	if err != nil {
+		log.Printf("Ошибка загрузки политик эскалации: %v", err)
+		return
+	}
+	var policies []models.EscalationPolicy
+	if err := cursor.All(ctx, &policies); err != nil {
+		log.Printf("Ошибка загрузки политик эскалации: %v", err)
+		return
+	}
+
+	now := s.Now()
+	for _, policy := range policies {
+		if !policy.Matches(alert) {
+			continue
+		}
+
+		escalation := models.Escalation{
+			ID:             primitive.NewObjectID(),
+			AlertID:        alert.ID,
+			OrganizationID: alert.OrganizationID,
+			PolicyID:       policy.ID,
+			Status:         models.EscalationActive,
+			DueAt:          now.Add(policy.Steps[0].Delay()),
+			History:        []models.EscalationEvent{},
+			CreatedAt:      now,
+		}
+		if _, err := s.Escalations.InsertOne(ctx, escalation); err != nil {
+			log.Printf("Ошибка планирования эскалации: %v", err)
+		}
+	}
+}
+
+// StopEscalations stops the running escalations of an alert once somebody
+// has acknowledged or resolved it.
+func (s *EscalationService) StopEscalations(alertID primitive.ObjectID) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err := s.Escalations.UpdateMany(ctx,
+		bson.M{"alertId": alertID, "status": models.EscalationActive},
+		bson.M{"$set": bson.M{"status": models.EscalationStopped}},
+	)
+	return err
+}
+
+// Run fires due steps until the context is cancelled.
+func (s *EscalationService) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		for s.ProcessNext() {
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// ProcessNext claims one due escalation and fires its next step, unless the
+// alert was handled in the meantime. The users of the step are notified in
+// parallel. It returns false when nothing is due.
+func (s *EscalationService) ProcessNext() bool {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	now := s.Now()
+	var escalation models.Escalation
+	err := s.Escalations.FindOneAndUpdate(ctx,
+		bson.M{"status": models.EscalationActive, "dueAt": bson.M{"$lte": now}},
+		bson.M{"$set": bson.M{"dueAt": now.Add(s.Lease)}},
+		options.FindOneAndUpdate().SetSort(bson.D{{Key: "dueAt", Value: 1}}),
+	).Decode(&escalation)
+	if err != nil {
+		if err != mongo.ErrNoDocuments {
+			log.Printf("Ошибка чтения расписания эскалаций: %v", err)
+		}
+		return false
+	}
+
+	var alert models.Alert
+	if err := s.Alerts.FindOne(ctx, bson.M{"_id": escalation.AlertID}).Decode(&alert); err != nil {
+		s.setStatus(ctx, escalation.ID, models.EscalationStopped)
+		return true
+	}
+	if alert.Status != "" && alert.Status != models.AlertStatusNew {
+		s.setStatus(ctx, escalation.ID, models.EscalationStopped)
+		return true
+	}
+
+	var policy models.EscalationPolicy
+	err = s.Policies.FindOne(ctx, bson.M{"_id": escalation.PolicyID}).Decode(&policy)
+	if err != nil || escalation.NextStep >= len(policy.Steps) {
+		s.setStatus(ctx, escalation.ID, models.EscalationStopped)
+		return true
+	}
+
+	step := policy.Steps[escalation.NextStep]
+	var wg sync.WaitGroup
+	for _, userID := range step.UserIDs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := s.Notifier.NotifyUser(&alert, userID); err != nil {
+				log.Printf("Ошибка эскалации тревоги %s пользователю %s: %v", alert.ID.Hex(), userID.Hex(), err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	// Notifying can take most of the lease; the update gets time of its own.
+	updateCtx, cancelUpdate := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancelUpdate()
+
+	set := bson.M{"nextStep": escalation.NextStep + 1}
+	if next := escalation.NextStep + 1; next < len(policy.Steps) {
+		set["dueAt"] = s.Now().Add(policy.Steps[next].Delay())
+	} else {
+		set["status"] = models.EscalationCompleted
+	}
+	_, err = s.Escalations.UpdateOne(updateCtx,
+		bson.M{"_id": escalation.ID, "status": models.EscalationActive, "nextStep": escalation.NextStep},
+		bson.M{
+			"$set": set,
+			"$push": bson.M{"history": models.EscalationEvent{
+				Step:       escalation.NextStep,
+				UserIDs:    step.UserIDs,
+				NotifiedAt: s.Now(),
+			}},
+		},
+	)
+	if err != nil {
+		log.Printf("Ошибка обновления эскалации: %v", err)
+	}
+	return true
+}
+
+func (s *EscalationService) setStatus(ctx context.Context, id primitive.ObjectID, status string) {
+	if _, err := s.Escalations.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{"status": status}}); err != nil {
+		log.Printf("Ошибка обновления эскалации: %v", err)
+	}
+}
+
+// GetAlertEscalations returns the escalations of an alert with their history.
+func (s *EscalationService) GetAlertEscalations(scope AccessScope, alertID string) ([]models.Escalation, error) {
+	objID, err := primitive.ObjectIDFromHex(alertID)
+	if err != nil {
+		return nil, errors.New("некорректный ID тревоги")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := s.Alerts.FindOne(ctx, scope.LocationFilter(bson.M{"_id": objID})).Err(); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, errors.New("тревога не найдена")
+		}
+		return nil, err
+	}
+
+	cursor, err := s.Escalations.Find(ctx, scope.Filter(bson.M{"alertId": objID}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	escalations := []models.Escalation{}
+	if err := cursor.All(ctx, &escalations); err != nil {
+		return nil, err
+	}
+
+	return escalations, nil
+}
@@ -0,0 +1,349 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"time"
+
+	"backend/config"
+	"backend/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ExportService writes alert lists to CSV and XLSX. Small exports are
+// written straight into the response; larger ones are queued as jobs in
+// MongoDB and written to Blobs, shared by all instances, by the Run loop of
+// any instance.
+type ExportService struct {
+	Collection *mongo.Collection
+	Alerts     *mongo.Collection
+	Cameras    *mongo.Collection
+	Buildings  *mongo.Collection
+	Floors     *mongo.Collection
+
+	Blobs        BlobStore
+	SyncLimit    int64
+	TTL          time.Duration
+	PollInterval time.Duration
+	// Lease is how long a claimed job is hidden from other workers. A job
+	// whose worker died is picked up again once the lease runs out.
+	Lease time.Duration
+}
+
+func NewExportService() *ExportService {
+	cfg := config.Exports
+	if cfg == nil {
+		cfg = &config.ExportConfig{SyncLimit: 1000, TTL: 24 * time.Hour}
+	}
+	return &ExportService{
+		Collection:   config.GetCollection("export_jobs"),
+		Alerts:       config.GetCollection("alerts"),
+		Cameras:      config.GetCollection("cameras"),
+		Buildings:    config.GetCollection("buildings"),
+		Floors:       config.GetCollection("floors"),
+		Blobs:        NewGridFSBlobStore("exports"),
+		SyncLimit:    cfg.SyncLimit,
+		TTL:          cfg.TTL,
+		PollInterval: 5 * time.Second,
+		Lease:        10 * time.Minute,
+	}
+}
+
+var alertExportHeader = []string{
+	"ID", "Тип", "Важность", "Статус", "Решение", "Уверенность",
+	"Здание", "Этаж", "Камера", "Зона", "Источник", "Начало", "Окончание",
+	"Принял", "Принята", "Закрыл", "Закрыта", "Инцидент", "Снимок", "Метки",
+}
+
+func alertExportRow(alert *models.Alert, names *locationNames, loc *time.Location) []string {
+	incident := ""
+	if !alert.IncidentID.IsZero() {
+		incident = alert.IncidentID.Hex()
+	}
+	return []string{
+		alert.ID.Hex(),
+		string(alert.AlertType),
+		severityLabel(alert.Severity),
+		statusLabel(alert.Status),
+		resolutionLabel(alert.Resolution),
+		formatConfidence(alert.Confidence),
+		names.buildings[alert.BuildingID],
+		names.floors[alert.FloorID],
+		names.cameras[alert.CameraID],
+		alert.ZoneName,
+		alert.Source,
+		formatExportTime(alert.StartDateTime, loc),
+		formatExportTime(alert.EndDateTime, loc),
+		alert.AcknowledgedBy,
+		formatExportTimePtr(alert.AcknowledgedAt, loc),
+		alert.ResolvedBy,
+		formatExportTimePtr(alert.ResolvedAt, loc),
+		incident,
+		alert.SnapshotURL,
+		strings.Join(alert.Tags, ", "),
+	}
+}
+
+// CountAlerts counts the alerts an export query selects.
+func (s *ExportService) CountAlerts(query bson.M) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	return s.Alerts.CountDocuments(ctx, query)
+}
+
+// WriteAlerts writes the alerts selected by query in the given format, with
+// times in loc. It returns the number of alerts written.
+func (s *ExportService) WriteAlerts(ctx context.Context, organizationID primitive.ObjectID, query bson.M, sort bson.D, format string, loc *time.Location, w io.Writer) (int64, error) {
+	table, err := newTableWriter(format, w)
+	if err != nil {
+		return 0, err
+	}
+	names, err := loadLocationNames(ctx, s.Cameras, s.Buildings, s.Floors, organizationID)
+	if err != nil {
+		return 0, err
+	}
+
+	cursor, err := s.Alerts.Find(ctx, query, options.Find().SetSort(sort))
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close(ctx)
+
+	if err := table.WriteRow(alertExportHeader); err != nil {
+		return 0, err
+	}
+	var rows int64
+	for cursor.Next(ctx) {
+		var alert models.Alert
+		if err := cursor.Decode(&alert); err != nil {
+			return rows, err
+		}
+		if err := table.WriteRow(alertExportRow(&alert, names, loc)); err != nil {
+			return rows, err
+		}
+		rows++
+	}
+	if err := cursor.Err(); err != nil {
+		return rows, err
+	}
+	return rows, table.Close()
+}
+
+// ExportFileName names an export of the given format created at t.
+func ExportFileName(format string, t time.Time) string {
+	return fmt.Sprintf("alerts-%s.%s", t.Format("20060102-150405"), format)
+}
+
+// CreateJob queues an export of the alerts selected by query. The query
+// must already carry the requester's access scope.
+func (s *ExportService) CreateJob(scope AccessScope, userID, format, timezone string, query bson.M, sort bson.D) (*models.ExportJob, error) {
+	if format != models.ExportCSV && format != models.ExportXLSX {
+		return nil, errors.New("неподдерживаемый формат экспорта: " + format)
+	}
+	if _, err := time.LoadLocation(timezone); err != nil {
+		return nil, errors.New("неизвестный часовой пояс: " + timezone)
+	}
+
+	now := time.Now()
+	job := &models.ExportJob{
+		ID:             primitive.NewObjectID(),
+		OrganizationID: scope.OrganizationID,
+		UserID:         userID,
+		Format:         format,
+		Timezone:       timezone,
+		Query:          query,
+		Sort:           sort,
+		Status:         models.ExportPending,
+		FileName:       ExportFileName(format, now),
+		CreatedAt:      now,
+		LeaseUntil:     now,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if _, err := s.Collection.InsertOne(ctx, job); err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+// GetJobs returns the user's export jobs, newest first.
+func (s *ExportService) GetJobs(scope AccessScope, userID string) ([]models.ExportJob, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	cursor, err := s.Collection.Find(ctx,
+		scope.Filter(bson.M{"userId": userID}),
+		options.Find().SetSort(bson.D{{Key: "createdAt", Value: -1}}).SetProjection(bson.M{"query": 0, "sort": 0}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	jobs := []models.ExportJob{}
+	if err := cursor.All(ctx, &jobs); err != nil {
+		return nil, err
+	}
+	return jobs, nil
+}
+
+// GetJob returns one of the user's export jobs.
+func (s *ExportService) GetJob(scope AccessScope, userID, id string) (*models.ExportJob, error) {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, errors.New("некорректный ID экспорта")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var job models.ExportJob
+	err = s.Collection.FindOne(ctx,
+		scope.Filter(bson.M{"_id": objID, "userId": userID}),
+		options.FindOne().SetProjection(bson.M{"query": 0, "sort": 0}),
+	).Decode(&job)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, errors.New("экспорт не найден")
+		}
+		return nil, err
+	}
+	return &job, nil
+}
+
+// ErrExportNotReady is returned when the file of an unfinished job is
+// opened.
+var ErrExportNotReady = errors.New("экспорт еще не готов")
+
+// OpenJob opens the file of a finished job.
+func (s *ExportService) OpenJob(job *models.ExportJob) (io.ReadCloser, error) {
+	if job.Status != models.ExportDone {
+		return nil, ErrExportNotReady
+	}
+	return s.Blobs.Open(context.Background(), jobKey(job))
+}
+
+func jobKey(job *models.ExportJob) string {
+	return fmt.Sprintf("%s/%s.%s", job.OrganizationID.Hex(), job.ID.Hex(), job.Format)
+}
+
+// Run processes queued jobs and removes expired files until the context is
+// cancelled.
+func (s *ExportService) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		s.removeExpired()
+		for s.ProcessNext() {
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// ProcessNext claims one queued job and writes its file. It returns false
+// when there is nothing to do.
+func (s *ExportService) ProcessNext() bool {
+	ctx, cancel := context.WithTimeout(context.Background(), s.Lease)
+	defer cancel()
+
+	now := time.Now()
+	var job models.ExportJob
+	err := s.Collection.FindOneAndUpdate(ctx,
+		bson.M{
+			"status":     bson.M{"$in": bson.A{models.ExportPending, models.ExportRunning}},
+			"leaseUntil": bson.M{"$lte": now},
+		},
+		bson.M{"$set": bson.M{"status": models.ExportRunning, "leaseUntil": now.Add(s.Lease)}},
+		options.FindOneAndUpdate().SetSort(bson.D{{Key: "createdAt", Value: 1}}),
+	).Decode(&job)
+	if err != nil {
+		if err != mongo.ErrNoDocuments {
+			log.Printf("Ошибка чтения очереди экспорта: %v", err)
+		}
+		return false
+	}
+
+	rows, size, err := s.writeJob(ctx, &job)
+	finished := time.Now()
+	set := bson.M{"finishedAt": finished, "rows": rows}
+	if err != nil {
+		log.Printf("Ошибка экспорта %s: %v", job.ID.Hex(), err)
+		set["status"] = models.ExportFailed
+		set["error"] = err.Error()
+	} else {
+		set["status"] = models.ExportDone
+		set["size"] = size
+		set["expiresAt"] = finished.Add(s.TTL)
+	}
+	if _, err := s.Collection.UpdateOne(ctx, bson.M{"_id": job.ID}, bson.M{"$set": set}); err != nil {
+		log.Printf("Ошибка обновления экспорта %s: %v", job.ID.Hex(), err)
+	}
+	return true
+}
+
+// writeJob streams the job's file into the blob store, which keeps it
+// hidden until it is complete.
+func (s *ExportService) writeJob(ctx context.Context, job *models.ExportJob) (int64, int64, error) {
+	loc, err := time.LoadLocation(job.Timezone)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	reader, writer := io.Pipe()
+	written := make(chan int64)
+	go func() {
+		rows, err := s.WriteAlerts(ctx, job.OrganizationID, job.Query, job.Sort, job.Format, loc, writer)
+		writer.CloseWithError(err)
+		written <- rows
+	}()
+
+	size, err := s.Blobs.Put(ctx, jobKey(job), reader)
+	reader.CloseWithError(err)
+	return <-written, size, err
+}
+
+// removeExpired deletes the files past their TTL and then their jobs. A job
+// whose file could not be deleted is kept and retried on the next run.
+func (s *ExportService) removeExpired() {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	filter := bson.M{"expiresAt": bson.M{"$lte": time.Now()}}
+	cursor, err := s.Collection.Find(ctx, filter, options.Find().SetProjection(bson.M{"organizationId": 1, "format": 1}))
+	if err != nil {
+		log.Printf("Ошибка очистки экспортов: %v", err)
+		return
+	}
+	var jobs []models.ExportJob
+	if err := cursor.All(ctx, &jobs); err != nil {
+		log.Printf("Ошибка очистки экспортов: %v", err)
+		return
+	}
+
+	for _, job := range jobs {
+		if err := s.Blobs.Delete(ctx, jobKey(&job)); err != nil {
+			log.Printf("Ошибка удаления файла экспорта %s: %v", job.ID.Hex(), err)
+			continue
+		}
+		if _, err := s.Collection.DeleteOne(ctx, bson.M{"_id": job.ID}); err != nil {
+			log.Printf("Ошибка удаления экспорта %s: %v", job.ID.Hex(), err)
+		}
+	}
+}
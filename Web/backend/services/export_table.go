@@ -0,0 +1,210 @@
+package services
+
+import (
+	"archive/zip"
+	"bufio"
+	"encoding/csv"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"backend/models"
+)
+
+// tableWriter writes an export row by row; the first row is the header.
+type tableWriter interface {
+	WriteRow(cells []string) error
+	Close() error
+}
+
+func newTableWriter(format string, w io.Writer) (tableWriter, error) {
+	switch format {
+	case models.ExportCSV:
+		return newCSVTable(w)
+	case models.ExportXLSX:
+		return newXLSXTable(w, "Тревоги")
+	}
+	return nil, errors.New("неподдерживаемый формат экспорта: " + format)
+}
+
+// ExportContentType returns the MIME type of an export format.
+func ExportContentType(format string) string {
+	switch format {
+	case models.ExportCSV:
+		return "text/csv; charset=utf-8"
+	case models.ExportXLSX:
+		return "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+	case models.ExportPDF:
+		return "application/pdf"
+	}
+	return "application/octet-stream"
+}
+
+// escapeFormula keeps a spreadsheet from running a cell as a formula. Text
+// starting with = + - @, a tab or a carriage return gets a leading
+// apostrophe; numbers stay as they are.
+func escapeFormula(cell string) string {
+	if cell == "" || !strings.ContainsRune("=+-@\t\r", rune(cell[0])) {
+		return cell
+	}
+	if _, err := strconv.ParseFloat(cell, 64); err == nil {
+		return cell
+	}
+	return "'" + cell
+}
+
+type csvTable struct {
+	w *csv.Writer
+}
+
+// newCSVTable starts the file with a byte order mark, without which Excel
+// reads UTF-8 as the local code page and garbles Cyrillic.
+func newCSVTable(w io.Writer) (*csvTable, error) {
+	if _, err := io.WriteString(w, "\uFEFF"); err != nil {
+		return nil, err
+	}
+	return &csvTable{w: csv.NewWriter(w)}, nil
+}
+
+func (t *csvTable) WriteRow(cells []string) error {
+	escaped := make([]string, len(cells))
+	for i, cell := range cells {
+		escaped[i] = escapeFormula(cell)
+	}
+	return t.w.Write(escaped)
+}
+
+func (t *csvTable) Close() error {
+	t.w.Flush()
+	return t.w.Error()
+}
+
+// xlsxTable streams a single sheet workbook with inline strings, so rows are
+// never held in memory. The header row is bold.
+type xlsxTable struct {
+	zip   *zip.Writer
+	sheet *bufio.Writer
+	rows  int
+}
+
+const xlsxMainNS = "http://schemas.openxmlformats.org/spreadsheetml/2006/main"
+
+func newXLSXTable(w io.Writer, sheetName string) (*xlsxTable, error) {
+	archive := zip.NewWriter(w)
+
+	var name strings.Builder
+	xml.EscapeText(&name, []byte(sheetName))
+	parts := []struct{ path, content string }{
+		{"[Content_Types].xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` +
+			`<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">` +
+			`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>` +
+			`<Default Extension="xml" ContentType="application/xml"/>` +
+			`<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>` +
+			`<Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>` +
+			`<Override PartName="/xl/styles.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.styles+xml"/>` +
+			`</Types>`},
+		{"_rels/.rels", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` +
+			`<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+			`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>` +
+			`</Relationships>`},
+		{"xl/workbook.xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` +
+			`<workbook xmlns="` + xlsxMainNS + `" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">` +
+			`<sheets><sheet name="` + name.String() + `" sheetId="1" r:id="rId1"/></sheets></workbook>`},
+		{"xl/_rels/workbook.xml.rels", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` +
+			`<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+			`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/>` +
+			`<Relationship Id="rId2" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/styles" Target="styles.xml"/>` +
+			`</Relationships>`},
+		{"xl/styles.xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` +
+			`<styleSheet xmlns="` + xlsxMainNS + `">` +
+			`<fonts count="2"><font><sz val="11"/><name val="Calibri"/></font><font><b/><sz val="11"/><name val="Calibri"/></font></fonts>` +
+			`<fills count="2"><fill><patternFill patternType="none"/></fill><fill><patternFill patternType="gray125"/></fill></fills>` +
+			`<borders count="1"><border><left/><right/><top/><bottom/><diagonal/></border></borders>` +
+			`<cellStyleXfs count="1"><xf numFmtId="0" fontId="0" fillId="0" borderId="0"/></cellStyleXfs>` +
+			`<cellXfs count="2"><xf numFmtId="0" fontId="0" fillId="0" borderId="0" xfId="0"/>` +
+			`<xf numFmtId="0" fontId="1" fillId="0" borderId="0" xfId="0" applyFont="1"/></cellXfs>` +
+			`</styleSheet>`},
+	}
+	for _, part := range parts {
+		f, err := archive.Create(part.path)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := io.WriteString(f, part.content); err != nil {
+			return nil, err
+		}
+	}
+
+	f, err := archive.Create("xl/worksheets/sheet1.xml")
+	if err != nil {
+		return nil, err
+	}
+	sheet := bufio.NewWriter(f)
+	sheet.WriteString(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?><worksheet xmlns="` + xlsxMainNS + `"><sheetData>`)
+	return &xlsxTable{zip: archive, sheet: sheet}, nil
+}
+
+func (t *xlsxTable) WriteRow(cells []string) error {
+	t.rows++
+	style := ""
+	if t.rows == 1 {
+		style = ` s="1"`
+	}
+
+	fmt.Fprintf(t.sheet, `<row r="%d">`, t.rows)
+	for i, cell := range cells {
+		fmt.Fprintf(t.sheet, `<c r="%s%d" t="inlineStr"%s><is><t xml:space="preserve">`, xlsxColumn(i), t.rows, style)
+		if err := xml.EscapeText(t.sheet, []byte(escapeFormula(cell))); err != nil {
+			return err
+		}
+		t.sheet.WriteString(`</t></is></c>`)
+	}
+	_, err := t.sheet.WriteString(`</row>`)
+	return err
+}
+
+func (t *xlsxTable) Close() error {
+	t.sheet.WriteString(`</sheetData></worksheet>`)
+	if err := t.sheet.Flush(); err != nil {
+		return err
+	}
+	return t.zip.Close()
+}
+
+// xlsxColumn returns the letters of a zero-based column index: A, B, ... Z,
+// AA, AB and so on.
+func xlsxColumn(index int) string {
+	column := ""
+	for index >= 0 {
+		column = string(rune('A'+index%26)) + column
+		index = index/26 - 1
+	}
+	return column
+}
+
+// formatExportTime formats a timestamp for exports and reports in the given
+// location. Zero times give an empty cell.
+func formatExportTime(t time.Time, loc *time.Location) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.In(loc).Format("02.01.2006 15:04:05")
+}
+
+func formatExportTimePtr(t *time.Time, loc *time.Location) string {
+	if t == nil {
+		return ""
+	}
+	return formatExportTime(*t, loc)
+}
+
+func formatConfidence(confidence float64) string {
+	if confidence == 0 {
+		return ""
+	}
+	return strconv.FormatFloat(confidence*100, 'f', 0, 64) + "%"
+}
@@ -0,0 +1,333 @@
+package services
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"backend/config"
+	"backend/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Formats of training manifests.
+const (
+	ManifestJSONL = "jsonl"
+	ManifestCSV   = "csv"
+)
+
+// FeedbackService keeps the operators' verdicts on alerts as labels for
+// retraining the x3d/stead anomaly model, and exports them as dataset
+// manifests.
+type FeedbackService struct {
+	Collection *mongo.Collection
+	// Alerts carry the labelPending mark of verdicts whose label is not
+	// stored yet, which Run stores again.
+	Alerts        *mongo.Collection
+	RetryInterval time.Duration
+}
+
+func NewFeedbackService() *FeedbackService {
+	return &FeedbackService{
+		Collection:    config.GetCollection("alert_feedback"),
+		Alerts:        config.GetCollection("alerts"),
+		RetryInterval: time.Minute,
+	}
+}
+
+// EnsureIndexes keeps one label per alert, which RecordLabel relies on to
+// never replace a label with an older one, and indexes the pending marks.
+func (s *FeedbackService) EnsureIndexes() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	if _, err := s.Collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "alertId", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	}); err != nil {
+		return err
+	}
+	_, err := s.Alerts.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "labelPending", Value: 1}},
+		Options: options.Index().SetPartialFilterExpression(bson.M{"labelPending": true}),
+	})
+	return err
+}
+
+// TrainingFilter selects the labels of a manifest.
+type TrainingFilter struct {
+	CameraID     primitive.ObjectID
+	ModelVersion string
+	StartDate    time.Time
+	EndDate      time.Time
+}
+
+// feedbackFromAlert builds the label of a resolved alert. Alerts resolved
+// without a verdict carry no label.
+func feedbackFromAlert(alert *models.Alert) (*models.AlertFeedback, bool) {
+	feedback := &models.AlertFeedback{
+		AlertID:        alert.ID,
+		OrganizationID: alert.OrganizationID,
+		CameraID:       alert.CameraID,
+		BuildingID:     alert.BuildingID,
+		FloorID:        alert.FloorID,
+		AlertType:      alert.AlertType,
+		ModelClass:     alert.ModelClass,
+		ModelVersion:   alert.ModelVersion,
+		Score:          alert.Confidence,
+		Start:          alert.StartDateTime,
+		End:            alert.EndDateTime,
+		Resolution:     alert.Resolution,
+		LabeledBy:      alert.LabeledBy,
+		LabeledAt:      time.Now(),
+	}
+	// Alerts resolved before verdicts were tracked carry only the resolve.
+	if feedback.LabeledBy == "" {
+		feedback.LabeledBy = alert.ResolvedBy
+	}
+	if alert.LabeledAt != nil {
+		feedback.LabeledAt = *alert.LabeledAt
+	} else if alert.ResolvedAt != nil {
+		feedback.LabeledAt = *alert.ResolvedAt
+	}
+	if alert.Clip != nil && ValidClipPath(alert.Clip.Path) {
+		feedback.ClipPath = alert.Clip.Path
+		feedback.Start, feedback.End = alert.Clip.Start, alert.Clip.End
+	}
+
+	switch alert.Resolution {
+	case models.AlertResolutionConfirmed:
+		feedback.Label = models.LabelAnomaly
+	case models.AlertResolutionFalsePositive:
+		feedback.Label = models.LabelNormal
+	default:
+		return nil, false
+	}
+	return feedback, true
+}
+
+// ValidClipPath reports whether a clip path stays inside the detector's clip
+// storage: relative and without ".." segments.
+func ValidClipPath(clipPath string) bool {
+	clipPath = strings.ReplaceAll(clipPath, `\`, "/")
+	if clipPath == "" || path.IsAbs(clipPath) || strings.Contains(clipPath, ":") {
+		return false
+	}
+	for _, part := range strings.Split(clipPath, "/") {
+		if part == ".." {
+			return false
+		}
+	}
+	return true
+}
+
+// RecordLabel stores the label of a resolved alert, replacing an older one,
+// and clears the alert's labelPending mark. Alerts resolved without a
+// verdict are skipped. On failure the mark stays and Run retries.
+func (s *FeedbackService) RecordLabel(alert *models.Alert) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if feedback, ok := feedbackFromAlert(alert); ok {
+		// A label newer than this one fails the filter, and the upsert then
+		// hits the unique alertId index: the newer label is kept.
+		_, err := s.Collection.ReplaceOne(ctx,
+			bson.M{"alertId": alert.ID, "labeledAt": bson.M{"$lte": feedback.LabeledAt}},
+			feedback,
+			options.Replace().SetUpsert(true),
+		)
+		if err != nil && !mongo.IsDuplicateKeyError(err) {
+			return err
+		}
+	}
+
+	if !alert.LabelPending || s.Alerts == nil {
+		return nil
+	}
+	_, err := s.Alerts.UpdateOne(ctx,
+		bson.M{"_id": alert.ID, "labeledAt": alert.LabeledAt},
+		bson.M{"$set": bson.M{"labelPending": false}},
+	)
+	return err
+}
+
+// Run stores the labels of pending verdicts until the context is
+// cancelled: those whose store failed, and those of alerts resolved before
+// labels were kept.
+func (s *FeedbackService) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.RetryInterval)
+	defer ticker.Stop()
+
+	for {
+		s.RetryLabels()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RetryLabels stores the labels of a batch of alerts marked labelPending
+// and returns how many were stored.
+func (s *FeedbackService) RetryLabels() int {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	cursor, err := s.Alerts.Find(ctx, bson.M{"labelPending": true}, options.Find().SetLimit(100))
+	if err != nil {
+		log.Printf("Ошибка чтения неразмеченных тревог: %v", err)
+		return 0
+	}
+	var alerts []models.Alert
+	if err := cursor.All(ctx, &alerts); err != nil {
+		log.Printf("Ошибка чтения неразмеченных тревог: %v", err)
+		return 0
+	}
+
+	stored := 0
+	for i := range alerts {
+		if err := s.RecordLabel(&alerts[i]); err != nil {
+			log.Printf("Ошибка сохранения разметки тревоги %s: %v", alerts[i].ID.Hex(), err)
+			continue
+		}
+		stored++
+	}
+	return stored
+}
+
+// manifestEntry is one clip of a training manifest. Label is 1 for an
+// anomaly and 0 for normal footage; times are UTC.
+type manifestEntry struct {
+	Clip         string    `json:"clip"`
+	Label        int       `json:"label"`
+	Class        string    `json:"class"`
+	CameraID     string    `json:"camera_id"`
+	Start        time.Time `json:"start"`
+	End          time.Time `json:"end"`
+	ModelVersion string    `json:"model_version"`
+	Score        float64   `json:"score"`
+	AlertID      string    `json:"alert_id"`
+}
+
+var manifestHeader = []string{"clip", "label", "class", "camera_id", "start", "end", "model_version", "score", "alert_id"}
+
+func newManifestEntry(feedback *models.AlertFeedback) manifestEntry {
+	class := feedback.ModelClass
+	if class == "" {
+		class = string(feedback.AlertType)
+	}
+	cameraID := ""
+	if !feedback.CameraID.IsZero() {
+		cameraID = feedback.CameraID.Hex()
+	}
+	return manifestEntry{
+		Clip:         feedback.ClipPath,
+		Label:        feedback.Label,
+		Class:        class,
+		CameraID:     cameraID,
+		Start:        feedback.Start.UTC(),
+		End:          feedback.End.UTC(),
+		ModelVersion: feedback.ModelVersion,
+		Score:        feedback.Score,
+		AlertID:      feedback.AlertID.Hex(),
+	}
+}
+
+func (e manifestEntry) row() []string {
+	return []string{
+		e.Clip,
+		strconv.Itoa(e.Label),
+		e.Class,
+		e.CameraID,
+		e.Start.Format(time.RFC3339),
+		e.End.Format(time.RFC3339),
+		e.ModelVersion,
+		strconv.FormatFloat(e.Score, 'f', -1, 64),
+		e.AlertID,
+	}
+}
+
+// ManifestQuery builds the Mongo filter selecting the labels in scope that
+// pass the filter. Labels without a clip cannot be trained on and are left
+// out.
+func ManifestQuery(scope AccessScope, filter TrainingFilter) bson.M {
+	query := scope.LocationFilter(bson.M{"clipPath": bson.M{"$exists": true, "$ne": ""}})
+	if !filter.CameraID.IsZero() {
+		query["cameraId"] = filter.CameraID
+	}
+	if filter.ModelVersion != "" {
+		query["modelVersion"] = filter.ModelVersion
+	}
+	if !filter.StartDate.IsZero() || !filter.EndDate.IsZero() {
+		period := bson.M{}
+		if !filter.StartDate.IsZero() {
+			period["$gte"] = filter.StartDate
+		}
+		if !filter.EndDate.IsZero() {
+			period["$lte"] = filter.EndDate
+		}
+		query["start"] = period
+	}
+	return query
+}
+
+// WriteManifest writes the training manifest of the selected labels,
+// oldest clip first: one JSON object per line for jsonl, or a CSV table
+// with a header row. It returns the number of clips written.
+func (s *FeedbackService) WriteManifest(ctx context.Context, scope AccessScope, filter TrainingFilter, format string, w io.Writer) (int64, error) {
+	var write func(manifestEntry) error
+	var flush func() error
+	switch format {
+	case ManifestJSONL:
+		encoder := json.NewEncoder(w)
+		write = func(entry manifestEntry) error { return encoder.Encode(entry) }
+		flush = func() error { return nil }
+	case ManifestCSV:
+		table := csv.NewWriter(w)
+		if err := table.Write(manifestHeader); err != nil {
+			return 0, err
+		}
+		write = func(entry manifestEntry) error { return table.Write(entry.row()) }
+		flush = func() error {
+			table.Flush()
+			return table.Error()
+		}
+	default:
+		return 0, errors.New("неподдерживаемый формат манифеста: " + format)
+	}
+
+	cursor, err := s.Collection.Find(ctx, ManifestQuery(scope, filter), options.Find().SetSort(bson.D{{Key: "start", Value: 1}}))
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close(ctx)
+
+	var rows int64
+	for cursor.Next(ctx) {
+		var feedback models.AlertFeedback
+		if err := cursor.Decode(&feedback); err != nil {
+			return rows, err
+		}
+		if err := write(newManifestEntry(&feedback)); err != nil {
+			return rows, err
+		}
+		rows++
+	}
+	if err := cursor.Err(); err != nil {
+		return rows, err
+	}
+	return rows, flush()
+}
@@ -24,13 +24,13 @@ func NewFloorService() *FloorService {
 
 
 
-func (s *FloorService) GetAllFloors() ([]models.Floor, error) {
+func (s *FloorService) GetAllFloors(scope AccessScope) ([]models.Floor, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
 	var floors []models.Floor
 
-	cursor, err := s.Collection.Find(ctx, bson.M{})
+	cursor, err := s.Collection.Find(ctx, scope.FloorFilter(bson.M{}))
 	if err != nil {
 		return nil, err
 	}
@@ -50,8 +50,9 @@ func (s *FloorService) GetAllFloors() ([]models.Floor, error) {
 	return floors, nil
 }
 
-func (s *FloorService) CreateFloor(floor *models.Floor) (*models.Floor, error) {
+func (s *FloorService) CreateFloor(scope AccessScope, floor *models.Floor) (*models.Floor, error) {
 	floor.ID = primitive.NewObjectID()
+	floor.OrganizationID = scope.OrganizationID
 
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
@@ -64,7 +65,7 @@ func (s *FloorService) CreateFloor(floor *models.Floor) (*models.Floor, error) {
 	return floor, nil
 }
 
-func (s *FloorService) DeleteFloor(id string) error {
+func (s *FloorService) DeleteFloor(scope AccessScope, id string) error {
 	objID, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
 		return err
@@ -73,7 +74,7 @@ func (s *FloorService) DeleteFloor(id string) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	_, err = s.Collection.DeleteOne(ctx, bson.M{"_id": objID})
+	_, err = s.Collection.DeleteOne(ctx, scope.FloorFilter(bson.M{"_id": objID}))
 	if err != nil {
 		return err
 	}
@@ -81,7 +82,7 @@ func (s *FloorService) DeleteFloor(id string) error {
 	return nil
 }
 
-func (s *FloorService) UpdateFloor(id string, floor *models.Floor) (*models.Floor, error) {
+func (s *FloorService) UpdateFloor(scope AccessScope, id string, floor *models.Floor) (*models.Floor, error) {
 	objID, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
 		return nil, err
@@ -90,7 +91,8 @@ func (s *FloorService) UpdateFloor(id string, floor *models.Floor) (*models.Floo
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	_, err = s.Collection.UpdateOne(ctx, bson.M{"_id": objID}, bson.M{"$set": floor})
+	floor.OrganizationID = scope.OrganizationID
+	_, err = s.Collection.UpdateOne(ctx, scope.FloorFilter(bson.M{"_id": objID}), bson.M{"$set": floor})
 	if err != nil {
 		return nil, err
 	}
@@ -98,7 +100,7 @@ func (s *FloorService) UpdateFloor(id string, floor *models.Floor) (*models.Floo
 	return floor, nil
 }
 
-func (s *FloorService) GetFloorByID(id string) (*models.Floor, error) {
+func (s *FloorService) GetFloorByID(scope AccessScope, id string) (*models.Floor, error) {
 	objID, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
 		return nil, err
@@ -108,7 +110,7 @@ func (s *FloorService) GetFloorByID(id string) (*models.Floor, error) {
 	defer cancel()
 
 	var floor models.Floor
-	err = s.Collection.FindOne(ctx, bson.M{"_id": objID}).Decode(&floor)
+	err = s.Collection.FindOne(ctx, scope.FloorFilter(bson.M{"_id": objID})).Decode(&floor)
 	if err != nil {
 		return nil, err
 	}
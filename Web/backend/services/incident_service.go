@@ -0,0 +1,430 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"backend/config"
+	"backend/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const defaultIncidentWindow = 2 * time.Minute
+
+// IncidentService correlates new alerts into incidents and manages their
+// lifecycle.
+type IncidentService struct {
+	Collection *mongo.Collection
+	Alerts     *mongo.Collection
+	Cameras    *mongo.Collection
+	Window     time.Duration
+}
+
+type IncidentFilter struct {
+	Status    string
+	AlertType string
+}
+
+func NewIncidentService() *IncidentService {
+	window := defaultIncidentWindow
+	if config.Incidents != nil {
+		window = config.Incidents.Window
+	}
+	return &IncidentService{
+		Collection: config.GetCollection("incidents"),
+		Alerts:     config.GetCollection("alerts"),
+		Cameras:    config.GetCollection("cameras"),
+		Window:     window,
+	}
+}
+
+// EnsureIndexes makes the open key unique, which keeps simultaneous alerts
+// of one event from opening several incidents, even on different servers.
+func (s *IncidentService) EnsureIndexes() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	_, err := s.Collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "openKey", Value: 1}},
+		Options: options.Index().
+			SetUnique(true).
+			SetPartialFilterExpression(bson.M{"openKey": bson.M{"$exists": true}}),
+	})
+	return err
+}
+
+// Correlate attaches a new alert to an open incident of the same type whose
+// last alert is within the window and that was raised on the same floor or
+// by the same or a neighbouring camera. Otherwise it opens a new incident.
+// It sets IncidentID and Correlated on the alert.
+//
+// The incident was announced by its first alert, so later ones stay quiet
+// while it is new. An alert that raises its severity, or that arrives after
+// it was acknowledged and its escalations stopped, is announced again.
+//
+// A new incident takes the open key of its floor or camera. When another
+// alert took it first, the alert joins that incident instead.
+func (s *IncidentService) Correlate(alert *models.Alert) (*models.Incident, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	nearby := bson.A{}
+	if !alert.FloorID.IsZero() {
+		nearby = append(nearby, bson.M{"floorId": alert.FloorID})
+	}
+	if !alert.CameraID.IsZero() {
+		cameras, err := s.neighbors(ctx, alert.OrganizationID, alert.CameraID)
+		if err != nil {
+			return nil, err
+		}
+		nearby = append(nearby, bson.M{"cameraIds": bson.M{"$in": cameras}})
+	}
+
+	cutoff := alert.StartDateTime.Add(-s.Window)
+	for attempt := 0; ; attempt++ {
+		if len(nearby) > 0 {
+			var before models.Incident
+			err := s.Collection.FindOneAndUpdate(ctx,
+				bson.M{
+					"organizationId": alert.OrganizationID,
+					"alert_type":     alert.AlertType,
+					"status":         bson.M{"$ne": models.AlertStatusResolved},
+					"lastAlertAt":    bson.M{"$gte": cutoff},
+					"$or":            nearby,
+				},
+				s.attach(alert),
+				options.FindOneAndUpdate().
+					SetSort(bson.D{{Key: "lastAlertAt", Value: -1}}).
+					SetReturnDocument(options.Before),
+			).Decode(&before)
+			if err == nil {
+				alert.IncidentID = before.ID
+				alert.Correlated = before.Status == models.AlertStatusNew && alert.Priority <= before.Priority
+				return joined(before, alert), nil
+			}
+			if err != mongo.ErrNoDocuments {
+				return nil, err
+			}
+		}
+
+		incident, err := s.open(ctx, alert, cutoff)
+		if mongo.IsDuplicateKeyError(err) && attempt == 0 {
+			// An incident was opened here meanwhile; it matches nearby.
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		alert.IncidentID = incident.ID
+		alert.Correlated = false
+		return incident, nil
+	}
+}
+
+// open inserts a new incident for the alert. An incident that still holds the
+// open key but had no alert within the window gives it up first.
+func (s *IncidentService) open(ctx context.Context, alert *models.Alert, cutoff time.Time) (*models.Incident, error) {
+	incident := models.Incident{
+		ID:             primitive.NewObjectID(),
+		OrganizationID: alert.OrganizationID,
+		AlertType:      alert.AlertType,
+		Severity:       alert.Severity,
+		Priority:       alert.Priority,
+		BuildingID:     alert.BuildingID,
+		FloorID:        alert.FloorID,
+		CameraIDs:      []primitive.ObjectID{},
+		AlertIDs:       []primitive.ObjectID{alert.ID},
+		AlertCount:     1,
+		FirstAlertAt:   alert.StartDateTime,
+		LastAlertAt:    alert.StartDateTime,
+		Status:         models.AlertStatusNew,
+		OpenKey:        openKey(alert),
+	}
+	if !alert.CameraID.IsZero() {
+		incident.CameraIDs = append(incident.CameraIDs, alert.CameraID)
+	}
+
+	if incident.OpenKey != "" {
+		_, err := s.Collection.UpdateOne(ctx,
+			bson.M{"openKey": incident.OpenKey, "lastAlertAt": bson.M{"$lt": cutoff}},
+			bson.M{"$unset": bson.M{"openKey": ""}},
+		)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if _, err := s.Collection.InsertOne(ctx, incident); err != nil {
+		return nil, err
+	}
+	return &incident, nil
+}
+
+// openKey returns the key an incident opened by the alert holds: its floor,
+// or its camera when it has no floor. Alerts with neither never join other
+// incidents and need no key.
+func openKey(alert *models.Alert) string {
+	prefix := alert.OrganizationID.Hex() + ":" + string(alert.AlertType)
+	switch {
+	case !alert.FloorID.IsZero():
+		return prefix + ":floor:" + alert.FloorID.Hex()
+	case !alert.CameraID.IsZero():
+		return prefix + ":camera:" + alert.CameraID.Hex()
+	}
+	return ""
+}
+
+// attach builds the update adding an alert to an incident. The incident
+// takes the highest severity of its alerts.
+func (s *IncidentService) attach(alert *models.Alert) mongo.Pipeline {
+	cameras := bson.A{}
+	if !alert.CameraID.IsZero() {
+		cameras = append(cameras, alert.CameraID)
+	}
+	higher := bson.M{"$gt": bson.A{alert.Priority, "$priority"}}
+
+	return mongo.Pipeline{{{Key: "$set", Value: bson.M{
+		"cameraIds":   bson.M{"$setUnion": bson.A{"$cameraIds", cameras}},
+		"alertIds":    bson.M{"$concatArrays": bson.A{"$alertIds", bson.A{alert.ID}}},
+		"alertCount":  bson.M{"$add": bson.A{"$alertCount", 1}},
+		"lastAlertAt": bson.M{"$max": bson.A{"$lastAlertAt", alert.StartDateTime}},
+		"severity":    bson.M{"$cond": bson.A{higher, alert.Severity, "$severity"}},
+		"priority":    bson.M{"$cond": bson.A{higher, alert.Priority, "$priority"}},
+	}}}}
+}
+
+// joined returns the incident as attach leaves it after adding the alert.
+func joined(incident models.Incident, alert *models.Alert) *models.Incident {
+	if !alert.CameraID.IsZero() && !containsID(incident.CameraIDs, alert.CameraID) {
+		incident.CameraIDs = append(incident.CameraIDs, alert.CameraID)
+	}
+	incident.AlertIDs = append(incident.AlertIDs, alert.ID)
+	incident.AlertCount++
+	if alert.StartDateTime.After(incident.LastAlertAt) {
+		incident.LastAlertAt = alert.StartDateTime
+	}
+	if alert.Priority > incident.Priority {
+		incident.Severity, incident.Priority = alert.Severity, alert.Priority
+	}
+	return &incident
+}
+
+// Detach takes back the correlation of an alert that could not be stored. An
+// incident the alert opened is removed; otherwise the alert is taken out of
+// the incident, which keeps the time, cameras and severity it contributed.
+func (s *IncidentService) Detach(alert *models.Alert) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	result, err := s.Collection.DeleteOne(ctx, bson.M{"_id": alert.IncidentID, "alertIds": bson.A{alert.ID}})
+	if err != nil || result.DeletedCount > 0 {
+		return err
+	}
+	_, err = s.Collection.UpdateOne(ctx,
+		bson.M{"_id": alert.IncidentID, "alertIds": alert.ID},
+		bson.M{"$pull": bson.M{"alertIds": alert.ID}, "$inc": bson.M{"alertCount": -1}},
+	)
+	return err
+}
+
+// neighbors returns the camera with the cameras it is adjacent to, in either
+// direction of the configured neighbour graph.
+func (s *IncidentService) neighbors(ctx context.Context, orgID, cameraID primitive.ObjectID) ([]primitive.ObjectID, error) {
+	cursor, err := s.Cameras.Find(ctx,
+		bson.M{"organizationId": orgID, "$or": bson.A{
+			bson.M{"_id": cameraID},
+			bson.M{"neighborIds": cameraID},
+		}},
+		options.Find().SetProjection(bson.M{"neighborIds": 1}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	var cameras []models.Camera
+	if err := cursor.All(ctx, &cameras); err != nil {
+		return nil, err
+	}
+
+	ids := []primitive.ObjectID{cameraID}
+	for _, camera := range cameras {
+		if camera.ID != cameraID {
+			ids = append(ids, camera.ID)
+			continue
+		}
+		ids = append(ids, camera.NeighborIDs...)
+	}
+	return ids, nil
+}
+
+func (s *IncidentService) GetIncidents(scope AccessScope, filter IncidentFilter, pagination AlertPagination) ([]models.Incident, int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	query := bson.M{}
+	if filter.Status != "" {
+		query["status"] = filter.Status
+	}
+	if filter.AlertType != "" {
+		query["alert_type"] = filter.AlertType
+	}
+	query = scope.LocationFilter(query)
+
+	total, err := s.Collection.CountDocuments(ctx, query)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if pagination.Page <= 0 {
+		pagination.Page = 1
+	}
+	if pagination.PageSize <= 0 {
+		pagination.PageSize = 20
+	}
+	findOptions := options.Find().
+		SetSort(bson.D{{Key: "priority", Value: -1}, {Key: "lastAlertAt", Value: -1}}).
+		SetSkip((pagination.Page - 1) * pagination.PageSize).
+		SetLimit(pagination.PageSize)
+
+	cursor, err := s.Collection.Find(ctx, query, findOptions)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer cursor.Close(ctx)
+
+	incidents := []models.Incident{}
+	if err := cursor.All(ctx, &incidents); err != nil {
+		return nil, 0, err
+	}
+
+	return incidents, total, nil
+}
+
+// GetIncident returns the incident with those of its alerts the scope may
+// see. Cameras of neighbouring buildings can correlate alerts into one
+// incident.
+func (s *IncidentService) GetIncident(scope AccessScope, id string) (*models.Incident, []models.Alert, error) {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, nil, errors.New("некорректный ID инцидента")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var incident models.Incident
+	err = s.Collection.FindOne(ctx, scope.LocationFilter(bson.M{"_id": objID})).Decode(&incident)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil, errors.New("инцидент не найден")
+		}
+		return nil, nil, err
+	}
+
+	cursor, err := s.Alerts.Find(ctx,
+		scope.LocationFilter(bson.M{"incidentId": objID}),
+		options.Find().SetSort(bson.D{{Key: "start_datetime", Value: 1}}),
+	)
+	if err != nil {
+		return nil, nil, err
+	}
+	alerts := []models.Alert{}
+	if err := cursor.All(ctx, &alerts); err != nil {
+		return nil, nil, err
+	}
+
+	return &incident, alerts, nil
+}
+
+// AcknowledgeIncident acknowledges a new incident together with its new
+// alerts. It returns the incident before and after the change.
+func (s *IncidentService) AcknowledgeIncident(scope AccessScope, id, username string) (before, after *models.Incident, err error) {
+	now := time.Now()
+	return s.transition(scope, id,
+		bson.A{models.AlertStatusNew},
+		bson.M{"status": models.AlertStatusAcknowledged, "acknowledgedBy": username, "acknowledgedAt": now},
+		func(i *models.Incident) {
+			i.Status = models.AlertStatusAcknowledged
+			i.AcknowledgedBy = username
+			i.AcknowledgedAt = &now
+		},
+	)
+}
+
+// ResolveIncident closes an incident and all of its open alerts.
+func (s *IncidentService) ResolveIncident(scope AccessScope, id, username string) (before, after *models.Incident, err error) {
+	now := time.Now()
+	return s.transition(scope, id,
+		bson.A{models.AlertStatusNew, models.AlertStatusAcknowledged},
+		bson.M{"status": models.AlertStatusResolved, "resolvedBy": username, "resolvedAt": now},
+		func(i *models.Incident) {
+			i.Status = models.AlertStatusResolved
+			i.ResolvedBy = username
+			i.ResolvedAt = &now
+		},
+	)
+}
+
+// transition moves an incident whose status is one of from and applies the
+// same change to its alerts that are still in one of those statuses.
+func (s *IncidentService) transition(scope AccessScope, id string, from bson.A, set bson.M, apply func(*models.Incident)) (*models.Incident, *models.Incident, error) {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, nil, errors.New("некорректный ID инцидента")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	alertStatuses := append(bson.A{nil}, from...)
+	if scope.Restricted {
+		// The incident moves as a whole, so it may only be handled by those
+		// who can see all of its open alerts.
+		hidden, err := s.Alerts.CountDocuments(ctx, bson.M{
+			"organizationId": scope.OrganizationID,
+			"incidentId":     objID,
+			"status":         bson.M{"$in": alertStatuses},
+			"$nor":           scope.LocationFilter(bson.M{})["$or"],
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+		if hidden > 0 {
+			return nil, nil, errors.New("инцидент включает тревоги вне доступных вам зданий")
+		}
+	}
+
+	update := bson.M{"$set": set}
+	if set["status"] == models.AlertStatusResolved {
+		// A resolved incident takes no alerts, so a new one may open there.
+		update["$unset"] = bson.M{"openKey": ""}
+	}
+
+	var before models.Incident
+	err = s.Collection.FindOneAndUpdate(ctx,
+		scope.LocationFilter(bson.M{"_id": objID, "status": bson.M{"$in": from}}),
+		update,
+	).Decode(&before)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil, errors.New("инцидент не найден или уже обработан")
+		}
+		return nil, nil, err
+	}
+
+	_, err = s.Alerts.UpdateMany(ctx,
+		scope.LocationFilter(bson.M{"incidentId": objID, "status": bson.M{"$in": alertStatuses}}),
+		bson.M{"$set": set},
+	)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	after := before
+	apply(&after)
+	return &before, &after, nil
+}
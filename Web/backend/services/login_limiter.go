@@ -0,0 +1,195 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"backend/config"
+	"backend/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var ErrLoginLocked = errors.New("слишком много неудачных попыток входа, повторите позже")
+
+// LoginLimiter tracks failed logins per username and per client IP. Failures
+// slow down further attempts progressively and lock the key for a while once
+// the limit is reached. Only failures within FailureWindow of each other are
+// counted; older ones are forgotten.
+type LoginLimiter struct {
+	Collection *mongo.Collection
+
+	MaxUserFailures int
+	MaxIPFailures   int
+	FailureWindow   time.Duration
+	LockoutDuration time.Duration
+	BaseDelay       time.Duration
+	MaxDelay        time.Duration
+}
+
+func NewLoginLimiter() *LoginLimiter {
+	return &LoginLimiter{
+		Collection:      config.GetCollection("login_attempts"),
+		MaxUserFailures: 5,
+		MaxIPFailures:   20,
+		FailureWindow:   15 * time.Minute,
+		LockoutDuration: 15 * time.Minute,
+		BaseDelay:       250 * time.Millisecond,
+		MaxDelay:        5 * time.Second,
+	}
+}
+
+// EnsureIndexes creates the TTL index that drops stale counters.
+func (l *LoginLimiter) EnsureIndexes() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	_, err := l.Collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "expiresAt", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	})
+	return err
+}
+
+func userKey(username string) string {
+	return "user:" + strings.ToLower(strings.TrimSpace(username))
+}
+
+func ipKey(ip string) string {
+	return "ip:" + ip
+}
+
+// Check returns ErrLoginLocked if the username or IP is locked, and otherwise
+// the delay to wait before checking the password.
+func (l *LoginLimiter) Check(username, ip string) (time.Duration, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	cursor, err := l.Collection.Find(ctx, bson.M{"_id": bson.M{"$in": bson.A{userKey(username), ipKey(ip)}}})
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close(ctx)
+
+	var attempts []models.LoginAttempts
+	if err := cursor.All(ctx, &attempts); err != nil {
+		return 0, err
+	}
+
+	failures := 0
+	windowStart := time.Now().Add(-l.FailureWindow)
+	for _, a := range attempts {
+		if a.LockedUntil != nil && time.Now().Before(*a.LockedUntil) {
+			return 0, ErrLoginLocked
+		}
+		if a.LastFailureAt.After(windowStart) && a.Failures > failures {
+			failures = a.Failures
+		}
+	}
+
+	return l.Delay(failures), nil
+}
+
+// Delay doubles with every consecutive failure up to MaxDelay.
+func (l *LoginLimiter) Delay(failures int) time.Duration {
+	if failures <= 0 {
+		return 0
+	}
+	delay := l.BaseDelay
+	for i := 1; i < failures && delay < l.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > l.MaxDelay {
+		delay = l.MaxDelay
+	}
+	return delay
+}
+
+func (l *LoginLimiter) RecordFailure(username, ip string) error {
+	if err := l.recordFailure(userKey(username), l.MaxUserFailures); err != nil {
+		return err
+	}
+	return l.recordFailure(ipKey(ip), l.MaxIPFailures)
+}
+
+func (l *LoginLimiter) recordFailure(key string, maxFailures int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// The counter starts over when the previous failure is outside the window.
+	now := time.Now()
+	var attempts models.LoginAttempts
+	err := l.Collection.FindOneAndUpdate(ctx,
+		bson.M{"_id": key},
+		mongo.Pipeline{{{Key: "$set", Value: bson.M{
+			"failures": bson.M{"$cond": bson.A{
+				bson.M{"$gt": bson.A{"$lastFailureAt", now.Add(-l.FailureWindow)}},
+				bson.M{"$add": bson.A{"$failures", 1}},
+				1,
+			}},
+			"lastFailureAt": now,
+			"expiresAt":     now.Add(l.FailureWindow),
+		}}}},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(&attempts)
+	if err != nil {
+		return err
+	}
+
+	if attempts.Failures >= maxFailures {
+		lockedUntil := now.Add(l.LockoutDuration)
+		expiresAt := lockedUntil
+		if l.FailureWindow > l.LockoutDuration {
+			expiresAt = now.Add(l.FailureWindow)
+		}
+		_, err = l.Collection.UpdateOne(ctx,
+			bson.M{"_id": key},
+			bson.M{"$set": bson.M{"lockedUntil": lockedUntil, "expiresAt": expiresAt}},
+		)
+	}
+	return err
+}
+
+// RecordSuccess clears the failures of the username. IP counters are kept so
+// that one valid account cannot be used to reset an attacker's budget.
+func (l *LoginLimiter) RecordSuccess(username string) error {
+	return l.Unlock(username)
+}
+
+// Unlock removes the failures and lockout of a username.
+func (l *LoginLimiter) Unlock(username string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err := l.Collection.DeleteOne(ctx, bson.M{"_id": userKey(username)})
+	return err
+}
+
+// GetLockouts lists which of the usernames are currently locked. IP lockouts
+// are not tied to an organization and are not listed.
+func (l *LoginLimiter) GetLockouts(usernames []string) ([]models.LoginAttempts, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	keys := make([]string, len(usernames))
+	for i, username := range usernames {
+		keys[i] = userKey(username)
+	}
+
+	cursor, err := l.Collection.Find(ctx, bson.M{"_id": bson.M{"$in": keys}, "lockedUntil": bson.M{"$gt": time.Now()}})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	lockouts := []models.LoginAttempts{}
+	if err := cursor.All(ctx, &lockouts); err != nil {
+		return nil, err
+	}
+
+	return lockouts, nil
+}
@@ -0,0 +1,222 @@
+package services
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"backend/config"
+)
+
+// MailMessage is a plain text email with optional attachments.
+type MailMessage struct {
+	To          []string
+	Subject     string
+	Body        string
+	Attachments []MailAttachment
+}
+
+type MailAttachment struct {
+	Filename    string
+	ContentType string
+	Data        []byte
+}
+
+// Mailer sends email. Implementations must be safe for concurrent use and
+// give up when the context is done.
+type Mailer interface {
+	Send(ctx context.Context, msg MailMessage) error
+}
+
+// NewMailer returns the mailer selected by MAILER_DRIVER.
+func NewMailer() Mailer {
+	cfg := config.Mailer
+	if cfg == nil {
+		return &LogMailer{}
+	}
+
+	switch cfg.Driver {
+	case config.MailerSMTP:
+		return &SMTPMailer{
+			Addr: cfg.SMTPHost + ":" + cfg.SMTPPort,
+			Host: cfg.SMTPHost,
+			Auth: smtpAuth(cfg),
+			From: cfg.From,
+		}
+	case config.MailerFile:
+		return &FileMailer{Path: cfg.FilePath}
+	default:
+		return &LogMailer{}
+	}
+}
+
+func smtpAuth(cfg *config.MailerConfig) smtp.Auth {
+	if cfg.SMTPUsername == "" {
+		return nil
+	}
+	return smtp.PlainAuth("", cfg.SMTPUsername, cfg.SMTPPassword, cfg.SMTPHost)
+}
+
+// SMTPMailer delivers mail through an SMTP relay. STARTTLS is used when the
+// server offers it.
+type SMTPMailer struct {
+	Addr string
+	Host string
+	Auth smtp.Auth
+	From string
+}
+
+// Send talks to the relay like smtp.SendMail, but on a connection that is
+// closed when the context is done, so a stalled relay cannot hold the caller.
+func (m *SMTPMailer) Send(ctx context.Context, msg MailMessage) error {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", m.Addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() { conn.SetDeadline(time.Now()) })
+	defer stop()
+
+	c, err := smtp.NewClient(conn, m.Host)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: m.Host}); err != nil {
+			return err
+		}
+	}
+	if m.Auth != nil {
+		if ok, _ := c.Extension("AUTH"); ok {
+			if err := c.Auth(m.Auth); err != nil {
+				return err
+			}
+		}
+	}
+	if err := c.Mail(m.From); err != nil {
+		return err
+	}
+	for _, to := range msg.To {
+		if err := c.Rcpt(to); err != nil {
+			return err
+		}
+	}
+
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(formatMail(m.From, msg)); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// FileMailer appends messages to a file, for development and tests.
+type FileMailer struct {
+	Path string
+
+	mu sync.Mutex
+}
+
+func (m *FileMailer) Send(_ context.Context, msg MailMessage) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	f, err := os.OpenFile(m.Path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = f.Write(append(formatMail("", msg), "\r\n"...))
+	return err
+}
+
+// LogMailer writes messages to the application log.
+type LogMailer struct{}
+
+func (m *LogMailer) Send(_ context.Context, msg MailMessage) error {
+	log.Printf("📧 Письмо для %s: %s\n%s", strings.Join(msg.To, ", "), msg.Subject, msg.Body)
+	return nil
+}
+
+func formatMail(from string, msg MailMessage) []byte {
+	var b strings.Builder
+	if from != "" {
+		fmt.Fprintf(&b, "From: %s\r\n", from)
+	}
+	fmt.Fprintf(&b, "To: %s\r\n", strings.Join(msg.To, ", "))
+	fmt.Fprintf(&b, "Subject: %s\r\n", mimeHeader(msg.Subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+
+	if len(msg.Attachments) == 0 {
+		writeTextPart(&b, msg.Body)
+		return []byte(b.String())
+	}
+
+	w := multipart.NewWriter(&b)
+	fmt.Fprintf(&b, "Content-Type: multipart/mixed; boundary=%s\r\n\r\n", w.Boundary())
+
+	textHeader := textproto.MIMEHeader{}
+	textHeader.Set("Content-Type", "text/plain; charset=UTF-8")
+	textHeader.Set("Content-Transfer-Encoding", "8bit")
+	part, _ := w.CreatePart(textHeader)
+	part.Write([]byte(strings.ReplaceAll(msg.Body, "\n", "\r\n")))
+
+	for _, attachment := range msg.Attachments {
+		header := textproto.MIMEHeader{}
+		header.Set("Content-Type", attachment.ContentType)
+		header.Set("Content-Transfer-Encoding", "base64")
+		header.Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": attachment.Filename}))
+		part, _ := w.CreatePart(header)
+		part.Write(wrapBase64(attachment.Data))
+	}
+	w.Close()
+
+	return []byte(b.String())
+}
+
+func writeTextPart(b *strings.Builder, body string) {
+	b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	b.WriteString("Content-Transfer-Encoding: 8bit\r\n\r\n")
+	b.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+	b.WriteString("\r\n")
+}
+
+// wrapBase64 encodes data in 76-character lines as required by RFC 2045.
+func wrapBase64(data []byte) []byte {
+	encoded := base64.StdEncoding.EncodeToString(data)
+	var b strings.Builder
+	for len(encoded) > 76 {
+		b.WriteString(encoded[:76])
+		b.WriteString("\r\n")
+		encoded = encoded[76:]
+	}
+	b.WriteString(encoded)
+	b.WriteString("\r\n")
+	return []byte(b.String())
+}
+
+// mimeHeader encodes non-ASCII header values, since subjects are in Russian.
+func mimeHeader(value string) string {
+	return mime.QEncoding.Encode("utf-8", value)
+}
@@ -0,0 +1,293 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"os"
+	"strings"
+	"time"
+
+	"backend/config"
+	"backend/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const recoveryCodeCount = 10
+
+var ErrInvalidMFACode = errors.New("неверный код подтверждения")
+
+type MFAService struct {
+	Users    *mongo.Collection
+	Settings *mongo.Collection
+	Issuer   string
+}
+
+func NewMFAService() *MFAService {
+	issuer := os.Getenv("MFA_ISSUER")
+	if issuer == "" {
+		issuer = "Anomaly Behavior Detect"
+	}
+
+	return &MFAService{
+		Users:    config.GetCollection("users"),
+		Settings: config.GetCollection("security_settings"),
+		Issuer:   issuer,
+	}
+}
+
+// BeginEnrollment generates a new secret that becomes active once a code from
+// it is confirmed.
+func (s *MFAService) BeginEnrollment(userID string) (secret, uri string, err error) {
+	user, err := s.getUser(userID)
+	if err != nil {
+		return "", "", err
+	}
+	if user.TOTPEnabled {
+		return "", "", errors.New("двухфакторная аутентификация уже включена")
+	}
+
+	secret, err = GenerateTOTPSecret()
+	if err != nil {
+		return "", "", err
+	}
+	if err := s.setUser(user.ID, bson.M{"totpPendingSecret": secret}); err != nil {
+		return "", "", err
+	}
+
+	return secret, TOTPProvisioningURI(s.Issuer, user.Username, secret), nil
+}
+
+// ConfirmEnrollment enables 2FA and returns fresh recovery codes.
+func (s *MFAService) ConfirmEnrollment(userID, code string) ([]string, error) {
+	user, err := s.getUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	if user.TOTPPendingSecret == "" {
+		return nil, errors.New("подключение двухфакторной аутентификации не начато")
+	}
+
+	step, ok := ValidateTOTP(user.TOTPPendingSecret, code, time.Now())
+	if !ok {
+		return nil, ErrInvalidMFACode
+	}
+
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.updateUser(user.ID, bson.M{
+		"$set": bson.M{
+			"totpEnabled":   true,
+			"totpSecret":    user.TOTPPendingSecret,
+			"totpLastStep":  step,
+			"recoveryCodes": hashes,
+		},
+		"$unset": bson.M{"totpPendingSecret": ""},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+// Disable turns 2FA off after checking a current code.
+func (s *MFAService) Disable(userID string, orgID primitive.ObjectID, code string) error {
+	user, err := s.getUser(userID)
+	if err != nil {
+		return err
+	}
+
+	required, err := s.RequiresMFA(orgID, user.RoleIn(orgID))
+	if err != nil {
+		return err
+	}
+	if required {
+		return errors.New("двухфакторная аутентификация обязательна для вашей роли")
+	}
+
+	if err := s.Verify(userID, code, ""); err != nil {
+		return err
+	}
+
+	return s.updateUser(user.ID, bson.M{
+		"$set":   bson.M{"totpEnabled": false},
+		"$unset": bson.M{"totpSecret": "", "totpLastStep": "", "recoveryCodes": ""},
+	})
+}
+
+// RegenerateRecoveryCodes replaces all recovery codes after checking a current code.
+func (s *MFAService) RegenerateRecoveryCodes(userID, code string) ([]string, error) {
+	if err := s.Verify(userID, code, ""); err != nil {
+		return nil, err
+	}
+
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+
+	objID, _ := primitive.ObjectIDFromHex(userID)
+	if err := s.setUser(objID, bson.M{"recoveryCodes": hashes}); err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+// Verify checks a TOTP code, or consumes a recovery code when one is given.
+// A TOTP code can be used only once.
+func (s *MFAService) Verify(userID, code, recoveryCode string) error {
+	user, err := s.getUser(userID)
+	if err != nil {
+		return err
+	}
+	if !user.TOTPEnabled {
+		return errors.New("двухфакторная аутентификация не включена")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if recoveryCode != "" {
+		hash := hashToken(normalizeRecoveryCode(recoveryCode))
+		result, err := s.Users.UpdateOne(ctx,
+			bson.M{"_id": user.ID, "recoveryCodes": hash},
+			bson.M{"$pull": bson.M{"recoveryCodes": hash}},
+		)
+		if err != nil {
+			return err
+		}
+		if result.ModifiedCount == 0 {
+			return ErrInvalidMFACode
+		}
+		return nil
+	}
+
+	step, ok := ValidateTOTP(user.TOTPSecret, code, time.Now())
+	if !ok {
+		return ErrInvalidMFACode
+	}
+
+	result, err := s.Users.UpdateOne(ctx,
+		bson.M{"_id": user.ID, "totpLastStep": bson.M{"$lt": step}},
+		bson.M{"$set": bson.M{"totpLastStep": step}},
+	)
+	if err != nil {
+		return err
+	}
+	if result.ModifiedCount == 0 {
+		return ErrInvalidMFACode
+	}
+
+	return nil
+}
+
+func (s *MFAService) GetSettings(orgID primitive.ObjectID) (*models.SecuritySettings, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	settings := models.SecuritySettings{OrganizationID: orgID, RequireMFARoles: []models.Role{}}
+	err := s.Settings.FindOne(ctx, bson.M{"_id": orgID}).Decode(&settings)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return nil, err
+	}
+
+	return &settings, nil
+}
+
+func (s *MFAService) UpdateSettings(orgID primitive.ObjectID, settings *models.SecuritySettings) (*models.SecuritySettings, error) {
+	for _, role := range settings.RequireMFARoles {
+		if !role.Valid() {
+			return nil, errors.New("неизвестная роль: " + string(role))
+		}
+	}
+	settings.OrganizationID = orgID
+	if settings.RequireMFARoles == nil {
+		settings.RequireMFARoles = []models.Role{}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err := s.Settings.ReplaceOne(ctx, bson.M{"_id": orgID}, settings, options.Replace().SetUpsert(true))
+	if err != nil {
+		return nil, err
+	}
+
+	return settings, nil
+}
+
+// RequiresMFA reports whether the organization requires 2FA for the role.
+func (s *MFAService) RequiresMFA(orgID primitive.ObjectID, role models.Role) (bool, error) {
+	if orgID.IsZero() {
+		return false, nil
+	}
+
+	settings, err := s.GetSettings(orgID)
+	if err != nil {
+		return false, err
+	}
+	for _, r := range settings.RequireMFARoles {
+		if r == role {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (s *MFAService) getUser(userID string) (*models.User, error) {
+	objID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return nil, errors.New("некорректный ID пользователя")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var user models.User
+	if err := s.Users.FindOne(ctx, bson.M{"_id": objID}).Decode(&user); err != nil {
+		return nil, errors.New("пользователь не найден")
+	}
+
+	return &user, nil
+}
+
+func (s *MFAService) setUser(id primitive.ObjectID, set bson.M) error {
+	return s.updateUser(id, bson.M{"$set": set})
+}
+
+func (s *MFAService) updateUser(id primitive.ObjectID, update bson.M) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err := s.Users.UpdateOne(ctx, bson.M{"_id": id}, update)
+	return err
+}
+
+func generateRecoveryCodes() (codes, hashes []string, err error) {
+	encoding := base32.StdEncoding.WithPadding(base32.NoPadding)
+	for i := 0; i < recoveryCodeCount; i++ {
+		b := make([]byte, 8)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, err
+		}
+		raw := strings.ToLower(encoding.EncodeToString(b))[:12]
+		code := raw[:6] + "-" + raw[6:]
+		codes = append(codes, code)
+		hashes = append(hashes, hashToken(normalizeRecoveryCode(code)))
+	}
+	return codes, hashes, nil
+}
+
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+}
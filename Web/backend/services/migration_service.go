@@ -0,0 +1,259 @@
+package services
+
+import (
+	"context"
+	"time"
+
+	"backend/config"
+	"backend/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// MigrationService brings documents written by older versions up to date.
+// Every migration is idempotent and runs on each start.
+type MigrationService struct {
+	Users         *mongo.Collection
+	Platform      *mongo.Collection
+	Organizations *mongo.Collection
+	AlertTypes    *mongo.Collection
+	Alerts        *mongo.Collection
+
+	// Scoped are the collections whose documents carry organizationId.
+	Scoped []*mongo.Collection
+}
+
+func NewMigrationService() *MigrationService {
+	s := &MigrationService{
+		Users:         config.GetCollection("users"),
+		Platform:      config.GetCollection("platform"),
+		Organizations: config.GetCollection("organizations"),
+		AlertTypes:    config.GetCollection("alert_types"),
+		Alerts:        config.GetCollection("alerts"),
+	}
+	for _, name := range []string{"buildings", "floors", "cameras", "zones", "alerts"} {
+		s.Scoped = append(s.Scoped, config.GetCollection(name))
+	}
+	return s
+}
+
+// Run applies the migrations in order.
+func (s *MigrationService) Run() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	for _, migrate := range []func(context.Context) error{
+		s.backfillOrganization,
+		s.migrateMemberships,
+		s.claimPlatformOperator,
+		s.dedupeAlertTypes,
+		s.backfillAlertLabels,
+	} {
+		if err := migrate(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// defaultOrganizationMark is the _id of the document in the platform
+// collection that names the organization legacy data was moved into.
+const defaultOrganizationMark = "default_organization"
+
+// backfillOrganization moves data created before organizations into a
+// default organization, and makes the users of that time its members.
+func (s *MigrationService) backfillOrganization(ctx context.Context) error {
+	missing := bson.M{"organizationId": bson.M{"$exists": false}}
+	legacyUsers := bson.M{"memberships": bson.M{"$exists": false}, "organizationIds": bson.M{"$exists": false}}
+
+	pending := false
+	for _, coll := range append([]*mongo.Collection{s.Users}, s.Scoped...) {
+		filter := missing
+		if coll == s.Users {
+			filter = legacyUsers
+		}
+		err := coll.FindOne(ctx, filter).Err()
+		if err == nil {
+			pending = true
+			break
+		}
+		if err != mongo.ErrNoDocuments {
+			return err
+		}
+	}
+	if !pending {
+		return nil
+	}
+
+	orgID, err := s.defaultOrganization(ctx)
+	if err != nil {
+		return err
+	}
+	for _, coll := range s.Scoped {
+		if _, err := coll.UpdateMany(ctx, missing, bson.M{"$set": bson.M{"organizationId": orgID}}); err != nil {
+			return err
+		}
+	}
+	_, err = s.Users.UpdateMany(ctx, legacyUsers, bson.M{"$set": bson.M{"organizationIds": []primitive.ObjectID{orgID}}})
+	return err
+}
+
+// defaultOrganization returns the organization legacy data belongs to,
+// creating it on first use. Instances starting at the same time agree on one
+// organization through the fixed-id mark.
+func (s *MigrationService) defaultOrganization(ctx context.Context) (primitive.ObjectID, error) {
+	mark := struct {
+		OrganizationID primitive.ObjectID `bson:"organizationId"`
+	}{OrganizationID: primitive.NewObjectID()}
+
+	_, err := s.Platform.InsertOne(ctx, bson.M{"_id": defaultOrganizationMark, "organizationId": mark.OrganizationID})
+	if mongo.IsDuplicateKeyError(err) {
+		err = s.Platform.FindOne(ctx, bson.M{"_id": defaultOrganizationMark}).Decode(&mark)
+	}
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+
+	_, err = s.Organizations.UpdateOne(ctx,
+		bson.M{"_id": mark.OrganizationID},
+		bson.M{"$setOnInsert": bson.M{"name": "Организация по умолчанию", "createdAt": time.Now()}},
+		options.Update().SetUpsert(true),
+	)
+	return mark.OrganizationID, err
+}
+
+// legacyUser is a user document from before memberships, when the role,
+// blocking and grants applied to every organization of the user.
+type legacyUser struct {
+	ID              primitive.ObjectID   `bson:"_id"`
+	Role            models.Role          `bson:"role"`
+	Disabled        bool                 `bson:"disabled"`
+	OrganizationIDs []primitive.ObjectID `bson:"organizationIds"`
+	BuildingIDs     []primitive.ObjectID `bson:"buildingIds"`
+	FloorIDs        []primitive.ObjectID `bson:"floorIds"`
+}
+
+// legacyRoles maps roles that are no longer defined. Before the permission
+// matrix every registered user had the role "user" and could work with
+// cameras and alerts.
+var legacyRoles = map[models.Role]models.Role{
+	"user": models.RoleOperator,
+}
+
+// migrateMemberships moves the global role, blocking and grants of legacy
+// users into one membership per organization. Legacy admins outside any
+// organization were the bootstrap administrators and become platform
+// operators.
+func (s *MigrationService) migrateMemberships(ctx context.Context) error {
+	cursor, err := s.Users.Find(ctx, bson.M{"memberships": bson.M{"$exists": false}})
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var legacy legacyUser
+		if err := cursor.Decode(&legacy); err != nil {
+			return err
+		}
+
+		role := legacy.Role
+		if mapped, ok := legacyRoles[role]; ok {
+			role = mapped
+		}
+		if !role.Valid() {
+			role = models.RoleViewer
+		}
+
+		memberships := make([]models.Membership, 0, len(legacy.OrganizationIDs))
+		for _, orgID := range legacy.OrganizationIDs {
+			memberships = append(memberships, models.Membership{
+				OrganizationID: orgID,
+				Role:           role,
+				Disabled:       legacy.Disabled,
+				BuildingIDs:    legacy.BuildingIDs,
+				FloorIDs:       legacy.FloorIDs,
+			})
+		}
+		set := bson.M{"memberships": memberships}
+		if legacy.Role == models.RoleAdmin && len(legacy.OrganizationIDs) == 0 {
+			set["platformOperator"] = true
+		}
+
+		_, err := s.Users.UpdateOne(ctx,
+			bson.M{"_id": legacy.ID, "memberships": bson.M{"$exists": false}},
+			bson.M{
+				"$set":   set,
+				"$unset": bson.M{"role": "", "disabled": "", "organizationIds": "", "buildingIds": "", "floorIds": ""},
+			},
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return cursor.Err()
+}
+
+// claimPlatformOperator takes the first-user mark on installations that
+// already have users, so that the next registration does not become a
+// platform operator.
+func (s *MigrationService) claimPlatformOperator(ctx context.Context) error {
+	count, err := s.Users.CountDocuments(ctx, bson.M{})
+	if err != nil || count == 0 {
+		return err
+	}
+
+	_, err = s.Platform.InsertOne(ctx, bson.M{"_id": platformOperatorMark, "createdAt": time.Now()})
+	if mongo.IsDuplicateKeyError(err) {
+		return nil
+	}
+	return err
+}
+
+// dedupeAlertTypes keeps the newest of the definitions that concurrent saves
+// created for the same key, so that the unique index can be built.
+func (s *MigrationService) dedupeAlertTypes(ctx context.Context) error {
+	cursor, err := s.AlertTypes.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$sort", Value: bson.D{{Key: "_id", Value: -1}}}},
+		{{Key: "$group", Value: bson.D{
+			{Key: "_id", Value: bson.D{{Key: "organizationId", Value: "$organizationId"}, {Key: "key", Value: "$key"}}},
+			{Key: "ids", Value: bson.D{{Key: "$push", Value: "$_id"}}},
+		}}},
+		{{Key: "$match", Value: bson.D{{Key: "ids.1", Value: bson.D{{Key: "$exists", Value: true}}}}}},
+	})
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var group struct {
+			IDs []primitive.ObjectID `bson:"ids"`
+		}
+		if err := cursor.Decode(&group); err != nil {
+			return err
+		}
+		if _, err := s.AlertTypes.DeleteMany(ctx, bson.M{"_id": bson.M{"$in": group.IDs[1:]}}); err != nil {
+			return err
+		}
+	}
+	return cursor.Err()
+}
+
+// backfillAlertLabels marks the alerts resolved with a verdict before
+// training labels were kept, so that FeedbackService.Run labels them. Alerts
+// whose label was stored carry labelPending false and are not marked again.
+func (s *MigrationService) backfillAlertLabels(ctx context.Context) error {
+	_, err := s.Alerts.UpdateMany(ctx,
+		bson.M{
+			"status":       models.AlertStatusResolved,
+			"resolution":   bson.M{"$in": bson.A{models.AlertResolutionConfirmed, models.AlertResolutionFalsePositive}},
+			"labelPending": bson.M{"$exists": false},
+		},
+		bson.M{"$set": bson.M{"labelPending": true}},
+	)
+	return err
+}
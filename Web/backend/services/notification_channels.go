@@ -0,0 +1,180 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"time"
+
+	"backend/config"
+	"backend/models"
+)
+
+// NotificationMessage is the channel-independent content of an alert push.
+type NotificationMessage struct {
+	Subject string
+	Text    string
+	// Snapshot is the alert frame, if one could be fetched.
+	Snapshot            []byte
+	SnapshotContentType string
+}
+
+// NotificationChannel pushes messages to one kind of address.
+type NotificationChannel interface {
+	Send(ctx context.Context, address string, msg NotificationMessage) error
+}
+
+// NewNotificationChannels returns the channels enabled by the configuration.
+func NewNotificationChannels(mailer Mailer) map[string]NotificationChannel {
+	channels := map[string]NotificationChannel{
+		models.ChannelEmail: &EmailChannel{Mailer: mailer},
+	}
+
+	cfg := config.Notifications
+	if cfg == nil {
+		return channels
+	}
+	client := &http.Client{Timeout: 15 * time.Second}
+	if cfg.TelegramBotToken != "" {
+		channels[models.ChannelTelegram] = &TelegramChannel{BaseURL: cfg.TelegramAPIURL, Token: cfg.TelegramBotToken, Client: client}
+	}
+	if cfg.SMSGatewayURL != "" {
+		channels[models.ChannelSMS] = &SMSChannel{URL: cfg.SMSGatewayURL, Token: cfg.SMSGatewayToken, Sender: cfg.SMSSender, Client: client}
+	}
+	return channels
+}
+
+// EmailChannel sends the alert by email with the snapshot attached.
+type EmailChannel struct {
+	Mailer Mailer
+}
+
+func (c *EmailChannel) Send(ctx context.Context, address string, msg NotificationMessage) error {
+	mail := MailMessage{To: []string{address}, Subject: msg.Subject, Body: msg.Text}
+	if len(msg.Snapshot) > 0 {
+		mail.Attachments = []MailAttachment{{
+			Filename:    "snapshot" + snapshotExtension(msg.SnapshotContentType),
+			ContentType: msg.SnapshotContentType,
+			Data:        msg.Snapshot,
+		}}
+	}
+	return c.Mailer.Send(ctx, mail)
+}
+
+// TelegramChannel sends through the Telegram Bot API. The address is a chat
+// ID. BaseURL can point to a self-hosted Bot API server.
+type TelegramChannel struct {
+	BaseURL string
+	Token   string
+	Client  *http.Client
+}
+
+func (c *TelegramChannel) Send(ctx context.Context, address string, msg NotificationMessage) error {
+	text := msg.Subject + "\n\n" + msg.Text
+	if len(msg.Snapshot) == 0 {
+		body, _ := json.Marshal(map[string]string{"chat_id": address, "text": text})
+		return c.call(ctx, "sendMessage", "application/json", bytes.NewReader(body))
+	}
+
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	w.WriteField("chat_id", address)
+	// Photo captions are limited to 1024 characters.
+	w.WriteField("caption", truncateRunes(text, 1024))
+	part, err := w.CreateFormFile("photo", "snapshot"+snapshotExtension(msg.SnapshotContentType))
+	if err != nil {
+		return err
+	}
+	part.Write(msg.Snapshot)
+	w.Close()
+
+	return c.call(ctx, "sendPhoto", w.FormDataContentType(), &body)
+}
+
+func (c *TelegramChannel) call(ctx context.Context, method, contentType string, body io.Reader) error {
+	url := strings.TrimRight(c.BaseURL, "/") + "/bot" + c.Token + "/" + method
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		// The URL contains the bot token; do not leak it into delivery logs.
+		return fmt.Errorf("ошибка запроса к Telegram API: %s", method)
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		OK          bool   `json:"ok"`
+		Description string `json:"description"`
+	}
+	json.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&result)
+	if resp.StatusCode != http.StatusOK || !result.OK {
+		return fmt.Errorf("Telegram API: %d %s", resp.StatusCode, result.Description)
+	}
+	return nil
+}
+
+// SMSChannel posts to a generic HTTP SMS gateway as JSON
+// {"to", "text", "sender"} with a bearer token. The address is a phone number.
+type SMSChannel struct {
+	URL    string
+	Token  string
+	Sender string
+	Client *http.Client
+}
+
+func (c *SMSChannel) Send(ctx context.Context, address string, msg NotificationMessage) error {
+	body, _ := json.Marshal(map[string]string{
+		"to":     address,
+		"text":   truncateRunes(msg.Subject, 160),
+		"sender": c.Sender,
+	})
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("SMS шлюз вернул статус %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func snapshotExtension(contentType string) string {
+	switch contentType {
+	case "image/png":
+		return ".png"
+	case "image/webp":
+		return ".webp"
+	default:
+		return ".jpg"
+	}
+}
+
+func truncateRunes(s string, n int) string {
+	runes := []rune(s)
+	if len(runes) <= n {
+		return s
+	}
+	return string(runes[:n-1]) + "…"
+}
@@ -0,0 +1,431 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"backend/config"
+	"backend/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	maxSnapshotSize = 5 << 20
+
+	// notifyConcurrency bounds the recipients of one alert served at once.
+	notifyConcurrency = 8
+)
+
+// NotificationService pushes alerts to users over their subscribed channels
+// and records the outcome of every push.
+type NotificationService struct {
+	Preferences   *mongo.Collection
+	Notifications *mongo.Collection
+	Users         *mongo.Collection
+	Alerts        *mongo.Collection
+	Scopes        *ScopeService
+	Types         *AlertTypeService
+	Channels      map[string]NotificationChannel
+	Client        *http.Client
+	Now           func() time.Time
+
+	// DeliveryTimeout bounds each push to one channel of one user.
+	DeliveryTimeout time.Duration
+}
+
+func NewNotificationService() *NotificationService {
+	return &NotificationService{
+		Preferences:   config.GetCollection("notification_preferences"),
+		Notifications: config.GetCollection("notifications"),
+		Users:         config.GetCollection("users"),
+		Alerts:        config.GetCollection("alerts"),
+		Scopes:        NewScopeService(),
+		Types:         NewAlertTypeService(),
+		Channels:      NewNotificationChannels(NewMailer()),
+		Client:        NewPublicHTTPClient(10 * time.Second),
+		Now:           time.Now,
+
+		DeliveryTimeout: 30 * time.Second,
+	}
+}
+
+// NotifyAlert pushes the alert to every member of its organization whose
+// preferences match, as the notification policy of the alert's type allows,
+// and who may see the alert's location. Alerts that joined an open incident
+// were already notified through its first alert. Recipients are served in
+// parallel, each with their own timeout, so that one slow channel does not
+// delay or cancel the others. It is registered as an alert hook.
+func (s *NotificationService) NotifyAlert(alert *models.Alert) {
+	if alert.Correlated {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	// Types no longer in the catalog, or disabled there, are not pushed.
+	alertType := &models.AlertTypeDefinition{Key: alert.AlertType}
+	if s.Types != nil {
+		definition, err := s.Types.Resolve(alert.OrganizationID, alert.AlertType, "")
+		if err != nil {
+			if !errors.Is(err, ErrUnknownAlertType) {
+				log.Printf("Ошибка загрузки типа тревоги: %v", err)
+			}
+			return
+		}
+		alertType = definition
+	}
+	if alertType.NotificationPolicy == models.NotifyNone {
+		return
+	}
+
+	cursor, err := s.Preferences.Find(ctx, bson.M{"organizationId": alert.OrganizationID})
+	if err != nil {
+		log.Printf("Ошибка загрузки настроек уведомлений: %v", err)
+		return
+	}
+	var preferences []models.NotificationPreferences
+	if err := cursor.All(ctx, &preferences); err != nil {
+		log.Printf("Ошибка загрузки настроек уведомлений: %v", err)
+		return
+	}
+
+	var recipients []*models.NotificationPreferences
+	for i := range preferences {
+		prefs := &preferences[i]
+		if prefs.Matches(alert) && alertType.Notifies(prefs) && s.canSee(prefs.UserID, alert) {
+			recipients = append(recipients, prefs)
+		}
+	}
+	if len(recipients) == 0 {
+		return
+	}
+
+	msg := s.message(ctx, alert)
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, notifyConcurrency)
+	for _, prefs := range recipients {
+		wg.Add(1)
+		slots <- struct{}{}
+		go func() {
+			defer func() {
+				<-slots
+				wg.Done()
+			}()
+			s.deliver(alert, prefs, msg, false)
+		}()
+	}
+	wg.Wait()
+}
+
+// NotifyUser pushes the alert to one user regardless of their filters and
+// quiet hours, e.g. as an escalation step. Like every push it requires that
+// the user may see the alert.
+func (s *NotificationService) NotifyUser(alert *models.Alert, userID primitive.ObjectID) error {
+	if !s.canSee(userID, alert) {
+		return errors.New("пользователь не может просматривать тревогу")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	prefs, err := s.preferences(ctx, userID, alert.OrganizationID)
+	if err != nil {
+		return err
+	}
+	if len(prefs.Channels) == 0 {
+		return errors.New("у пользователя не настроены каналы уведомлений")
+	}
+
+	s.deliver(alert, prefs, s.message(ctx, alert), true)
+	return nil
+}
+
+// NotifyMention pushes a comment to a member it mentions who may see the
+// alert. Quiet hours apply as to any non-critical alert.
+func (s *NotificationService) NotifyMention(alert *models.Alert, comment *models.AlertComment, userID primitive.ObjectID) error {
+	if !s.canSee(userID, alert) {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	prefs, err := s.preferences(ctx, userID, alert.OrganizationID)
+	if err != nil {
+		return err
+	}
+
+	msg := s.message(ctx, alert)
+	msg.Subject = fmt.Sprintf("%s упоминает вас: тревога %s", comment.AuthorName, alert.AlertType)
+	msg.Text = comment.Body + "\n\n" + msg.Text
+	s.deliver(alert, prefs, msg, false)
+	return nil
+}
+
+// canSee checks that the user is an active member allowed to read alerts at
+// the alert's location.
+func (s *NotificationService) canSee(userID primitive.ObjectID, alert *models.Alert) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var user models.User
+	err := s.Users.FindOne(ctx, bson.M{"_id": userID, "memberships.organizationId": alert.OrganizationID}).Decode(&user)
+	role := user.RoleIn(alert.OrganizationID)
+	if err != nil || !role.Can(models.PermAlertsRead) {
+		return false
+	}
+	if s.Scopes == nil {
+		return true
+	}
+
+	scope, err := s.Scopes.Resolve(alert.OrganizationID, userID.Hex(), role)
+	return err == nil && scope.AllowsLocation(alert.OrganizationID, alert.BuildingID, alert.FloorID)
+}
+
+// deliver sends to every enabled channel of the user, each push within
+// DeliveryTimeout. Outside of forced deliveries, quiet hours hold back
+// everything but critical alerts, if the user allowed those.
+func (s *NotificationService) deliver(alert *models.Alert, prefs *models.NotificationPreferences, msg *NotificationMessage, force bool) {
+	quiet := !force && prefs.QuietHours != nil && prefs.QuietHours.Contains(s.Now()) &&
+		!(prefs.QuietHours.AllowCritical && alert.Severity == models.SeverityCritical)
+
+	for _, sub := range prefs.Channels {
+		if !sub.Enabled {
+			continue
+		}
+
+		notification := models.Notification{
+			ID:             primitive.NewObjectID(),
+			AlertID:        alert.ID,
+			OrganizationID: alert.OrganizationID,
+			UserID:         prefs.UserID,
+			Channel:        sub.Channel,
+			Address:        sub.Address,
+			Status:         models.NotificationSent,
+			CreatedAt:      s.Now(),
+		}
+
+		channel, ok := s.Channels[sub.Channel]
+		switch {
+		case quiet:
+			notification.Status = models.NotificationSkipped
+			notification.Error = "тихие часы"
+		case !ok:
+			notification.Status = models.NotificationFailed
+			notification.Error = "канал не настроен"
+		default:
+			if err := s.send(channel, sub.Address, msg); err != nil {
+				notification.Status = models.NotificationFailed
+				notification.Error = err.Error()
+			}
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		if _, err := s.Notifications.InsertOne(ctx, notification); err != nil {
+			log.Printf("Ошибка сохранения статуса уведомления: %v", err)
+		}
+		cancel()
+	}
+}
+
+func (s *NotificationService) send(channel NotificationChannel, address string, msg *NotificationMessage) error {
+	timeout := s.DeliveryTimeout
+	if timeout <= 0 {
+		timeout = 30 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	return channel.Send(ctx, address, *msg)
+}
+
+func (s *NotificationService) message(ctx context.Context, alert *models.Alert) *NotificationMessage {
+	severity := alert.Severity
+	if severity == "" {
+		severity = "не указана"
+	}
+
+	var text strings.Builder
+	fmt.Fprintf(&text, "Тип: %s\n", alert.AlertType)
+	fmt.Fprintf(&text, "Важность: %s\n", severity)
+	fmt.Fprintf(&text, "Время: %s\n", alert.StartDateTime.Format("02.01.2006 15:04:05"))
+	if !alert.CameraID.IsZero() {
+		fmt.Fprintf(&text, "Камера: %s\n", alert.CameraID.Hex())
+	}
+	if alert.ZoneName != "" {
+		fmt.Fprintf(&text, "Зона: %s\n", alert.ZoneName)
+	}
+	if alert.Source != "" {
+		fmt.Fprintf(&text, "Источник: %s\n", alert.Source)
+	}
+
+	msg := &NotificationMessage{
+		Subject: fmt.Sprintf("Тревога: %s", alert.AlertType),
+		Text:    text.String(),
+	}
+	if alert.SnapshotURL != "" {
+		msg.Snapshot, msg.SnapshotContentType = fetchSnapshot(ctx, s.Client, alert.SnapshotURL)
+	}
+	return msg
+}
+
+// fetchSnapshot downloads the alert frame. The URL comes from the detector's
+// request, so the client must only reach public addresses (see
+// NewPublicHTTPClient). Failures give no frame: the notification or report
+// goes out without it.
+func fetchSnapshot(ctx context.Context, client *http.Client, url string) ([]byte, string) {
+	if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+		return nil, ""
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, ""
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		log.Printf("Ошибка загрузки снимка тревоги: %v", err)
+		return nil, ""
+	}
+	defer resp.Body.Close()
+
+	contentType := resp.Header.Get("Content-Type")
+	if resp.StatusCode != http.StatusOK || !strings.HasPrefix(contentType, "image/") {
+		return nil, ""
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxSnapshotSize+1))
+	if err != nil || len(data) > maxSnapshotSize {
+		return nil, ""
+	}
+	return data, contentType
+}
+
+// GetPreferences returns the user's preferences in the organization, or empty
+// ones if none were saved.
+func (s *NotificationService) GetPreferences(userID, orgID primitive.ObjectID) (*models.NotificationPreferences, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	return s.preferences(ctx, userID, orgID)
+}
+
+func (s *NotificationService) preferences(ctx context.Context, userID, orgID primitive.ObjectID) (*models.NotificationPreferences, error) {
+	var prefs models.NotificationPreferences
+	err := s.Preferences.FindOne(ctx, bson.M{"userId": userID, "organizationId": orgID}).Decode(&prefs)
+	if err == mongo.ErrNoDocuments {
+		return &models.NotificationPreferences{UserID: userID, OrganizationID: orgID, Channels: []models.ChannelSubscription{}}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &prefs, nil
+}
+
+func (s *NotificationService) UpdatePreferences(userID, orgID primitive.ObjectID, prefs *models.NotificationPreferences) (*models.NotificationPreferences, error) {
+	if err := validatePreferences(prefs); err != nil {
+		return nil, err
+	}
+	if prefs.Channels == nil {
+		prefs.Channels = []models.ChannelSubscription{}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var updated models.NotificationPreferences
+	err := s.Preferences.FindOneAndUpdate(ctx,
+		bson.M{"userId": userID, "organizationId": orgID},
+		bson.M{"$set": bson.M{
+			"channels":    prefs.Channels,
+			"alertTypes":  prefs.AlertTypes,
+			"buildingIds": prefs.BuildingIDs,
+			"minSeverity": prefs.MinSeverity,
+			"quietHours":  prefs.QuietHours,
+		}},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(&updated)
+	if err != nil {
+		return nil, err
+	}
+
+	return &updated, nil
+}
+
+func validatePreferences(prefs *models.NotificationPreferences) error {
+	for _, sub := range prefs.Channels {
+		switch sub.Channel {
+		case models.ChannelEmail, models.ChannelTelegram, models.ChannelSMS:
+		default:
+			return errors.New("неизвестный канал уведомлений: " + sub.Channel)
+		}
+		if strings.TrimSpace(sub.Address) == "" {
+			return errors.New("не указан адрес для канала " + sub.Channel)
+		}
+	}
+	if prefs.MinSeverity != "" && models.SeverityRank(prefs.MinSeverity) == 0 {
+		return errors.New("неизвестная важность: " + prefs.MinSeverity)
+	}
+	if q := prefs.QuietHours; q != nil {
+		_, errStart := time.Parse("15:04", q.Start)
+		_, errEnd := time.Parse("15:04", q.End)
+		if errStart != nil || errEnd != nil {
+			return errors.New("тихие часы задаются в формате ЧЧ:ММ")
+		}
+		if q.Timezone != "" {
+			if _, err := time.LoadLocation(q.Timezone); err != nil {
+				return errors.New("неизвестный часовой пояс: " + q.Timezone)
+			}
+		}
+	}
+	return nil
+}
+
+// GetAlertNotifications returns the delivery status of the pushes of an alert
+// at a location the scope can access. The recipients' addresses are only
+// included with withAddresses.
+func (s *NotificationService) GetAlertNotifications(scope AccessScope, alertID string, withAddresses bool) ([]models.Notification, error) {
+	objID, err := primitive.ObjectIDFromHex(alertID)
+	if err != nil {
+		return nil, errors.New("некорректный ID тревоги")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := s.Alerts.FindOne(ctx, scope.LocationFilter(bson.M{"_id": objID})).Err(); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, errors.New("тревога не найдена")
+		}
+		return nil, err
+	}
+
+	cursor, err := s.Notifications.Find(ctx, scope.Filter(bson.M{"alertId": objID}), options.Find().SetSort(bson.D{{Key: "createdAt", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	notifications := []models.Notification{}
+	if err := cursor.All(ctx, &notifications); err != nil {
+		return nil, err
+	}
+	if !withAddresses {
+		for i := range notifications {
+			notifications[i].Address = ""
+		}
+	}
+
+	return notifications, nil
+}
@@ -0,0 +1,265 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"sync"
+	"time"
+
+	"backend/config"
+	"backend/models"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang.org/x/oauth2"
+)
+
+const oidcStateTTL = 10 * time.Minute
+
+var ErrOIDCDisabled = errors.New("вход через SSO не настроен")
+
+// OIDCService implements the authorization code flow with PKCE and provisions
+// local users for identities coming from the provider.
+type OIDCService struct {
+	Users  *mongo.Collection
+	States *mongo.Collection
+	Config *config.OIDCConfig
+
+	mutex    sync.Mutex
+	oauth2   *oauth2.Config
+	verifier *oidc.IDTokenVerifier
+}
+
+func NewOIDCService(cfg *config.OIDCConfig) *OIDCService {
+	return &OIDCService{
+		Users:  config.GetCollection("users"),
+		States: config.GetCollection("oidc_states"),
+		Config: cfg,
+	}
+}
+
+// provider runs discovery on first use so that an unreachable identity
+// provider does not prevent the server from starting.
+func (s *OIDCService) provider(ctx context.Context) (*oauth2.Config, *oidc.IDTokenVerifier, error) {
+	if s.Config == nil {
+		return nil, nil, ErrOIDCDisabled
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.oauth2 != nil {
+		return s.oauth2, s.verifier, nil
+	}
+
+	provider, err := oidc.NewProvider(ctx, s.Config.IssuerURL)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	s.oauth2 = &oauth2.Config{
+		ClientID:     s.Config.ClientID,
+		ClientSecret: s.Config.ClientSecret,
+		RedirectURL:  s.Config.RedirectURL,
+		Endpoint:     provider.Endpoint(),
+		Scopes:       s.Config.Scopes,
+	}
+	s.verifier = provider.Verifier(&oidc.Config{ClientID: s.Config.ClientID})
+
+	return s.oauth2, s.verifier, nil
+}
+
+// AuthURL starts a login and returns the provider URL to redirect the browser to.
+func (s *OIDCService) AuthURL() (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	oauth2Config, _, err := s.provider(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	state := models.OIDCState{
+		State:        randomString(),
+		Nonce:        randomString(),
+		CodeVerifier: oauth2.GenerateVerifier(),
+		ExpiresAt:    time.Now().Add(oidcStateTTL),
+	}
+	if _, err := s.States.InsertOne(ctx, state); err != nil {
+		return "", err
+	}
+
+	return oauth2Config.AuthCodeURL(state.State,
+		oidc.Nonce(state.Nonce),
+		oauth2.S256ChallengeOption(state.CodeVerifier),
+	), nil
+}
+
+type oidcClaims struct {
+	Issuer            string   `json:"iss"`
+	Subject           string   `json:"sub"`
+	Email             string   `json:"email"`
+	PreferredUsername string   `json:"preferred_username"`
+	Nonce             string   `json:"nonce"`
+	Groups            []string `json:"-"`
+}
+
+// Callback completes the login: it exchanges the code, verifies the ID token
+// and returns the local user, creating it on first login.
+func (s *OIDCService) Callback(stateID, code string) (*models.User, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	oauth2Config, verifier, err := s.provider(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var state models.OIDCState
+	err = s.States.FindOneAndDelete(ctx, bson.M{"_id": stateID}).Decode(&state)
+	if err != nil || time.Now().After(state.ExpiresAt) {
+		return nil, errors.New("недействительный или просроченный параметр state")
+	}
+
+	token, err := oauth2Config.Exchange(ctx, code, oauth2.VerifierOption(state.CodeVerifier))
+	if err != nil {
+		return nil, errors.New("ошибка обмена кода авторизации")
+	}
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		return nil, errors.New("провайдер не вернул id_token")
+	}
+
+	idToken, err := verifier.Verify(ctx, rawIDToken)
+	if err != nil {
+		return nil, errors.New("недействительный id_token")
+	}
+
+	var claims oidcClaims
+	var allClaims map[string]interface{}
+	if err := idToken.Claims(&claims); err != nil {
+		return nil, err
+	}
+	if err := idToken.Claims(&allClaims); err != nil {
+		return nil, err
+	}
+	if claims.Nonce != state.Nonce {
+		return nil, errors.New("недействительный nonce")
+	}
+	claims.Groups = stringList(allClaims[s.Config.GroupsClaim])
+
+	return s.provisionUser(ctx, &claims)
+}
+
+// provisionUser finds or creates the user of the SSO identity. The role
+// mapped from the groups applies to the membership in the configured
+// organization only.
+func (s *OIDCService) provisionUser(ctx context.Context, claims *oidcClaims) (*models.User, error) {
+	role := MapGroupsToRole(claims.Groups, s.Config.GroupRoles, models.Role(s.Config.DefaultRole))
+	orgID, orgErr := primitive.ObjectIDFromHex(s.Config.OrganizationID)
+
+	var user models.User
+	err := s.Users.FindOneAndUpdate(ctx,
+		bson.M{"oidcIssuer": claims.Issuer, "oidcSubject": claims.Subject},
+		bson.M{"$set": bson.M{"email": claims.Email}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&user)
+	if err == nil {
+		if membership := user.Membership(orgID); orgErr == nil && membership != nil && membership.Role != role {
+			_, err = s.Users.UpdateOne(ctx,
+				bson.M{"_id": user.ID, "memberships.organizationId": orgID},
+				bson.M{"$set": bson.M{"memberships.$.role": role}},
+			)
+			if err != nil {
+				return nil, err
+			}
+			membership.Role = role
+		}
+		return &user, nil
+	}
+	if err != mongo.ErrNoDocuments {
+		return nil, err
+	}
+
+	username := claims.PreferredUsername
+	if username == "" {
+		username = claims.Email
+	}
+	if username == "" {
+		username = claims.Subject
+	}
+
+	// Локальная учетная запись с тем же именем не связывается с SSO автоматически
+	count, err := s.Users.CountDocuments(ctx, bson.M{"username": username})
+	if err != nil {
+		return nil, err
+	}
+	if count > 0 {
+		return nil, errors.New("имя пользователя уже занято локальной учетной записью")
+	}
+
+	user = models.User{
+		ID:          primitive.NewObjectID(),
+		Username:    username,
+		Email:       claims.Email,
+		Memberships: []models.Membership{},
+		OIDCIssuer:  claims.Issuer,
+		OIDCSubject: claims.Subject,
+	}
+	if orgErr == nil {
+		user.Memberships = append(user.Memberships, models.Membership{OrganizationID: orgID, Role: role})
+	}
+
+	if _, err := s.Users.InsertOne(ctx, user); err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+var roleRank = map[models.Role]int{
+	models.RoleViewer:     1,
+	models.RoleOperator:   2,
+	models.RoleSupervisor: 3,
+	models.RoleAdmin:      4,
+}
+
+// MapGroupsToRole returns the most privileged role mapped from the user's
+// groups, or the default role when none of the groups is mapped.
+func MapGroupsToRole(groups []string, mapping map[string]string, defaultRole models.Role) models.Role {
+	role := defaultRole
+	for _, group := range groups {
+		mapped := models.Role(mapping[group])
+		if mapped.Valid() && roleRank[mapped] > roleRank[role] {
+			role = mapped
+		}
+	}
+	return role
+}
+
+func stringList(value interface{}) []string {
+	switch v := value.(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		result := make([]string, 0, len(v))
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				result = append(result, s)
+			}
+		}
+		return result
+	}
+	return nil
+}
+
+func randomString() string {
+	b := make([]byte, 24)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
@@ -0,0 +1,134 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"backend/config"
+	"backend/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type OrganizationService struct {
+	Collection *mongo.Collection
+	Users      *mongo.Collection
+}
+
+func NewOrganizationService() *OrganizationService {
+	return &OrganizationService{
+		Collection: config.GetCollection("organizations"),
+		Users:      config.GetCollection("users"),
+	}
+}
+
+func (s *OrganizationService) GetOrganizations(ids []primitive.ObjectID) ([]models.Organization, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	organizations := []models.Organization{}
+	if len(ids) == 0 {
+		return organizations, nil
+	}
+
+	cursor, err := s.Collection.Find(ctx, bson.M{"_id": bson.M{"$in": ids}})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	if err := cursor.All(ctx, &organizations); err != nil {
+		return nil, err
+	}
+
+	return organizations, nil
+}
+
+// CreateOrganization stores the organization and makes the creator its first
+// admin. The organization is removed again when the creator cannot be added,
+// e.g. when it is created with an API key.
+func (s *OrganizationService) CreateOrganization(org *models.Organization, ownerID string) (*models.Organization, error) {
+	org.ID = primitive.NewObjectID()
+	org.CreatedAt = time.Now()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err := s.Collection.InsertOne(ctx, org)
+	if err != nil {
+		return nil, err
+	}
+
+	// Без владельца организацией никто не сможет управлять, поэтому она удаляется
+	if err := s.AddMember(org.ID.Hex(), ownerID, models.RoleAdmin); err != nil {
+		if _, rollbackErr := s.Collection.DeleteOne(ctx, bson.M{"_id": org.ID}); rollbackErr != nil {
+			return nil, errors.Join(err, rollbackErr)
+		}
+		return nil, err
+	}
+
+	return org, nil
+}
+
+// AddMember makes the user a member of the organization with the role.
+// Existing memberships are left unchanged.
+func (s *OrganizationService) AddMember(orgID, userID string, role models.Role) error {
+	orgObjID, userObjID, err := parseMembershipIDs(orgID, userID)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	result, err := s.Users.UpdateOne(ctx,
+		bson.M{"_id": userObjID, "memberships.organizationId": bson.M{"$ne": orgObjID}},
+		bson.M{"$push": bson.M{"memberships": models.Membership{OrganizationID: orgObjID, Role: role}}},
+	)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return errors.New("пользователь не найден или уже состоит в организации")
+	}
+
+	return nil
+}
+
+func (s *OrganizationService) RemoveMember(orgID, userID string) error {
+	orgObjID, userObjID, err := parseMembershipIDs(orgID, userID)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	result, err := s.Users.UpdateOne(ctx,
+		bson.M{"_id": userObjID, "memberships.organizationId": orgObjID},
+		bson.M{"$pull": bson.M{"memberships": bson.M{"organizationId": orgObjID}}},
+	)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return errors.New("пользователь не найден")
+	}
+
+	return nil
+}
+
+func parseMembershipIDs(orgID, userID string) (primitive.ObjectID, primitive.ObjectID, error) {
+	orgObjID, err := primitive.ObjectIDFromHex(orgID)
+	if err != nil {
+		return orgObjID, primitive.NilObjectID, errors.New("некорректный ID организации")
+	}
+	userObjID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return orgObjID, userObjID, errors.New("некорректный ID пользователя")
+	}
+	return orgObjID, userObjID, nil
+}
@@ -0,0 +1,549 @@
+package services
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// A4 in points.
+const (
+	pdfPageWidth  = 595.28
+	pdfPageHeight = 841.89
+	pdfMargin     = 50.0
+
+	pdfMaxImageHeight = 320.0
+)
+
+// pdfFont is the TrueType font embedded whole into reports. The standard
+// PDF fonts have no Cyrillic, so reports always embed one. It is read-only
+// after loading and shared by concurrent reports.
+type pdfFont struct {
+	data       []byte
+	unitsPerEm int
+	ascent     int
+	descent    int
+	bbox       [4]int
+	glyphs     map[rune]uint16
+	advances   []uint16
+}
+
+// loadPDFFont reads a TrueType font file. Errors wrap ErrReportFont.
+func loadPDFFont(path string) (*pdfFont, error) {
+	if path == "" {
+		return nil, ErrReportFont
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrReportFont, err)
+	}
+	font, err := parseTrueType(data)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %v", ErrReportFont, path, err)
+	}
+	return font, nil
+}
+
+// parseTrueType reads the metrics and the character map of a TrueType font.
+// Fonts with CFF outlines and font collections are not supported.
+func parseTrueType(data []byte) (*pdfFont, error) {
+	invalid := errors.New("некорректный или неподдерживаемый файл шрифта TrueType")
+	if len(data) < 12 {
+		return nil, invalid
+	}
+	if version := binary.BigEndian.Uint32(data); version != 0x00010000 && version != 0x74727565 {
+		return nil, invalid
+	}
+
+	tables := map[string][]byte{}
+	numTables := int(binary.BigEndian.Uint16(data[4:]))
+	for i := 0; i < numTables; i++ {
+		record := 12 + 16*i
+		if record+16 > len(data) {
+			return nil, invalid
+		}
+		offset := int(binary.BigEndian.Uint32(data[record+8:]))
+		length := int(binary.BigEndian.Uint32(data[record+12:]))
+		if offset < 0 || length < 0 || offset+length > len(data) {
+			return nil, invalid
+		}
+		tables[string(data[record:record+4])] = data[offset : offset+length]
+	}
+
+	head, hhea, hmtx, cmap := tables["head"], tables["hhea"], tables["hmtx"], tables["cmap"]
+	if len(head) < 54 || len(hhea) < 36 || cmap == nil || tables["glyf"] == nil {
+		return nil, invalid
+	}
+
+	font := &pdfFont{
+		data:       data,
+		unitsPerEm: int(binary.BigEndian.Uint16(head[18:])),
+		ascent:     int(int16(binary.BigEndian.Uint16(hhea[4:]))),
+		descent:    int(int16(binary.BigEndian.Uint16(hhea[6:]))),
+	}
+	if font.unitsPerEm == 0 {
+		return nil, invalid
+	}
+	for i := range font.bbox {
+		font.bbox[i] = int(int16(binary.BigEndian.Uint16(head[36+2*i:])))
+	}
+
+	numberOfHMetrics := int(binary.BigEndian.Uint16(hhea[34:]))
+	if numberOfHMetrics == 0 || len(hmtx) < 4*numberOfHMetrics {
+		return nil, invalid
+	}
+	font.advances = make([]uint16, numberOfHMetrics)
+	for i := range font.advances {
+		font.advances[i] = binary.BigEndian.Uint16(hmtx[4*i:])
+	}
+
+	glyphs, err := parseCmap(cmap)
+	if err != nil {
+		return nil, invalid
+	}
+	font.glyphs = glyphs
+	return font, nil
+}
+
+// parseCmap reads the Unicode subtable of a cmap table, preferring the full
+// repertoire (format 12) over the basic plane (format 4).
+func parseCmap(cmap []byte) (map[rune]uint16, error) {
+	errCmap := errors.New("cmap")
+	if len(cmap) < 4 {
+		return nil, errCmap
+	}
+
+	var format4, format12 []byte
+	numTables := int(binary.BigEndian.Uint16(cmap[2:]))
+	for i := 0; i < numTables; i++ {
+		record := 4 + 8*i
+		if record+8 > len(cmap) {
+			return nil, errCmap
+		}
+		platform := binary.BigEndian.Uint16(cmap[record:])
+		encoding := binary.BigEndian.Uint16(cmap[record+2:])
+		offset := int(binary.BigEndian.Uint32(cmap[record+4:]))
+		if offset+4 > len(cmap) || (platform != 0 && !(platform == 3 && (encoding == 1 || encoding == 10))) {
+			continue
+		}
+		switch binary.BigEndian.Uint16(cmap[offset:]) {
+		case 4:
+			format4 = cmap[offset:]
+		case 12:
+			format12 = cmap[offset:]
+		}
+	}
+
+	glyphs := map[rune]uint16{}
+	switch {
+	case len(format12) >= 16:
+		groups := int(binary.BigEndian.Uint32(format12[12:]))
+		if 16+12*groups > len(format12) {
+			return nil, errCmap
+		}
+		for i := 0; i < groups; i++ {
+			group := format12[16+12*i:]
+			start := binary.BigEndian.Uint32(group)
+			end := binary.BigEndian.Uint32(group[4:])
+			glyph := binary.BigEndian.Uint32(group[8:])
+			for c := start; c <= end && c <= utf8.MaxRune; c++ {
+				glyphs[rune(c)] = uint16(glyph + c - start)
+			}
+		}
+	case len(format4) >= 14:
+		segments := int(binary.BigEndian.Uint16(format4[6:])) / 2
+		if 16+8*segments > len(format4) {
+			return nil, errCmap
+		}
+		ends := 14
+		starts := ends + 2*segments + 2
+		deltas := starts + 2*segments
+		rangeOffsets := deltas + 2*segments
+		for i := 0; i < segments; i++ {
+			end := int(binary.BigEndian.Uint16(format4[ends+2*i:]))
+			start := int(binary.BigEndian.Uint16(format4[starts+2*i:]))
+			delta := int(binary.BigEndian.Uint16(format4[deltas+2*i:]))
+			rangeOffset := int(binary.BigEndian.Uint16(format4[rangeOffsets+2*i:]))
+			for c := start; c <= end && c != 0xFFFF; c++ {
+				glyph := (c + delta) & 0xFFFF
+				if rangeOffset != 0 {
+					at := rangeOffsets + 2*i + rangeOffset + 2*(c-start)
+					if at+2 > len(format4) {
+						continue
+					}
+					glyph = int(binary.BigEndian.Uint16(format4[at:]))
+					if glyph != 0 {
+						glyph = (glyph + delta) & 0xFFFF
+					}
+				}
+				if glyph != 0 {
+					glyphs[rune(c)] = uint16(glyph)
+				}
+			}
+		}
+	default:
+		return nil, errCmap
+	}
+	return glyphs, nil
+}
+
+// glyphWidth returns the advance of a glyph in thousandths of the font size.
+func (f *pdfFont) glyphWidth(glyph uint16) int {
+	advance := f.advances[len(f.advances)-1]
+	if int(glyph) < len(f.advances) {
+		advance = f.advances[glyph]
+	}
+	return int(advance) * 1000 / f.unitsPerEm
+}
+
+func (f *pdfFont) runeWidth(r rune) int {
+	return f.glyphWidth(f.glyphs[r])
+}
+
+func (f *pdfFont) textWidth(text string, size float64) float64 {
+	width := 0
+	for _, r := range text {
+		width += f.runeWidth(r)
+	}
+	return float64(width) * size / 1000
+}
+
+// encode converts text to the string bytes of a text operator, the glyph
+// ids of the font. Glyphs used are recorded for the font's width and
+// ToUnicode tables.
+func (f *pdfFont) encode(text string, used map[uint16]rune) []byte {
+	var out []byte
+	for _, r := range text {
+		glyph := f.glyphs[r]
+		if _, ok := used[glyph]; !ok {
+			used[glyph] = r
+		}
+		out = append(out, byte(glyph>>8), byte(glyph))
+	}
+	return out
+}
+
+type pdfImage struct {
+	data       []byte
+	width      int
+	height     int
+	colorSpace string
+}
+
+// pdfDocument lays out a report top to bottom over A4 pages: headings, text
+// wrapped to the page width and images scaled to fit.
+type pdfDocument struct {
+	font   *pdfFont
+	used   map[uint16]rune
+	pages  []*bytes.Buffer
+	page   *bytes.Buffer
+	y      float64
+	images []pdfImage
+}
+
+func newPDFDocument(font *pdfFont) *pdfDocument {
+	d := &pdfDocument{font: font, used: map[uint16]rune{}}
+	d.newPage()
+	return d
+}
+
+func (d *pdfDocument) newPage() {
+	d.page = &bytes.Buffer{}
+	d.pages = append(d.pages, d.page)
+	d.y = pdfPageHeight - pdfMargin
+}
+
+// reserve starts a new page unless height fits above the bottom margin.
+func (d *pdfDocument) reserve(height float64) {
+	if d.y-height < pdfMargin {
+		d.newPage()
+	}
+}
+
+func (d *pdfDocument) Space(height float64) {
+	d.y -= height
+}
+
+func (d *pdfDocument) Heading(text string) {
+	d.Space(4)
+	d.text(text, 16, 0)
+	d.Space(6)
+}
+
+func (d *pdfDocument) Subheading(text string) {
+	d.Space(10)
+	d.text(text, 12, 0)
+	d.Space(2)
+}
+
+func (d *pdfDocument) Paragraph(text string) {
+	d.text(text, 10, 0)
+}
+
+// Field prints "label: value" with the value wrapped under itself.
+func (d *pdfDocument) Field(label, value string) {
+	if value == "" {
+		return
+	}
+	const size = 10
+	label += ": "
+	indent := d.font.textWidth(label, size)
+	lines := d.wrap(value, pdfPageWidth-2*pdfMargin-indent, size)
+	for i, line := range lines {
+		d.reserve(size * 1.4)
+		d.y -= size * 1.4
+		if i == 0 {
+			d.show(label, size, pdfMargin)
+		}
+		d.show(line, size, pdfMargin+indent)
+	}
+}
+
+func (d *pdfDocument) text(text string, size, indent float64) {
+	for _, line := range d.wrap(text, pdfPageWidth-2*pdfMargin-indent, size) {
+		d.reserve(size * 1.4)
+		d.y -= size * 1.4
+		d.show(line, size, pdfMargin+indent)
+	}
+}
+
+func (d *pdfDocument) show(text string, size, x float64) {
+	fmt.Fprintf(d.page, "BT /F1 %.1f Tf %.2f %.2f Td <%X> Tj ET\n", size, x, d.y+size*0.3, d.font.encode(text, d.used))
+}
+
+// wrap breaks text into lines no wider than width, at spaces where possible.
+func (d *pdfDocument) wrap(text string, width, size float64) []string {
+	var lines []string
+	for _, paragraph := range strings.Split(text, "\n") {
+		line := ""
+		for _, word := range strings.Fields(paragraph) {
+			candidate := word
+			if line != "" {
+				candidate = line + " " + word
+			}
+			if d.font.textWidth(candidate, size) <= width {
+				line = candidate
+				continue
+			}
+			if line != "" {
+				lines = append(lines, line)
+			}
+			// Words longer than a line are split anywhere.
+			line = ""
+			for _, r := range word {
+				if line != "" && d.font.textWidth(line+string(r), size) > width {
+					lines = append(lines, line)
+					line = ""
+				}
+				line += string(r)
+			}
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// Image places a JPEG, PNG or GIF picture scaled to the page width. Other
+// formats return an error and leave the page unchanged.
+func (d *pdfDocument) Image(data []byte) error {
+	config, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+
+	img := pdfImage{data: data, width: config.Width, height: config.Height, colorSpace: "DeviceRGB"}
+	switch {
+	case format == "jpeg" && config.ColorModel == color.GrayModel:
+		img.colorSpace = "DeviceGray"
+	case format == "jpeg" && config.ColorModel != color.CMYKModel:
+	default:
+		// PDF embeds JPEG as is; everything else is converted to it.
+		decoded, _, err := image.Decode(bytes.NewReader(data))
+		if err != nil {
+			return err
+		}
+		var converted bytes.Buffer
+		if err := jpeg.Encode(&converted, decoded, &jpeg.Options{Quality: 85}); err != nil {
+			return err
+		}
+		img.data = converted.Bytes()
+	}
+	if img.width == 0 || img.height == 0 {
+		return errors.New("пустое изображение")
+	}
+
+	width := pdfPageWidth - 2*pdfMargin
+	height := width * float64(img.height) / float64(img.width)
+	if height > pdfMaxImageHeight {
+		width, height = width*pdfMaxImageHeight/height, pdfMaxImageHeight
+	}
+	d.reserve(height + 6)
+	d.y -= height + 6
+
+	d.images = append(d.images, img)
+	fmt.Fprintf(d.page, "q %.2f 0 0 %.2f %.2f %.2f cm /Im%d Do Q\n", width, height, pdfMargin, d.y, len(d.images))
+	return nil
+}
+
+// WriteTo serializes the document.
+func (d *pdfDocument) WriteTo(w io.Writer) (int64, error) {
+	pw := &pdfWriter{w: w}
+
+	// Object numbers: catalog, page tree, font objects, images, then a page
+	// and its content stream for every page.
+	const catalog, pages, font = 1, 2, 3
+	const fontObjects = 5
+	firstImage := font + fontObjects
+	firstPage := firstImage + len(d.images)
+
+	pw.printf("%%PDF-1.4\n%%\xe2\xe3\xcf\xd3\n")
+	pw.object(catalog, fmt.Sprintf("<< /Type /Catalog /Pages %d 0 R >>", pages))
+
+	kids := make([]string, len(d.pages))
+	for i := range d.pages {
+		kids[i] = fmt.Sprintf("%d 0 R", firstPage+2*i)
+	}
+	pw.object(pages, fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(d.pages)))
+
+	d.writeEmbeddedFont(pw, font)
+
+	for i, img := range d.images {
+		pw.stream(firstImage+i, fmt.Sprintf("/Type /XObject /Subtype /Image /Width %d /Height %d /ColorSpace /%s /BitsPerComponent 8 /Filter /DCTDecode",
+			img.width, img.height, img.colorSpace), img.data)
+	}
+
+	var xobjects strings.Builder
+	for i := range d.images {
+		fmt.Fprintf(&xobjects, " /Im%d %d 0 R", i+1, firstImage+i)
+	}
+	for i, content := range d.pages {
+		pageID := firstPage + 2*i
+		pw.object(pageID, fmt.Sprintf("<< /Type /Page /Parent %d 0 R /MediaBox [0 0 %.2f %.2f] /Resources << /Font << /F1 %d 0 R >> /XObject <<%s >> >> /Contents %d 0 R >>",
+			pages, pdfPageWidth, pdfPageHeight, font, xobjects.String(), pageID+1))
+		pw.deflated(pageID+1, "", content.Bytes())
+	}
+
+	xref := pw.n
+	pw.printf("xref\n0 %d\n0000000000 65535 f \n", len(pw.offsets)+1)
+	for _, offset := range pw.offsets {
+		pw.printf("%010d 00000 n \n", offset)
+	}
+	pw.printf("trailer\n<< /Size %d /Root %d 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(pw.offsets)+1, catalog, xref)
+	return pw.n, pw.err
+}
+
+// writeEmbeddedFont writes the five objects of a composite font over the
+// TrueType file, addressed by glyph id, with a ToUnicode map so that text
+// can be searched and copied.
+func (d *pdfDocument) writeEmbeddedFont(pw *pdfWriter, id int) {
+	f := d.font
+	scale := func(v int) int { return v * 1000 / f.unitsPerEm }
+
+	glyphs := make([]int, 0, len(d.used))
+	for glyph := range d.used {
+		glyphs = append(glyphs, int(glyph))
+	}
+	sort.Ints(glyphs)
+
+	var widths, toUnicode strings.Builder
+	for _, glyph := range glyphs {
+		fmt.Fprintf(&widths, "%d [%d] ", glyph, f.glyphWidth(uint16(glyph)))
+	}
+	toUnicode.WriteString("/CIDInit /ProcSet findresource begin\n12 dict begin\nbegincmap\n" +
+		"/CIDSystemInfo << /Registry (Adobe) /Ordering (UCS) /Supplement 0 >> def\n" +
+		"/CMapName /Adobe-Identity-UCS def\n/CMapType 2 def\n" +
+		"1 begincodespacerange\n<0000> <FFFF>\nendcodespacerange\n")
+	for start := 0; start < len(glyphs); start += 100 {
+		block := glyphs[start:min(start+100, len(glyphs))]
+		fmt.Fprintf(&toUnicode, "%d beginbfchar\n", len(block))
+		for _, glyph := range block {
+			fmt.Fprintf(&toUnicode, "<%04X> <", glyph)
+			for _, unit := range utf16Units(d.used[uint16(glyph)]) {
+				fmt.Fprintf(&toUnicode, "%04X", unit)
+			}
+			toUnicode.WriteString(">\n")
+		}
+		toUnicode.WriteString("endbfchar\n")
+	}
+	toUnicode.WriteString("endcmap\nCMapName currentdict /CMap defineresource pop\nend\nend\n")
+
+	pw.object(id, fmt.Sprintf("<< /Type /Font /Subtype /Type0 /BaseFont /ReportFont /Encoding /Identity-H /DescendantFonts [%d 0 R] /ToUnicode %d 0 R >>", id+1, id+4))
+	pw.object(id+1, fmt.Sprintf("<< /Type /Font /Subtype /CIDFontType2 /BaseFont /ReportFont "+
+		"/CIDSystemInfo << /Registry (Adobe) /Ordering (Identity) /Supplement 0 >> "+
+		"/FontDescriptor %d 0 R /CIDToGIDMap /Identity /DW 1000 /W [%s] >>", id+2, widths.String()))
+	pw.object(id+2, fmt.Sprintf("<< /Type /FontDescriptor /FontName /ReportFont /Flags 32 /FontBBox [%d %d %d %d] "+
+		"/ItalicAngle 0 /Ascent %d /Descent %d /CapHeight %d /StemV 80 /FontFile2 %d 0 R >>",
+		scale(f.bbox[0]), scale(f.bbox[1]), scale(f.bbox[2]), scale(f.bbox[3]),
+		scale(f.ascent), scale(f.descent), scale(f.ascent), id+3))
+	pw.deflated(id+3, fmt.Sprintf("/Length1 %d", len(f.data)), f.data)
+	pw.deflated(id+4, "", []byte(toUnicode.String()))
+}
+
+func utf16Units(r rune) []uint16 {
+	if r < 0x10000 {
+		return []uint16{uint16(r)}
+	}
+	r -= 0x10000
+	return []uint16{uint16(0xD800 + (r >> 10)), uint16(0xDC00 + (r & 0x3FF))}
+}
+
+// pdfWriter writes numbered objects in order, remembering their offsets for
+// the cross-reference table. The first error stops all further output.
+type pdfWriter struct {
+	w       io.Writer
+	n       int64
+	err     error
+	offsets []int64
+}
+
+func (pw *pdfWriter) write(data []byte) {
+	if pw.err != nil {
+		return
+	}
+	n, err := pw.w.Write(data)
+	pw.n += int64(n)
+	pw.err = err
+}
+
+func (pw *pdfWriter) printf(format string, args ...interface{}) {
+	pw.write([]byte(fmt.Sprintf(format, args...)))
+}
+
+// begin starts an object. Objects must be written in the order of their
+// numbers.
+func (pw *pdfWriter) begin(id int) {
+	pw.offsets = append(pw.offsets, pw.n)
+	pw.printf("%d 0 obj\n", id)
+}
+
+func (pw *pdfWriter) object(id int, body string) {
+	pw.begin(id)
+	pw.printf("%s\nendobj\n", body)
+}
+
+func (pw *pdfWriter) stream(id int, dict string, data []byte) {
+	pw.begin(id)
+	pw.printf("<< %s /Length %d >>\nstream\n", dict, len(data))
+	pw.write(data)
+	pw.printf("\nendstream\nendobj\n")
+}
+
+func (pw *pdfWriter) deflated(id int, dict string, data []byte) {
+	var compressed bytes.Buffer
+	zw := zlib.NewWriter(&compressed)
+	zw.Write(data)
+	zw.Close()
+	pw.stream(id, strings.TrimSpace(dict+" /Filter /FlateDecode"), compressed.Bytes())
+}
@@ -0,0 +1,90 @@
+package services
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"syscall"
+	"time"
+)
+
+var ErrNonPublicAddress = errors.New("адрес во внутренней сети недоступен")
+
+// nonPublicPrefixes are the special-purpose ranges not covered by the
+// netip.Addr predicates used in PublicAddress.
+var nonPublicPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("198.18.0.0/15"),
+	netip.MustParsePrefix("240.0.0.0/4"),
+	netip.MustParsePrefix("64:ff9b::/96"),
+}
+
+// PublicAddress reports whether the address is on the public internet, and
+// not loopback, private, link-local (including the 169.254.169.254 cloud
+// metadata endpoint) or otherwise reserved.
+func PublicAddress(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	if !addr.IsValid() || addr.IsUnspecified() || addr.IsLoopback() || addr.IsPrivate() ||
+		addr.IsLinkLocalUnicast() || addr.IsLinkLocalMulticast() || addr.IsInterfaceLocalMulticast() ||
+		addr.IsMulticast() {
+		return false
+	}
+	for _, prefix := range nonPublicPrefixes {
+		if prefix.Contains(addr) {
+			return false
+		}
+	}
+	return true
+}
+
+// NewPublicHTTPClient returns a client for URLs that users configure, such as
+// webhooks and snapshots. The check runs on the address actually dialled, so
+// a hostname that resolves, or later re-resolves, to an internal address is
+// refused as well. Redirects are not followed, and no proxy is used since the
+// proxy would do the dialling.
+func NewPublicHTTPClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: 10 * time.Second,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			addrPort, err := netip.ParseAddrPort(address)
+			if err != nil || !PublicAddress(addrPort.Addr()) {
+				return ErrNonPublicAddress
+			}
+			return nil
+		},
+	}
+
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			DialContext:         dialer.DialContext,
+			ForceAttemptHTTP2:   true,
+			MaxIdleConns:        100,
+			IdleConnTimeout:     90 * time.Second,
+			TLSHandshakeTimeout: 10 * time.Second,
+		},
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// validatePublicURL checks that the URL is http or https and does not name
+// an internal address literally. Hostnames are checked when dialled.
+func validatePublicURL(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" {
+		return errors.New("некорректный URL")
+	}
+	if u.Hostname() == "localhost" {
+		return ErrNonPublicAddress
+	}
+	if addr, err := netip.ParseAddr(u.Hostname()); err == nil && !PublicAddress(addr) {
+		return ErrNonPublicAddress
+	}
+	return nil
+}
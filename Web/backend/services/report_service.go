@@ -0,0 +1,463 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"backend/config"
+	"backend/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const defaultReportSnapshots = 12
+
+// ErrReportFont is returned for reports when no usable TrueType font with
+// Cyrillic is configured in EXPORT_PDF_FONT.
+var ErrReportFont = errors.New("PDF-отчеты недоступны: не настроен шрифт с кириллицей (EXPORT_PDF_FONT)")
+
+var severityLabels = map[string]string{
+	models.SeverityLow:      "низкая",
+	models.SeverityMedium:   "средняя",
+	models.SeverityHigh:     "высокая",
+	models.SeverityCritical: "критическая",
+}
+
+var statusLabels = map[string]string{
+	models.AlertStatusNew:          "новая",
+	models.AlertStatusAcknowledged: "принята",
+	models.AlertStatusResolved:     "закрыта",
+}
+
+var resolutionLabels = map[string]string{
+	models.AlertResolutionConfirmed:     "подтверждена",
+	models.AlertResolutionFalsePositive: "ложное срабатывание",
+}
+
+func severityLabel(severity string) string {
+	if label, ok := severityLabels[severity]; ok {
+		return label
+	}
+	return severity
+}
+
+// statusLabel treats a missing status as new, like the rest of the alert
+// lifecycle.
+func statusLabel(status string) string {
+	if status == "" {
+		status = models.AlertStatusNew
+	}
+	if label, ok := statusLabels[status]; ok {
+		return label
+	}
+	return status
+}
+
+func resolutionLabel(resolution string) string {
+	if label, ok := resolutionLabels[resolution]; ok {
+		return label
+	}
+	return resolution
+}
+
+// locationNames resolves the camera, building and floor ids of an
+// organization to their names for exports and reports.
+type locationNames struct {
+	cameras   map[primitive.ObjectID]string
+	buildings map[primitive.ObjectID]string
+	floors    map[primitive.ObjectID]string
+}
+
+func loadLocationNames(ctx context.Context, cameras, buildings, floors *mongo.Collection, organizationID primitive.ObjectID) (*locationNames, error) {
+	names := &locationNames{}
+	for _, source := range []struct {
+		collection *mongo.Collection
+		names      *map[primitive.ObjectID]string
+	}{
+		{cameras, &names.cameras},
+		{buildings, &names.buildings},
+		{floors, &names.floors},
+	} {
+		cursor, err := source.collection.Find(ctx,
+			bson.M{"organizationId": organizationID},
+			options.Find().SetProjection(bson.M{"name": 1}),
+		)
+		if err != nil {
+			return nil, err
+		}
+		var documents []struct {
+			ID   primitive.ObjectID `bson:"_id"`
+			Name string             `bson:"name"`
+		}
+		if err := cursor.All(ctx, &documents); err != nil {
+			return nil, err
+		}
+
+		*source.names = make(map[primitive.ObjectID]string, len(documents))
+		for _, document := range documents {
+			(*source.names)[document.ID] = document.Name
+		}
+	}
+	return names, nil
+}
+
+// ReportService renders PDF reports of single alerts and incidents for
+// sending to clients: details, snapshots and the timeline of notifications,
+// escalations, comments, acknowledgement and resolution.
+type ReportService struct {
+	Alerts        *mongo.Collection
+	Incidents     *IncidentService
+	Notifications *mongo.Collection
+	Escalations   *mongo.Collection
+	Comments      *mongo.Collection
+	Cameras       *mongo.Collection
+	Buildings     *mongo.Collection
+	Floors        *mongo.Collection
+	Client        *http.Client
+
+	// MaxSnapshots caps the snapshots of an incident report.
+	MaxSnapshots int
+
+	// FontPath is the TrueType font with Cyrillic that reports are set in.
+	// Without it reports are refused with ErrReportFont.
+	FontPath string
+
+	fontOnce sync.Once
+	font     *pdfFont
+	fontErr  error
+}
+
+func NewReportService() *ReportService {
+	fontPath := ""
+	if config.Exports != nil {
+		fontPath = config.Exports.PDFFont
+	}
+	if fontPath == "" {
+		log.Printf("EXPORT_PDF_FONT не задан, PDF-отчеты недоступны")
+	}
+
+	return &ReportService{
+		Alerts:        config.GetCollection("alerts"),
+		Incidents:     NewIncidentService(),
+		Notifications: config.GetCollection("notifications"),
+		Escalations:   config.GetCollection("escalations"),
+		Comments:      config.GetCollection("alert_comments"),
+		Cameras:       config.GetCollection("cameras"),
+		Buildings:     config.GetCollection("buildings"),
+		Floors:        config.GetCollection("floors"),
+		Client:        NewPublicHTTPClient(10 * time.Second),
+		MaxSnapshots:  defaultReportSnapshots,
+		FontPath:      fontPath,
+	}
+}
+
+// reportEvent is one line of a report timeline.
+type reportEvent struct {
+	At   time.Time
+	Text string
+}
+
+// AlertReport renders the PDF report of an alert with times in loc.
+func (s *ReportService) AlertReport(scope AccessScope, id string, loc *time.Location) ([]byte, error) {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, errors.New("некорректный ID тревоги")
+	}
+
+	doc, err := s.document()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	var alert models.Alert
+	err = s.Alerts.FindOne(ctx, scope.LocationFilter(bson.M{"_id": objID})).Decode(&alert)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, errors.New("тревога не найдена")
+		}
+		return nil, err
+	}
+
+	names, err := loadLocationNames(ctx, s.Cameras, s.Buildings, s.Floors, scope.OrganizationID)
+	if err != nil {
+		return nil, err
+	}
+	events, err := s.alertEvents(ctx, []models.Alert{alert}, names)
+	if err != nil {
+		return nil, err
+	}
+
+	doc.Heading("Отчет по тревоге")
+	s.alertFields(doc, &alert, names, loc)
+	if !alert.IncidentID.IsZero() {
+		doc.Field("Инцидент", alert.IncidentID.Hex())
+	}
+
+	if alert.SnapshotURL != "" {
+		doc.Subheading("Снимок")
+		s.snapshot(ctx, doc, &alert)
+	}
+
+	doc.Subheading("Хронология")
+	s.timeline(doc, events, loc)
+
+	return s.render(doc)
+}
+
+// IncidentReport renders the PDF report of an incident and its alerts with
+// times in loc.
+func (s *ReportService) IncidentReport(scope AccessScope, id string, loc *time.Location) ([]byte, error) {
+	doc, err := s.document()
+	if err != nil {
+		return nil, err
+	}
+	incident, alerts, err := s.Incidents.GetIncident(scope, id)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+
+	names, err := loadLocationNames(ctx, s.Cameras, s.Buildings, s.Floors, scope.OrganizationID)
+	if err != nil {
+		return nil, err
+	}
+	events, err := s.alertEvents(ctx, alerts, names)
+	if err != nil {
+		return nil, err
+	}
+	if incident.AcknowledgedAt != nil {
+		events = append(events, reportEvent{*incident.AcknowledgedAt, "Инцидент принят: " + incident.AcknowledgedBy})
+	}
+	if incident.ResolvedAt != nil {
+		events = append(events, reportEvent{*incident.ResolvedAt, "Инцидент закрыт: " + incident.ResolvedBy})
+	}
+
+	cameras := make([]string, 0, len(incident.CameraIDs))
+	for _, cameraID := range incident.CameraIDs {
+		cameras = append(cameras, nameOrID(names.cameras, cameraID))
+	}
+
+	doc.Heading("Отчет по инциденту")
+	doc.Field("ID", incident.ID.Hex())
+	doc.Field("Тип", string(incident.AlertType))
+	doc.Field("Важность", severityLabel(incident.Severity))
+	doc.Field("Статус", statusLabel(incident.Status))
+	doc.Field("Здание", names.buildings[incident.BuildingID])
+	doc.Field("Этаж", names.floors[incident.FloorID])
+	doc.Field("Камеры", strings.Join(cameras, ", "))
+	doc.Field("Период", formatExportTime(incident.FirstAlertAt, loc)+" — "+formatExportTime(incident.LastAlertAt, loc))
+	doc.Field("Тревог", fmt.Sprint(incident.AlertCount))
+	doc.Field("Принял", incident.AcknowledgedBy)
+	doc.Field("Закрыл", incident.ResolvedBy)
+
+	doc.Subheading("Хронология")
+	s.timeline(doc, events, loc)
+
+	snapshots := 0
+	for i := range alerts {
+		if alerts[i].SnapshotURL == "" {
+			continue
+		}
+		if snapshots == s.MaxSnapshots {
+			doc.Paragraph(fmt.Sprintf("Показаны первые %d снимков.", s.MaxSnapshots))
+			break
+		}
+		if snapshots == 0 {
+			doc.Subheading("Снимки")
+		}
+		snapshots++
+		doc.Paragraph(formatExportTime(alerts[i].StartDateTime, loc) + ", " + nameOrID(names.cameras, alerts[i].CameraID))
+		s.snapshot(ctx, doc, &alerts[i])
+	}
+
+	return s.render(doc)
+}
+
+// document starts a report, loading the font on first use.
+func (s *ReportService) document() (*pdfDocument, error) {
+	s.fontOnce.Do(func() {
+		s.font, s.fontErr = loadPDFFont(s.FontPath)
+		if s.fontErr != nil && s.FontPath != "" {
+			log.Printf("Ошибка загрузки шрифта отчетов: %v", s.fontErr)
+		}
+	})
+	if s.fontErr != nil {
+		return nil, s.fontErr
+	}
+	return newPDFDocument(s.font), nil
+}
+
+func (s *ReportService) render(doc *pdfDocument) ([]byte, error) {
+	var out bytes.Buffer
+	if _, err := doc.WriteTo(&out); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+func (s *ReportService) alertFields(doc *pdfDocument, alert *models.Alert, names *locationNames, loc *time.Location) {
+	doc.Field("ID", alert.ID.Hex())
+	doc.Field("Тип", string(alert.AlertType))
+	doc.Field("Важность", severityLabel(alert.Severity))
+	doc.Field("Статус", statusLabel(alert.Status))
+	doc.Field("Решение", resolutionLabel(alert.Resolution))
+	doc.Field("Уверенность", formatConfidence(alert.Confidence))
+	doc.Field("Здание", names.buildings[alert.BuildingID])
+	doc.Field("Этаж", names.floors[alert.FloorID])
+	if !alert.CameraID.IsZero() {
+		doc.Field("Камера", nameOrID(names.cameras, alert.CameraID))
+	}
+	doc.Field("Зона", alert.ZoneName)
+	doc.Field("Источник", alert.Source)
+	doc.Field("Время", formatExportTime(alert.StartDateTime, loc))
+	doc.Field("Метки", strings.Join(alert.Tags, ", "))
+}
+
+// snapshot places the alert frame, or a note when it cannot be loaded.
+func (s *ReportService) snapshot(ctx context.Context, doc *pdfDocument, alert *models.Alert) {
+	data, _ := fetchSnapshot(ctx, s.Client, alert.SnapshotURL)
+	if data == nil {
+		doc.Paragraph("Снимок недоступен: " + alert.SnapshotURL)
+		return
+	}
+	if err := doc.Image(data); err != nil {
+		doc.Paragraph("Снимок в неподдерживаемом формате: " + alert.SnapshotURL)
+	}
+}
+
+// alertEvents collects the timeline of the alerts: when they were raised,
+// notified, escalated, commented, acknowledged and resolved.
+func (s *ReportService) alertEvents(ctx context.Context, alerts []models.Alert, names *locationNames) ([]reportEvent, error) {
+	if len(alerts) == 0 {
+		return nil, nil
+	}
+	ids := make([]primitive.ObjectID, len(alerts))
+	for i := range alerts {
+		ids[i] = alerts[i].ID
+	}
+	// Prefix events with the alert they belong to when there are several.
+	prefix := func(alert *models.Alert) string {
+		if len(alerts) == 1 {
+			return ""
+		}
+		if alert.CameraID.IsZero() {
+			return fmt.Sprintf("[%s] ", alert.ID.Hex())
+		}
+		return fmt.Sprintf("[%s] ", nameOrID(names.cameras, alert.CameraID))
+	}
+	byID := make(map[primitive.ObjectID]*models.Alert, len(alerts))
+
+	var events []reportEvent
+	for i := range alerts {
+		alert := &alerts[i]
+		byID[alert.ID] = alert
+
+		text := fmt.Sprintf("Тревога %s, важность %s", alert.AlertType, severityLabel(alert.Severity))
+		if alert.Source != "" {
+			text += ", источник " + alert.Source
+		}
+		events = append(events, reportEvent{alert.StartDateTime, prefix(alert) + text})
+		if alert.AcknowledgedAt != nil {
+			events = append(events, reportEvent{*alert.AcknowledgedAt, prefix(alert) + "Принята: " + alert.AcknowledgedBy})
+		}
+		if alert.ResolvedAt != nil {
+			text := "Закрыта: " + alert.ResolvedBy
+			if alert.Resolution != "" {
+				text += " (" + resolutionLabel(alert.Resolution) + ")"
+			}
+			events = append(events, reportEvent{*alert.ResolvedAt, prefix(alert) + text})
+		}
+	}
+
+	// Reports go to clients, so recipients' addresses are left out.
+	cursor, err := s.Notifications.Find(ctx,
+		bson.M{"alertId": bson.M{"$in": ids}},
+		options.Find().SetProjection(bson.M{"address": 0}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	var notifications []models.Notification
+	if err := cursor.All(ctx, &notifications); err != nil {
+		return nil, err
+	}
+	for _, notification := range notifications {
+		text := fmt.Sprintf("Уведомление (%s): %s", notification.Channel, notification.Status)
+		if notification.Error != "" {
+			text += ", " + notification.Error
+		}
+		events = append(events, reportEvent{notification.CreatedAt, prefix(byID[notification.AlertID]) + text})
+	}
+
+	cursor, err = s.Escalations.Find(ctx, bson.M{"alertId": bson.M{"$in": ids}})
+	if err != nil {
+		return nil, err
+	}
+	var escalations []models.Escalation
+	if err := cursor.All(ctx, &escalations); err != nil {
+		return nil, err
+	}
+	for _, escalation := range escalations {
+		for _, step := range escalation.History {
+			text := fmt.Sprintf("Эскалация, шаг %d: уведомлено пользователей: %d", step.Step+1, len(step.UserIDs))
+			events = append(events, reportEvent{step.NotifiedAt, prefix(byID[escalation.AlertID]) + text})
+		}
+	}
+
+	if s.Comments != nil {
+		cursor, err = s.Comments.Find(ctx, bson.M{"alertId": bson.M{"$in": ids}, "deleted": bson.M{"$ne": true}})
+		if err != nil {
+			return nil, err
+		}
+		var comments []models.AlertComment
+		if err := cursor.All(ctx, &comments); err != nil {
+			return nil, err
+		}
+		for _, comment := range comments {
+			text := fmt.Sprintf("Комментарий %s: %s", comment.AuthorName, comment.Body)
+			if !comment.ParentID.IsZero() {
+				text = fmt.Sprintf("Ответ %s: %s", comment.AuthorName, comment.Body)
+			}
+			events = append(events, reportEvent{comment.CreatedAt, prefix(byID[comment.AlertID]) + text})
+		}
+	}
+
+	sort.SliceStable(events, func(i, j int) bool { return events[i].At.Before(events[j].At) })
+	return events, nil
+}
+
+func (s *ReportService) timeline(doc *pdfDocument, events []reportEvent, loc *time.Location) {
+	if len(events) == 0 {
+		doc.Paragraph("Событий нет.")
+		return
+	}
+	for _, event := range events {
+		doc.Field(formatExportTime(event.At, loc), event.Text)
+	}
+}
+
+func nameOrID(names map[primitive.ObjectID]string, id primitive.ObjectID) string {
+	if name := names[id]; name != "" {
+		return name
+	}
+	if id.IsZero() {
+		return ""
+	}
+	return id.Hex()
+}
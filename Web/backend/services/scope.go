@@ -0,0 +1,169 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"backend/config"
+	"backend/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// AccessScope limits service queries to the data the caller is allowed to see.
+// It is built from the JWT claims of the current request and, for users with
+// building or floor grants, from the grants of their membership.
+type AccessScope struct {
+	OrganizationID primitive.ObjectID
+
+	// Restricted scopes only see the granted buildings and floors. FloorIDs
+	// already include every floor of the granted buildings.
+	Restricted  bool
+	BuildingIDs []primitive.ObjectID
+	FloorIDs    []primitive.ObjectID
+}
+
+// Filter adds the tenant conditions to a Mongo query.
+func (s AccessScope) Filter(query bson.M) bson.M {
+	query["organizationId"] = s.OrganizationID
+	return query
+}
+
+// BuildingFilter limits a query on the buildings collection.
+func (s AccessScope) BuildingFilter(query bson.M) bson.M {
+	query = s.Filter(query)
+	if s.Restricted {
+		query["$or"] = bson.A{
+			bson.M{"_id": bson.M{"$in": s.ids(s.BuildingIDs)}},
+			bson.M{"floors._id": bson.M{"$in": s.ids(s.FloorIDs)}},
+		}
+	}
+	return query
+}
+
+// FloorFilter limits a query on the floors collection.
+func (s AccessScope) FloorFilter(query bson.M) bson.M {
+	query = s.Filter(query)
+	if s.Restricted {
+		condition := bson.M{"$in": s.ids(s.FloorIDs)}
+		if id, ok := query["_id"]; ok {
+			condition["$eq"] = id
+		}
+		query["_id"] = condition
+	}
+	return query
+}
+
+// LocationFilter limits a query on documents that carry buildingId and
+// floorId, such as cameras and alerts.
+func (s AccessScope) LocationFilter(query bson.M) bson.M {
+	query = s.Filter(query)
+	if s.Restricted {
+		query["$or"] = bson.A{
+			bson.M{"buildingId": bson.M{"$in": s.ids(s.BuildingIDs)}},
+			bson.M{"floorId": bson.M{"$in": s.ids(s.FloorIDs)}},
+		}
+	}
+	return query
+}
+
+// AllowsLocation reports whether a document of the given organization placed
+// in the building and floor is visible in this scope.
+func (s AccessScope) AllowsLocation(orgID, buildingID, floorID primitive.ObjectID) bool {
+	if orgID != s.OrganizationID {
+		return false
+	}
+	if !s.Restricted {
+		return true
+	}
+	return containsID(s.BuildingIDs, buildingID) || containsID(s.FloorIDs, floorID)
+}
+
+func (s AccessScope) ids(ids []primitive.ObjectID) []primitive.ObjectID {
+	if ids == nil {
+		return []primitive.ObjectID{}
+	}
+	return ids
+}
+
+func containsID(ids []primitive.ObjectID, id primitive.ObjectID) bool {
+	if id.IsZero() {
+		return false
+	}
+	for _, v := range ids {
+		if v == id {
+			return true
+		}
+	}
+	return false
+}
+
+// ScopeService resolves the access scope of an authenticated user.
+type ScopeService struct {
+	Users     *mongo.Collection
+	Buildings *mongo.Collection
+}
+
+func NewScopeService() *ScopeService {
+	return &ScopeService{
+		Users:     config.GetCollection("users"),
+		Buildings: config.GetCollection("buildings"),
+	}
+}
+
+// Resolve builds the scope for a user within an organization. Admins and users
+// without building or floor grants see the whole organization.
+func (s *ScopeService) Resolve(orgID primitive.ObjectID, userID string, role models.Role) (AccessScope, error) {
+	scope := AccessScope{OrganizationID: orgID}
+	if role == models.RoleAdmin {
+		return scope, nil
+	}
+
+	userObjID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return scope, errors.New("некорректный ID пользователя")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var user models.User
+	err = s.Users.FindOne(ctx, bson.M{"_id": userObjID}).Decode(&user)
+	if err != nil {
+		return scope, errors.New("пользователь не найден")
+	}
+	membership := user.Membership(orgID)
+	if membership == nil {
+		return scope, errors.New("пользователь не состоит в организации")
+	}
+	if len(membership.BuildingIDs) == 0 && len(membership.FloorIDs) == 0 {
+		return scope, nil
+	}
+
+	scope.Restricted = true
+	scope.BuildingIDs = membership.BuildingIDs
+	scope.FloorIDs = append([]primitive.ObjectID{}, membership.FloorIDs...)
+
+	if len(membership.BuildingIDs) > 0 {
+		cursor, err := s.Buildings.Find(ctx, bson.M{"_id": bson.M{"$in": membership.BuildingIDs}, "organizationId": orgID})
+		if err != nil {
+			return scope, err
+		}
+		defer cursor.Close(ctx)
+
+		var buildings []models.Building
+		if err := cursor.All(ctx, &buildings); err != nil {
+			return scope, err
+		}
+		for _, building := range buildings {
+			for _, floor := range building.Floors {
+				scope.FloorIDs = append(scope.FloorIDs, floor.ID)
+			}
+		}
+	}
+
+	return scope, nil
+}
@@ -0,0 +1,181 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"backend/config"
+	"backend/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// lowConfidence is the detector score below which an alert that no rule
+// matched is downgraded by one level.
+const lowConfidence = 0.5
+
+var severitiesByRank = []string{"", models.SeverityLow, models.SeverityMedium, models.SeverityHigh, models.SeverityCritical}
+
+// SeverityService computes alert severities from the organization's rules.
+type SeverityService struct {
+	Collection *mongo.Collection
+	Now        func() time.Time
+}
+
+func NewSeverityService() *SeverityService {
+	return &SeverityService{
+		Collection: config.GetCollection("severity_rules"),
+		Now:        time.Now,
+	}
+}
+
+// Compute returns the severity of the alert: that of the first matching rule
+// of its organization, or else defaultSeverity, the default of its type,
+// lowered one level for low-confidence detections.
+func (s *SeverityService) Compute(alert *models.Alert, defaultSeverity string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	cursor, err := s.Collection.Find(ctx,
+		bson.M{"organizationId": alert.OrganizationID},
+		options.Find().SetSort(bson.D{{Key: "order", Value: 1}}),
+	)
+	if err != nil {
+		return "", err
+	}
+	var rules []models.SeverityRule
+	if err := cursor.All(ctx, &rules); err != nil {
+		return "", err
+	}
+
+	return ComputeSeverity(rules, alert, defaultSeverity, s.Now()), nil
+}
+
+// ComputeSeverity applies rules, already sorted by Order, to an alert raised
+// at t. An empty defaultSeverity is medium.
+func ComputeSeverity(rules []models.SeverityRule, alert *models.Alert, defaultSeverity string, t time.Time) string {
+	for i := range rules {
+		if rules[i].Matches(alert, t) {
+			return rules[i].Severity
+		}
+	}
+
+	severity := defaultSeverity
+	if models.SeverityRank(severity) == 0 {
+		severity = models.SeverityMedium
+	}
+	if alert.Confidence > 0 && alert.Confidence < lowConfidence {
+		if rank := models.SeverityRank(severity); rank > 1 {
+			severity = severitiesByRank[rank-1]
+		}
+	}
+	return severity
+}
+
+func (s *SeverityService) GetRules(scope AccessScope) ([]models.SeverityRule, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	cursor, err := s.Collection.Find(ctx, scope.Filter(bson.M{}), options.Find().SetSort(bson.D{{Key: "order", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	rules := []models.SeverityRule{}
+	if err := cursor.All(ctx, &rules); err != nil {
+		return nil, err
+	}
+
+	return rules, nil
+}
+
+func (s *SeverityService) CreateRule(scope AccessScope, rule *models.SeverityRule) (*models.SeverityRule, error) {
+	if err := validateSeverityRule(rule); err != nil {
+		return nil, err
+	}
+
+	rule.ID = primitive.NewObjectID()
+	rule.OrganizationID = scope.OrganizationID
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if _, err := s.Collection.InsertOne(ctx, rule); err != nil {
+		return nil, err
+	}
+
+	return rule, nil
+}
+
+func (s *SeverityService) UpdateRule(scope AccessScope, id string, rule *models.SeverityRule) (*models.SeverityRule, error) {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, errors.New("некорректный ID правила")
+	}
+	if err := validateSeverityRule(rule); err != nil {
+		return nil, err
+	}
+
+	rule.ID = objID
+	rule.OrganizationID = scope.OrganizationID
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	result, err := s.Collection.ReplaceOne(ctx, scope.Filter(bson.M{"_id": objID}), rule)
+	if err != nil {
+		return nil, err
+	}
+	if result.MatchedCount == 0 {
+		return nil, errors.New("правило не найдено")
+	}
+
+	return rule, nil
+}
+
+func (s *SeverityService) DeleteRule(scope AccessScope, id string) error {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return errors.New("некорректный ID правила")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	result, err := s.Collection.DeleteOne(ctx, scope.Filter(bson.M{"_id": objID}))
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return errors.New("правило не найдено")
+	}
+	return nil
+}
+
+func validateSeverityRule(rule *models.SeverityRule) error {
+	if strings.TrimSpace(rule.Name) == "" {
+		return errors.New("не указано название правила")
+	}
+	if models.SeverityRank(rule.Severity) == 0 {
+		return errors.New("неизвестная важность: " + rule.Severity)
+	}
+	if w := rule.TimeWindow; w != nil {
+		_, errStart := time.Parse("15:04", w.Start)
+		_, errEnd := time.Parse("15:04", w.End)
+		if errStart != nil || errEnd != nil {
+			return errors.New("время правила задается в формате ЧЧ:ММ")
+		}
+		if w.Timezone != "" {
+			if _, err := time.LoadLocation(w.Timezone); err != nil {
+				return errors.New("неизвестный часовой пояс: " + w.Timezone)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,373 @@
+package services
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"math/big"
+	"time"
+
+	"backend/config"
+	"backend/models"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var ErrInvalidRefreshToken = errors.New("недействительный refresh токен")
+
+// Purposes of the intermediate tokens issued between the password and the
+// second factor of a login.
+const (
+	MFAPurposeVerify = "mfa"
+	MFAPurposeEnroll = "mfa_enroll"
+
+	mfaTokenTTL = 5 * time.Minute
+)
+
+type TokenPair struct {
+	AccessToken  string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int64  `json:"expires_in"`
+}
+
+type TokenService struct {
+	Collection *mongo.Collection
+	Users      *mongo.Collection
+}
+
+func NewTokenService() *TokenService {
+	return &TokenService{
+		Collection: config.GetCollection("refresh_tokens"),
+		Users:      config.GetCollection("users"),
+	}
+}
+
+// IssueTokens starts a new session for the user and returns its first token pair.
+func (s *TokenService) IssueTokens(user *models.User, orgID primitive.ObjectID) (*TokenPair, error) {
+	return s.issue(user, orgID, uuid.New().String())
+}
+
+// Refresh rotates a refresh token. Presenting a token that was already rotated
+// or revoked revokes the whole session, since it means the token leaked.
+func (s *TokenService) Refresh(refreshToken string) (*TokenPair, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var stored models.RefreshToken
+	err := s.Collection.FindOne(ctx, bson.M{"tokenHash": hashToken(refreshToken)}).Decode(&stored)
+	if err != nil {
+		return nil, ErrInvalidRefreshToken
+	}
+	if stored.RevokedAt != nil {
+		s.revoke(ctx, bson.M{"sessionId": stored.SessionID})
+		return nil, ErrInvalidRefreshToken
+	}
+	if time.Now().After(stored.ExpiresAt) {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	result, err := s.Collection.UpdateOne(ctx,
+		bson.M{"_id": stored.ID, "revokedAt": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"revokedAt": time.Now()}},
+	)
+	if err != nil {
+		return nil, err
+	}
+	if result.ModifiedCount == 0 {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	var user models.User
+	err = s.Users.FindOne(ctx, bson.M{"_id": stored.UserID}).Decode(&user)
+	if err != nil {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	orgID := primitive.NilObjectID
+	if !stored.OrganizationID.IsZero() {
+		orgID, err = ResolveOrganization(&user, stored.OrganizationID.Hex())
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return s.issue(&user, orgID, stored.SessionID)
+}
+
+// Logout revokes the session the refresh token belongs to.
+func (s *TokenService) Logout(refreshToken string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var stored models.RefreshToken
+	err := s.Collection.FindOne(ctx, bson.M{"tokenHash": hashToken(refreshToken)}).Decode(&stored)
+	if err != nil {
+		return ErrInvalidRefreshToken
+	}
+
+	return s.revoke(ctx, bson.M{"sessionId": stored.SessionID})
+}
+
+// RevokeAllSessions revokes every session of the user. AuthMiddleware rejects
+// the access tokens of revoked sessions.
+func (s *TokenService) RevokeAllSessions(userID string) error {
+	objID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return errors.New("некорректный ID пользователя")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	return s.revoke(ctx, bson.M{"userId": objID})
+}
+
+// RevokeOtherSessions revokes every session of the user except the current
+// one, e.g. after a password change.
+func (s *TokenService) RevokeOtherSessions(userID, currentSessionID string) error {
+	objID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return errors.New("некорректный ID пользователя")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	return s.revoke(ctx, bson.M{"userId": objID, "sessionId": bson.M{"$ne": currentSessionID}})
+}
+
+func (s *TokenService) revoke(ctx context.Context, filter bson.M) error {
+	filter["revokedAt"] = bson.M{"$exists": false}
+	_, err := s.Collection.UpdateMany(ctx, filter, bson.M{"$set": bson.M{"revokedAt": time.Now(), "sessionRevoked": true}})
+	return err
+}
+
+func (s *TokenService) issue(user *models.User, orgID primitive.ObjectID, sessionID string) (*TokenPair, error) {
+	accessToken, err := SignAccessToken(user, orgID, sessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+	refreshToken := base64.RawURLEncoding.EncodeToString(secret)
+
+	now := time.Now()
+	stored := models.RefreshToken{
+		ID:             primitive.NewObjectID(),
+		SessionID:      sessionID,
+		UserID:         user.ID,
+		OrganizationID: orgID,
+		TokenHash:      hashToken(refreshToken),
+		CreatedAt:      now,
+		ExpiresAt:      now.Add(config.Auth.RefreshTokenTTL),
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if _, err := s.Collection.InsertOne(ctx, stored); err != nil {
+		return nil, err
+	}
+
+	return &TokenPair{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int64(config.Auth.AccessTokenTTL.Seconds()),
+	}, nil
+}
+
+// SignAccessToken creates a short-lived JWT for the user bound to an organization.
+func SignAccessToken(user *models.User, orgID primitive.ObjectID, sessionID string) (string, error) {
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"user_id":  user.ID.Hex(),
+		"username": user.Username,
+		"role":     user.RoleIn(orgID),
+		"sid":      sessionID,
+		"iat":      now.Unix(),
+		"exp":      now.Add(config.Auth.AccessTokenTTL).Unix(),
+	}
+	if !orgID.IsZero() {
+		claims["org_id"] = orgID.Hex()
+	}
+	if user.PlatformOperator {
+		claims["platform"] = true
+	}
+
+	token := jwt.NewWithClaims(config.Auth.SigningMethod, claims)
+	if config.Auth.KeyID != "" {
+		token.Header["kid"] = config.Auth.KeyID
+	}
+
+	return token.SignedString(config.Auth.SigningKey())
+}
+
+// ParseAccessToken validates the signature and expiry of an access token. Only
+// the configured algorithm is accepted.
+func ParseAccessToken(tokenString string) (jwt.MapClaims, error) {
+	claims, err := parseToken(tokenString)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := claims["purpose"]; ok {
+		return nil, errors.New("недействительный токен")
+	}
+	return claims, nil
+}
+
+// Principal is the caller of a request authenticated with an access token.
+type Principal struct {
+	User           *models.User
+	OrganizationID primitive.ObjectID
+	Role           models.Role
+	SessionID      string
+}
+
+// Authenticate validates an access token and re-reads the user and the
+// session, so that role changes, disabled memberships and logouts apply
+// before the token expires. The role is the one of the user's membership in
+// the token's organization.
+func (s *TokenService) Authenticate(accessToken string) (*Principal, error) {
+	claims, err := ParseAccessToken(accessToken)
+	if err != nil {
+		return nil, err
+	}
+
+	userHex, _ := claims["user_id"].(string)
+	userID, err := primitive.ObjectIDFromHex(userHex)
+	if err != nil {
+		return nil, errors.New("недействительный токен")
+	}
+	sessionID, _ := claims["sid"].(string)
+	if sessionID == "" {
+		return nil, errors.New("недействительный токен")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	err = s.Collection.FindOne(ctx, bson.M{"sessionId": sessionID, "sessionRevoked": true}).Err()
+	if err == nil {
+		return nil, errors.New("сеанс завершен")
+	}
+	if err != mongo.ErrNoDocuments {
+		return nil, err
+	}
+
+	var user models.User
+	if err := s.Users.FindOne(ctx, bson.M{"_id": userID}).Decode(&user); err != nil {
+		return nil, errors.New("пользователь не найден")
+	}
+
+	principal := &Principal{User: &user, SessionID: sessionID}
+	if orgHex, ok := claims["org_id"].(string); ok {
+		orgID, err := primitive.ObjectIDFromHex(orgHex)
+		if err != nil {
+			return nil, errors.New("недействительный токен")
+		}
+		membership := user.Membership(orgID)
+		if membership == nil {
+			return nil, errors.New("пользователь не состоит в организации")
+		}
+		if membership.Disabled {
+			return nil, ErrUserDisabled
+		}
+		principal.OrganizationID = orgID
+		principal.Role = membership.Role
+	}
+	return principal, nil
+}
+
+// SignMFAToken issues the short-lived token that lets a user who passed the
+// password check complete the second step of the login.
+func SignMFAToken(userID string, orgID primitive.ObjectID, purpose string) (string, error) {
+	claims := jwt.MapClaims{
+		"user_id": userID,
+		"purpose": purpose,
+		"exp":     time.Now().Add(mfaTokenTTL).Unix(),
+	}
+	if !orgID.IsZero() {
+		claims["org_id"] = orgID.Hex()
+	}
+
+	token := jwt.NewWithClaims(config.Auth.SigningMethod, claims)
+	return token.SignedString(config.Auth.SigningKey())
+}
+
+// ParseMFAToken validates an MFA token of the given purpose and returns the
+// user and organization it was issued for.
+func ParseMFAToken(tokenString, purpose string) (string, primitive.ObjectID, error) {
+	claims, err := parseToken(tokenString)
+	if err != nil || claims["purpose"] != purpose {
+		return "", primitive.NilObjectID, errors.New("недействительный токен двухфакторной аутентификации")
+	}
+
+	userID, _ := claims["user_id"].(string)
+	orgHex, _ := claims["org_id"].(string)
+	orgID, _ := primitive.ObjectIDFromHex(orgHex)
+	return userID, orgID, nil
+}
+
+func parseToken(tokenString string) (jwt.MapClaims, error) {
+	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		if token.Method.Alg() != config.Auth.SigningMethod.Alg() {
+			return nil, errors.New("неожиданный алгоритм подписи")
+		}
+		return config.Auth.VerificationKey(), nil
+	})
+	if err != nil || !token.Valid {
+		return nil, errors.New("недействительный токен")
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return nil, errors.New("неверный формат токена")
+	}
+	return claims, nil
+}
+
+// JWKS returns the public signing key as a JSON Web Key Set. Symmetric keys are
+// never published, so the set is empty for HS256.
+func JWKS() map[string]interface{} {
+	keys := []map[string]string{}
+
+	key := map[string]string{
+		"use": "sig",
+		"alg": config.Auth.SigningMethod.Alg(),
+	}
+	if config.Auth.KeyID != "" {
+		key["kid"] = config.Auth.KeyID
+	}
+
+	switch pub := config.Auth.VerificationKey().(type) {
+	case *rsa.PublicKey:
+		key["kty"] = "RSA"
+		key["n"] = base64.RawURLEncoding.EncodeToString(pub.N.Bytes())
+		key["e"] = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+		keys = append(keys, key)
+	case ed25519.PublicKey:
+		key["kty"] = "OKP"
+		key["crv"] = "Ed25519"
+		key["x"] = base64.RawURLEncoding.EncodeToString(pub)
+		keys = append(keys, key)
+	}
+
+	return map[string]interface{}{"keys": keys}
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
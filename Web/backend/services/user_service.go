@@ -0,0 +1,296 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"backend/config"
+	"backend/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const minPasswordLength = 8
+
+var (
+	ErrUserNotFound = errors.New("пользователь не найден")
+	ErrUserExists   = errors.New("пользователь уже существует")
+	ErrEmailInUse   = errors.New("email уже используется другой учетной записью")
+)
+
+type UserService struct {
+	Collection *mongo.Collection
+}
+
+func NewUserService() *UserService {
+	return &UserService{
+		Collection: config.GetCollection("users"),
+	}
+}
+
+// EnsureIndexes makes usernames and non-empty emails unique, so that
+// concurrent registrations and invitation acceptances cannot create
+// duplicates.
+func (s *UserService) EnsureIndexes() error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	_, err := s.Collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "username", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys: bson.D{{Key: "email", Value: 1}},
+			Options: options.Index().SetUnique(true).SetPartialFilterExpression(bson.M{
+				"email": bson.M{"$type": "string", "$gt": ""},
+			}),
+		},
+	})
+	return err
+}
+
+// duplicateUserError turns a unique index violation on insert or update into
+// an error naming the taken field.
+func duplicateUserError(err error) error {
+	if !mongo.IsDuplicateKeyError(err) {
+		return err
+	}
+	if strings.Contains(err.Error(), "email") {
+		return ErrEmailInUse
+	}
+	return ErrUserExists
+}
+
+// GetUsers lists the members of the scope's organization.
+func (s *UserService) GetUsers(scope AccessScope) ([]models.User, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	findOptions := options.Find().SetSort(bson.D{{Key: "username", Value: 1}})
+	cursor, err := s.Collection.Find(ctx, bson.M{"memberships.organizationId": scope.OrganizationID}, findOptions)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	users := []models.User{}
+	if err := cursor.All(ctx, &users); err != nil {
+		return nil, err
+	}
+	for i := range users {
+		scopeMemberships(&users[i], scope)
+	}
+
+	return users, nil
+}
+
+// GetUser returns a member of the scope's organization.
+func (s *UserService) GetUser(scope AccessScope, id string) (*models.User, error) {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, errors.New("некорректный ID пользователя")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var user models.User
+	err = s.Collection.FindOne(ctx, bson.M{"_id": objID, "memberships.organizationId": scope.OrganizationID}).Decode(&user)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrUserNotFound
+		}
+		return nil, err
+	}
+	scopeMemberships(&user, scope)
+
+	return &user, nil
+}
+
+// SetDisabled blocks or unblocks the user's membership in the scope's
+// organization. Their memberships in other organizations are not affected.
+func (s *UserService) SetDisabled(scope AccessScope, id string, disabled bool) (*models.User, error) {
+	return s.updateMembership(scope, id, bson.M{"disabled": disabled})
+}
+
+// DeleteUser removes the user from the scope's organization. The account is
+// shared with the user's other organizations and is kept, also when this was
+// its last membership.
+func (s *UserService) DeleteUser(scope AccessScope, id string) error {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return errors.New("некорректный ID пользователя")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	result, err := s.Collection.UpdateOne(ctx,
+		bson.M{"_id": objID, "memberships.organizationId": scope.OrganizationID},
+		bson.M{"$pull": bson.M{"memberships": bson.M{"organizationId": scope.OrganizationID}}},
+	)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+// SetEmail changes the email of the user. Callers must have verified that the
+// user controls the new address.
+func (s *UserService) SetEmail(id primitive.ObjectID, email string) (*models.User, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var user models.User
+	err := s.Collection.FindOneAndUpdate(ctx,
+		bson.M{"_id": id},
+		bson.M{"$set": bson.M{"email": email}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&user)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrUserNotFound
+		}
+		return nil, duplicateUserError(err)
+	}
+
+	return &user, nil
+}
+
+// ChangePassword replaces the password of a user who knows the current one.
+func (s *UserService) ChangePassword(userID, currentPassword, newPassword string) error {
+	objID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return errors.New("некорректный ID пользователя")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var user models.User
+	if err := s.Collection.FindOne(ctx, bson.M{"_id": objID}).Decode(&user); err != nil {
+		return ErrUserNotFound
+	}
+	if user.Password == "" || bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(currentPassword)) != nil {
+		return errors.New("неверный текущий пароль")
+	}
+
+	return s.SetPassword(objID, newPassword)
+}
+
+func (s *UserService) SetPassword(id primitive.ObjectID, password string) error {
+	hash, err := HashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err = s.Collection.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{"password": hash}})
+	return err
+}
+
+// HashPassword checks the password policy and returns the bcrypt hash.
+func HashPassword(password string) (string, error) {
+	if len([]rune(password)) < minPasswordLength {
+		return "", fmt.Errorf("пароль должен содержать не менее %d символов", minPasswordLength)
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+func (s *UserService) UpdateAccess(scope AccessScope, id string, buildingIDs, floorIDs []string) (*models.User, error) {
+	buildings, err := parseObjectIDs(buildingIDs)
+	if err != nil {
+		return nil, errors.New("некорректный ID здания")
+	}
+	floors, err := parseObjectIDs(floorIDs)
+	if err != nil {
+		return nil, errors.New("некорректный ID этажа")
+	}
+
+	return s.updateMembership(scope, id, bson.M{"buildingIds": buildings, "floorIds": floors})
+}
+
+func (s *UserService) UpdateRole(scope AccessScope, id string, role models.Role) (*models.User, error) {
+	if !role.Valid() {
+		return nil, errors.New("неизвестная роль")
+	}
+
+	return s.updateMembership(scope, id, bson.M{"role": role})
+}
+
+// update applies $set to a member of the scope's organization and returns the
+// updated user.
+func (s *UserService) update(scope AccessScope, id string, set bson.M) (*models.User, error) {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, errors.New("некорректный ID пользователя")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var user models.User
+	err = s.Collection.FindOneAndUpdate(ctx,
+		bson.M{"_id": objID, "memberships.organizationId": scope.OrganizationID},
+		bson.M{"$set": set},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&user)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrUserNotFound
+		}
+		return nil, err
+	}
+	scopeMemberships(&user, scope)
+
+	return &user, nil
+}
+
+// updateMembership sets fields of the user's membership in the scope's
+// organization.
+func (s *UserService) updateMembership(scope AccessScope, id string, fields bson.M) (*models.User, error) {
+	set := bson.M{}
+	for key, value := range fields {
+		set["memberships.$."+key] = value
+	}
+	return s.update(scope, id, set)
+}
+
+// scopeMemberships hides the user's memberships in other organizations.
+func scopeMemberships(user *models.User, scope AccessScope) {
+	memberships := []models.Membership{}
+	if membership := user.Membership(scope.OrganizationID); membership != nil {
+		memberships = append(memberships, *membership)
+	}
+	user.Memberships = memberships
+}
+
+func parseObjectIDs(ids []string) ([]primitive.ObjectID, error) {
+	result := make([]primitive.ObjectID, 0, len(ids))
+	for _, id := range ids {
+		objID, err := primitive.ObjectIDFromHex(id)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, objID)
+	}
+	return result, nil
+}
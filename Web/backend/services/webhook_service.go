@@ -0,0 +1,406 @@
+package services
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"backend/config"
+	"backend/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const WebhookEventAlertCreated = "alert.created"
+
+// WebhookService manages webhook subscriptions and delivers events to them
+// through a queue persisted in MongoDB, so pending deliveries survive
+// restarts and are shared by all instances.
+type WebhookService struct {
+	Collection *mongo.Collection
+	Deliveries *mongo.Collection
+	Client     *http.Client
+
+	MaxAttempts  int
+	BaseBackoff  time.Duration
+	MaxBackoff   time.Duration
+	PollInterval time.Duration
+	// Lease is how long a claimed delivery is hidden from other workers.
+	Lease time.Duration
+}
+
+type WebhookDeliveryFilter struct {
+	WebhookID string
+	Status    string
+}
+
+func NewWebhookService() *WebhookService {
+	return &WebhookService{
+		Collection:   config.GetCollection("webhooks"),
+		Deliveries:   config.GetCollection("webhook_deliveries"),
+		Client:       NewPublicHTTPClient(10 * time.Second),
+		MaxAttempts:  8,
+		BaseBackoff:  30 * time.Second,
+		MaxBackoff:   time.Hour,
+		PollInterval: 5 * time.Second,
+		Lease:        time.Minute,
+	}
+}
+
+// CreateWebhook stores a subscription with a new signing secret, which is
+// returned to the caller only once.
+func (s *WebhookService) CreateWebhook(scope AccessScope, webhook *models.Webhook) (string, *models.Webhook, error) {
+	if err := validateWebhook(webhook); err != nil {
+		return "", nil, err
+	}
+
+	webhook.ID = primitive.NewObjectID()
+	webhook.OrganizationID = scope.OrganizationID
+	webhook.Secret = "whsec_" + randomString()
+	webhook.CreatedAt = time.Now()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if _, err := s.Collection.InsertOne(ctx, webhook); err != nil {
+		return "", nil, err
+	}
+
+	return webhook.Secret, webhook, nil
+}
+
+func (s *WebhookService) GetWebhooks(scope AccessScope) ([]models.Webhook, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	cursor, err := s.Collection.Find(ctx, scope.Filter(bson.M{}), options.Find().SetSort(bson.D{{Key: "createdAt", Value: -1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	webhooks := []models.Webhook{}
+	if err := cursor.All(ctx, &webhooks); err != nil {
+		return nil, err
+	}
+
+	return webhooks, nil
+}
+
+// UpdateWebhook replaces the name, URL, filters and enabled flag. The secret
+// is kept.
+func (s *WebhookService) UpdateWebhook(scope AccessScope, id string, webhook *models.Webhook) (*models.Webhook, error) {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, errors.New("некорректный ID вебхука")
+	}
+	if err := validateWebhook(webhook); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var updated models.Webhook
+	err = s.Collection.FindOneAndUpdate(ctx,
+		scope.Filter(bson.M{"_id": objID}),
+		bson.M{"$set": bson.M{
+			"name":        webhook.Name,
+			"url":         webhook.URL,
+			"enabled":     webhook.Enabled,
+			"alertTypes":  webhook.AlertTypes,
+			"buildingIds": webhook.BuildingIDs,
+			"severities":  webhook.Severities,
+		}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&updated)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, errors.New("вебхук не найден")
+		}
+		return nil, err
+	}
+
+	return &updated, nil
+}
+
+func (s *WebhookService) DeleteWebhook(scope AccessScope, id string) error {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return errors.New("некорректный ID вебхука")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	result, err := s.Collection.DeleteOne(ctx, scope.Filter(bson.M{"_id": objID}))
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return errors.New("вебхук не найден")
+	}
+
+	_, err = s.Deliveries.DeleteMany(ctx, bson.M{"webhookId": objID, "status": models.DeliveryPending})
+	return err
+}
+
+func validateWebhook(webhook *models.Webhook) error {
+	if strings.TrimSpace(webhook.Name) == "" {
+		return errors.New("не указано название вебхука")
+	}
+	if err := validatePublicURL(webhook.URL); err != nil {
+		if errors.Is(err, ErrNonPublicAddress) {
+			return err
+		}
+		return errors.New("некорректный URL вебхука")
+	}
+	return nil
+}
+
+// EnqueueAlert queues a delivery of the alert to every enabled webhook of its
+// organization whose filters match. It is registered as an alert hook.
+func (s *WebhookService) EnqueueAlert(alert *models.Alert) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	cursor, err := s.Collection.Find(ctx, bson.M{"organizationId": alert.OrganizationID, "enabled": true})
+	if err != nil {
+		log.Printf("Ошибка загрузки вебхуков: %v", err)
+		return
+	}
+	var webhooks []models.Webhook
+	if err := cursor.All(ctx, &webhooks); err != nil {
+		log.Printf("Ошибка загрузки вебхуков: %v", err)
+		return
+	}
+
+	now := time.Now()
+	for _, webhook := range webhooks {
+		if !webhook.Matches(alert) {
+			continue
+		}
+
+		delivery := models.WebhookDelivery{
+			ID:             primitive.NewObjectID(),
+			WebhookID:      webhook.ID,
+			OrganizationID: webhook.OrganizationID,
+			AlertID:        alert.ID,
+			Event:          WebhookEventAlertCreated,
+			Status:         models.DeliveryPending,
+			NextAttemptAt:  now,
+			CreatedAt:      now,
+		}
+		payload, err := json.Marshal(map[string]interface{}{
+			"id":         delivery.ID.Hex(),
+			"event":      delivery.Event,
+			"created_at": now,
+			"alert":      alert,
+		})
+		if err != nil {
+			log.Printf("Ошибка сериализации тревоги для вебхука: %v", err)
+			return
+		}
+		delivery.Payload = string(payload)
+
+		if _, err := s.Deliveries.InsertOne(ctx, delivery); err != nil {
+			log.Printf("Ошибка постановки доставки вебхука в очередь: %v", err)
+		}
+	}
+}
+
+// Run processes due deliveries until the context is cancelled.
+func (s *WebhookService) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		for s.ProcessNext() {
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// ProcessNext claims one due delivery and attempts it. It returns false when
+// the queue has nothing due.
+func (s *WebhookService) ProcessNext() bool {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	var delivery models.WebhookDelivery
+	err := s.Deliveries.FindOneAndUpdate(ctx,
+		bson.M{"status": models.DeliveryPending, "nextAttemptAt": bson.M{"$lte": now}},
+		bson.M{"$set": bson.M{"nextAttemptAt": now.Add(s.Lease)}},
+		options.FindOneAndUpdate().SetSort(bson.D{{Key: "nextAttemptAt", Value: 1}}),
+	).Decode(&delivery)
+	if err != nil {
+		if err != mongo.ErrNoDocuments {
+			log.Printf("Ошибка чтения очереди вебхуков: %v", err)
+		}
+		return false
+	}
+
+	var webhook models.Webhook
+	if err := s.Collection.FindOne(ctx, bson.M{"_id": delivery.WebhookID}).Decode(&webhook); err != nil {
+		s.finish(ctx, &delivery, 0, errors.New("вебхук удален"), true)
+		return true
+	}
+	if !webhook.Enabled {
+		s.finish(ctx, &delivery, 0, errors.New("вебхук отключен"), true)
+		return true
+	}
+
+	statusCode, err := s.send(ctx, &webhook, &delivery)
+	s.finish(ctx, &delivery, statusCode, err, false)
+	return true
+}
+
+func (s *WebhookService) send(ctx context.Context, webhook *models.Webhook, delivery *models.WebhookDelivery) (int, error) {
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook.URL, strings.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Webhook-Event", delivery.Event)
+	req.Header.Set("X-Webhook-Delivery", delivery.ID.Hex())
+	req.Header.Set("X-Webhook-Timestamp", timestamp)
+	req.Header.Set("X-Webhook-Signature", "sha256="+SignWebhookPayload(webhook.Secret, timestamp, []byte(delivery.Payload)))
+
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("получен статус %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// finish records the outcome of an attempt: success, a retry after backoff,
+// or the dead-letter list once attempts run out.
+func (s *WebhookService) finish(ctx context.Context, delivery *models.WebhookDelivery, statusCode int, sendErr error, dead bool) {
+	now := time.Now()
+	attempts := delivery.Attempts + 1
+	set := bson.M{"attempts": attempts, "lastStatusCode": statusCode}
+
+	switch {
+	case sendErr == nil:
+		set["status"] = models.DeliveryDelivered
+		set["deliveredAt"] = now
+		set["lastError"] = ""
+	case dead || attempts >= s.MaxAttempts:
+		set["status"] = models.DeliveryDead
+		set["lastError"] = sendErr.Error()
+	default:
+		set["nextAttemptAt"] = now.Add(s.Backoff(attempts))
+		set["lastError"] = sendErr.Error()
+	}
+
+	if _, err := s.Deliveries.UpdateOne(ctx, bson.M{"_id": delivery.ID}, bson.M{"$set": set}); err != nil {
+		log.Printf("Ошибка обновления доставки вебхука: %v", err)
+	}
+}
+
+// Backoff returns the wait before the next attempt: BaseBackoff doubled for
+// every failed attempt, capped at MaxBackoff.
+func (s *WebhookService) Backoff(attempts int) time.Duration {
+	backoff := s.BaseBackoff
+	for i := 1; i < attempts && backoff < s.MaxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > s.MaxBackoff {
+		backoff = s.MaxBackoff
+	}
+	return backoff
+}
+
+// GetDeliveries returns the delivery log of the organization, newest first.
+func (s *WebhookService) GetDeliveries(scope AccessScope, filter WebhookDeliveryFilter, limit int64) ([]models.WebhookDelivery, error) {
+	query := scope.Filter(bson.M{})
+	if filter.WebhookID != "" {
+		webhookID, err := primitive.ObjectIDFromHex(filter.WebhookID)
+		if err != nil {
+			return nil, errors.New("некорректный ID вебхука")
+		}
+		query["webhookId"] = webhookID
+	}
+	if filter.Status != "" {
+		query["status"] = filter.Status
+	}
+	if limit < 1 || limit > 500 {
+		limit = 100
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	cursor, err := s.Deliveries.Find(ctx, query, options.Find().SetSort(bson.D{{Key: "createdAt", Value: -1}}).SetLimit(limit))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	deliveries := []models.WebhookDelivery{}
+	if err := cursor.All(ctx, &deliveries); err != nil {
+		return nil, err
+	}
+
+	return deliveries, nil
+}
+
+// RetryDelivery moves a dead delivery back into the queue.
+func (s *WebhookService) RetryDelivery(scope AccessScope, id string) error {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return errors.New("некорректный ID доставки")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	result, err := s.Deliveries.UpdateOne(ctx,
+		scope.Filter(bson.M{"_id": objID, "status": models.DeliveryDead}),
+		bson.M{"$set": bson.M{"status": models.DeliveryPending, "attempts": 0, "nextAttemptAt": time.Now()}},
+	)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return errors.New("доставка не найдена в списке недоставленных")
+	}
+	return nil
+}
+
+// SignWebhookPayload returns the hex HMAC-SHA256 of "<timestamp>.<body>".
+// Receivers recompute it with the webhook secret and reject stale timestamps.
+func SignWebhookPayload(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,182 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"backend/config"
+	"backend/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type ZoneService struct {
+	Collection *mongo.Collection
+}
+
+func NewZoneService() *ZoneService {
+	return &ZoneService{
+		Collection: config.GetCollection("zones"),
+	}
+}
+
+func (s *ZoneService) GetZonesByCamera(scope AccessScope, cameraID string) ([]models.Zone, error) {
+	camID, err := primitive.ObjectIDFromHex(cameraID)
+	if err != nil {
+		return nil, errors.New("некорректный ID камеры")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	cursor, err := s.Collection.Find(ctx, scope.Filter(bson.M{"cameraId": camID}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	zones := []models.Zone{}
+	if err := cursor.All(ctx, &zones); err != nil {
+		return nil, err
+	}
+
+	return zones, nil
+}
+
+func (s *ZoneService) CreateZone(scope AccessScope, cameraID string, zone *models.Zone) (*models.Zone, error) {
+	camID, err := primitive.ObjectIDFromHex(cameraID)
+	if err != nil {
+		return nil, errors.New("некорректный ID камеры")
+	}
+	if err := ValidateZone(zone); err != nil {
+		return nil, err
+	}
+
+	zone.ID = primitive.NewObjectID()
+	zone.CameraID = camID
+	zone.OrganizationID = scope.OrganizationID
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err = s.Collection.InsertOne(ctx, zone)
+	if err != nil {
+		return nil, err
+	}
+
+	return zone, nil
+}
+
+func (s *ZoneService) UpdateZone(scope AccessScope, cameraID, id string, zone *models.Zone) (*models.Zone, error) {
+	camID, err := primitive.ObjectIDFromHex(cameraID)
+	if err != nil {
+		return nil, errors.New("некорректный ID камеры")
+	}
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, errors.New("некорректный ID зоны")
+	}
+	if err := ValidateZone(zone); err != nil {
+		return nil, err
+	}
+
+	zone.ID = objID
+	zone.CameraID = camID
+	zone.OrganizationID = scope.OrganizationID
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	result, err := s.Collection.ReplaceOne(ctx, scope.Filter(bson.M{"_id": objID, "cameraId": camID}), zone)
+	if err != nil {
+		return nil, err
+	}
+	if result.MatchedCount == 0 {
+		return nil, errors.New("зона не найдена")
+	}
+
+	return zone, nil
+}
+
+func (s *ZoneService) DeleteZone(scope AccessScope, cameraID, id string) error {
+	camID, err := primitive.ObjectIDFromHex(cameraID)
+	if err != nil {
+		return errors.New("некорректный ID камеры")
+	}
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return errors.New("некорректный ID зоны")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err = s.Collection.DeleteOne(ctx, scope.Filter(bson.M{"_id": objID, "cameraId": camID}))
+	return err
+}
+
+// ValidateZone checks that a zone has a name, a known type and a polygon of at
+// least three points inside the normalized frame.
+func ValidateZone(zone *models.Zone) error {
+	if zone.Name == "" {
+		return errors.New("не указано название зоны")
+	}
+	if zone.Type != models.ZoneTypeInclude && zone.Type != models.ZoneTypeExclude {
+		return errors.New("тип зоны должен быть include или exclude")
+	}
+	if len(zone.Polygon) < 3 {
+		return errors.New("полигон зоны должен содержать не менее трех точек")
+	}
+	for _, p := range zone.Polygon {
+		if p.X < 0 || p.X > 1 || p.Y < 0 || p.Y > 1 {
+			return errors.New("координаты зоны должны быть в диапазоне от 0 до 1")
+		}
+	}
+	return nil
+}
+
+// MatchZones applies camera zones to a detection point. Exclude zones take
+// precedence; when a camera has include zones, a detection outside all of
+// them is ignored. It returns the zone that fired (nil if the camera has no
+// zone covering the point) and whether the detection should raise an alert.
+func MatchZones(zones []models.Zone, p models.Point) (*models.Zone, bool) {
+	var included *models.Zone
+	hasInclude := false
+
+	for i := range zones {
+		zone := &zones[i]
+		inside := PointInPolygon(p, zone.Polygon)
+		switch zone.Type {
+		case models.ZoneTypeExclude:
+			if inside {
+				return zone, false
+			}
+		case models.ZoneTypeInclude:
+			hasInclude = true
+			if inside && included == nil {
+				included = zone
+			}
+		}
+	}
+
+	if hasInclude && included == nil {
+		return nil, false
+	}
+	return included, true
+}
+
+// PointInPolygon reports whether p lies inside the polygon using ray casting.
+func PointInPolygon(p models.Point, polygon []models.Point) bool {
+	inside := false
+	for i, j := 0, len(polygon)-1; i < len(polygon); j, i = i, i+1 {
+		a, b := polygon[i], polygon[j]
+		if (a.Y > p.Y) != (b.Y > p.Y) &&
+			p.X < (b.X-a.X)*(p.Y-a.Y)/(b.Y-a.Y)+a.X {
+			inside = !inside
+		}
+	}
+	return inside
+}
@@ -0,0 +1,102 @@
+package controllers_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"backend/config"
+	"backend/controllers"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestLoginMFALockout(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	gin.SetMode(gin.TestMode)
+	os.Setenv("DATABASE_NAME", "test")
+	config.Auth = &config.AuthConfig{SigningMethod: jwt.SigningMethodHS256, Secret: []byte("test-secret"), AccessTokenTTL: time.Minute}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	require.NoError(t, err)
+	userID, orgID := primitive.NewObjectID(), primitive.NewObjectID()
+	userDoc := bson.D{
+		{Key: "_id", Value: userID},
+		{Key: "username", Value: "guard"},
+		{Key: "password", Value: string(hash)},
+		{Key: "memberships", Value: bson.A{bson.D{{Key: "organizationId", Value: orgID}, {Key: "role", Value: "operator"}}}},
+		{Key: "totpEnabled", Value: true},
+		{Key: "totpSecret", Value: "JBSWY3DPEHPK3PXP"},
+	}
+	noAttempts := func() bson.D { return mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch) }
+	post := func(router *gin.Engine, path string, body gin.H) *httptest.ResponseRecorder {
+		data, _ := json.Marshal(body)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, path, bytes.NewReader(data)))
+		return w
+	}
+
+	mt.Run("password logins do not reset failed codes", func(mt *mtest.T) {
+		config.DB = mt.Client
+		auth := controllers.NewAuthController()
+		router := gin.New()
+		router.POST("/login", auth.Login)
+		router.POST("/login/mfa", auth.LoginMFA)
+
+		for failures := 1; failures <= 5; failures++ {
+			mt.AddMockResponses(noAttempts(), mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch, userDoc))
+			w := post(router, "/login", gin.H{"username": "guard", "password": "secret"})
+			require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+			var step struct {
+				MFAToken string `json:"mfa_token"`
+			}
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &step))
+			require.NotEmpty(t, step.MFAToken)
+
+			// The user, the limits, the user again for the code, then the
+			// username and IP counters.
+			mt.AddMockResponses(
+				mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch, userDoc),
+				noAttempts(),
+				mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch, userDoc),
+				bson.D{{Key: "ok", Value: 1}, {Key: "value", Value: bson.D{{Key: "_id", Value: "user:guard"}, {Key: "failures", Value: failures}}}},
+			)
+			if failures == 5 {
+				mt.AddMockResponses(mtest.CreateSuccessResponse())
+			}
+			mt.AddMockResponses(bson.D{{Key: "ok", Value: 1}, {Key: "value", Value: bson.D{{Key: "_id", Value: "ip:192.0.2.1"}, {Key: "failures", Value: failures}}}})
+			w = post(router, "/login/mfa", gin.H{"mfa_token": step.MFAToken, "code": "not-a-code"})
+			require.Equal(t, http.StatusUnauthorized, w.Code, w.Body.String())
+		}
+
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch, bson.D{
+			{Key: "_id", Value: "user:guard"},
+			{Key: "failures", Value: 5},
+			{Key: "lockedUntil", Value: time.Now().Add(time.Minute)},
+		}))
+		w := post(router, "/login", gin.H{"username": "guard", "password": "secret"})
+		assert.Equal(t, http.StatusTooManyRequests, w.Code)
+
+		locked := false
+		for _, event := range mt.GetAllStartedEvents() {
+			assert.NotEqual(t, "delete", event.CommandName, "the failure counter was reset")
+			if event.CommandName == "update" {
+				update := event.Command.Lookup("updates").Array().Index(0).Value().Document()
+				_, err := update.LookupErr("u", "$set", "lockedUntil")
+				locked = locked || err == nil
+			}
+		}
+		assert.True(t, locked)
+	})
+}
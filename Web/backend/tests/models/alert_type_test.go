@@ -0,0 +1,42 @@
+package models_test
+
+import (
+	"backend/models"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMergeAlertTypes(t *testing.T) {
+	catalog := models.MergeAlertTypes([]models.AlertTypeDefinition{
+		{Key: "Weapon", DisplayName: "Оружие", DefaultSeverity: models.SeverityCritical, ModelClasses: []string{"gun", "knife"}},
+		{Key: models.AlertTypeFire, DisplayName: "Возгорание", DefaultSeverity: models.SeverityHigh, Disabled: true},
+	})
+
+	assert.Len(t, catalog, len(models.BuiltinAlertTypes)+1)
+	assert.Equal(t, "Возгорание", catalog[0].DisplayName)
+	assert.True(t, catalog[0].BuiltIn)
+	assert.False(t, catalog[len(catalog)-1].BuiltIn)
+
+	assert.Nil(t, models.FindAlertType(catalog, models.AlertTypeFire, ""))
+	assert.Nil(t, models.FindAlertType(catalog, "Unknown", ""))
+	assert.Equal(t, models.AlertTypeFight, models.FindAlertType(catalog, models.AlertTypeFight, "").Key)
+	assert.Equal(t, models.AlertType("Weapon"), models.FindAlertType(catalog, "", "KNIFE").Key)
+	// Classes of a disabled type map to nothing.
+	assert.Nil(t, models.FindAlertType(catalog, "", "smoke"))
+}
+
+func TestAlertTypeNotifies(t *testing.T) {
+	everyone := &models.NotificationPreferences{}
+	fireOnly := &models.NotificationPreferences{AlertTypes: []models.AlertType{models.AlertTypeFire}}
+
+	subscribers := models.AlertTypeDefinition{Key: models.AlertTypeFire}
+	assert.True(t, subscribers.Notifies(everyone))
+
+	optIn := models.AlertTypeDefinition{Key: models.AlertTypeFire, NotificationPolicy: models.NotifyOptIn}
+	assert.False(t, optIn.Notifies(everyone))
+	assert.True(t, optIn.Notifies(fireOnly))
+
+	none := models.AlertTypeDefinition{Key: models.AlertTypeFire, NotificationPolicy: models.NotifyNone}
+	assert.False(t, none.Notifies(fireOnly))
+}
@@ -0,0 +1,36 @@
+package models_test
+
+import (
+	"backend/models"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQuietHours(t *testing.T) {
+	at := func(hour, minute int) time.Time {
+		return time.Date(2024, 1, 1, hour, minute, 0, 0, time.UTC)
+	}
+
+	night := models.QuietHours{Start: "22:00", End: "07:00"}
+	assert.True(t, night.Contains(at(23, 30)))
+	assert.True(t, night.Contains(at(6, 59)))
+	assert.False(t, night.Contains(at(7, 0)))
+	assert.False(t, night.Contains(at(12, 0)))
+
+	lunch := models.QuietHours{Start: "13:00", End: "14:00", Timezone: "Europe/Moscow"}
+	assert.True(t, lunch.Contains(at(10, 30)))
+	assert.False(t, lunch.Contains(at(13, 30)))
+
+	broken := models.QuietHours{Start: "late", End: "07:00"}
+	assert.False(t, broken.Contains(at(23, 0)))
+}
+
+func TestNotificationPreferencesMatch(t *testing.T) {
+	prefs := models.NotificationPreferences{MinSeverity: models.SeverityHigh}
+
+	assert.True(t, prefs.Matches(&models.Alert{Severity: models.SeverityCritical}))
+	assert.False(t, prefs.Matches(&models.Alert{Severity: models.SeverityMedium}))
+	assert.False(t, prefs.Matches(&models.Alert{}))
+}
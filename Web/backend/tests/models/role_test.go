@@ -0,0 +1,28 @@
+package models_test
+
+import (
+	"backend/models"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRolePermissions(t *testing.T) {
+	assert.True(t, models.RoleAdmin.Can(models.PermBuildingsDelete))
+	assert.True(t, models.RoleAdmin.Can(models.PermUsersManage))
+	assert.False(t, models.RoleAdmin.Can(models.PermAuditReadAll))
+
+	assert.True(t, models.RoleSupervisor.Can(models.PermDetectionConfigure))
+	assert.False(t, models.RoleSupervisor.Can(models.PermBuildingsDelete))
+
+	assert.True(t, models.RoleOperator.Can(models.PermRecordingsView))
+	assert.False(t, models.RoleOperator.Can(models.PermDetectionConfigure))
+
+	assert.True(t, models.RoleViewer.Can(models.PermAlertsRead))
+	assert.False(t, models.RoleViewer.Can(models.PermStreamsView))
+	assert.False(t, models.RoleViewer.Can(models.PermRecordingsView))
+
+	assert.False(t, models.Role("user").Can(models.PermAlertsRead))
+	assert.False(t, models.Role("user").Valid())
+	assert.True(t, models.RoleOperator.Valid())
+}
@@ -0,0 +1,24 @@
+package models_test
+
+import (
+	"backend/models"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestWebhookMatches(t *testing.T) {
+	buildingID := primitive.NewObjectID()
+	webhook := models.Webhook{
+		AlertTypes:  []models.AlertType{models.AlertTypeFire},
+		BuildingIDs: []primitive.ObjectID{buildingID},
+	}
+
+	assert.True(t, webhook.Matches(&models.Alert{AlertType: models.AlertTypeFire, BuildingID: buildingID}))
+	assert.False(t, webhook.Matches(&models.Alert{AlertType: models.AlertTypeFight, BuildingID: buildingID}))
+	assert.False(t, webhook.Matches(&models.Alert{AlertType: models.AlertTypeFire, BuildingID: primitive.NewObjectID()}))
+
+	webhook.Severities = []string{models.SeverityCritical}
+	assert.False(t, webhook.Matches(&models.Alert{AlertType: models.AlertTypeFire, BuildingID: buildingID, Severity: models.SeverityLow}))
+}
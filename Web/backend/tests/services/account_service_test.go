@@ -0,0 +1,244 @@
+package services_test
+
+import (
+	"backend/models"
+	"backend/services"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestFileMailer(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mail.log")
+	mailer := &services.FileMailer{Path: path}
+
+	err := mailer.Send(context.Background(), services.MailMessage{To: []string{"ivan@example.com"}, Subject: "Сброс пароля", Body: "ссылка"})
+	assert.Nil(t, err)
+
+	content, _ := os.ReadFile(path)
+	assert.Contains(t, string(content), "To: ivan@example.com")
+	assert.Contains(t, string(content), "Subject: =?utf-8?q?")
+	assert.Contains(t, string(content), "ссылка")
+}
+
+func TestHashPassword(t *testing.T) {
+	_, err := services.HashPassword("short")
+	assert.NotNil(t, err)
+
+	hash, err := services.HashPassword("long enough")
+	assert.Nil(t, err)
+	assert.True(t, strings.HasPrefix(hash, "$2"))
+}
+
+func TestAcceptInvitation(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	orgID := primitive.NewObjectID()
+
+	invitation := bson.D{
+		{Key: "_id", Value: primitive.NewObjectID()},
+		{Key: "purpose", Value: models.AccountTokenInvite},
+		{Key: "email", Value: "ivan@example.com"},
+		{Key: "organizationId", Value: orgID},
+		{Key: "role", Value: "operator"},
+		{Key: "expiresAt", Value: time.Now().Add(time.Hour)},
+	}
+
+	mt.Run("valid invitation", func(mt *mtest.T) {
+		mt.AddMockResponses(
+			bson.D{{Key: "ok", Value: 1}, {Key: "value", Value: invitation}},
+			mtest.CreateSuccessResponse(),
+		)
+
+		service := services.AccountService{Tokens: mt.Coll, Users: &services.UserService{Collection: mt.Coll}}
+		user, err := service.AcceptInvitation("token", "ivan", "password123")
+
+		assert.Nil(t, err)
+		assert.Equal(t, models.RoleOperator, user.RoleIn(orgID))
+		assert.Equal(t, "ivan@example.com", user.Email)
+		assert.Equal(t, []primitive.ObjectID{orgID}, user.OrganizationIDs())
+	})
+
+	mt.Run("used or expired token", func(mt *mtest.T) {
+		mt.AddMockResponses(bson.D{{Key: "ok", Value: 1}, {Key: "value", Value: nil}})
+
+		service := services.AccountService{Tokens: mt.Coll, Users: &services.UserService{Collection: mt.Coll}}
+		_, err := service.AcceptInvitation("token", "ivan", "password123")
+
+		assert.Equal(t, services.ErrInvalidAccountToken, err)
+	})
+
+	mt.Run("taken username gives the invitation back", func(mt *mtest.T) {
+		mt.AddMockResponses(
+			bson.D{{Key: "ok", Value: 1}, {Key: "value", Value: invitation}},
+			mtest.CreateWriteErrorsResponse(mtest.WriteError{Index: 0, Code: 11000, Message: "E11000 duplicate key error collection: users index: username_1"}),
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}),
+		)
+
+		service := services.AccountService{Tokens: mt.Coll, Users: &services.UserService{Collection: mt.Coll}}
+		_, err := service.AcceptInvitation("token", "ivan", "password123")
+
+		assert.ErrorIs(t, err, services.ErrUserExists)
+		events := mt.GetAllStartedEvents()
+		update := events[len(events)-1].Command.Lookup("updates").Array().Index(0).Value().Document()
+		assert.NotNil(t, update.Lookup("u", "$unset", "usedAt"))
+	})
+}
+
+func TestEmailChange(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	userID := primitive.NewObjectID()
+
+	mt.Run("link goes to the new address", func(mt *mtest.T) {
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch, bson.D{
+				{Key: "_id", Value: userID},
+				{Key: "username", Value: "ivan"},
+				{Key: "email", Value: "ivan@example.com"},
+			}),
+			mtest.CreateSuccessResponse(),
+		)
+
+		mailer := &recordingMailer{}
+		service := services.AccountService{Tokens: mt.Coll, Users: &services.UserService{Collection: mt.Coll}, Mailer: mailer, EmailChangeTTL: time.Hour}
+		err := service.RequestEmailChange(userID.Hex(), " ivan@new.example.com ")
+
+		assert.Nil(t, err)
+		assert.Len(t, mailer.messages, 1)
+		assert.Equal(t, []string{"ivan@new.example.com"}, mailer.messages[0].To)
+
+		events := mt.GetAllStartedEvents()
+		assert.Len(t, events, 2)
+		assert.Equal(t, "insert", events[1].CommandName)
+	})
+
+	mt.Run("invalid address", func(mt *mtest.T) {
+		service := services.AccountService{Tokens: mt.Coll, Users: &services.UserService{Collection: mt.Coll}, Mailer: &recordingMailer{}}
+
+		for _, email := range []string{"ivan", "Ivan <ivan@example.com>", "ivan@"} {
+			assert.NotNil(t, service.RequestEmailChange(userID.Hex(), email), email)
+		}
+		assert.Empty(t, mt.GetAllStartedEvents())
+	})
+
+	mt.Run("confirmation sets the email", func(mt *mtest.T) {
+		mt.AddMockResponses(
+			bson.D{{Key: "ok", Value: 1}, {Key: "value", Value: bson.D{
+				{Key: "_id", Value: primitive.NewObjectID()},
+				{Key: "purpose", Value: models.AccountTokenEmailChange},
+				{Key: "email", Value: "ivan@new.example.com"},
+				{Key: "userId", Value: userID},
+			}}},
+			bson.D{{Key: "ok", Value: 1}, {Key: "value", Value: bson.D{
+				{Key: "_id", Value: userID},
+				{Key: "username", Value: "ivan"},
+				{Key: "email", Value: "ivan@new.example.com"},
+			}}},
+		)
+
+		service := services.AccountService{Tokens: mt.Coll, Users: &services.UserService{Collection: mt.Coll}}
+		user, err := service.ConfirmEmailChange("token")
+
+		assert.Nil(t, err)
+		assert.Equal(t, "ivan@new.example.com", user.Email)
+		events := mt.GetAllStartedEvents()
+		assert.Equal(t, models.AccountTokenEmailChange, events[0].Command.Lookup("query", "purpose").StringValue())
+		assert.Equal(t, userID, events[1].Command.Lookup("query", "_id").ObjectID())
+	})
+
+	mt.Run("address taken in the meantime", func(mt *mtest.T) {
+		mt.AddMockResponses(
+			bson.D{{Key: "ok", Value: 1}, {Key: "value", Value: bson.D{
+				{Key: "_id", Value: primitive.NewObjectID()},
+				{Key: "purpose", Value: models.AccountTokenEmailChange},
+				{Key: "email", Value: "ivan@new.example.com"},
+				{Key: "userId", Value: userID},
+			}}},
+			mtest.CreateCommandErrorResponse(mtest.CommandError{Code: 11000, Message: "E11000 duplicate key error collection: users index: email_1"}),
+		)
+
+		service := services.AccountService{Tokens: mt.Coll, Users: &services.UserService{Collection: mt.Coll}}
+		_, err := service.ConfirmEmailChange("token")
+
+		assert.Equal(t, services.ErrEmailInUse, err)
+	})
+}
+
+func TestJoinOrganization(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	userID, memberOf, orgID := primitive.NewObjectID(), primitive.NewObjectID(), primitive.NewObjectID()
+	userDoc := bson.D{
+		{Key: "_id", Value: userID},
+		{Key: "username", Value: "ivan"},
+		{Key: "email", Value: "ivan@example.com"},
+		{Key: "memberships", Value: bson.A{bson.D{{Key: "organizationId", Value: memberOf}, {Key: "role", Value: "admin"}}}},
+	}
+
+	mt.Run("invitation to the user's email", func(mt *mtest.T) {
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch, userDoc),
+			bson.D{{Key: "ok", Value: 1}, {Key: "value", Value: bson.D{
+				{Key: "_id", Value: primitive.NewObjectID()},
+				{Key: "purpose", Value: models.AccountTokenInvite},
+				{Key: "email", Value: "ivan@example.com"},
+				{Key: "organizationId", Value: orgID},
+				{Key: "role", Value: "viewer"},
+			}}},
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}),
+		)
+
+		service := services.AccountService{Tokens: mt.Coll, Users: &services.UserService{Collection: mt.Coll}}
+		user, err := service.JoinOrganization("token", userID.Hex())
+
+		assert.Nil(t, err)
+		assert.Equal(t, models.RoleAdmin, user.RoleIn(memberOf))
+		assert.Equal(t, models.RoleViewer, user.RoleIn(orgID))
+
+		events := mt.GetAllStartedEvents()
+		query := events[1].Command.Lookup("query").Document()
+		assert.Equal(t, "ivan@example.com", query.Lookup("email").StringValue())
+	})
+
+	mt.Run("invitation to another email", func(mt *mtest.T) {
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch, userDoc),
+			bson.D{{Key: "ok", Value: 1}, {Key: "value", Value: nil}},
+		)
+
+		service := services.AccountService{Tokens: mt.Coll, Users: &services.UserService{Collection: mt.Coll}}
+		_, err := service.JoinOrganization("token", userID.Hex())
+
+		assert.Equal(t, services.ErrInvalidAccountToken, err)
+		assert.Len(t, mt.GetAllStartedEvents(), 2)
+	})
+}
+
+type recordingMailer struct {
+	messages []services.MailMessage
+}
+
+func (m *recordingMailer) Send(_ context.Context, msg services.MailMessage) error {
+	m.messages = append(m.messages, msg)
+	return nil
+}
+
+func TestRequestPasswordResetUnknownEmail(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	mt.Run("no mail sent", func(mt *mtest.T) {
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch))
+
+		mailer := &recordingMailer{}
+		service := services.AccountService{Tokens: mt.Coll, Users: &services.UserService{Collection: mt.Coll}, Mailer: mailer}
+
+		assert.Nil(t, service.RequestPasswordReset("nobody@example.com"))
+		assert.Empty(t, mailer.messages)
+	})
+}
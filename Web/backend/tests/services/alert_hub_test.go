@@ -0,0 +1,230 @@
+package services_test
+
+import (
+	"backend/models"
+	"backend/services"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"golang.org/x/net/websocket"
+)
+
+func serveAlertHub(t *testing.T, hub *services.AlertHub, scope services.AccessScope) string {
+	service := &services.AlertService{Hub: hub}
+	server := httptest.NewServer(websocket.Handler(func(conn *websocket.Conn) {
+		service.HandleWebSocket(scope, models.AlertStreamFilter{}, conn)
+	}))
+	t.Cleanup(server.Close)
+	return server.URL
+}
+
+// subscribeAll connects a client subscribed to every alert.
+func subscribeAll(url string) (*websocket.Conn, error) {
+	conn, err := websocket.Dial("ws"+strings.TrimPrefix(url, "http"), "", url)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(time.Now().Add(30 * time.Second))
+
+	var reply socketMessage
+	if err := websocket.JSON.Receive(conn, &reply); err != nil {
+		return nil, err
+	}
+	if err := websocket.JSON.Send(conn, bson.M{"type": "subscribe", "id": "all"}); err != nil {
+		return nil, err
+	}
+	if err := websocket.JSON.Receive(conn, &reply); err != nil {
+		return nil, err
+	}
+	return conn, nil
+}
+
+func TestAlertHubFanOut(t *testing.T) {
+	const clients, publishers, alertsPerPublisher = 200, 4, 25
+
+	scope := services.AccessScope{OrganizationID: primitive.NewObjectID()}
+	hub := &services.AlertHub{}
+	url := serveAlertHub(t, hub, scope)
+
+	conns := make([]*websocket.Conn, clients)
+	var connect sync.WaitGroup
+	for i := range conns {
+		connect.Add(1)
+		go func(i int) {
+			defer connect.Done()
+			conn, err := subscribeAll(url)
+			assert.NoError(t, err)
+			conns[i] = conn
+		}(i)
+	}
+	connect.Wait()
+	for _, conn := range conns {
+		require.NotNil(t, conn)
+		defer conn.Close()
+	}
+	require.Equal(t, clients, hub.Clients())
+
+	received := make([]int, clients)
+	var read sync.WaitGroup
+	for i, conn := range conns {
+		read.Add(1)
+		go func(i int, conn *websocket.Conn) {
+			defer read.Done()
+			seen := make(map[primitive.ObjectID]bool)
+			for len(seen) < publishers*alertsPerPublisher {
+				var msg socketMessage
+				if err := websocket.JSON.Receive(conn, &msg); err != nil {
+					return
+				}
+				if msg.Type == "alert" {
+					seen[msg.Alert.ID] = true
+				}
+			}
+			received[i] = len(seen)
+		}(i, conn)
+	}
+
+	var publish sync.WaitGroup
+	for p := 0; p < publishers; p++ {
+		publish.Add(1)
+		go func() {
+			defer publish.Done()
+			for i := 0; i < alertsPerPublisher; i++ {
+				hub.Broadcast(&models.Alert{ID: primitive.NewObjectID(), OrganizationID: scope.OrganizationID, AlertType: models.AlertTypeFire})
+			}
+		}()
+	}
+	publish.Wait()
+	read.Wait()
+
+	for i := range received {
+		assert.Equal(t, publishers*alertsPerPublisher, received[i], "client %d", i)
+	}
+}
+
+func TestAlertHubEvictsSlowConsumer(t *testing.T) {
+	const alerts = 300
+
+	scope := services.AccessScope{OrganizationID: primitive.NewObjectID()}
+	hub := &services.AlertHub{QueueSize: 8, WriteTimeout: 200 * time.Millisecond}
+	url := serveAlertHub(t, hub, scope)
+
+	slow, err := subscribeAll(url)
+	require.NoError(t, err)
+	defer slow.Close()
+	fast, err := subscribeAll(url)
+	require.NoError(t, err)
+	defer fast.Close()
+
+	delivered := make(chan struct{})
+	go func() {
+		for {
+			var msg socketMessage
+			if websocket.JSON.Receive(fast, &msg) != nil {
+				close(delivered)
+				return
+			}
+			if msg.Type == "alert" {
+				delivered <- struct{}{}
+			}
+		}
+	}()
+
+	// Large alerts fill the slow client's socket buffers quickly.
+	zoneName := strings.Repeat("x", 64<<10)
+	for i := 0; i < alerts; i++ {
+		hub.Broadcast(&models.Alert{ID: primitive.NewObjectID(), OrganizationID: scope.OrganizationID, ZoneName: zoneName})
+		select {
+		case _, ok := <-delivered:
+			require.True(t, ok, "fast client was disconnected after %d alerts", i)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("alert %d was not delivered to the fast client", i)
+		}
+	}
+
+	assert.Eventually(t, func() bool { return hub.Clients() == 1 }, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestAlertHubActivity(t *testing.T) {
+	scope := services.AccessScope{OrganizationID: primitive.NewObjectID()}
+	hub := &services.AlertHub{}
+	url := serveAlertHub(t, hub, scope)
+
+	conn, err := subscribeAll(url)
+	require.NoError(t, err)
+	defer conn.Close()
+	require.Eventually(t, func() bool { return hub.Clients() == 1 }, 5*time.Second, 10*time.Millisecond)
+
+	other := &models.Alert{ID: primitive.NewObjectID(), OrganizationID: primitive.NewObjectID(), Tags: []string{"drill"}}
+	hub.BroadcastActivity(&models.AlertActivity{Type: models.ActivityTags, Alert: other})
+
+	alert := &models.Alert{ID: primitive.NewObjectID(), OrganizationID: scope.OrganizationID}
+	hub.BroadcastActivity(&models.AlertActivity{Type: models.ActivityTags, Alert: alert})
+	comment := &models.AlertComment{ID: primitive.NewObjectID(), AlertID: alert.ID, AuthorName: "operator", Body: "police called"}
+	hub.BroadcastActivity(&models.AlertActivity{Type: models.ActivityComment, Alert: alert, Comment: comment})
+
+	// The alert of another organization is not sent.
+	var msg socketMessage
+	require.NoError(t, websocket.JSON.Receive(conn, &msg))
+	assert.Equal(t, "tags", msg.Type)
+	assert.Equal(t, alert.ID.Hex(), msg.AlertID)
+	assert.Equal(t, []string{}, msg.Tags)
+	assert.Equal(t, []string{"all"}, msg.Subscriptions)
+
+	msg = socketMessage{}
+	require.NoError(t, websocket.JSON.Receive(conn, &msg))
+	assert.Equal(t, "comment", msg.Type)
+	require.NotNil(t, msg.Comment)
+	assert.Equal(t, comment.ID, msg.Comment.ID)
+	assert.Equal(t, "police called", msg.Comment.Body)
+}
+
+func TestAlertHubDefaultSubscription(t *testing.T) {
+	scope := services.AccessScope{OrganizationID: primitive.NewObjectID()}
+	hub := &services.AlertHub{}
+	service := &services.AlertService{Hub: hub}
+	server := httptest.NewServer(websocket.Handler(func(conn *websocket.Conn) {
+		service.HandleWebSocket(scope, models.AlertStreamFilter{MinSeverity: models.SeverityHigh}, conn)
+	}))
+	defer server.Close()
+
+	conn, err := websocket.Dial("ws"+strings.TrimPrefix(server.URL, "http"), "", server.URL)
+	require.NoError(t, err)
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	var msg socketMessage
+	require.NoError(t, websocket.JSON.Receive(conn, &msg))
+	require.Equal(t, "hello", msg.Type)
+
+	alert := func(alertType models.AlertType, severity string) *models.Alert {
+		return &models.Alert{ID: primitive.NewObjectID(), OrganizationID: scope.OrganizationID, AlertType: alertType, Severity: severity}
+	}
+
+	// Without subscribing the client receives what the URL filter selects.
+	hub.Broadcast(alert(models.AlertTypeIntrusion, models.SeverityLow))
+	high := alert(models.AlertTypeIntrusion, models.SeverityHigh)
+	hub.Broadcast(high)
+	require.NoError(t, websocket.JSON.Receive(conn, &msg))
+	assert.Equal(t, high.ID, msg.Alert.ID)
+	assert.Equal(t, []string{"default"}, msg.Subscriptions)
+
+	// Its own subscription replaces the default one.
+	require.NoError(t, websocket.JSON.Send(conn, bson.M{"type": "subscribe", "id": "fire", "filter": bson.M{"alert_types": bson.A{"Fire"}}}))
+	require.NoError(t, websocket.JSON.Receive(conn, &msg))
+	require.Equal(t, "subscribed", msg.Type)
+
+	hub.Broadcast(alert(models.AlertTypeIntrusion, models.SeverityCritical))
+	fire := alert(models.AlertTypeFire, models.SeverityLow)
+	hub.Broadcast(fire)
+	require.NoError(t, websocket.JSON.Receive(conn, &msg))
+	assert.Equal(t, fire.ID, msg.Alert.ID)
+	assert.Equal(t, []string{"fire"}, msg.Subscriptions)
+}
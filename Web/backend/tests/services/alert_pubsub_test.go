@@ -0,0 +1,87 @@
+package services_test
+
+import (
+	"backend/models"
+	"backend/services"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestMemoryPubSubFeedsStreams(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	mt.Run("delivers through the subscriber", func(mt *mtest.T) {
+		mt.AddMockResponses(mtest.CreateSuccessResponse())
+		scope := services.AccessScope{OrganizationID: primitive.NewObjectID()}
+		service := &services.AlertService{Collection: mt.Coll, PubSub: services.NewMemoryPubSub()}
+
+		stream := service.StreamAlerts(scope, models.AlertStreamFilter{})
+		defer stream.Close()
+
+		// Alerts published before the subscriber starts are kept.
+		alert, err := service.CreateAlert(scope, &models.Alert{AlertType: models.AlertTypeFire})
+		require.NoError(t, err)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go service.RunPubSub(ctx)
+
+		select {
+		case streamed := <-stream.Alerts:
+			assert.Equal(t, alert.ID, streamed.ID)
+		case <-time.After(5 * time.Second):
+			t.Fatal("alert was not delivered")
+		}
+	})
+}
+
+func TestChangeStreamPubSub(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	mt.Run("resumes after the last event", func(mt *mtest.T) {
+		first, second := primitive.NewObjectID(), primitive.NewObjectID()
+		event := func(token string, alertID primitive.ObjectID) bson.D {
+			return bson.D{
+				{Key: "_id", Value: bson.D{{Key: "_data", Value: token}}},
+				{Key: "operationType", Value: "insert"},
+				{Key: "fullDocument", Value: bson.D{{Key: "_id", Value: alertID}, {Key: "alert_type", Value: "Fire"}}},
+			}
+		}
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch, event("token-1", first)),
+			mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch, event("token-2", second)),
+		)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		var delivered []primitive.ObjectID
+		pubsub := &services.ChangeStreamPubSub{Collection: mt.Coll}
+		err := pubsub.Run(ctx, func(alert *models.Alert) {
+			delivered = append(delivered, alert.ID)
+			if len(delivered) == 2 {
+				cancel()
+			}
+		})
+
+		assert.ErrorIs(t, err, context.Canceled)
+		assert.Equal(t, []primitive.ObjectID{first, second}, delivered)
+
+		var watches []bson.Raw
+		for _, started := range mt.GetAllStartedEvents() {
+			if started.CommandName == "aggregate" {
+				watches = append(watches, started.Command)
+			}
+		}
+		require.Len(t, watches, 2)
+		resumeAfter := watches[1].Lookup("pipeline", "0", "$changeStream", "resumeAfter", "_data")
+		assert.Equal(t, "token-1", resumeAfter.StringValue())
+	})
+}
@@ -0,0 +1,67 @@
+package services_test
+
+import (
+	"backend/models"
+	"backend/services"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestAlertQueryPeriod(t *testing.T) {
+	scope := services.AccessScope{OrganizationID: primitive.NewObjectID()}
+	start := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	end := time.Date(2024, 3, 31, 0, 0, 0, 0, time.UTC)
+
+	query := services.AlertQuery(scope, services.AlertFilter{StartDate: start, EndDate: end})
+
+	// Alerts carry no createdAt; the period applies to when they were raised.
+	assert.NotContains(t, query, "createdAt")
+	assert.Equal(t, bson.M{"$gte": start, "$lte": end}, query["start_datetime"])
+
+	query = services.AlertQuery(scope, services.AlertFilter{EndDate: end})
+	assert.Equal(t, bson.M{"$lte": end}, query["start_datetime"])
+}
+
+func TestCreateAlertLocation(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	granted, foreign := primitive.NewObjectID(), primitive.NewObjectID()
+	scope := services.AccessScope{
+		OrganizationID: primitive.NewObjectID(),
+		Restricted:     true,
+		BuildingIDs:    []primitive.ObjectID{granted},
+	}
+
+	mt.Run("rejects a building outside the scope", func(mt *mtest.T) {
+		service := &services.AlertService{Collection: mt.Coll}
+
+		_, err := service.CreateAlert(scope, &models.Alert{AlertType: models.AlertTypeFire, BuildingID: foreign})
+		assert.ErrorIs(t, err, services.ErrAlertOutOfScope)
+		assert.Empty(t, mt.GetAllStartedEvents())
+	})
+
+	mt.Run("drops the zone sent by the source", func(mt *mtest.T) {
+		mt.AddMockResponses(mtest.CreateSuccessResponse())
+		service := &services.AlertService{Collection: mt.Coll}
+
+		alert, err := service.CreateAlert(scope, &models.Alert{
+			AlertType:  models.AlertTypeFire,
+			BuildingID: granted,
+			ZoneID:     primitive.NewObjectID(),
+			ZoneName:   "Склад",
+		})
+		require.NoError(t, err)
+		assert.True(t, alert.ZoneID.IsZero())
+		assert.Empty(t, alert.ZoneName)
+
+		inserted := mt.GetStartedEvent().Command.Lookup("documents").Array().Index(0).Value().Document()
+		_, err = inserted.LookupErr("zoneId")
+		assert.Error(t, err)
+	})
+}
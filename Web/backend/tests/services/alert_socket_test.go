@@ -0,0 +1,112 @@
+package services_test
+
+import (
+	"backend/models"
+	"backend/services"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+	"golang.org/x/net/websocket"
+)
+
+type socketMessage struct {
+	Type          string               `json:"type"`
+	ID            string               `json:"id"`
+	Error         string               `json:"error"`
+	Subscriptions []string             `json:"subscriptions"`
+	Alert         *models.Alert        `json:"alert"`
+	AlertID       string               `json:"alert_id"`
+	Comment       *models.AlertComment `json:"comment"`
+	Tags          []string             `json:"tags"`
+	ResumeToken   string               `json:"resume_token"`
+	Count         int                  `json:"count"`
+}
+
+func TestAlertWebSocketProtocol(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	scope := services.AccessScope{OrganizationID: primitive.NewObjectID()}
+
+	dial := func(t *testing.T, service *services.AlertService) *websocket.Conn {
+		server := httptest.NewServer(websocket.Handler(func(conn *websocket.Conn) {
+			service.HandleWebSocket(scope, models.AlertStreamFilter{}, conn)
+		}))
+		t.Cleanup(server.Close)
+
+		conn, err := websocket.Dial("ws"+strings.TrimPrefix(server.URL, "http"), "", server.URL)
+		require.NoError(t, err)
+		t.Cleanup(func() { conn.Close() })
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+		var hello socketMessage
+		require.NoError(t, websocket.JSON.Receive(conn, &hello))
+		require.Equal(t, "hello", hello.Type)
+		return conn
+	}
+	exchange := func(t *testing.T, conn *websocket.Conn, request interface{}) socketMessage {
+		require.NoError(t, websocket.JSON.Send(conn, request))
+		var reply socketMessage
+		require.NoError(t, websocket.JSON.Receive(conn, &reply))
+		return reply
+	}
+	subscribeFire := func(t *testing.T, conn *websocket.Conn) {
+		reply := exchange(t, conn, bson.M{"type": "subscribe", "id": "fire", "filter": bson.M{"alert_types": bson.A{"Fire"}}})
+		require.Equal(t, socketMessage{Type: "subscribed", ID: "fire"}, reply)
+	}
+
+	mt.Run("pushes alerts matching a subscription", func(mt *mtest.T) {
+		mt.AddMockResponses(mtest.CreateSuccessResponse(), mtest.CreateSuccessResponse())
+		service := &services.AlertService{Collection: mt.Coll}
+		conn := dial(t, service)
+
+		assert.Equal(t, "pong", exchange(t, conn, bson.M{"type": "ping"}).Type)
+		subscribeFire(t, conn)
+
+		_, err := service.CreateAlert(scope, &models.Alert{AlertType: models.AlertTypeFight})
+		require.NoError(t, err)
+		fire, err := service.CreateAlert(scope, &models.Alert{AlertType: models.AlertTypeFire})
+		require.NoError(t, err)
+
+		var pushed socketMessage
+		require.NoError(t, websocket.JSON.Receive(conn, &pushed))
+		assert.Equal(t, "alert", pushed.Type)
+		assert.Equal(t, []string{"fire"}, pushed.Subscriptions)
+		assert.Equal(t, fire.ID, pushed.Alert.ID)
+		assert.Equal(t, fire.ID.Hex(), pushed.ResumeToken)
+
+		assert.Equal(t, "unsubscribed", exchange(t, conn, bson.M{"type": "unsubscribe", "id": "fire"}).Type)
+	})
+
+	mt.Run("replays missed alerts on resume", func(mt *mtest.T) {
+		missed := primitive.NewObjectID()
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch,
+			bson.D{{Key: "_id", Value: primitive.NewObjectID()}, {Key: "alert_type", Value: "Fight"}, {Key: "organizationId", Value: scope.OrganizationID}},
+			bson.D{{Key: "_id", Value: missed}, {Key: "alert_type", Value: "Fire"}, {Key: "organizationId", Value: scope.OrganizationID}},
+		))
+		conn := dial(t, &services.AlertService{Collection: mt.Coll})
+		subscribeFire(t, conn)
+
+		pushed := exchange(t, conn, bson.M{"type": "resume", "token": primitive.NewObjectID().Hex()})
+		assert.Equal(t, missed, pushed.Alert.ID)
+
+		var resumed socketMessage
+		require.NoError(t, websocket.JSON.Receive(conn, &resumed))
+		assert.Equal(t, socketMessage{Type: "resumed", Count: 1}, resumed)
+	})
+
+	mt.Run("rejects expired resume tokens", func(mt *mtest.T) {
+		conn := dial(t, &services.AlertService{Collection: mt.Coll})
+
+		stale := primitive.NewObjectIDFromTimestamp(time.Now().Add(-time.Hour))
+		reply := exchange(t, conn, bson.M{"type": "resume", "token": stale.Hex()})
+		assert.Equal(t, "error", reply.Type)
+		assert.Equal(t, services.ErrResumeExpired.Error(), reply.Error)
+	})
+}
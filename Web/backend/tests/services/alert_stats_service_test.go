@@ -0,0 +1,96 @@
+package services_test
+
+import (
+	"backend/services"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestAlertStats(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	now := time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC)
+	day := time.Date(2024, 3, 9, 0, 0, 0, 0, time.UTC)
+	scope := services.AccessScope{OrganizationID: primitive.NewObjectID()}
+	newService := func(mt *mtest.T) *services.AlertStatsService {
+		return &services.AlertStatsService{Collection: mt.Coll, Now: func() time.Time { return now }}
+	}
+	pipeline := func(mt *mtest.T) bson.Raw {
+		return bson.Raw(mt.GetStartedEvent().Command.Lookup("pipeline").Array())
+	}
+
+	mt.Run("counts by hour of day", func(mt *mtest.T) {
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch, bson.D{
+			{Key: "_id", Value: int32(14)},
+			{Key: "total", Value: int64(3)},
+			{Key: "series", Value: bson.A{bson.D{{Key: "bucket", Value: day}, {Key: "count", Value: int64(3)}}}},
+		}))
+
+		series, err := newService(mt).AlertCounts(scope, services.StatsQuery{GroupBy: services.StatsByHour, Timezone: "Europe/Moscow"})
+		require.NoError(t, err)
+		require.Len(t, series, 1)
+		assert.Equal(t, int32(14), series[0].Key)
+		assert.Equal(t, []services.StatsBucket{{Bucket: day, Count: 3}}, series[0].Series)
+
+		stages := pipeline(mt)
+		match := stages.Index(0).Value().Document().Lookup("$match")
+		assert.Equal(t, now.Add(-30*24*time.Hour).UnixMilli(), match.Document().Lookup("start_datetime", "$gte").DateTime())
+		group := stages.Index(1).Value().Document().Lookup("$group", "_id")
+		assert.Equal(t, "Europe/Moscow", group.Document().Lookup("key", "$hour", "timezone").StringValue())
+		assert.Equal(t, "day", group.Document().Lookup("bucket", "$dateTrunc", "unit").StringValue())
+	})
+
+	mt.Run("reports response times in seconds", func(mt *mtest.T) {
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch, bson.D{
+			{Key: "_id", Value: day},
+			{Key: "alerts", Value: int64(4)},
+			{Key: "acknowledged", Value: int64(2)},
+			{Key: "resolved", Value: int64(0)},
+			{Key: "mtta", Value: 90000.0},
+			{Key: "mttr", Value: nil},
+		}))
+
+		buckets, err := newService(mt).ResponseTimes(scope, services.StatsQuery{})
+		require.NoError(t, err)
+		require.Len(t, buckets, 1)
+		assert.Equal(t, 90.0, *buckets[0].MTTASeconds)
+		assert.Nil(t, buckets[0].MTTRSeconds)
+	})
+
+	mt.Run("ranks cameras by false-positive rate", func(mt *mtest.T) {
+		lobby, gate := primitive.NewObjectID(), primitive.NewObjectID()
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch,
+			bson.D{{Key: "_id", Value: lobby}, {Key: "resolved", Value: int64(10)}, {Key: "falsePositives", Value: int64(1)}},
+			bson.D{{Key: "_id", Value: gate}, {Key: "resolved", Value: int64(4)}, {Key: "falsePositives", Value: int64(3)}},
+		))
+
+		stats, err := newService(mt).FalsePositiveRates(scope, services.StatsQuery{Granularity: "week"})
+		require.NoError(t, err)
+		require.Len(t, stats, 2)
+		assert.Equal(t, gate, stats[0].CameraID)
+		assert.Equal(t, 0.75, stats[0].Rate)
+		assert.Equal(t, 0.1, stats[1].Rate)
+	})
+
+	mt.Run("explains that $dateTrunc needs MongoDB 5.0", func(mt *mtest.T) {
+		mt.AddMockResponses(mtest.CreateCommandErrorResponse(mtest.CommandError{
+			Code:    168,
+			Name:    "InvalidPipelineOperator",
+			Message: "Unrecognized expression '$dateTrunc'",
+		}))
+
+		_, err := newService(mt).AlertCounts(scope, services.StatsQuery{})
+		assert.ErrorIs(t, err, services.ErrStatsUnsupported)
+	})
+
+	mt.Run("rejects an unknown granularity", func(mt *mtest.T) {
+		_, err := newService(mt).AlertCounts(scope, services.StatsQuery{Granularity: "fortnight"})
+		assert.Error(t, err)
+	})
+}
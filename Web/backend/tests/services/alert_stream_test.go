@@ -0,0 +1,60 @@
+package services_test
+
+import (
+	"backend/models"
+	"backend/services"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestStreamAlerts(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	scope := services.AccessScope{OrganizationID: primitive.NewObjectID()}
+
+	mt.Run("delivers matching alerts", func(mt *mtest.T) {
+		mt.AddMockResponses(mtest.CreateSuccessResponse(), mtest.CreateSuccessResponse())
+		service := &services.AlertService{Collection: mt.Coll}
+
+		stream := service.StreamAlerts(scope, models.AlertStreamFilter{MinSeverity: models.SeverityCritical})
+		defer stream.Close()
+
+		_, err := service.CreateAlert(scope, &models.Alert{AlertType: models.AlertTypeIntrusion})
+		require.NoError(t, err)
+		fire, err := service.CreateAlert(scope, &models.Alert{AlertType: models.AlertTypeFire})
+		require.NoError(t, err)
+
+		select {
+		case alert := <-stream.Alerts:
+			assert.Equal(t, fire.ID, alert.ID)
+		case <-time.After(5 * time.Second):
+			t.Fatal("alert was not streamed")
+		}
+	})
+
+}
+
+func TestBusEnqueueKeepsOrder(t *testing.T) {
+	const events = 1000
+
+	bus := services.NewBus[int]()
+	received := make(chan int, events)
+	bus.Subscribe(func(event int) { received <- event })
+
+	for i := 0; i < events; i++ {
+		bus.Enqueue(i)
+	}
+	for i := 0; i < events; i++ {
+		select {
+		case event := <-received:
+			require.Equal(t, i, event)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("event %d not delivered", i)
+		}
+	}
+}
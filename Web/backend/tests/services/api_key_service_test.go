@@ -0,0 +1,79 @@
+package services_test
+
+import (
+	"backend/models"
+	"backend/services"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestGenerateAPIKey(t *testing.T) {
+	raw, prefix, hash, err := services.GenerateAPIKey()
+
+	assert.Nil(t, err)
+	assert.True(t, strings.HasPrefix(raw, "abd_"+prefix+"_"))
+	assert.NotContains(t, hash, prefix)
+
+	parsed, err := services.ParseAPIKey(raw)
+	assert.Nil(t, err)
+	assert.Equal(t, prefix, parsed)
+
+	_, err = services.ParseAPIKey("Bearer something")
+	assert.Equal(t, services.ErrInvalidAPIKey, err)
+}
+
+func TestAuthenticateAPIKey(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	raw, prefix, hash, _ := services.GenerateAPIKey()
+	keyDoc := func(extra ...bson.E) bson.D {
+		doc := bson.D{
+			{Key: "_id", Value: primitive.NewObjectID()},
+			{Key: "name", Value: "edge-node-1"},
+			{Key: "prefix", Value: prefix},
+			{Key: "keyHash", Value: hash},
+			{Key: "permissions", Value: bson.A{"alerts:write"}},
+		}
+		return append(doc, extra...)
+	}
+
+	mt.Run("valid key", func(mt *mtest.T) {
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch, keyDoc()),
+			mtest.CreateSuccessResponse(),
+		)
+
+		service := services.APIKeyService{Collection: mt.Coll}
+		key, err := service.Authenticate(raw)
+
+		assert.Nil(t, err)
+		assert.True(t, key.Can(models.PermAlertsWrite))
+		assert.False(t, key.Can(models.PermCamerasRead))
+		assert.NotNil(t, key.LastUsedAt)
+	})
+
+	mt.Run("wrong secret", func(mt *mtest.T) {
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch, keyDoc()))
+
+		service := services.APIKeyService{Collection: mt.Coll}
+		_, err := service.Authenticate("abd_" + prefix + "_forged")
+
+		assert.Equal(t, services.ErrInvalidAPIKey, err)
+	})
+
+	mt.Run("expired", func(mt *mtest.T) {
+		expired := bson.E{Key: "expiresAt", Value: time.Now().Add(-time.Hour)}
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch, keyDoc(expired)))
+
+		service := services.APIKeyService{Collection: mt.Coll}
+		_, err := service.Authenticate(raw)
+
+		assert.Equal(t, services.ErrInvalidAPIKey, err)
+	})
+}
@@ -0,0 +1,192 @@
+package services_test
+
+import (
+	"backend/models"
+	"backend/services"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func auditChain(n int) []models.AuditEntry {
+	entries := make([]models.AuditEntry, 0, n)
+	prevHash := ""
+	for i := 1; i <= n; i++ {
+		entry := models.AuditEntry{
+			Seq:       int64(i),
+			Timestamp: time.Date(2024, 1, 1, 12, 0, i, 0, time.UTC),
+			Username:  "operator",
+			Action:    models.AuditStreamView,
+			Success:   true,
+			PrevHash:  prevHash,
+		}
+		entry.Hash = services.HashAuditEntry(&entry)
+		prevHash = entry.Hash
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+func auditDocs(t *testing.T, entries []models.AuditEntry) []bson.D {
+	docs := make([]bson.D, 0, len(entries))
+	for _, entry := range entries {
+		data, err := bson.Marshal(entry)
+		assert.Nil(t, err)
+		var doc bson.D
+		assert.Nil(t, bson.Unmarshal(data, &doc))
+		docs = append(docs, doc)
+	}
+	return docs
+}
+
+func TestHashAuditEntry(t *testing.T) {
+	entry := auditChain(1)[0]
+	hash := services.HashAuditEntry(&entry)
+
+	entry.Username = "admin"
+	assert.NotEqual(t, hash, services.HashAuditEntry(&entry))
+
+	entry.Username = "operator"
+	entry.Hash = "ignored"
+	assert.Equal(t, hash, services.HashAuditEntry(&entry))
+}
+
+func TestRecordAuditEntry(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	mt.Run("extends the chain", func(mt *mtest.T) {
+		head := auditChain(4)[3]
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch, auditDocs(t, []models.AuditEntry{head})...),
+			mtest.CreateSuccessResponse(),
+		)
+
+		service := services.AuditService{Collection: mt.Coll}
+		entry := models.AuditEntry{Action: models.AuditCameraDelete, Username: "admin", Success: true}
+
+		assert.Nil(t, service.Record(&entry))
+		assert.Equal(t, int64(5), entry.Seq)
+		assert.Equal(t, head.Hash, entry.PrevHash)
+		assert.Equal(t, services.HashAuditEntry(&entry), entry.Hash)
+	})
+
+	mt.Run("retries on a concurrent append", func(mt *mtest.T) {
+		chain := auditChain(2)
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch, auditDocs(t, chain[:1])...),
+			mtest.CreateWriteErrorsResponse(mtest.WriteError{Index: 0, Code: 11000, Message: "duplicate key"}),
+			mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch, auditDocs(t, chain[1:])...),
+			mtest.CreateSuccessResponse(),
+		)
+
+		service := services.AuditService{Collection: mt.Coll}
+		entry := models.AuditEntry{Action: models.AuditLoginFailure, Username: "ivan"}
+
+		assert.Nil(t, service.Record(&entry))
+		assert.Equal(t, int64(3), entry.Seq)
+		assert.Equal(t, chain[1].Hash, entry.PrevHash)
+	})
+}
+
+func TestVerifyAuditLog(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	mt.Run("intact chain", func(mt *mtest.T) {
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch, auditDocs(t, auditChain(3))...))
+
+		service := services.AuditService{Collection: mt.Coll}
+		result, err := service.Verify(services.AccessScope{})
+
+		assert.Nil(t, err)
+		assert.True(t, result.Valid)
+		assert.Equal(t, int64(3), result.Checked)
+	})
+
+	mt.Run("modified entry", func(mt *mtest.T) {
+		chain := auditChain(3)
+		chain[1].Username = "someone-else"
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch, auditDocs(t, chain)...))
+
+		service := services.AuditService{Collection: mt.Coll}
+		result, err := service.Verify(services.AccessScope{})
+
+		assert.Nil(t, err)
+		assert.False(t, result.Valid)
+		assert.Equal(t, int64(2), result.BrokenAt)
+	})
+
+	mt.Run("deleted entry", func(mt *mtest.T) {
+		chain := auditChain(3)
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch, auditDocs(t, []models.AuditEntry{chain[0], chain[2]})...))
+
+		service := services.AuditService{Collection: mt.Coll}
+		result, err := service.Verify(services.AccessScope{})
+
+		assert.Nil(t, err)
+		assert.False(t, result.Valid)
+		assert.Equal(t, int64(3), result.BrokenAt)
+	})
+	orgA, orgB := primitive.NewObjectID(), primitive.NewObjectID()
+	orgChain := func() []models.AuditEntry {
+		chain := auditChain(3)
+		chain[0].OrganizationID = orgA
+		chain[1].OrganizationID = orgB
+		chain[2].OrganizationIDs = []primitive.ObjectID{orgA, orgB}
+		for i := range chain {
+			if i > 0 {
+				chain[i].PrevHash = chain[i-1].Hash
+			}
+			chain[i].Hash = services.HashAuditEntry(&chain[i])
+		}
+		return chain
+	}
+
+	mt.Run("counts the organization's entries", func(mt *mtest.T) {
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch, auditDocs(t, orgChain())...))
+
+		service := services.AuditService{Collection: mt.Coll}
+		result, err := service.Verify(services.AccessScope{OrganizationID: orgA})
+
+		assert.Nil(t, err)
+		assert.True(t, result.Valid)
+		assert.Equal(t, int64(2), result.Checked)
+	})
+
+	mt.Run("hides breaks in other organizations", func(mt *mtest.T) {
+		chain := orgChain()
+		chain[1].Username = "someone-else"
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch, auditDocs(t, chain)...))
+
+		service := services.AuditService{Collection: mt.Coll}
+		result, err := service.Verify(services.AccessScope{OrganizationID: orgA})
+
+		assert.Nil(t, err)
+		assert.False(t, result.Valid)
+		assert.Zero(t, result.BrokenAt)
+	})
+}
+
+func TestGetAuditEntriesOfOrganization(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	mt.Run("includes failed logins of members", func(mt *mtest.T) {
+		orgID := primitive.NewObjectID()
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch, bson.D{{Key: "n", Value: 0}}),
+			mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch),
+		)
+
+		service := services.AuditService{Collection: mt.Coll}
+		_, _, err := service.GetEntries(services.AccessScope{OrganizationID: orgID}, services.AuditFilter{}, 1, 10)
+		assert.Nil(t, err)
+
+		events := mt.GetAllStartedEvents()
+		or := events[len(events)-1].Command.Lookup("filter", "$or").Array()
+		assert.Equal(t, orgID, or.Index(0).Value().Document().Lookup("organizationId").ObjectID())
+		assert.Equal(t, orgID, or.Index(1).Value().Document().Lookup("organizationIds").ObjectID())
+	})
+}
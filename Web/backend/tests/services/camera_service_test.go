@@ -11,6 +11,8 @@ import (
 	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
 )
 
+var scope = services.AccessScope{OrganizationID: primitive.NewObjectID()}
+
 func TestGetAllCameras(t *testing.T) {
 	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
 	defer mt.Client.Disconnect(nil)
@@ -37,7 +39,7 @@ func TestGetAllCameras(t *testing.T) {
 		mt.AddMockResponses(first, second, killCursors)
 
 		service := services.CameraService{Collection: mt.Coll}
-		cameras, err := service.GetAllCameras()
+		cameras, err := service.GetAllCameras(scope)
 
 		assert.Nil(t, err)
 		assert.Equal(t, 2, len(cameras))
@@ -52,7 +54,7 @@ func TestGetAllCameras(t *testing.T) {
 		}))
 
 		service := services.CameraService{Collection: mt.Coll}
-		cameras, err := service.GetAllCameras()
+		cameras, err := service.GetAllCameras(scope)
 
 		assert.NotNil(t, err)
 		assert.Nil(t, cameras)
@@ -74,11 +76,12 @@ func TestCreateCamera(t *testing.T) {
 			Location: "Test Location",
 		}
 
-		result, err := service.CreateCamera(camera)
+		result, err := service.CreateCamera(scope, camera)
 
 		assert.Nil(t, err)
 		assert.NotNil(t, result)
 		assert.Equal(t, "Test Camera", result.Name)
+		assert.Equal(t, scope.OrganizationID, result.OrganizationID)
 	})
 
 	mt.Run("database error", func(mt *mtest.T) {
@@ -94,7 +97,7 @@ func TestCreateCamera(t *testing.T) {
 			Status: models.CameraStatusActive,
 		}
 
-		result, err := service.CreateCamera(camera)
+		result, err := service.CreateCamera(scope, camera)
 
 		assert.NotNil(t, err)
 		assert.Nil(t, result)
@@ -109,14 +112,14 @@ func TestDeleteCamera(t *testing.T) {
 		mt.AddMockResponses(mtest.CreateSuccessResponse())
 
 		service := services.CameraService{Collection: mt.Coll}
-		err := service.DeleteCamera(primitive.NewObjectID().Hex())
+		err := service.DeleteCamera(scope, primitive.NewObjectID().Hex())
 
 		assert.Nil(t, err)
 	})
 
 	mt.Run("invalid id", func(mt *mtest.T) {
 		service := services.CameraService{Collection: mt.Coll}
-		err := service.DeleteCamera("invalid-id")
+		err := service.DeleteCamera(scope, "invalid-id")
 
 		assert.NotNil(t, err)
 		assert.Contains(t, err.Error(), "некорректный ID камеры")
@@ -129,7 +132,7 @@ func TestDeleteCamera(t *testing.T) {
 		}))
 
 		service := services.CameraService{Collection: mt.Coll}
-		err := service.DeleteCamera(primitive.NewObjectID().Hex())
+		err := service.DeleteCamera(scope, primitive.NewObjectID().Hex())
 
 		assert.NotNil(t, err)
 	})
@@ -0,0 +1,314 @@
+package services_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"backend/models"
+	"backend/services"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+type recordingMentions struct {
+	mu    sync.Mutex
+	users []primitive.ObjectID
+	done  chan struct{}
+}
+
+func (r *recordingMentions) NotifyMention(alert *models.Alert, comment *models.AlertComment, userID primitive.ObjectID) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.users = append(r.users, userID)
+	r.done <- struct{}{}
+	return nil
+}
+
+func rawStrings(t *testing.T, array bson.Raw) []string {
+	values, err := array.Values()
+	require.NoError(t, err)
+	strs := make([]string, len(values))
+	for i, value := range values {
+		strs[i] = value.StringValue()
+	}
+	return strs
+}
+
+// A tiny PNG header, enough for content type detection.
+var pngHeader = []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+
+// memoryBlobs is a BlobStore kept in memory. A Put whose reader fails
+// stores nothing.
+type memoryBlobs struct {
+	mu    sync.Mutex
+	files map[string][]byte
+}
+
+func (m *memoryBlobs) Put(ctx context.Context, key string, r io.Reader) (int64, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return int64(len(data)), err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.files == nil {
+		m.files = map[string][]byte{}
+	}
+	m.files[key] = data
+	return int64(len(data)), nil
+}
+
+func (m *memoryBlobs) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	data, ok := m.files[key]
+	if !ok {
+		return nil, services.ErrBlobNotFound
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (m *memoryBlobs) Delete(ctx context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.files, key)
+	return nil
+}
+
+func TestAlertComments(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	orgID, alertID := primitive.NewObjectID(), primitive.NewObjectID()
+	scope := services.AccessScope{OrganizationID: orgID}
+	alertDoc := bson.D{{Key: "_id", Value: alertID}, {Key: "alert_type", Value: "Intrusion"}, {Key: "organizationId", Value: orgID}}
+
+	mt.Run("adds a reply to the thread and notifies mentions", func(mt *mtest.T) {
+		rootID, parentID := primitive.NewObjectID(), primitive.NewObjectID()
+		authorID, mentionedID := primitive.NewObjectID(), primitive.NewObjectID()
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch, alertDoc),
+			mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch, bson.D{
+				{Key: "_id", Value: parentID},
+				{Key: "alertId", Value: alertID},
+				{Key: "parentId", Value: rootID},
+			}),
+			mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch,
+				bson.D{{Key: "_id", Value: mentionedID}},
+				bson.D{{Key: "_id", Value: authorID}},
+			),
+			mtest.CreateSuccessResponse(),
+		)
+		notifier := &recordingMentions{done: make(chan struct{}, 2)}
+		pubsub := services.NewMemoryPubSub()
+		service := &services.CommentService{Collection: mt.Coll, Alerts: mt.Coll, Users: mt.Coll, Notifier: notifier, PubSub: pubsub}
+
+		comment, err := service.AddComment(scope, alertID.Hex(), parentID.Hex(), authorID.Hex(), "operator",
+			"  @ivan, @operator: drill, see guard@example.com.  ")
+		require.NoError(t, err)
+		assert.Equal(t, rootID, comment.ParentID)
+		assert.Equal(t, "@ivan, @operator: drill, see guard@example.com.", comment.Body)
+		assert.Equal(t, []primitive.ObjectID{mentionedID, authorID}, comment.Mentions)
+
+		var users bson.Raw
+		for _, started := range mt.GetAllStartedEvents() {
+			if started.CommandName == "find" && started.Command.Lookup("filter", "username").Type != 0 {
+				users = started.Command
+			}
+		}
+		require.NotNil(t, users)
+		assert.Equal(t, []string{"ivan", "operator"}, rawStrings(t, users.Lookup("filter", "username", "$in").Array()))
+
+		// The author is not notified about their own mention.
+		select {
+		case <-notifier.done:
+		case <-time.After(5 * time.Second):
+			t.Fatal("mention was not notified")
+		}
+		notifier.mu.Lock()
+		assert.Equal(t, []primitive.ObjectID{mentionedID}, notifier.users)
+		notifier.mu.Unlock()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		var activity *models.AlertActivity
+		go pubsub.RunActivity(ctx, func(a *models.AlertActivity) {
+			activity = a
+			cancel()
+		})
+		<-ctx.Done()
+		require.NotNil(t, activity)
+		assert.Equal(t, models.ActivityComment, activity.Type)
+		assert.Equal(t, alertID, activity.Alert.ID)
+		assert.Equal(t, comment.ID, activity.Comment.ID)
+	})
+
+	mt.Run("rejects an empty comment", func(mt *mtest.T) {
+		service := &services.CommentService{Collection: mt.Coll, Alerts: mt.Coll, Users: mt.Coll}
+		_, err := service.AddComment(scope, alertID.Hex(), "", "user", "operator", " \n ")
+		assert.Error(t, err)
+	})
+
+	mt.Run("edits only the author's comments", func(mt *mtest.T) {
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch, alertDoc),
+			bson.D{{Key: "ok", Value: 1}, {Key: "value", Value: nil}},
+		)
+		service := &services.CommentService{Collection: mt.Coll, Alerts: mt.Coll, Users: mt.Coll}
+
+		_, _, err := service.EditComment(scope, alertID.Hex(), primitive.NewObjectID().Hex(), "intruder", "changed")
+		assert.Error(t, err)
+
+		events := mt.GetAllStartedEvents()
+		query := events[len(events)-1].Command.Lookup("query")
+		assert.Equal(t, "intruder", query.Document().Lookup("authorId").StringValue())
+	})
+}
+
+func TestAlertAttachments(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	orgID, alertID := primitive.NewObjectID(), primitive.NewObjectID()
+	scope := services.AccessScope{OrganizationID: orgID}
+	alertDoc := bson.D{{Key: "_id", Value: alertID}, {Key: "organizationId", Value: orgID}}
+	newService := func(mt *mtest.T, blobs *memoryBlobs) *services.CommentService {
+		return &services.CommentService{
+			Collection:  mt.Coll,
+			Attachments: mt.Coll,
+			Alerts:      mt.Coll,
+			Blobs:       blobs,
+			MaxFileSize: 1 << 10,
+		}
+	}
+
+	mt.Run("stores a photo in the blob store", func(mt *mtest.T) {
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch, alertDoc),
+			mtest.CreateSuccessResponse(),
+		)
+		blobs := &memoryBlobs{}
+		photo := append(append([]byte{}, pngHeader...), bytes.Repeat([]byte{1}, 600)...)
+
+		attachment, err := newService(mt, blobs).AddAttachment(scope, alertID.Hex(), "", "user", `C:\Users\guard\scene.png`, bytes.NewReader(photo))
+		require.NoError(t, err)
+		assert.Equal(t, "scene.png", attachment.FileName)
+		assert.Equal(t, "image/png", attachment.ContentType)
+		assert.Equal(t, int64(len(photo)), attachment.Size)
+		assert.Equal(t, photo, blobs.files[attachment.Key])
+	})
+
+	mt.Run("rejects other file types", func(mt *mtest.T) {
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch, alertDoc))
+		_, err := newService(mt, &memoryBlobs{}).AddAttachment(scope, alertID.Hex(), "", "user", "run.sh", bytes.NewReader([]byte("#!/bin/sh\nrm -rf /\n")))
+		assert.Error(t, err)
+	})
+
+	mt.Run("rejects and removes files over the limit", func(mt *mtest.T) {
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch, alertDoc))
+		blobs := &memoryBlobs{}
+		photo := append(append([]byte{}, pngHeader...), bytes.Repeat([]byte{1}, 2<<10)...)
+
+		_, err := newService(mt, blobs).AddAttachment(scope, alertID.Hex(), "", "user", "scene.png", bytes.NewReader(photo))
+		assert.Error(t, err)
+		assert.Empty(t, blobs.files)
+	})
+
+	attachmentID := primitive.NewObjectID()
+	key := "alerts/" + orgID.Hex() + "/" + alertID.Hex() + "/" + attachmentID.Hex()
+	deleted := bson.D{{Key: "ok", Value: 1}, {Key: "value", Value: bson.D{
+		{Key: "_id", Value: attachmentID},
+		{Key: "alertId", Value: alertID},
+		{Key: "organizationId", Value: orgID},
+		{Key: "key", Value: key},
+		{Key: "uploadedBy", Value: "guard"},
+	}}}
+
+	mt.Run("only the uploader deletes an attachment", func(mt *mtest.T) {
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch, alertDoc),
+			bson.D{{Key: "ok", Value: 1}, {Key: "value", Value: nil}},
+		)
+		blobs := &memoryBlobs{files: map[string][]byte{key: pngHeader}}
+
+		_, err := newService(mt, blobs).DeleteAttachment(scope, alertID.Hex(), attachmentID.Hex(), "intruder", false)
+		assert.Error(t, err)
+		assert.Contains(t, blobs.files, key)
+
+		events := mt.GetAllStartedEvents()
+		query := events[len(events)-1].Command.Lookup("query").Document()
+		assert.Equal(t, "intruder", query.Lookup("uploadedBy").StringValue())
+	})
+
+	mt.Run("user managers delete any attachment", func(mt *mtest.T) {
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch, alertDoc),
+			deleted,
+		)
+		blobs := &memoryBlobs{files: map[string][]byte{key: pngHeader}}
+
+		attachment, err := newService(mt, blobs).DeleteAttachment(scope, alertID.Hex(), attachmentID.Hex(), "admin", true)
+		require.NoError(t, err)
+		assert.Equal(t, "guard", attachment.UploadedBy)
+		assert.NotContains(t, blobs.files, key)
+
+		events := mt.GetAllStartedEvents()
+		query := events[len(events)-1].Command.Lookup("query").Document()
+		_, err = query.LookupErr("uploadedBy")
+		assert.Error(t, err)
+	})
+}
+
+func TestAlertTags(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	orgID, alertID := primitive.NewObjectID(), primitive.NewObjectID()
+	scope := services.AccessScope{OrganizationID: orgID}
+
+	mt.Run("normalizes and publishes tags", func(mt *mtest.T) {
+		mt.AddMockResponses(bson.D{{Key: "ok", Value: 1}, {Key: "value", Value: bson.D{
+			{Key: "_id", Value: alertID},
+			{Key: "organizationId", Value: orgID},
+			{Key: "tags", Value: bson.A{"drill"}},
+		}}})
+		pubsub := services.NewMemoryPubSub()
+		service := &services.AlertService{Collection: mt.Coll, PubSub: pubsub}
+
+		before, after, err := service.SetTags(scope, alertID.Hex(), []string{" Police  Called ", "drill", "police called", ""})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"drill"}, before.Tags)
+		assert.Equal(t, []string{"police called", "drill"}, after.Tags)
+
+		events := mt.GetAllStartedEvents()
+		tags := events[len(events)-1].Command.Lookup("update", "$set", "tags").Array()
+		assert.Equal(t, []string{"police called", "drill"}, rawStrings(t, tags))
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		var activity *models.AlertActivity
+		go pubsub.RunActivity(ctx, func(a *models.AlertActivity) {
+			activity = a
+			cancel()
+		})
+		<-ctx.Done()
+		require.NotNil(t, activity)
+		assert.Equal(t, models.ActivityTags, activity.Type)
+		assert.Equal(t, after.Tags, activity.Alert.Tags)
+	})
+
+	mt.Run("rejects too long tags", func(mt *mtest.T) {
+		_, err := services.NormalizeTags([]string{string(bytes.Repeat([]byte("x"), 51))})
+		assert.Error(t, err)
+	})
+
+	mt.Run("filters alerts by tag", func(mt *mtest.T) {
+		query := services.AlertQuery(scope, services.AlertFilter{Tags: []string{"drill"}})
+		assert.Equal(t, bson.M{"$in": []string{"drill"}}, query["tags"])
+	})
+}
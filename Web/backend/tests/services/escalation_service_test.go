@@ -0,0 +1,186 @@
+package services_test
+
+import (
+	"backend/models"
+	"backend/services"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+type recordingNotifier struct {
+	mu    sync.Mutex
+	users []primitive.ObjectID
+}
+
+func (n *recordingNotifier) NotifyUser(alert *models.Alert, userID primitive.ObjectID) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.users = append(n.users, userID)
+	return nil
+}
+
+func TestProcessEscalation(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	manager, regionalLead := primitive.NewObjectID(), primitive.NewObjectID()
+	alertID, policyID := primitive.NewObjectID(), primitive.NewObjectID()
+
+	escalationDoc := func(nextStep int) bson.D {
+		return bson.D{
+			{Key: "_id", Value: primitive.NewObjectID()},
+			{Key: "alertId", Value: alertID},
+			{Key: "policyId", Value: policyID},
+			{Key: "status", Value: models.EscalationActive},
+			{Key: "nextStep", Value: nextStep},
+		}
+	}
+	alertDoc := func(status string) bson.D {
+		return bson.D{{Key: "_id", Value: alertID}, {Key: "alert_type", Value: "Fire"}, {Key: "status", Value: status}}
+	}
+	policyDoc := bson.D{
+		{Key: "_id", Value: policyID},
+		{Key: "steps", Value: bson.A{
+			bson.D{{Key: "delaySeconds", Value: 120}, {Key: "userIds", Value: bson.A{manager}}},
+			bson.D{{Key: "delaySeconds", Value: 300}, {Key: "userIds", Value: bson.A{regionalLead}}},
+		}},
+	}
+	lastSet := func(mt *mtest.T) bson.Raw {
+		var set bson.Raw
+		for _, event := range mt.GetAllStartedEvents() {
+			if event.CommandName == "update" {
+				set = event.Command.Lookup("updates", "0", "u", "$set").Document()
+			}
+		}
+		return set
+	}
+	newService := func(mt *mtest.T, notifier services.AlertNotifier) *services.EscalationService {
+		return &services.EscalationService{
+			Policies: mt.Coll, Escalations: mt.Coll, Alerts: mt.Coll,
+			Notifier: notifier,
+			Lease:    time.Minute,
+			Now:      func() time.Time { return now },
+		}
+	}
+
+	mt.Run("fires the next step", func(mt *mtest.T) {
+		mt.AddMockResponses(
+			bson.D{{Key: "ok", Value: 1}, {Key: "value", Value: escalationDoc(0)}},
+			mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch, alertDoc(models.AlertStatusNew)),
+			mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch, policyDoc),
+			mtest.CreateSuccessResponse(),
+		)
+
+		notifier := &recordingNotifier{}
+		assert.True(t, newService(mt, notifier).ProcessNext())
+
+		assert.Equal(t, []primitive.ObjectID{manager}, notifier.users)
+		set := lastSet(mt)
+		assert.Equal(t, int32(1), set.Lookup("nextStep").Int32())
+		assert.Equal(t, now.Add(5*time.Minute).UnixMilli(), set.Lookup("dueAt").DateTime())
+	})
+
+	mt.Run("completes after the last step", func(mt *mtest.T) {
+		mt.AddMockResponses(
+			bson.D{{Key: "ok", Value: 1}, {Key: "value", Value: escalationDoc(1)}},
+			mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch, alertDoc(models.AlertStatusNew)),
+			mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch, policyDoc),
+			mtest.CreateSuccessResponse(),
+		)
+
+		notifier := &recordingNotifier{}
+		assert.True(t, newService(mt, notifier).ProcessNext())
+
+		assert.Equal(t, []primitive.ObjectID{regionalLead}, notifier.users)
+		assert.Equal(t, models.EscalationCompleted, lastSet(mt).Lookup("status").StringValue())
+	})
+
+	mt.Run("stops once acknowledged", func(mt *mtest.T) {
+		mt.AddMockResponses(
+			bson.D{{Key: "ok", Value: 1}, {Key: "value", Value: escalationDoc(0)}},
+			mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch, alertDoc(models.AlertStatusAcknowledged)),
+			mtest.CreateSuccessResponse(),
+		)
+
+		notifier := &recordingNotifier{}
+		assert.True(t, newService(mt, notifier).ProcessNext())
+
+		assert.Empty(t, notifier.users)
+		assert.Equal(t, models.EscalationStopped, lastSet(mt).Lookup("status").StringValue())
+	})
+}
+
+func TestEscalationPolicyRecipients(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	scope := services.AccessScope{OrganizationID: primitive.NewObjectID()}
+	manager, outsider := primitive.NewObjectID(), primitive.NewObjectID()
+	policy := func() *models.EscalationPolicy {
+		return &models.EscalationPolicy{Name: "Пожар", Steps: []models.EscalationStep{
+			{DelaySeconds: 60, UserIDs: []primitive.ObjectID{manager}},
+			{DelaySeconds: 300, UserIDs: []primitive.ObjectID{manager, outsider}},
+		}}
+	}
+
+	mt.Run("members of the organization", func(mt *mtest.T) {
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch, bson.D{{Key: "n", Value: 2}}),
+			mtest.CreateSuccessResponse(),
+		)
+
+		service := &services.EscalationService{Policies: mt.Coll, Users: mt.Coll, Now: time.Now}
+		_, err := service.CreatePolicy(scope, policy())
+		assert.Nil(t, err)
+
+		match := mt.GetAllStartedEvents()[0].Command.Lookup("pipeline", "0", "$match").Document()
+		assert.Equal(t, scope.OrganizationID, match.Lookup("memberships.organizationId").ObjectID())
+	})
+
+	mt.Run("user of another organization", func(mt *mtest.T) {
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch, bson.D{{Key: "n", Value: 1}}))
+
+		service := &services.EscalationService{Policies: mt.Coll, Users: mt.Coll, Now: time.Now}
+		_, err := service.CreatePolicy(scope, policy())
+
+		assert.NotNil(t, err)
+		assert.Len(t, mt.GetAllStartedEvents(), 1)
+	})
+}
+
+func TestGetAlertEscalations(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	buildingID := primitive.NewObjectID()
+	scope := services.AccessScope{OrganizationID: primitive.NewObjectID(), Restricted: true, BuildingIDs: []primitive.ObjectID{buildingID}}
+
+	mt.Run("alert in the scope", func(mt *mtest.T) {
+		alertID := primitive.NewObjectID()
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch, bson.D{{Key: "_id", Value: alertID}}),
+			mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch, bson.D{{Key: "_id", Value: primitive.NewObjectID()}, {Key: "alertId", Value: alertID}}),
+		)
+
+		service := &services.EscalationService{Escalations: mt.Coll, Alerts: mt.Coll}
+		escalations, err := service.GetAlertEscalations(scope, alertID.Hex())
+
+		assert.Nil(t, err)
+		assert.Len(t, escalations, 1)
+
+		filter := mt.GetAllStartedEvents()[0].Command.Lookup("filter").Document()
+		assert.Equal(t, buildingID, filter.Lookup("$or", "0", "buildingId", "$in", "0").ObjectID())
+	})
+
+	mt.Run("alert outside the scope", func(mt *mtest.T) {
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch))
+
+		service := &services.EscalationService{Escalations: mt.Coll, Alerts: mt.Coll}
+		_, err := service.GetAlertEscalations(scope, primitive.NewObjectID().Hex())
+
+		assert.NotNil(t, err)
+		assert.Len(t, mt.GetAllStartedEvents(), 1)
+	})
+}
@@ -0,0 +1,374 @@
+package services_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/zlib"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"backend/models"
+	"backend/services"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestAlertExport(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	orgID := primitive.NewObjectID()
+	cameraID, buildingID := primitive.NewObjectID(), primitive.NewObjectID()
+	alertID := primitive.NewObjectID()
+	raised := time.Date(2024, 3, 4, 9, 30, 0, 0, time.UTC)
+	moscow, err := time.LoadLocation("Europe/Moscow")
+	require.NoError(t, err)
+
+	newService := func(mt *mtest.T) *services.ExportService {
+		return &services.ExportService{
+			Collection: mt.Coll,
+			Alerts:     mt.Coll,
+			Cameras:    mt.Coll,
+			Buildings:  mt.Coll,
+			Floors:     mt.Coll,
+			Blobs:      &memoryBlobs{},
+			TTL:        time.Hour,
+			Lease:      time.Minute,
+		}
+	}
+	// Camera, building and floor names, then the alerts.
+	mockAlerts := func(mt *mtest.T) {
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch, bson.D{{Key: "_id", Value: cameraID}, {Key: "name", Value: "Вход"}}),
+			mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch, bson.D{{Key: "_id", Value: buildingID}, {Key: "name", Value: "Склад, корпус 2"}}),
+			mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch),
+			mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch, bson.D{
+				{Key: "_id", Value: alertID},
+				{Key: "alert_type", Value: "Intrusion"},
+				{Key: "severity", Value: models.SeverityHigh},
+				{Key: "status", Value: models.AlertStatusResolved},
+				{Key: "resolution", Value: models.AlertResolutionFalsePositive},
+				{Key: "cameraId", Value: cameraID},
+				{Key: "buildingId", Value: buildingID},
+				{Key: "start_datetime", Value: raised},
+				{Key: "organizationId", Value: orgID},
+			}),
+		)
+	}
+
+	mt.Run("writes csv with names and local times", func(mt *mtest.T) {
+		mockAlerts(mt)
+
+		var out bytes.Buffer
+		rows, err := newService(mt).WriteAlerts(context.Background(), orgID, bson.M{}, bson.D{}, models.ExportCSV, moscow, &out)
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), rows)
+
+		require.True(t, strings.HasPrefix(out.String(), "\uFEFFID,Тип,Важность"))
+		lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+		require.Len(t, lines, 2)
+		assert.Contains(t, lines[1], alertID.Hex()+",Intrusion,высокая,закрыта,ложное срабатывание,")
+		assert.Contains(t, lines[1], `"Склад, корпус 2",,Вход,`)
+		assert.Contains(t, lines[1], "04.03.2024 12:30:00")
+	})
+
+	mt.Run("writes xlsx", func(mt *mtest.T) {
+		mockAlerts(mt)
+
+		var out bytes.Buffer
+		_, err := newService(mt).WriteAlerts(context.Background(), orgID, bson.M{}, bson.D{}, models.ExportXLSX, time.UTC, &out)
+		require.NoError(t, err)
+
+		archive, err := zip.NewReader(bytes.NewReader(out.Bytes()), int64(out.Len()))
+		require.NoError(t, err)
+		var sheet string
+		for _, f := range archive.File {
+			if f.Name == "xl/worksheets/sheet1.xml" {
+				r, err := f.Open()
+				require.NoError(t, err)
+				data, err := io.ReadAll(r)
+				require.NoError(t, err)
+				sheet = string(data)
+			}
+		}
+		assert.Contains(t, sheet, `<c r="A1" t="inlineStr" s="1"><is><t xml:space="preserve">ID</t></is></c>`)
+		assert.Contains(t, sheet, `<c r="C2" t="inlineStr"><is><t xml:space="preserve">высокая</t></is></c>`)
+		assert.Contains(t, sheet, `<row r="2">`)
+		assert.NotContains(t, sheet, `<row r="3">`)
+	})
+
+	mt.Run("keeps spreadsheets from running cells as formulas", func(mt *mtest.T) {
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch, bson.D{{Key: "_id", Value: cameraID}, {Key: "name", Value: "=HYPERLINK(\"http://evil\")"}}),
+			mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch),
+			mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch),
+			mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch, bson.D{
+				{Key: "_id", Value: alertID},
+				{Key: "alert_type", Value: "Intrusion"},
+				{Key: "cameraId", Value: cameraID},
+				{Key: "tags", Value: bson.A{"@sum(a1)"}},
+				{Key: "start_datetime", Value: raised},
+				{Key: "organizationId", Value: orgID},
+			}),
+		)
+
+		var out bytes.Buffer
+		_, err := newService(mt).WriteAlerts(context.Background(), orgID, bson.M{}, bson.D{}, models.ExportCSV, time.UTC, &out)
+		require.NoError(t, err)
+
+		assert.Contains(t, out.String(), `"'=HYPERLINK(""http://evil"")"`)
+		assert.Contains(t, out.String(), `'@sum(a1)`)
+		assert.NotContains(t, out.String(), `,=`)
+	})
+
+	mt.Run("rejects an unknown format", func(mt *mtest.T) {
+		_, err := newService(mt).CreateJob(services.AccessScope{OrganizationID: orgID}, "user", "ods", "UTC", bson.M{}, bson.D{})
+		assert.Error(t, err)
+	})
+
+	mt.Run("background job writes the file", func(mt *mtest.T) {
+		service := newService(mt)
+		jobID := primitive.NewObjectID()
+		mt.AddMockResponses(bson.D{{Key: "ok", Value: 1}, {Key: "value", Value: bson.D{
+			{Key: "_id", Value: jobID},
+			{Key: "organizationId", Value: orgID},
+			{Key: "format", Value: models.ExportCSV},
+			{Key: "timezone", Value: "UTC"},
+			{Key: "query", Value: bson.D{{Key: "organizationId", Value: orgID}}},
+			{Key: "sort", Value: bson.D{{Key: "priority", Value: -1}}},
+			{Key: "status", Value: models.ExportRunning},
+		}}})
+		mockAlerts(mt)
+		mt.AddMockResponses(mtest.CreateSuccessResponse())
+
+		assert.True(t, service.ProcessNext())
+
+		file, err := service.OpenJob(&models.ExportJob{ID: jobID, OrganizationID: orgID, Format: models.ExportCSV, Status: models.ExportDone})
+		require.NoError(t, err)
+		data, err := io.ReadAll(file)
+		file.Close()
+		require.NoError(t, err)
+		assert.Contains(t, string(data), alertID.Hex())
+
+		events := mt.GetAllStartedEvents()
+		update := events[len(events)-1].Command
+		set := update.Lookup("updates").Array().Index(0).Value().Document().Lookup("u", "$set").Document()
+		assert.Equal(t, models.ExportDone, set.Lookup("status").StringValue())
+		assert.Equal(t, int64(1), set.Lookup("rows").Int64())
+		assert.Equal(t, int64(len(data)), set.Lookup("size").Int64())
+	})
+
+	mt.Run("failed job stores no file", func(mt *mtest.T) {
+		service := newService(mt)
+		jobID := primitive.NewObjectID()
+		mt.AddMockResponses(bson.D{{Key: "ok", Value: 1}, {Key: "value", Value: bson.D{
+			{Key: "_id", Value: jobID},
+			{Key: "organizationId", Value: orgID},
+			{Key: "format", Value: models.ExportCSV},
+			{Key: "timezone", Value: "UTC"},
+			{Key: "status", Value: models.ExportRunning},
+		}}})
+		mt.AddMockResponses(mtest.CreateCommandErrorResponse(mtest.CommandError{Code: 1, Message: "сбой"}))
+		mt.AddMockResponses(mtest.CreateSuccessResponse())
+
+		assert.True(t, service.ProcessNext())
+
+		_, err := service.Blobs.Open(context.Background(), orgID.Hex()+"/"+jobID.Hex()+".csv")
+		assert.ErrorIs(t, err, services.ErrBlobNotFound)
+
+		events := mt.GetAllStartedEvents()
+		update := events[len(events)-1].Command
+		set := update.Lookup("updates").Array().Index(0).Value().Document().Lookup("u", "$set").Document()
+		assert.Equal(t, models.ExportFailed, set.Lookup("status").StringValue())
+	})
+
+	mt.Run("unfinished job has no file", func(mt *mtest.T) {
+		_, err := newService(mt).OpenJob(&models.ExportJob{ID: primitive.NewObjectID(), Status: models.ExportRunning})
+		assert.ErrorIs(t, err, services.ErrExportNotReady)
+	})
+}
+
+// writeTestFont writes a minimal TrueType font and returns its path. Its
+// cmap maps printable ASCII to glyphs 1-95 and А-я to glyphs 96-159; the
+// advances are 500 for glyph 0, 250 for glyph 1 and 600 for the others,
+// at 2000 units per em.
+func writeTestFont(t *testing.T) string {
+	be := binary.BigEndian
+	u16 := func(values ...int) []byte {
+		out := make([]byte, 0, 2*len(values))
+		for _, v := range values {
+			out = be.AppendUint16(out, uint16(v))
+		}
+		return out
+	}
+
+	head := make([]byte, 54)
+	be.PutUint16(head[18:], 2000)
+	copy(head[36:], u16(0, -400, 1800, 1600))
+	hhea := make([]byte, 36)
+	copy(hhea[4:], u16(1600, -400))
+	be.PutUint16(hhea[34:], 3)
+	hmtx := u16(500, 0, 250, 0, 600, 0)
+
+	var subtable []byte
+	subtable = append(subtable, u16(4, 40, 0, 6, 4, 1, 2)...)
+	subtable = append(subtable, u16(0x7E, 0x44F, 0xFFFF, 0)...)
+	subtable = append(subtable, u16(0x20, 0x410, 0xFFFF)...)
+	subtable = append(subtable, u16(1-0x20, 96-0x410, 1)...)
+	subtable = append(subtable, u16(0, 0, 0)...)
+	cmap := append(u16(0, 1, 3, 1), be.AppendUint32(nil, 12)...)
+	cmap = append(cmap, subtable...)
+
+	tables := []struct {
+		tag  string
+		data []byte
+	}{{"cmap", cmap}, {"glyf", make([]byte, 4)}, {"head", head}, {"hhea", hhea}, {"hmtx", hmtx}}
+
+	font := append(be.AppendUint32(nil, 0x00010000), u16(len(tables), 64, 2, 16)...)
+	offset := 12 + 16*len(tables)
+	var data []byte
+	for _, table := range tables {
+		font = append(font, table.tag...)
+		font = be.AppendUint32(font, 0)
+		font = be.AppendUint32(font, uint32(offset+len(data)))
+		font = be.AppendUint32(font, uint32(len(table.data)))
+		data = append(data, table.data...)
+		for len(data)%4 != 0 {
+			data = append(data, 0)
+		}
+	}
+	font = append(font, data...)
+
+	path := filepath.Join(t.TempDir(), "font.ttf")
+	require.NoError(t, os.WriteFile(path, font, 0o644))
+	return path
+}
+
+// pdfStreams returns the inflated content of the compressed streams of a
+// PDF.
+func pdfStreams(t *testing.T, pdf []byte) []string {
+	var streams []string
+	for _, part := range bytes.Split(pdf, []byte("/Filter /FlateDecode"))[1:] {
+		start := bytes.Index(part, []byte("stream\n"))
+		end := bytes.Index(part, []byte("\nendstream"))
+		require.True(t, start >= 0 && end > start)
+		r, err := zlib.NewReader(bytes.NewReader(part[start+len("stream\n") : end]))
+		require.NoError(t, err)
+		data, err := io.ReadAll(r)
+		require.NoError(t, err)
+		streams = append(streams, string(data))
+	}
+	return streams
+}
+
+func TestAlertReport(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	fontPath := writeTestFont(t)
+
+	mt.Run("renders a pdf", func(mt *mtest.T) {
+		orgID, alertID := primitive.NewObjectID(), primitive.NewObjectID()
+		raised := time.Date(2024, 3, 4, 9, 30, 0, 0, time.UTC)
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch, bson.D{
+				{Key: "_id", Value: alertID},
+				{Key: "alert_type", Value: "Intrusion"},
+				{Key: "status", Value: models.AlertStatusAcknowledged},
+				{Key: "acknowledgedBy", Value: "operator"},
+				{Key: "acknowledgedAt", Value: raised.Add(time.Minute)},
+				{Key: "start_datetime", Value: raised},
+				{Key: "organizationId", Value: orgID},
+			}),
+			mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch),
+			mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch),
+			mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch),
+			mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch, bson.D{
+				{Key: "_id", Value: primitive.NewObjectID()},
+				{Key: "alertId", Value: alertID},
+				{Key: "channel", Value: models.ChannelEmail},
+				{Key: "address", Value: "guard@example.com"},
+				{Key: "status", Value: models.NotificationSent},
+				{Key: "createdAt", Value: raised.Add(2 * time.Second)},
+			}),
+			mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch),
+		)
+		service := &services.ReportService{
+			Alerts:        mt.Coll,
+			Notifications: mt.Coll,
+			Escalations:   mt.Coll,
+			Cameras:       mt.Coll,
+			Buildings:     mt.Coll,
+			Floors:        mt.Coll,
+			FontPath:      fontPath,
+		}
+
+		report, err := service.AlertReport(services.AccessScope{OrganizationID: orgID}, alertID.Hex(), time.UTC)
+		require.NoError(t, err)
+		assert.True(t, bytes.HasPrefix(report, []byte("%PDF-1.4")))
+		assert.True(t, bytes.HasSuffix(report, []byte("%%EOF\n")))
+
+		// The font is embedded whole, with widths scaled to thousandths of
+		// an em for the glyphs used: the space and "О" of the heading.
+		font, err := os.ReadFile(fontPath)
+		require.NoError(t, err)
+		assert.Contains(t, string(report), "/Subtype /Type0 /BaseFont /ReportFont /Encoding /Identity-H")
+		assert.Contains(t, string(report), fmt.Sprintf("/Length1 %d", len(font)))
+		assert.Contains(t, string(report), "/FontBBox [0 -200 900 800]")
+		assert.Contains(t, string(report), "/W [1 [125] ")
+		assert.Contains(t, string(report), " 110 [300] ")
+
+		// Text stays searchable: ToUnicode maps the glyphs back.
+		var toUnicode string
+		for _, stream := range pdfStreams(t, report) {
+			if strings.Contains(stream, "beginbfchar") {
+				toUnicode = stream
+			}
+		}
+		assert.Contains(t, toUnicode, "<0001> <0020>")
+		assert.Contains(t, toUnicode, "<006E> <041E>")
+
+		// Recipients' addresses are not even loaded.
+		var projection bson.Raw
+		for _, event := range mt.GetAllStartedEvents() {
+			if _, err := event.Command.LookupErr("filter", "alertId"); err == nil && projection == nil {
+				projection = event.Command.Lookup("projection").Document()
+			}
+		}
+		require.NotNil(t, projection)
+		assert.Equal(t, int32(0), projection.Lookup("address").Int32())
+
+		// The xref table must point at the objects.
+		start := bytes.LastIndex(report, []byte("startxref\n"))
+		require.NotEqual(t, -1, start)
+		var xref int
+		_, err = fmt.Sscan(string(report[start+len("startxref\n"):]), &xref)
+		require.NoError(t, err)
+		assert.True(t, bytes.HasPrefix(report[xref:], []byte("xref\n")))
+	})
+
+	mt.Run("rejects an invalid id", func(mt *mtest.T) {
+		_, err := (&services.ReportService{}).AlertReport(services.AccessScope{}, "nope", time.UTC)
+		assert.Error(t, err)
+	})
+
+	mt.Run("refuses reports without a font", func(mt *mtest.T) {
+		notFont := filepath.Join(t.TempDir(), "font.ttf")
+		require.NoError(t, os.WriteFile(notFont, []byte("not a font"), 0o644))
+
+		for _, path := range []string{"", notFont} {
+			service := &services.ReportService{Alerts: mt.Coll, FontPath: path}
+			_, err := service.AlertReport(services.AccessScope{}, primitive.NewObjectID().Hex(), time.UTC)
+			assert.ErrorIs(t, err, services.ErrReportFont)
+		}
+		assert.Empty(t, mt.GetAllStartedEvents())
+	})
+}
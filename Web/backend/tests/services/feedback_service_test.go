@@ -0,0 +1,296 @@
+package services_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"backend/models"
+	"backend/services"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/event"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestFeedbackLabels(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	orgID, alertID, cameraID := primitive.NewObjectID(), primitive.NewObjectID(), primitive.NewObjectID()
+	scope := services.AccessScope{OrganizationID: orgID}
+	clipStart := time.Date(2024, 3, 4, 9, 30, 0, 0, time.UTC)
+	alertDoc := bson.D{
+		{Key: "_id", Value: alertID},
+		{Key: "alert_type", Value: "Intrusion"},
+		{Key: "confidence", Value: 0.87},
+		{Key: "modelVersion", Value: "stead-3"},
+		{Key: "cameraId", Value: cameraID},
+		{Key: "clip", Value: bson.D{
+			{Key: "path", Value: "cam1/20240304-093000.mp4"},
+			{Key: "start", Value: clipStart},
+			{Key: "end", Value: clipStart.Add(4 * time.Second)},
+		}},
+		{Key: "status", Value: models.AlertStatusAcknowledged},
+		{Key: "organizationId", Value: orgID},
+	}
+	newService := func(mt *mtest.T) *services.AlertService {
+		return &services.AlertService{Collection: mt.Coll, Feedback: &services.FeedbackService{Collection: mt.Coll, Alerts: mt.Coll}}
+	}
+	setOf := func(event *event.CommandStartedEvent) bson.Raw {
+		return event.Command.Lookup("update", "$set").Document()
+	}
+
+	mt.Run("stores the verdict with the clip", func(mt *mtest.T) {
+		mt.AddMockResponses(
+			bson.D{{Key: "ok", Value: 1}, {Key: "value", Value: alertDoc}},
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}),
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}),
+		)
+
+		_, after, err := newService(mt).ResolveAlert(scope, alertID.Hex(), "operator", models.AlertResolutionFalsePositive)
+		require.NoError(t, err)
+		assert.Equal(t, "operator", after.LabeledBy)
+
+		events := mt.GetAllStartedEvents()
+		require.Len(t, events, 3)
+		assert.True(t, setOf(events[0]).Lookup("labelPending").Boolean())
+		update := events[1].Command.Lookup("updates").Array().Index(0).Value().Document()
+		assert.True(t, update.Lookup("upsert").Boolean())
+		assert.Equal(t, alertID, update.Lookup("q", "alertId").ObjectID())
+		assert.Equal(t, after.LabeledAt.UnixMilli(), update.Lookup("q", "labeledAt", "$lte").Time().UnixMilli())
+
+		label := update.Lookup("u").Document()
+		assert.Equal(t, int32(models.LabelNormal), label.Lookup("label").Int32())
+		assert.Equal(t, models.AlertResolutionFalsePositive, label.Lookup("resolution").StringValue())
+		assert.Equal(t, "cam1/20240304-093000.mp4", label.Lookup("clipPath").StringValue())
+		assert.Equal(t, "stead-3", label.Lookup("modelVersion").StringValue())
+		assert.Equal(t, 0.87, label.Lookup("score").Double())
+		assert.Equal(t, cameraID, label.Lookup("cameraId").ObjectID())
+		assert.Equal(t, clipStart, label.Lookup("start").Time().UTC())
+		assert.Equal(t, "operator", label.Lookup("labeledBy").StringValue())
+
+		cleared := events[2].Command.Lookup("updates").Array().Index(0).Value().Document()
+		assert.Equal(t, alertID, cleared.Lookup("q", "_id").ObjectID())
+		assert.False(t, cleared.Lookup("u", "$set", "labelPending").Boolean())
+	})
+
+	mt.Run("keeps a newer label", func(mt *mtest.T) {
+		mt.AddMockResponses(
+			bson.D{{Key: "ok", Value: 1}, {Key: "value", Value: alertDoc}},
+			mtest.CreateWriteErrorsResponse(mtest.WriteError{Index: 0, Code: 11000, Message: "duplicate key"}),
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}),
+		)
+
+		_, _, err := newService(mt).ResolveAlert(scope, alertID.Hex(), "operator", models.AlertResolutionConfirmed)
+		require.NoError(t, err)
+		assert.Len(t, mt.GetAllStartedEvents(), 3)
+	})
+
+	mt.Run("leaves the alert pending when the label is not stored", func(mt *mtest.T) {
+		mt.AddMockResponses(
+			bson.D{{Key: "ok", Value: 1}, {Key: "value", Value: alertDoc}},
+			mtest.CreateCommandErrorResponse(mtest.CommandError{Code: 1, Message: "сбой"}),
+		)
+
+		_, _, err := newService(mt).ResolveAlert(scope, alertID.Hex(), "operator", models.AlertResolutionConfirmed)
+		require.NoError(t, err)
+		assert.Len(t, mt.GetAllStartedEvents(), 2)
+	})
+
+	mt.Run("ignores a verdict sent with a new alert", func(mt *mtest.T) {
+		mt.AddMockResponses(mtest.CreateSuccessResponse())
+		labeledAt := time.Now()
+		alert, err := newService(mt).CreateAlert(scope, &models.Alert{
+			AlertType:    models.AlertTypeIntrusion,
+			Resolution:   models.AlertResolutionFalsePositive,
+			LabeledBy:    "intruder",
+			LabeledAt:    &labeledAt,
+			LabelPending: true,
+		})
+		require.NoError(t, err)
+		assert.Empty(t, alert.Resolution)
+
+		inserted := mt.GetStartedEvent().Command.Lookup("documents").Array().Index(0).Value().Document()
+		for _, field := range []string{"resolution", "labeledBy", "labeledAt", "labelPending"} {
+			_, err := inserted.LookupErr(field)
+			assert.Error(t, err, field)
+		}
+
+		// An alert stored with a verdict by an older version and resolved
+		// without one gets no label either.
+		planted := append(bson.D{}, alertDoc...)
+		planted = append(planted, bson.E{Key: "resolution", Value: models.AlertResolutionFalsePositive})
+		mt.ClearEvents()
+		mt.AddMockResponses(bson.D{{Key: "ok", Value: 1}, {Key: "value", Value: planted}})
+
+		_, after, err := newService(mt).ResolveAlert(scope, alertID.Hex(), "operator", "")
+		require.NoError(t, err)
+		assert.Empty(t, after.Resolution)
+		events := mt.GetAllStartedEvents()
+		require.Len(t, events, 1)
+		assert.Equal(t, "", events[0].Command.Lookup("update", "$set", "resolution").StringValue())
+	})
+
+	mt.Run("skips alerts resolved without a verdict", func(mt *mtest.T) {
+		mt.AddMockResponses(bson.D{{Key: "ok", Value: 1}, {Key: "value", Value: alertDoc}})
+
+		_, _, err := newService(mt).ResolveAlert(scope, alertID.Hex(), "operator", "")
+		require.NoError(t, err)
+		assert.Len(t, mt.GetAllStartedEvents(), 1)
+	})
+}
+
+func TestFeedbackCorrection(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	orgID, alertID := primitive.NewObjectID(), primitive.NewObjectID()
+	scope := services.AccessScope{OrganizationID: orgID}
+	resolved := time.Date(2024, 3, 4, 10, 0, 0, 0, time.UTC)
+	alertDoc := bson.D{
+		{Key: "_id", Value: alertID},
+		{Key: "alert_type", Value: "Intrusion"},
+		{Key: "status", Value: models.AlertStatusResolved},
+		{Key: "resolution", Value: models.AlertResolutionConfirmed},
+		{Key: "resolvedBy", Value: "operator"},
+		{Key: "resolvedAt", Value: resolved},
+		{Key: "organizationId", Value: orgID},
+	}
+	feedback := func(mt *mtest.T) *services.FeedbackService {
+		return &services.FeedbackService{Collection: mt.Coll, Alerts: mt.Coll}
+	}
+
+	mt.Run("replaces the label of a resolved alert", func(mt *mtest.T) {
+		mt.AddMockResponses(
+			bson.D{{Key: "ok", Value: 1}, {Key: "value", Value: alertDoc}},
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}),
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}),
+		)
+
+		service := &services.AlertService{Collection: mt.Coll, Feedback: feedback(mt)}
+		before, after, err := service.SetResolution(scope, alertID.Hex(), "supervisor", models.AlertResolutionFalsePositive)
+		require.NoError(t, err)
+		assert.Equal(t, models.AlertResolutionConfirmed, before.Resolution)
+		assert.Equal(t, models.AlertResolutionFalsePositive, after.Resolution)
+
+		events := mt.GetAllStartedEvents()
+		require.Len(t, events, 3)
+		assert.Equal(t, models.AlertStatusResolved, events[0].Command.Lookup("query", "status").StringValue())
+		label := events[1].Command.Lookup("updates").Array().Index(0).Value().Document().Lookup("u").Document()
+		assert.Equal(t, int32(models.LabelNormal), label.Lookup("label").Int32())
+		assert.Equal(t, "supervisor", label.Lookup("labeledBy").StringValue())
+	})
+
+	mt.Run("refuses alerts that are not resolved", func(mt *mtest.T) {
+		mt.AddMockResponses(bson.D{{Key: "ok", Value: 1}, {Key: "value", Value: nil}})
+
+		service := &services.AlertService{Collection: mt.Coll, Feedback: feedback(mt)}
+		_, _, err := service.SetResolution(scope, alertID.Hex(), "supervisor", models.AlertResolutionConfirmed)
+		assert.Error(t, err)
+	})
+
+	mt.Run("refuses an empty verdict", func(mt *mtest.T) {
+		service := &services.AlertService{Collection: mt.Coll, Feedback: feedback(mt)}
+		_, _, err := service.SetResolution(scope, alertID.Hex(), "supervisor", "")
+		assert.Error(t, err)
+	})
+
+	mt.Run("retries pending labels", func(mt *mtest.T) {
+		pending := append(bson.D{}, alertDoc...)
+		pending = append(pending, bson.E{Key: "labelPending", Value: true})
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch, pending),
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}),
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}),
+		)
+
+		assert.Equal(t, 1, feedback(mt).RetryLabels())
+
+		events := mt.GetAllStartedEvents()
+		require.Len(t, events, 3)
+		assert.True(t, events[0].Command.Lookup("filter", "labelPending").Boolean())
+		label := events[1].Command.Lookup("updates").Array().Index(0).Value().Document().Lookup("u").Document()
+		assert.Equal(t, "operator", label.Lookup("labeledBy").StringValue())
+		assert.Equal(t, resolved, label.Lookup("labeledAt").Time().UTC())
+	})
+}
+
+func TestValidClipPath(t *testing.T) {
+	assert.True(t, services.ValidClipPath("cam1/20240304-093000.mp4"))
+	assert.True(t, services.ValidClipPath("cam1/..clip.mp4"))
+	for _, clipPath := range []string{"", "/etc/passwd", "../secrets/clip.mp4", "cam1/../../clip.mp4", `..\clip.mp4`, `C:\clips\clip.mp4`, "s3://bucket/clip.mp4"} {
+		assert.False(t, services.ValidClipPath(clipPath), clipPath)
+	}
+}
+
+func TestTrainingManifest(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	orgID, alertID, cameraID := primitive.NewObjectID(), primitive.NewObjectID(), primitive.NewObjectID()
+	scope := services.AccessScope{OrganizationID: orgID}
+	start := time.Date(2024, 3, 4, 9, 30, 0, 0, time.UTC)
+	mockLabels := func(mt *mtest.T) {
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch, bson.D{
+			{Key: "_id", Value: primitive.NewObjectID()},
+			{Key: "alertId", Value: alertID},
+			{Key: "organizationId", Value: orgID},
+			{Key: "cameraId", Value: cameraID},
+			{Key: "alertType", Value: "Fight"},
+			{Key: "modelVersion", Value: "stead-3"},
+			{Key: "score", Value: 0.91},
+			{Key: "start", Value: start},
+			{Key: "end", Value: start.Add(4 * time.Second)},
+			{Key: "clipPath", Value: "cam1/fight.mp4"},
+			{Key: "resolution", Value: models.AlertResolutionConfirmed},
+			{Key: "label", Value: models.LabelAnomaly},
+		}))
+	}
+	service := func(mt *mtest.T) *services.FeedbackService {
+		return &services.FeedbackService{Collection: mt.Coll}
+	}
+
+	mt.Run("writes json lines", func(mt *mtest.T) {
+		mockLabels(mt)
+
+		var out bytes.Buffer
+		rows, err := service(mt).WriteManifest(context.Background(), scope, services.TrainingFilter{ModelVersion: "stead-3"}, services.ManifestJSONL, &out)
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), rows)
+
+		var entry map[string]interface{}
+		require.NoError(t, json.Unmarshal(out.Bytes(), &entry))
+		assert.Equal(t, "cam1/fight.mp4", entry["clip"])
+		assert.Equal(t, float64(models.LabelAnomaly), entry["label"])
+		assert.Equal(t, "Fight", entry["class"])
+		assert.Equal(t, cameraID.Hex(), entry["camera_id"])
+		assert.Equal(t, "2024-03-04T09:30:00Z", entry["start"])
+		assert.Equal(t, 0.91, entry["score"])
+
+		filter := mt.GetStartedEvent().Command.Lookup("filter").Document()
+		assert.Equal(t, "stead-3", filter.Lookup("modelVersion").StringValue())
+		assert.True(t, filter.Lookup("clipPath", "$exists").Boolean())
+	})
+
+	mt.Run("writes csv", func(mt *mtest.T) {
+		mockLabels(mt)
+
+		var out bytes.Buffer
+		_, err := service(mt).WriteManifest(context.Background(), scope, services.TrainingFilter{}, services.ManifestCSV, &out)
+		require.NoError(t, err)
+
+		lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+		require.Len(t, lines, 2)
+		assert.Equal(t, "clip,label,class,camera_id,start,end,model_version,score,alert_id", lines[0])
+		assert.Equal(t, "cam1/fight.mp4,1,Fight,"+cameraID.Hex()+",2024-03-04T09:30:00Z,2024-03-04T09:30:04Z,stead-3,0.91,"+alertID.Hex(), lines[1])
+	})
+
+	mt.Run("rejects an unknown format", func(mt *mtest.T) {
+		_, err := service(mt).WriteManifest(context.Background(), scope, services.TrainingFilter{}, "parquet", &bytes.Buffer{})
+		assert.Error(t, err)
+	})
+}
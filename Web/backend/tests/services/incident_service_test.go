@@ -0,0 +1,218 @@
+package services_test
+
+import (
+	"backend/models"
+	"backend/services"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestCorrelateAlert(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	orgID, floorID := primitive.NewObjectID(), primitive.NewObjectID()
+	corridor, stairs, lobby := primitive.NewObjectID(), primitive.NewObjectID(), primitive.NewObjectID()
+
+	newAlert := func() *models.Alert {
+		return &models.Alert{
+			ID:             primitive.NewObjectID(),
+			OrganizationID: orgID,
+			AlertType:      models.AlertTypeFight,
+			Severity:       models.SeverityHigh,
+			Priority:       3,
+			CameraID:       corridor,
+			FloorID:        floorID,
+			StartDateTime:  time.Now(),
+		}
+	}
+	cameras := mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch,
+		bson.D{{Key: "_id", Value: corridor}, {Key: "neighborIds", Value: bson.A{stairs}}},
+		bson.D{{Key: "_id", Value: lobby}, {Key: "neighborIds", Value: bson.A{corridor}}},
+	)
+	newService := func(mt *mtest.T) *services.IncidentService {
+		return &services.IncidentService{Collection: mt.Coll, Alerts: mt.Coll, Cameras: mt.Coll, Window: time.Minute}
+	}
+
+	mt.Run("joins an open incident nearby", func(mt *mtest.T) {
+		incidentID := primitive.NewObjectID()
+		mt.AddMockResponses(cameras, bson.D{{Key: "ok", Value: 1}, {Key: "value", Value: bson.D{
+			{Key: "_id", Value: incidentID},
+			{Key: "status", Value: models.AlertStatusNew},
+			{Key: "priority", Value: 3},
+			{Key: "alertCount", Value: 1},
+		}}})
+
+		alert := newAlert()
+		incident, err := newService(mt).Correlate(alert)
+
+		assert.NoError(t, err)
+		assert.Equal(t, 2, incident.AlertCount)
+		assert.Equal(t, incidentID, alert.IncidentID)
+		assert.True(t, alert.Correlated)
+
+		query := mt.GetStartedEvent()
+		for query != nil && query.CommandName != "findAndModify" {
+			query = mt.GetStartedEvent()
+		}
+		nearby := query.Command.Lookup("query", "$or").Array()
+		assert.Equal(t, floorID, nearby.Index(0).Value().Document().Lookup("floorId").ObjectID())
+		var cameraIDs []primitive.ObjectID
+		values, _ := nearby.Index(1).Value().Document().Lookup("cameraIds", "$in").Array().Values()
+		for _, v := range values {
+			cameraIDs = append(cameraIDs, v.ObjectID())
+		}
+		assert.ElementsMatch(t, []primitive.ObjectID{corridor, stairs, lobby}, cameraIDs)
+	})
+
+	mt.Run("announces alerts that raise the severity or follow the acknowledgement", func(mt *mtest.T) {
+		for _, incident := range []bson.D{
+			{{Key: "_id", Value: primitive.NewObjectID()}, {Key: "status", Value: models.AlertStatusNew}, {Key: "priority", Value: 2}, {Key: "severity", Value: models.SeverityMedium}},
+			{{Key: "_id", Value: primitive.NewObjectID()}, {Key: "status", Value: models.AlertStatusAcknowledged}, {Key: "priority", Value: 3}, {Key: "severity", Value: models.SeverityHigh}},
+		} {
+			mt.AddMockResponses(cameras, bson.D{{Key: "ok", Value: 1}, {Key: "value", Value: incident}})
+
+			alert := newAlert()
+			joined, err := newService(mt).Correlate(alert)
+
+			assert.NoError(t, err)
+			assert.False(t, alert.Correlated)
+			assert.Equal(t, models.SeverityHigh, joined.Severity)
+		}
+	})
+
+	mt.Run("opens a new incident", func(mt *mtest.T) {
+		mt.AddMockResponses(cameras, bson.D{{Key: "ok", Value: 1}, {Key: "value", Value: nil}}, mtest.CreateSuccessResponse(), mtest.CreateSuccessResponse())
+
+		alert := newAlert()
+		incident, err := newService(mt).Correlate(alert)
+
+		assert.NoError(t, err)
+		assert.False(t, alert.Correlated)
+		assert.Equal(t, incident.ID, alert.IncidentID)
+		assert.Equal(t, []primitive.ObjectID{alert.ID}, incident.AlertIDs)
+		assert.Equal(t, []primitive.ObjectID{corridor}, incident.CameraIDs)
+		assert.Equal(t, models.AlertStatusNew, incident.Status)
+
+		// A stale incident of the floor gives up its key before the insert.
+		key := orgID.Hex() + ":" + string(models.AlertTypeFight) + ":floor:" + floorID.Hex()
+		events := mt.GetAllStartedEvents()
+		retire := events[len(events)-2].Command.Lookup("updates").Array().Index(0).Value().Document()
+		assert.Equal(t, key, retire.Lookup("q", "openKey").StringValue())
+		inserted := events[len(events)-1].Command.Lookup("documents").Array().Index(0).Value().Document()
+		assert.Equal(t, key, inserted.Lookup("openKey").StringValue())
+	})
+
+	mt.Run("joins the incident another server opened meanwhile", func(mt *mtest.T) {
+		incidentID := primitive.NewObjectID()
+		mt.AddMockResponses(
+			cameras,
+			bson.D{{Key: "ok", Value: 1}, {Key: "value", Value: nil}},
+			mtest.CreateSuccessResponse(),
+			mtest.CreateWriteErrorsResponse(mtest.WriteError{Index: 0, Code: 11000, Message: "duplicate key"}),
+			bson.D{{Key: "ok", Value: 1}, {Key: "value", Value: bson.D{
+				{Key: "_id", Value: incidentID},
+				{Key: "status", Value: models.AlertStatusNew},
+				{Key: "priority", Value: 3},
+				{Key: "alertCount", Value: 1},
+			}}},
+		)
+
+		alert := newAlert()
+		incident, err := newService(mt).Correlate(alert)
+
+		assert.NoError(t, err)
+		assert.Equal(t, incidentID, alert.IncidentID)
+		assert.Equal(t, 2, incident.AlertCount)
+		assert.True(t, alert.Correlated)
+	})
+}
+
+func TestDetachAlert(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	alert := &models.Alert{ID: primitive.NewObjectID(), IncidentID: primitive.NewObjectID()}
+
+	mt.Run("removes the incident the alert opened", func(mt *mtest.T) {
+		mt.AddMockResponses(mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}))
+
+		service := &services.IncidentService{Collection: mt.Coll}
+		assert.NoError(t, service.Detach(alert))
+		assert.Len(t, mt.GetAllStartedEvents(), 1)
+	})
+
+	mt.Run("takes the alert out of a joined incident", func(mt *mtest.T) {
+		mt.AddMockResponses(
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 0}),
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}, bson.E{Key: "nModified", Value: 1}),
+		)
+
+		service := &services.IncidentService{Collection: mt.Coll}
+		assert.NoError(t, service.Detach(alert))
+
+		events := mt.GetAllStartedEvents()
+		update := events[len(events)-1].Command.Lookup("updates").Array().Index(0).Value().Document()
+		assert.Equal(t, alert.ID, update.Lookup("u", "$pull", "alertIds").ObjectID())
+		assert.Equal(t, int32(-1), update.Lookup("u", "$inc", "alertCount").Int32())
+	})
+}
+
+func TestIncidentLocationScope(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	buildingID := primitive.NewObjectID()
+	scope := services.AccessScope{OrganizationID: primitive.NewObjectID(), Restricted: true, BuildingIDs: []primitive.ObjectID{buildingID}}
+	incidentID := primitive.NewObjectID()
+	newService := func(mt *mtest.T) *services.IncidentService {
+		return &services.IncidentService{Collection: mt.Coll, Alerts: mt.Coll}
+	}
+
+	mt.Run("lists only alerts in scope", func(mt *mtest.T) {
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch, bson.D{{Key: "_id", Value: incidentID}, {Key: "buildingId", Value: buildingID}}),
+			mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch),
+		)
+
+		_, _, err := newService(mt).GetIncident(scope, incidentID.Hex())
+		assert.NoError(t, err)
+
+		events := mt.GetAllStartedEvents()
+		filter := events[len(events)-1].Command.Lookup("filter").Document()
+		assert.Equal(t, incidentID, filter.Lookup("incidentId").ObjectID())
+		_, err = filter.LookupErr("$or")
+		assert.NoError(t, err)
+	})
+
+	mt.Run("refuses incidents with alerts outside the scope", func(mt *mtest.T) {
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch, bson.D{{Key: "n", Value: 1}}))
+
+		_, _, err := newService(mt).ResolveIncident(scope, incidentID.Hex(), "operator")
+		assert.Error(t, err)
+		assert.Len(t, mt.GetAllStartedEvents(), 1)
+	})
+
+	mt.Run("updates only alerts in scope", func(mt *mtest.T) {
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch, bson.D{{Key: "n", Value: 0}}),
+			bson.D{{Key: "ok", Value: 1}, {Key: "value", Value: bson.D{{Key: "_id", Value: incidentID}, {Key: "status", Value: models.AlertStatusNew}}}},
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 2}, bson.E{Key: "nModified", Value: 2}),
+		)
+
+		_, after, err := newService(mt).ResolveIncident(scope, incidentID.Hex(), "operator")
+		assert.NoError(t, err)
+		assert.Equal(t, models.AlertStatusResolved, after.Status)
+
+		events := mt.GetAllStartedEvents()
+		update := events[len(events)-1].Command.Lookup("updates").Array().Index(0).Value().Document()
+		_, err = update.Lookup("q").Document().LookupErr("$or")
+		assert.NoError(t, err)
+		_, err = update.LookupErr("u", "$unset")
+		assert.Error(t, err)
+
+		// The resolved incident frees its open key.
+		_, err = events[len(events)-2].Command.LookupErr("update", "$unset", "openKey")
+		assert.NoError(t, err)
+	})
+}
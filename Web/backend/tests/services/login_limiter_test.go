@@ -0,0 +1,100 @@
+package services_test
+
+import (
+	"backend/services"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestLoginDelay(t *testing.T) {
+	limiter := services.LoginLimiter{BaseDelay: 250 * time.Millisecond, MaxDelay: time.Second}
+
+	assert.Equal(t, time.Duration(0), limiter.Delay(0))
+	assert.Equal(t, 250*time.Millisecond, limiter.Delay(1))
+	assert.Equal(t, 500*time.Millisecond, limiter.Delay(2))
+	assert.Equal(t, time.Second, limiter.Delay(3))
+	assert.Equal(t, time.Second, limiter.Delay(10))
+}
+
+func TestLoginCheck(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	mt.Run("locked username", func(mt *mtest.T) {
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch, bson.D{
+			{Key: "_id", Value: "user:admin"},
+			{Key: "failures", Value: 5},
+			{Key: "lockedUntil", Value: time.Now().Add(time.Minute)},
+		}))
+
+		limiter := services.LoginLimiter{Collection: mt.Coll, BaseDelay: time.Millisecond, MaxDelay: time.Second}
+		_, err := limiter.Check("admin", "10.0.0.1")
+
+		assert.Equal(t, services.ErrLoginLocked, err)
+	})
+
+	mt.Run("expired lockout delays", func(mt *mtest.T) {
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch,
+			bson.D{
+				{Key: "_id", Value: "user:admin"},
+				{Key: "failures", Value: 2},
+				{Key: "lastFailureAt", Value: time.Now().Add(-2 * time.Minute)},
+				{Key: "lockedUntil", Value: time.Now().Add(-time.Minute)},
+			},
+			bson.D{
+				{Key: "_id", Value: "ip:10.0.0.1"},
+				{Key: "failures", Value: 3},
+				{Key: "lastFailureAt", Value: time.Now().Add(-time.Minute)},
+			},
+		))
+
+		limiter := services.LoginLimiter{Collection: mt.Coll, FailureWindow: time.Hour, BaseDelay: time.Millisecond, MaxDelay: time.Second}
+		delay, err := limiter.Check("admin", "10.0.0.1")
+
+		assert.Nil(t, err)
+		assert.Equal(t, 4*time.Millisecond, delay)
+	})
+
+	mt.Run("failures outside the window are forgotten", func(mt *mtest.T) {
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch, bson.D{
+			{Key: "_id", Value: "user:admin"},
+			{Key: "failures", Value: 4},
+			{Key: "lastFailureAt", Value: time.Now().Add(-2 * time.Hour)},
+		}))
+
+		limiter := services.LoginLimiter{Collection: mt.Coll, FailureWindow: time.Hour, BaseDelay: time.Millisecond, MaxDelay: time.Second}
+		delay, err := limiter.Check("admin", "10.0.0.1")
+
+		assert.Nil(t, err)
+		assert.Equal(t, time.Duration(0), delay)
+	})
+}
+
+func TestLoginRecordFailure(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	mt.Run("restarts the count after the window", func(mt *mtest.T) {
+		mt.AddMockResponses(
+			bson.D{{Key: "ok", Value: 1}, {Key: "value", Value: bson.D{{Key: "_id", Value: "user:admin"}, {Key: "failures", Value: 1}}}},
+			bson.D{{Key: "ok", Value: 1}, {Key: "value", Value: bson.D{{Key: "_id", Value: "ip:10.0.0.1"}, {Key: "failures", Value: 1}}}},
+		)
+
+		limiter := services.LoginLimiter{Collection: mt.Coll, MaxUserFailures: 5, MaxIPFailures: 20, FailureWindow: 15 * time.Minute}
+		assert.Nil(t, limiter.RecordFailure("admin", "10.0.0.1"))
+
+		update := mt.GetStartedEvent().Command.Lookup("update").Array().Index(0).Value().Document()
+		set := update.Lookup("$set").Document()
+		assert.NotNil(t, set.Lookup("failures", "$cond").Array())
+		assert.Equal(t, 15*time.Minute, set.Lookup("expiresAt").Time().Sub(set.Lookup("lastFailureAt").Time()))
+	})
+
+	mt.Run("returns storage errors", func(mt *mtest.T) {
+		mt.AddMockResponses(mtest.CreateCommandErrorResponse(mtest.CommandError{Code: 1, Message: "down"}))
+
+		limiter := services.LoginLimiter{Collection: mt.Coll, MaxUserFailures: 5, MaxIPFailures: 20, FailureWindow: time.Minute}
+		assert.NotNil(t, limiter.RecordFailure("admin", "10.0.0.1"))
+	})
+}
@@ -0,0 +1,363 @@
+package services_test
+
+import (
+	"backend/models"
+	"backend/services"
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+var snapshot = []byte("\xff\xd8\xff\xe0 fake jpeg")
+
+// startSMTPStub accepts one SMTP session and sends the DATA of the message.
+func startSMTPStub(t *testing.T) (string, <-chan string) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	t.Cleanup(func() { ln.Close() })
+
+	received := make(chan string, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		reply := func(line string) { conn.Write([]byte(line + "\r\n")) }
+		reader := bufio.NewReader(conn)
+		reply("220 stub ESMTP")
+
+		var data strings.Builder
+		inData := false
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			if inData {
+				if line == ".\r\n" {
+					inData = false
+					received <- data.String()
+					reply("250 OK")
+				} else {
+					data.WriteString(line)
+				}
+				continue
+			}
+
+			switch cmd := strings.ToUpper(strings.TrimSpace(line)); {
+			case strings.HasPrefix(cmd, "EHLO"):
+				reply("250-stub")
+				reply("250 8BITMIME")
+			case cmd == "DATA":
+				inData = true
+				reply("354 end with .")
+			case cmd == "QUIT":
+				reply("221 bye")
+				return
+			default:
+				reply("250 OK")
+			}
+		}
+	}()
+
+	return ln.Addr().String(), received
+}
+
+func TestEmailChannel(t *testing.T) {
+	addr, received := startSMTPStub(t)
+	host, _, _ := net.SplitHostPort(addr)
+	channel := &services.EmailChannel{Mailer: &services.SMTPMailer{Addr: addr, Host: host, From: "alerts@example.com"}}
+
+	err := channel.Send(context.Background(), "guard@example.com", services.NotificationMessage{
+		Subject:             "Тревога: Fire",
+		Text:                "Тип: Fire",
+		Snapshot:            snapshot,
+		SnapshotContentType: "image/jpeg",
+	})
+	assert.Nil(t, err)
+
+	select {
+	case data := <-received:
+		assert.Contains(t, data, "To: guard@example.com")
+		assert.Contains(t, data, "multipart/mixed")
+		assert.Contains(t, data, `filename=snapshot.jpg`)
+		assert.Contains(t, data, "Тип: Fire")
+	case <-time.After(5 * time.Second):
+		t.Fatal("SMTP stub did not receive a message")
+	}
+}
+
+func TestSMTPMailerHonoursContext(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer ln.Close()
+	go func() {
+		// Accept and never answer, like a stalled relay.
+		conn, err := ln.Accept()
+		if err == nil {
+			defer conn.Close()
+			time.Sleep(5 * time.Second)
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	mailer := &services.SMTPMailer{Addr: ln.Addr().String(), Host: "127.0.0.1", From: "alerts@example.com"}
+	started := time.Now()
+	err = mailer.Send(ctx, services.MailMessage{To: []string{"guard@example.com"}, Subject: "Тревога"})
+
+	assert.NotNil(t, err)
+	assert.Less(t, time.Since(started), 2*time.Second)
+}
+
+func TestTelegramChannel(t *testing.T) {
+	var path, chatID, caption string
+	var photo []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+			var body map[string]string
+			json.NewDecoder(r.Body).Decode(&body)
+			chatID = body["chat_id"]
+		} else {
+			r.ParseMultipartForm(1 << 20)
+			chatID = r.FormValue("chat_id")
+			caption = r.FormValue("caption")
+			file, _, _ := r.FormFile("photo")
+			photo, _ = io.ReadAll(file)
+		}
+		w.Write([]byte(`{"ok":true}`))
+	}))
+	defer server.Close()
+
+	channel := &services.TelegramChannel{BaseURL: server.URL, Token: "123:abc", Client: server.Client()}
+
+	err := channel.Send(context.Background(), "-100500", services.NotificationMessage{Subject: "Тревога", Text: "текст"})
+	assert.Nil(t, err)
+	assert.Equal(t, "/bot123:abc/sendMessage", path)
+	assert.Equal(t, "-100500", chatID)
+
+	err = channel.Send(context.Background(), "-100500", services.NotificationMessage{Subject: "Тревога", Text: "текст", Snapshot: snapshot, SnapshotContentType: "image/jpeg"})
+	assert.Nil(t, err)
+	assert.Equal(t, "/bot123:abc/sendPhoto", path)
+	assert.Equal(t, "Тревога\n\nтекст", caption)
+	assert.Equal(t, snapshot, photo)
+}
+
+func TestTelegramChannelError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"ok":false,"description":"chat not found"}`))
+	}))
+	defer server.Close()
+
+	channel := &services.TelegramChannel{BaseURL: server.URL, Token: "123:abc", Client: server.Client()}
+	err := channel.Send(context.Background(), "1", services.NotificationMessage{Subject: "Тревога"})
+
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "chat not found")
+}
+
+func TestSMSChannel(t *testing.T) {
+	var auth string
+	var body map[string]string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = r.Header.Get("Authorization")
+		json.NewDecoder(r.Body).Decode(&body)
+	}))
+	defer server.Close()
+
+	channel := &services.SMSChannel{URL: server.URL, Token: "sms-token", Sender: "GUARD", Client: server.Client()}
+	err := channel.Send(context.Background(), "+79990000000", services.NotificationMessage{Subject: "Тревога: Fire"})
+
+	assert.Nil(t, err)
+	assert.Equal(t, "Bearer sms-token", auth)
+	assert.Equal(t, map[string]string{"to": "+79990000000", "text": "Тревога: Fire", "sender": "GUARD"}, body)
+}
+
+type recordingChannel struct {
+	messages []services.NotificationMessage
+}
+
+func (c *recordingChannel) Send(ctx context.Context, address string, msg services.NotificationMessage) error {
+	c.messages = append(c.messages, msg)
+	return nil
+}
+
+func TestNotifyUser(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	snapshotServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/jpeg")
+		w.Write(snapshot)
+	}))
+	defer snapshotServer.Close()
+
+	orgID := primitive.NewObjectID()
+	member := func(userID primitive.ObjectID, role models.Role) bson.D {
+		return bson.D{
+			{Key: "_id", Value: userID},
+			{Key: "memberships", Value: bson.A{bson.D{{Key: "organizationId", Value: orgID}, {Key: "role", Value: role}}}},
+		}
+	}
+
+	mt.Run("sends with snapshot and records status", func(mt *mtest.T) {
+		userID := primitive.NewObjectID()
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch, member(userID, models.RoleOperator)),
+			mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch, bson.D{
+				{Key: "_id", Value: primitive.NewObjectID()},
+				{Key: "userId", Value: userID},
+				{Key: "channels", Value: bson.A{
+					bson.D{{Key: "channel", Value: "telegram"}, {Key: "address", Value: "42"}, {Key: "enabled", Value: true}},
+					bson.D{{Key: "channel", Value: "sms"}, {Key: "address", Value: "+7999"}, {Key: "enabled", Value: true}},
+				}},
+				{Key: "quietHours", Value: bson.D{{Key: "start", Value: "00:00"}, {Key: "end", Value: "23:59"}}},
+			}),
+			mtest.CreateSuccessResponse(),
+			mtest.CreateSuccessResponse(),
+		)
+
+		telegram := &recordingChannel{}
+		service := services.NotificationService{
+			Preferences:   mt.Coll,
+			Notifications: mt.Coll,
+			Users:         mt.Coll,
+			Channels:      map[string]services.NotificationChannel{models.ChannelTelegram: telegram},
+			Client:        snapshotServer.Client(),
+			Now:           time.Now,
+		}
+
+		alert := &models.Alert{ID: primitive.NewObjectID(), OrganizationID: orgID, AlertType: models.AlertTypeFire, SnapshotURL: snapshotServer.URL}
+		assert.Nil(t, service.NotifyUser(alert, userID))
+
+		assert.Len(t, telegram.messages, 1)
+		assert.Equal(t, snapshot, telegram.messages[0].Snapshot)
+
+		var statuses []string
+		for _, event := range mt.GetAllStartedEvents() {
+			if event.CommandName == "insert" {
+				statuses = append(statuses, event.Command.Lookup("documents", "0", "status").StringValue())
+			}
+		}
+		assert.Equal(t, []string{models.NotificationSent, models.NotificationFailed}, statuses)
+	})
+
+	mt.Run("skips users who cannot see the alert", func(mt *mtest.T) {
+		userID := primitive.NewObjectID()
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch, member(userID, "")))
+
+		telegram := &recordingChannel{}
+		service := services.NotificationService{
+			Preferences:   mt.Coll,
+			Notifications: mt.Coll,
+			Users:         mt.Coll,
+			Channels:      map[string]services.NotificationChannel{models.ChannelTelegram: telegram},
+			Now:           time.Now,
+		}
+
+		alert := &models.Alert{ID: primitive.NewObjectID(), OrganizationID: orgID, AlertType: models.AlertTypeFire}
+		assert.NotNil(t, service.NotifyUser(alert, userID))
+		assert.Empty(t, telegram.messages)
+		assert.Len(t, mt.GetAllStartedEvents(), 1)
+	})
+}
+
+func TestNotifyAlertSkipsDisabledTypes(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	mt.Run("disabled in the catalog", func(mt *mtest.T) {
+		orgID := primitive.NewObjectID()
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch, bson.D{
+			{Key: "_id", Value: primitive.NewObjectID()},
+			{Key: "organizationId", Value: orgID},
+			{Key: "key", Value: string(models.AlertTypeFire)},
+			{Key: "disabled", Value: true},
+		}))
+
+		telegram := &recordingChannel{}
+		service := services.NotificationService{
+			Preferences:   mt.Coll,
+			Notifications: mt.Coll,
+			Users:         mt.Coll,
+			Types:         &services.AlertTypeService{Collection: mt.Coll},
+			Channels:      map[string]services.NotificationChannel{models.ChannelTelegram: telegram},
+			Now:           time.Now,
+		}
+
+		service.NotifyAlert(&models.Alert{ID: primitive.NewObjectID(), OrganizationID: orgID, AlertType: models.AlertTypeFire})
+
+		// Only the catalog is read; preferences are never loaded.
+		assert.Len(t, mt.GetAllStartedEvents(), 1)
+		assert.Empty(t, telegram.messages)
+	})
+}
+
+func TestGetAlertNotifications(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	orgID, buildingID := primitive.NewObjectID(), primitive.NewObjectID()
+	scope := services.AccessScope{OrganizationID: orgID, Restricted: true, BuildingIDs: []primitive.ObjectID{buildingID}}
+	notification := bson.D{
+		{Key: "_id", Value: primitive.NewObjectID()},
+		{Key: "channel", Value: models.ChannelSMS},
+		{Key: "address", Value: "+79990000000"},
+		{Key: "status", Value: models.NotificationSent},
+	}
+
+	mt.Run("addresses are redacted", func(mt *mtest.T) {
+		alertID := primitive.NewObjectID()
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch, bson.D{{Key: "_id", Value: alertID}}),
+			mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch, notification),
+		)
+
+		service := services.NotificationService{Alerts: mt.Coll, Notifications: mt.Coll}
+		notifications, err := service.GetAlertNotifications(scope, alertID.Hex(), false)
+
+		assert.Nil(t, err)
+		assert.Len(t, notifications, 1)
+		assert.Empty(t, notifications[0].Address)
+
+		filter := mt.GetAllStartedEvents()[0].Command.Lookup("filter").Document()
+		assert.Equal(t, buildingID, filter.Lookup("$or", "0", "buildingId", "$in", "0").ObjectID())
+	})
+
+	mt.Run("user managers see addresses", func(mt *mtest.T) {
+		alertID := primitive.NewObjectID()
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch, bson.D{{Key: "_id", Value: alertID}}),
+			mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch, notification),
+		)
+
+		service := services.NotificationService{Alerts: mt.Coll, Notifications: mt.Coll}
+		notifications, err := service.GetAlertNotifications(scope, alertID.Hex(), true)
+
+		assert.Nil(t, err)
+		assert.Equal(t, "+79990000000", notifications[0].Address)
+	})
+
+	mt.Run("alert outside the scope", func(mt *mtest.T) {
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch))
+
+		service := services.NotificationService{Alerts: mt.Coll, Notifications: mt.Coll}
+		_, err := service.GetAlertNotifications(scope, primitive.NewObjectID().Hex(), true)
+
+		assert.NotNil(t, err)
+		assert.Len(t, mt.GetAllStartedEvents(), 1)
+	})
+}
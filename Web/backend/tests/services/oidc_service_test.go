@@ -0,0 +1,161 @@
+package services_test
+
+import (
+	"backend/config"
+	"backend/models"
+	"backend/services"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+// mockOIDCProvider is a minimal identity provider serving discovery, JWKS and
+// a token endpoint that checks the PKCE verifier.
+type mockOIDCProvider struct {
+	server    *httptest.Server
+	key       *rsa.PrivateKey
+	clientID  string
+	challenge string
+	nonce     string
+	groups    []string
+}
+
+func newMockOIDCProvider(t *testing.T, clientID string) *mockOIDCProvider {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(t, err)
+
+	p := &mockOIDCProvider{key: key, clientID: clientID}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"issuer":                                p.server.URL,
+			"authorization_endpoint":                p.server.URL + "/authorize",
+			"token_endpoint":                        p.server.URL + "/token",
+			"jwks_uri":                              p.server.URL + "/jwks",
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": "test",
+			"alg": "RS256",
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+		if base64.RawURLEncoding.EncodeToString(sum[:]) != p.challenge {
+			http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+			return
+		}
+
+		idToken := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+			"iss":                p.server.URL,
+			"sub":                "idp-user-42",
+			"aud":                p.clientID,
+			"exp":                time.Now().Add(time.Hour).Unix(),
+			"iat":                time.Now().Unix(),
+			"nonce":              p.nonce,
+			"email":              "guard@example.com",
+			"preferred_username": "guard",
+			"groups":             p.groups,
+		})
+		idToken.Header["kid"] = "test"
+		signed, _ := idToken.SignedString(key)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token": "access",
+			"token_type":   "Bearer",
+			"expires_in":   3600,
+			"id_token":     signed,
+		})
+	})
+	p.server = httptest.NewServer(mux)
+	return p
+}
+
+func TestOIDCLoginProvisionsUser(t *testing.T) {
+	provider := newMockOIDCProvider(t, "backend")
+	defer provider.server.Close()
+	provider.groups = []string{"guards", "shift-leads"}
+
+	orgID := primitive.NewObjectID()
+	cfg := &config.OIDCConfig{
+		IssuerURL:      provider.server.URL,
+		ClientID:       "backend",
+		RedirectURL:    "http://localhost:8080/auth/oidc/callback",
+		Scopes:         []string{"openid", "email", "profile"},
+		GroupsClaim:    "groups",
+		GroupRoles:     map[string]string{"guards": "operator", "shift-leads": "supervisor"},
+		DefaultRole:    "viewer",
+		OrganizationID: orgID.Hex(),
+	}
+
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	mt.Run("first login", func(mt *mtest.T) {
+		service := &services.OIDCService{Users: mt.Coll, States: mt.Coll, Config: cfg}
+
+		mt.AddMockResponses(mtest.CreateSuccessResponse())
+		authURL, err := service.AuthURL()
+		assert.Nil(t, err)
+
+		parsed, err := url.Parse(authURL)
+		assert.Nil(t, err)
+		query := parsed.Query()
+		assert.Equal(t, "S256", query.Get("code_challenge_method"))
+		provider.challenge = query.Get("code_challenge")
+		provider.nonce = query.Get("nonce")
+
+		inserted := mt.GetStartedEvent().Command.Lookup("documents").Array().Index(0).Value().Document()
+
+		mt.AddMockResponses(
+			mtest.CreateSuccessResponse(bson.E{Key: "value", Value: inserted}),
+			mtest.CreateSuccessResponse(bson.E{Key: "value", Value: nil}),
+			mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch),
+			mtest.CreateSuccessResponse(),
+		)
+
+		user, err := service.Callback(query.Get("state"), "auth-code")
+		assert.Nil(t, err)
+		assert.Equal(t, "guard", user.Username)
+		assert.Equal(t, models.RoleSupervisor, user.RoleIn(orgID))
+		assert.Equal(t, "idp-user-42", user.OIDCSubject)
+		assert.Equal(t, []primitive.ObjectID{orgID}, user.OrganizationIDs())
+	})
+
+	mt.Run("unknown state", func(mt *mtest.T) {
+		service := &services.OIDCService{Users: mt.Coll, States: mt.Coll, Config: cfg}
+
+		mt.AddMockResponses(mtest.CreateSuccessResponse(bson.E{Key: "value", Value: nil}))
+		_, err := service.Callback("forged", "auth-code")
+		assert.NotNil(t, err)
+	})
+}
+
+func TestMapGroupsToRole(t *testing.T) {
+	mapping := map[string]string{"admins": "admin", "guards": "operator", "broken": "root"}
+
+	assert.Equal(t, models.RoleViewer, services.MapGroupsToRole(nil, mapping, models.RoleViewer))
+	assert.Equal(t, models.RoleOperator, services.MapGroupsToRole([]string{"guards", "broken"}, mapping, models.RoleViewer))
+	assert.Equal(t, models.RoleAdmin, services.MapGroupsToRole([]string{"guards", "admins"}, mapping, models.RoleViewer))
+}
@@ -0,0 +1,49 @@
+package services_test
+
+import (
+	"testing"
+
+	"backend/models"
+	"backend/services"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestCreateOrganization(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	mt.Run("creator becomes admin", func(mt *mtest.T) {
+		mt.AddMockResponses(
+			mtest.CreateSuccessResponse(),
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}, bson.E{Key: "nModified", Value: 1}),
+		)
+		service := &services.OrganizationService{Collection: mt.Coll, Users: mt.Coll}
+
+		org, err := service.CreateOrganization(&models.Organization{Name: "Склад"}, primitive.NewObjectID().Hex())
+		assert.Nil(t, err)
+
+		events := mt.GetAllStartedEvents()
+		update := events[len(events)-1].Command.Lookup("updates").Array().Index(0).Value().Document()
+		membership := update.Lookup("u", "$push", "memberships").Document()
+		assert.Equal(t, org.ID, membership.Lookup("organizationId").ObjectID())
+		assert.Equal(t, string(models.RoleAdmin), membership.Lookup("role").StringValue())
+	})
+
+	mt.Run("removes the organization without an owner", func(mt *mtest.T) {
+		mt.AddMockResponses(
+			mtest.CreateSuccessResponse(),
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}),
+		)
+		service := &services.OrganizationService{Collection: mt.Coll, Users: mt.Coll}
+
+		// API keys have no user to make the owner.
+		_, err := service.CreateOrganization(&models.Organization{Name: "Склад"}, "")
+		assert.NotNil(t, err)
+
+		events := mt.GetAllStartedEvents()
+		assert.Equal(t, "delete", events[len(events)-1].CommandName)
+	})
+}
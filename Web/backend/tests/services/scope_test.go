@@ -0,0 +1,53 @@
+package services_test
+
+import (
+	"backend/services"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestAccessScopeAllowsLocation(t *testing.T) {
+	orgID := primitive.NewObjectID()
+	buildingA := primitive.NewObjectID()
+	buildingB := primitive.NewObjectID()
+	floorB1 := primitive.NewObjectID()
+
+	t.Run("unrestricted", func(t *testing.T) {
+		scope := services.AccessScope{OrganizationID: orgID}
+		assert.True(t, scope.AllowsLocation(orgID, buildingB, primitive.NilObjectID))
+		assert.False(t, scope.AllowsLocation(primitive.NewObjectID(), buildingB, primitive.NilObjectID))
+	})
+
+	t.Run("building grant", func(t *testing.T) {
+		scope := services.AccessScope{OrganizationID: orgID, Restricted: true, BuildingIDs: []primitive.ObjectID{buildingA}}
+		assert.True(t, scope.AllowsLocation(orgID, buildingA, primitive.NilObjectID))
+		assert.False(t, scope.AllowsLocation(orgID, buildingB, floorB1))
+		assert.False(t, scope.AllowsLocation(orgID, primitive.NilObjectID, primitive.NilObjectID))
+	})
+
+	t.Run("floor grant", func(t *testing.T) {
+		scope := services.AccessScope{OrganizationID: orgID, Restricted: true, FloorIDs: []primitive.ObjectID{floorB1}}
+		assert.True(t, scope.AllowsLocation(orgID, buildingB, floorB1))
+		assert.False(t, scope.AllowsLocation(orgID, buildingB, primitive.NewObjectID()))
+	})
+}
+
+func TestAccessScopeFilters(t *testing.T) {
+	orgID := primitive.NewObjectID()
+	floorID := primitive.NewObjectID()
+
+	unrestricted := services.AccessScope{OrganizationID: orgID}
+	assert.Equal(t, bson.M{"organizationId": orgID}, unrestricted.LocationFilter(bson.M{}))
+
+	restricted := services.AccessScope{OrganizationID: orgID, Restricted: true, FloorIDs: []primitive.ObjectID{floorID}}
+	query := restricted.LocationFilter(bson.M{})
+	assert.Equal(t, orgID, query["organizationId"])
+	assert.Len(t, query["$or"], 2)
+
+	id := primitive.NewObjectID()
+	floorQuery := restricted.FloorFilter(bson.M{"_id": id})
+	assert.Equal(t, bson.M{"$eq": id, "$in": []primitive.ObjectID{floorID}}, floorQuery["_id"])
+}
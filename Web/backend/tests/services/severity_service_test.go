@@ -0,0 +1,51 @@
+package services_test
+
+import (
+	"backend/models"
+	"backend/services"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestComputeSeverityDefaults(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	assert.Equal(t, models.SeverityCritical, services.ComputeSeverity(nil, &models.Alert{}, models.SeverityCritical, now))
+	assert.Equal(t, models.SeverityHigh, services.ComputeSeverity(nil, &models.Alert{Confidence: 0.9}, models.SeverityHigh, now))
+	assert.Equal(t, models.SeverityMedium, services.ComputeSeverity(nil, &models.Alert{Confidence: 0.3}, models.SeverityHigh, now))
+	assert.Equal(t, models.SeverityLow, services.ComputeSeverity(nil, &models.Alert{Confidence: 0.3}, models.SeverityLow, now))
+	assert.Equal(t, models.SeverityMedium, services.ComputeSeverity(nil, &models.Alert{}, "", now))
+}
+
+func TestComputeSeverityRules(t *testing.T) {
+	warehouse := primitive.NewObjectID()
+	high := 0.8
+	rules := []models.SeverityRule{
+		{
+			Name:        "Склад ночью",
+			AlertTypes:  []models.AlertType{models.AlertTypeIntrusion},
+			BuildingIDs: []primitive.ObjectID{warehouse},
+			TimeWindow:  &models.TimeWindow{Start: "22:00", End: "06:00"},
+			Severity:    models.SeverityCritical,
+		},
+		{
+			Name:          "Уверенные детекции",
+			MinConfidence: &high,
+			Severity:      models.SeverityHigh,
+		},
+	}
+
+	night := time.Date(2024, 1, 1, 23, 0, 0, 0, time.UTC)
+	day := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	intrusion := &models.Alert{AlertType: models.AlertTypeIntrusion, BuildingID: warehouse, Confidence: 0.6}
+
+	assert.Equal(t, models.SeverityCritical, services.ComputeSeverity(rules, intrusion, models.SeverityHigh, night))
+	// Outside the window no rule matches and the default applies.
+	assert.Equal(t, models.SeverityHigh, services.ComputeSeverity(rules, intrusion, models.SeverityHigh, day))
+
+	suspicious := &models.Alert{AlertType: models.AlertTypeSuspicious, Confidence: 0.95}
+	assert.Equal(t, models.SeverityHigh, services.ComputeSeverity(rules, suspicious, models.SeverityMedium, day))
+}
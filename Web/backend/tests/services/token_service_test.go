@@ -0,0 +1,164 @@
+package services_test
+
+import (
+	"backend/config"
+	"backend/models"
+	"backend/services"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func authConfigs(t *testing.T) map[string]*config.AuthConfig {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(t, err)
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	assert.Nil(t, err)
+
+	return map[string]*config.AuthConfig{
+		"HS256": {SigningMethod: jwt.SigningMethodHS256, Secret: []byte("test-secret"), AccessTokenTTL: time.Minute},
+		"RS256": {SigningMethod: jwt.SigningMethodRS256, PrivateKey: rsaKey, KeyID: "rsa-1", AccessTokenTTL: time.Minute},
+		"EdDSA": {SigningMethod: jwt.SigningMethodEdDSA, PrivateKey: edKey, KeyID: "ed-1", AccessTokenTTL: time.Minute},
+	}
+}
+
+func TestAccessTokenRoundTrip(t *testing.T) {
+	orgID := primitive.NewObjectID()
+	user := &models.User{
+		ID:       primitive.NewObjectID(),
+		Username: "guard",
+		Memberships: []models.Membership{
+			{OrganizationID: primitive.NewObjectID(), Role: models.RoleAdmin},
+			{OrganizationID: orgID, Role: models.RoleOperator},
+		},
+	}
+
+	for name, cfg := range authConfigs(t) {
+		t.Run(name, func(t *testing.T) {
+			config.Auth = cfg
+
+			token, err := services.SignAccessToken(user, orgID, "session-1")
+			assert.Nil(t, err)
+
+			claims, err := services.ParseAccessToken(token)
+			assert.Nil(t, err)
+			assert.Equal(t, user.ID.Hex(), claims["user_id"])
+			assert.Equal(t, orgID.Hex(), claims["org_id"])
+			assert.Equal(t, "session-1", claims["sid"])
+			assert.Equal(t, "operator", claims["role"])
+		})
+	}
+}
+
+func TestAuthenticateUsesCurrentMembership(t *testing.T) {
+	config.Auth = authConfigs(t)["HS256"]
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	orgID := primitive.NewObjectID()
+	user := &models.User{ID: primitive.NewObjectID(), Username: "guard", Memberships: []models.Membership{{OrganizationID: orgID, Role: models.RoleAdmin}}}
+	token, err := services.SignAccessToken(user, orgID, "session-1")
+	assert.Nil(t, err)
+	stored := func(role models.Role, disabled bool) bson.D {
+		return bson.D{
+			{Key: "_id", Value: user.ID},
+			{Key: "username", Value: "guard"},
+			{Key: "memberships", Value: bson.A{bson.D{
+				{Key: "organizationId", Value: orgID},
+				{Key: "role", Value: role},
+				{Key: "disabled", Value: disabled},
+			}}},
+		}
+	}
+
+	mt.Run("role changed after login", func(mt *mtest.T) {
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, "foo.refresh_tokens", mtest.FirstBatch),
+			mtest.CreateCursorResponse(0, "foo.users", mtest.FirstBatch, stored(models.RoleViewer, false)),
+		)
+		service := &services.TokenService{Collection: mt.Coll, Users: mt.Coll}
+
+		principal, err := service.Authenticate(token)
+		assert.Nil(t, err)
+		assert.Equal(t, models.RoleViewer, principal.Role)
+		assert.Equal(t, orgID, principal.OrganizationID)
+		assert.Equal(t, "session-1", principal.SessionID)
+	})
+
+	mt.Run("membership disabled after login", func(mt *mtest.T) {
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, "foo.refresh_tokens", mtest.FirstBatch),
+			mtest.CreateCursorResponse(0, "foo.users", mtest.FirstBatch, stored(models.RoleAdmin, true)),
+		)
+		service := &services.TokenService{Collection: mt.Coll, Users: mt.Coll}
+
+		_, err := service.Authenticate(token)
+		assert.Equal(t, services.ErrUserDisabled, err)
+	})
+
+	mt.Run("session logged out", func(mt *mtest.T) {
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, "foo.refresh_tokens", mtest.FirstBatch, bson.D{
+			{Key: "_id", Value: primitive.NewObjectID()},
+			{Key: "sessionId", Value: "session-1"},
+			{Key: "sessionRevoked", Value: true},
+		}))
+		service := &services.TokenService{Collection: mt.Coll, Users: mt.Coll}
+
+		_, err := service.Authenticate(token)
+		assert.NotNil(t, err)
+
+		filter := mt.GetStartedEvent().Command.Lookup("filter").Document()
+		assert.Equal(t, "session-1", filter.Lookup("sessionId").StringValue())
+	})
+}
+
+func TestParseAccessTokenRejectsOtherAlgorithm(t *testing.T) {
+	configs := authConfigs(t)
+	user := &models.User{ID: primitive.NewObjectID()}
+
+	config.Auth = configs["HS256"]
+	token, err := services.SignAccessToken(user, primitive.NilObjectID, "s")
+	assert.Nil(t, err)
+
+	config.Auth = configs["RS256"]
+	_, err = services.ParseAccessToken(token)
+	assert.NotNil(t, err)
+}
+
+func TestParseAccessTokenRejectsExpired(t *testing.T) {
+	cfg := authConfigs(t)["HS256"]
+	cfg.AccessTokenTTL = -time.Minute
+	config.Auth = cfg
+
+	token, err := services.SignAccessToken(&models.User{ID: primitive.NewObjectID()}, primitive.NilObjectID, "s")
+	assert.Nil(t, err)
+
+	_, err = services.ParseAccessToken(token)
+	assert.NotNil(t, err)
+}
+
+func TestJWKS(t *testing.T) {
+	configs := authConfigs(t)
+
+	config.Auth = configs["HS256"]
+	assert.Empty(t, services.JWKS()["keys"])
+
+	config.Auth = configs["RS256"]
+	keys := services.JWKS()["keys"].([]map[string]string)
+	assert.Len(t, keys, 1)
+	assert.Equal(t, "RSA", keys[0]["kty"])
+	assert.Equal(t, "rsa-1", keys[0]["kid"])
+	assert.Equal(t, "AQAB", keys[0]["e"])
+
+	config.Auth = configs["EdDSA"]
+	keys = services.JWKS()["keys"].([]map[string]string)
+	assert.Equal(t, "OKP", keys[0]["kty"])
+	assert.Equal(t, "Ed25519", keys[0]["crv"])
+}
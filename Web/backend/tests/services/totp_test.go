@@ -0,0 +1,83 @@
+package services_test
+
+import (
+	"backend/config"
+	"backend/services"
+	"encoding/base32"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// rfc6238Secret is the SHA1 test key from RFC 6238 appendix B.
+var rfc6238Secret = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+
+func TestTOTPCodeMatchesRFC6238(t *testing.T) {
+	vectors := map[int64]string{
+		59:         "287082",
+		1111111109: "081804",
+		1111111111: "050471",
+		1234567890: "005924",
+		2000000000: "279037",
+	}
+
+	for unix, expected := range vectors {
+		code, err := services.TOTPCode(rfc6238Secret, time.Unix(unix, 0))
+		assert.Nil(t, err)
+		assert.Equal(t, expected, code, "time %d", unix)
+	}
+}
+
+func TestValidateTOTP(t *testing.T) {
+	secret, err := services.GenerateTOTPSecret()
+	assert.Nil(t, err)
+
+	now := time.Unix(1700000000, 0)
+	code, _ := services.TOTPCode(secret, now)
+
+	step, ok := services.ValidateTOTP(secret, code, now)
+	assert.True(t, ok)
+	assert.Equal(t, now.Unix()/30, step)
+
+	_, ok = services.ValidateTOTP(secret, code, now.Add(30*time.Second))
+	assert.True(t, ok, "previous step is accepted")
+
+	_, ok = services.ValidateTOTP(secret, code, now.Add(90*time.Second))
+	assert.False(t, ok)
+
+	_, ok = services.ValidateTOTP(secret, "000000x", now)
+	assert.False(t, ok)
+}
+
+func TestTOTPProvisioningURI(t *testing.T) {
+	uri := services.TOTPProvisioningURI("Anomaly Behavior Detect", "guard", "JBSWY3DPEHPK3PXP")
+
+	assert.True(t, strings.HasPrefix(uri, "otpauth://totp/"))
+	parsed, err := url.Parse(uri)
+	assert.Nil(t, err)
+	assert.Equal(t, "JBSWY3DPEHPK3PXP", parsed.Query().Get("secret"))
+	assert.Equal(t, "Anomaly Behavior Detect", parsed.Query().Get("issuer"))
+}
+
+func TestMFATokenIsNotAnAccessToken(t *testing.T) {
+	config.Auth = authConfigs(t)["HS256"]
+	orgID := primitive.NewObjectID()
+
+	token, err := services.SignMFAToken("user-1", orgID, services.MFAPurposeVerify)
+	assert.Nil(t, err)
+
+	_, err = services.ParseAccessToken(token)
+	assert.NotNil(t, err)
+
+	_, _, err = services.ParseMFAToken(token, services.MFAPurposeEnroll)
+	assert.NotNil(t, err)
+
+	userID, parsedOrg, err := services.ParseMFAToken(token, services.MFAPurposeVerify)
+	assert.Nil(t, err)
+	assert.Equal(t, "user-1", userID)
+	assert.Equal(t, orgID, parsedOrg)
+}
@@ -0,0 +1,197 @@
+package services_test
+
+import (
+	"backend/models"
+	"backend/services"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestSignWebhookPayload(t *testing.T) {
+	mac := hmac.New(sha256.New, []byte("secret"))
+	mac.Write([]byte("1700000000.{\"a\":1}"))
+
+	assert.Equal(t, hex.EncodeToString(mac.Sum(nil)), services.SignWebhookPayload("secret", "1700000000", []byte(`{"a":1}`)))
+}
+
+func TestWebhookBackoff(t *testing.T) {
+	service := services.WebhookService{BaseBackoff: 30 * time.Second, MaxBackoff: 5 * time.Minute}
+
+	assert.Equal(t, 30*time.Second, service.Backoff(1))
+	assert.Equal(t, time.Minute, service.Backoff(2))
+	assert.Equal(t, 4*time.Minute, service.Backoff(4))
+	assert.Equal(t, 5*time.Minute, service.Backoff(5))
+}
+
+func TestPublicAddress(t *testing.T) {
+	for address, public := range map[string]bool{
+		"93.184.216.34":        true,
+		"2606:4700::1111":      true,
+		"127.0.0.1":            false,
+		"10.1.2.3":             false,
+		"172.16.0.1":           false,
+		"192.168.1.10":         false,
+		"169.254.169.254":      false,
+		"100.64.0.1":           false,
+		"0.0.0.0":              false,
+		"::1":                  false,
+		"fd00::1":              false,
+		"fe80::1":              false,
+		"::ffff:192.168.1.10":  false,
+		"::ffff:93.184.216.34": true,
+	} {
+		assert.Equal(t, public, services.PublicAddress(netip.MustParseAddr(address)), address)
+	}
+}
+
+func TestCreateWebhookRejectsInternalURL(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	mt.Run("metadata endpoint", func(mt *mtest.T) {
+		service := services.WebhookService{Collection: mt.Coll}
+
+		for _, url := range []string{"http://169.254.169.254/latest/meta-data", "http://localhost:8080/", "https://[::1]/hook"} {
+			_, _, err := service.CreateWebhook(services.AccessScope{}, &models.Webhook{Name: "siem", URL: url})
+			assert.Equal(t, services.ErrNonPublicAddress, err, url)
+		}
+		assert.Empty(t, mt.GetAllStartedEvents())
+	})
+}
+
+func TestProcessWebhookDelivery(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	webhookID := primitive.NewObjectID()
+	deliveryDoc := func(attempts int) bson.D {
+		return bson.D{
+			{Key: "_id", Value: primitive.NewObjectID()},
+			{Key: "webhookId", Value: webhookID},
+			{Key: "event", Value: services.WebhookEventAlertCreated},
+			{Key: "payload", Value: `{"event":"alert.created"}`},
+			{Key: "status", Value: models.DeliveryPending},
+			{Key: "attempts", Value: attempts},
+		}
+	}
+	webhookDoc := func(url string) bson.D {
+		return bson.D{
+			{Key: "_id", Value: webhookID},
+			{Key: "url", Value: url},
+			{Key: "secret", Value: "whsec_test"},
+			{Key: "enabled", Value: true},
+		}
+	}
+	lastSet := func(mt *mtest.T) bson.Raw {
+		var update bson.Raw
+		for _, event := range mt.GetAllStartedEvents() {
+			if event.CommandName == "update" {
+				update = event.Command.Lookup("updates", "0", "u", "$set").Document()
+			}
+		}
+		return update
+	}
+
+	mt.Run("signed delivery", func(mt *mtest.T) {
+		var signature, timestamp, body string
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			data, _ := io.ReadAll(r.Body)
+			body = string(data)
+			signature = r.Header.Get("X-Webhook-Signature")
+			timestamp = r.Header.Get("X-Webhook-Timestamp")
+		}))
+		defer server.Close()
+
+		mt.AddMockResponses(
+			bson.D{{Key: "ok", Value: 1}, {Key: "value", Value: deliveryDoc(0)}},
+			mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch, webhookDoc(server.URL)),
+			mtest.CreateSuccessResponse(),
+		)
+
+		service := services.WebhookService{Collection: mt.Coll, Deliveries: mt.Coll, Client: server.Client(), MaxAttempts: 3, BaseBackoff: time.Second, MaxBackoff: time.Minute, Lease: time.Minute}
+
+		assert.True(t, service.ProcessNext())
+		assert.Equal(t, `{"event":"alert.created"}`, body)
+		assert.Equal(t, "sha256="+services.SignWebhookPayload("whsec_test", timestamp, []byte(body)), signature)
+		assert.Equal(t, models.DeliveryDelivered, lastSet(mt).Lookup("status").StringValue())
+	})
+
+	mt.Run("last failed attempt goes to dead letters", func(mt *mtest.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}))
+		defer server.Close()
+
+		mt.AddMockResponses(
+			bson.D{{Key: "ok", Value: 1}, {Key: "value", Value: deliveryDoc(2)}},
+			mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch, webhookDoc(server.URL)),
+			mtest.CreateSuccessResponse(),
+		)
+
+		service := services.WebhookService{Collection: mt.Coll, Deliveries: mt.Coll, Client: server.Client(), MaxAttempts: 3, BaseBackoff: time.Second, MaxBackoff: time.Minute, Lease: time.Minute}
+
+		assert.True(t, service.ProcessNext())
+		set := lastSet(mt)
+		assert.Equal(t, models.DeliveryDead, set.Lookup("status").StringValue())
+		assert.Equal(t, int32(503), set.Lookup("lastStatusCode").Int32())
+	})
+
+	mt.Run("webhook disabled after queueing", func(mt *mtest.T) {
+		requests := 0
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests++
+		}))
+		defer server.Close()
+
+		disabled := webhookDoc(server.URL)
+		disabled[3].Value = false
+		mt.AddMockResponses(
+			bson.D{{Key: "ok", Value: 1}, {Key: "value", Value: deliveryDoc(0)}},
+			mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch, disabled),
+			mtest.CreateSuccessResponse(),
+		)
+
+		service := services.WebhookService{Collection: mt.Coll, Deliveries: mt.Coll, Client: server.Client(), MaxAttempts: 3, BaseBackoff: time.Second, MaxBackoff: time.Minute, Lease: time.Minute}
+
+		assert.True(t, service.ProcessNext())
+		assert.Zero(t, requests)
+		assert.Equal(t, models.DeliveryDead, lastSet(mt).Lookup("status").StringValue())
+	})
+
+	mt.Run("internal address is not dialled", func(mt *mtest.T) {
+		requests := 0
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests++
+		}))
+		defer server.Close()
+
+		mt.AddMockResponses(
+			bson.D{{Key: "ok", Value: 1}, {Key: "value", Value: deliveryDoc(0)}},
+			mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch, webhookDoc(server.URL)),
+			mtest.CreateSuccessResponse(),
+		)
+
+		service := services.WebhookService{Collection: mt.Coll, Deliveries: mt.Coll, Client: services.NewPublicHTTPClient(time.Second), MaxAttempts: 3, BaseBackoff: time.Second, MaxBackoff: time.Minute, Lease: time.Minute}
+
+		assert.True(t, service.ProcessNext())
+		assert.Zero(t, requests)
+		assert.Contains(t, lastSet(mt).Lookup("lastError").StringValue(), services.ErrNonPublicAddress.Error())
+	})
+
+	mt.Run("empty queue", func(mt *mtest.T) {
+		mt.AddMockResponses(bson.D{{Key: "ok", Value: 1}, {Key: "value", Value: nil}})
+
+		service := services.WebhookService{Collection: mt.Coll, Deliveries: mt.Coll, Lease: time.Minute}
+		assert.False(t, service.ProcessNext())
+	})
+}
@@ -0,0 +1,67 @@
+package services_test
+
+import (
+	"backend/models"
+	"backend/services"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func square(x0, y0, x1, y1 float64) []models.Point {
+	return []models.Point{{X: x0, Y: y0}, {X: x1, Y: y0}, {X: x1, Y: y1}, {X: x0, Y: y1}}
+}
+
+func TestPointInPolygon(t *testing.T) {
+	polygon := square(0.2, 0.2, 0.6, 0.6)
+
+	assert.True(t, services.PointInPolygon(models.Point{X: 0.4, Y: 0.4}, polygon))
+	assert.False(t, services.PointInPolygon(models.Point{X: 0.8, Y: 0.4}, polygon))
+	assert.False(t, services.PointInPolygon(models.Point{X: 0.4, Y: 0.1}, polygon))
+}
+
+func TestMatchZones(t *testing.T) {
+	street := models.Zone{Name: "Street", Type: models.ZoneTypeExclude, Polygon: square(0, 0, 0.3, 1)}
+	hall := models.Zone{Name: "Hall", Type: models.ZoneTypeInclude, Polygon: square(0.2, 0, 1, 1)}
+
+	t.Run("no zones", func(t *testing.T) {
+		zone, allowed := services.MatchZones(nil, models.Point{X: 0.5, Y: 0.5})
+		assert.True(t, allowed)
+		assert.Nil(t, zone)
+	})
+
+	t.Run("exclude wins over include", func(t *testing.T) {
+		zone, allowed := services.MatchZones([]models.Zone{hall, street}, models.Point{X: 0.25, Y: 0.5})
+		assert.False(t, allowed)
+		assert.Equal(t, "Street", zone.Name)
+	})
+
+	t.Run("inside include", func(t *testing.T) {
+		zone, allowed := services.MatchZones([]models.Zone{hall, street}, models.Point{X: 0.7, Y: 0.5})
+		assert.True(t, allowed)
+		assert.Equal(t, "Hall", zone.Name)
+	})
+
+	t.Run("outside all include zones", func(t *testing.T) {
+		zone, allowed := services.MatchZones([]models.Zone{hall}, models.Point{X: 0.1, Y: 0.5})
+		assert.False(t, allowed)
+		assert.Nil(t, zone)
+	})
+}
+
+func TestValidateZone(t *testing.T) {
+	valid := models.Zone{Name: "Hall", Type: models.ZoneTypeInclude, Polygon: square(0, 0, 1, 1)}
+	assert.Nil(t, services.ValidateZone(&valid))
+
+	badType := valid
+	badType.Type = "other"
+	assert.NotNil(t, services.ValidateZone(&badType))
+
+	tooFew := valid
+	tooFew.Polygon = valid.Polygon[:2]
+	assert.NotNil(t, services.ValidateZone(&tooFew))
+
+	outOfFrame := valid
+	outOfFrame.Polygon = square(0, 0, 1.5, 1)
+	assert.NotNil(t, services.ValidateZone(&outOfFrame))
+}